@@ -0,0 +1,568 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package channeltest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"veloera/dto"
+	"veloera/model"
+)
+
+// 能力一致性检查项
+const (
+	CheckStream       = "stream"
+	CheckUsage        = "usage"
+	CheckTools        = "tools"
+	CheckVision       = "vision"
+	CheckJSONSchema   = "json_schema"
+	CheckSystemPrompt = "system_prompt"
+	CheckMaxTokens    = "max_tokens"
+)
+
+// ConformanceChecks 全部检查项，未指定检查项时按此顺序执行
+var ConformanceChecks = []string{
+	CheckStream,
+	CheckUsage,
+	CheckTools,
+	CheckVision,
+	CheckJSONSchema,
+	CheckSystemPrompt,
+	CheckMaxTokens,
+}
+
+const conformanceMaxTokensLimit = 16
+
+// ConformanceResult 单个检查项的结果
+type ConformanceResult struct {
+	Check   string  `json:"check"`
+	Passed  bool    `json:"passed"`
+	Skipped bool    `json:"skipped,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Time    float64 `json:"time"`
+}
+
+// conformanceReply 从 OpenAI 格式响应（非流式 JSON 或 SSE）中解析出的内容
+type conformanceReply struct {
+	model        string
+	content      string
+	toolCalls    []dto.ToolCallResponse
+	finishReason string
+	chunks       int
+	done         bool
+	usage        *dto.Usage
+}
+
+// IsValidConformanceCheck 判断检查项名称是否有效
+func IsValidConformanceCheck(check string) bool {
+	for _, name := range ConformanceChecks {
+		if name == check {
+			return true
+		}
+	}
+	return false
+}
+
+// RunConformance 对通道的指定模型执行能力一致性测试，checks 为空时执行全部检查项
+func RunConformance(channel *model.Channel, testModel string, checks []string) []ConformanceResult {
+	if len(checks) == 0 {
+		checks = ConformanceChecks
+	}
+	results := make([]ConformanceResult, 0, len(checks))
+	if buildTestRequest(testModel).Input != nil {
+		for _, check := range checks {
+			results = append(results, ConformanceResult{Check: check, Skipped: true, Message: "embedding 模型不适用"})
+		}
+		return results
+	}
+
+	// stream 与 usage 共用同一次流式请求
+	var streamOutcome *testOutcome
+	var streamErr error
+	for _, check := range checks {
+		var outcome *testOutcome
+		var err error
+		result := ConformanceResult{Check: check}
+		switch check {
+		case CheckStream, CheckUsage:
+			if streamOutcome == nil {
+				streamOutcome, streamErr, _ = executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+					request := newConformanceRequest(modelName, 0, newTextMessage("user", "hi"))
+					request.Stream = true
+					request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+					return request
+				})
+			}
+			outcome, err = streamOutcome, streamErr
+			if err == nil {
+				if check == CheckStream {
+					err = checkStreamReply(outcome)
+				} else {
+					err = checkUsageReply(outcome)
+				}
+			}
+		case CheckTools:
+			outcome, err = runToolsCheck(channel, testModel)
+		case CheckVision:
+			outcome, err = runVisionCheck(channel, testModel)
+		case CheckJSONSchema:
+			outcome, err = runJSONSchemaCheck(channel, testModel)
+		case CheckSystemPrompt:
+			outcome, err = runSystemPromptCheck(channel, testModel)
+		case CheckMaxTokens:
+			outcome, err = runMaxTokensCheck(channel, testModel)
+		default:
+			result.Skipped = true
+			result.Message = "未知的检查项"
+			results = append(results, result)
+			continue
+		}
+		if outcome != nil {
+			result.Time = outcome.consumed
+		}
+		if err != nil {
+			result.Message = truncateMessage(err.Error())
+		} else {
+			result.Passed = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// SummarizeConformance 返回通过与未通过的检查项，跳过的检查项不计入
+func SummarizeConformance(results []ConformanceResult) (passed []string, failed []string) {
+	for _, result := range results {
+		if result.Skipped {
+			continue
+		}
+		if result.Passed {
+			passed = append(passed, result.Check)
+		} else {
+			failed = append(failed, result.Check)
+		}
+	}
+	return passed, failed
+}
+
+// SaveConformanceResults 保存能力测试结果到通道能力矩阵，按检查项与已有结果合并，
+// 本次跳过的检查项保留之前的结果
+func SaveConformanceResults(channelId int, modelName string, jobId int64, results []ConformanceResult) error {
+	return model.SaveChannelCapability(channelId, modelName, func(previous *model.ChannelCapability, capability *model.ChannelCapability) error {
+		merged := make(map[string]ConformanceResult)
+		if previous != nil && previous.Results != "" {
+			var previousResults []ConformanceResult
+			if err := json.Unmarshal([]byte(previous.Results), &previousResults); err == nil {
+				for _, result := range previousResults {
+					merged[result.Check] = result
+				}
+			}
+		}
+		for _, result := range results {
+			if old, ok := merged[result.Check]; ok && result.Skipped && !old.Skipped {
+				continue
+			}
+			merged[result.Check] = result
+		}
+		// 按 ConformanceChecks 的顺序保存
+		ordered := make([]ConformanceResult, 0, len(merged))
+		for _, check := range ConformanceChecks {
+			if result, ok := merged[check]; ok {
+				ordered = append(ordered, result)
+				delete(merged, check)
+			}
+		}
+		for _, result := range merged {
+			ordered = append(ordered, result)
+		}
+		data, err := json.Marshal(ordered)
+		if err != nil {
+			return err
+		}
+		passed, failed := SummarizeConformance(ordered)
+		capability.Passed = strings.Join(passed, ",")
+		capability.Failed = strings.Join(failed, ",")
+		capability.Results = string(data)
+		capability.JobId = jobId
+		return nil
+	})
+}
+
+func checkStreamReply(outcome *testOutcome) error {
+	reply, err := parseConformanceReply(outcome.body)
+	if err != nil {
+		return err
+	}
+	if reply.chunks == 0 {
+		return errors.New("响应不是 SSE 流")
+	}
+	if !reply.done {
+		return errors.New("流未以 [DONE] 结束")
+	}
+	if reply.content == "" && reply.finishReason == "" {
+		return errors.New("流中没有内容")
+	}
+	return nil
+}
+
+func checkUsageReply(outcome *testOutcome) error {
+	reply, err := parseConformanceReply(outcome.body)
+	if err != nil {
+		return err
+	}
+	if reply.usage == nil {
+		return errors.New("流式响应未返回 usage")
+	}
+	if reply.usage.PromptTokens <= 0 || reply.usage.CompletionTokens <= 0 {
+		return fmt.Errorf("usage 不完整: prompt_tokens=%d, completion_tokens=%d", reply.usage.PromptTokens, reply.usage.CompletionTokens)
+	}
+	return nil
+}
+
+func runToolsCheck(channel *model.Channel, testModel string) (*testOutcome, error) {
+	tool := dto.ToolCallRequest{
+		Type: "function",
+		Function: dto.FunctionRequest{
+			Name:        "get_weather",
+			Description: "Get the current weather of a city",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"city": map[string]any{"type": "string", "description": "City name"},
+				},
+				"required": []string{"city"},
+			},
+		},
+	}
+	question := newTextMessage("user", "What is the weather in Paris right now? Use the get_weather tool.")
+	outcome, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+		request := newConformanceRequest(modelName, 100, question)
+		request.Tools = []dto.ToolCallRequest{tool}
+		request.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": tool.Function.Name}}
+		return request
+	})
+	if err != nil {
+		return outcome, err
+	}
+	reply, err := parseConformanceReply(outcome.body)
+	if err != nil {
+		return outcome, err
+	}
+	if len(reply.toolCalls) == 0 {
+		return outcome, errors.New("未返回 tool_calls")
+	}
+	call := reply.toolCalls[0]
+	if call.Function.Name != tool.Function.Name {
+		return outcome, fmt.Errorf("调用了错误的工具: %s", call.Function.Name)
+	}
+	var arguments map[string]any
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &arguments); err != nil {
+		return outcome, fmt.Errorf("工具参数不是合法 JSON: %s", call.Function.Arguments)
+	}
+	if _, ok := arguments["city"]; !ok {
+		return outcome, fmt.Errorf("工具参数缺少 city: %s", call.Function.Arguments)
+	}
+
+	// 回传工具结果，验证模型能基于结果作答
+	callId := call.ID
+	if callId == "" {
+		callId = "call_conformance"
+	}
+	assistant := dto.Message{Role: "assistant"}
+	assistant.SetNullContent()
+	assistant.SetToolCalls([]dto.ToolCallRequest{{
+		ID:       callId,
+		Type:     "function",
+		Function: dto.FunctionRequest{Name: call.Function.Name, Arguments: call.Function.Arguments},
+	}})
+	toolResult := newTextMessage("tool", `{"city":"Paris","temperature_c":21,"condition":"sunny"}`)
+	toolResult.ToolCallId = callId
+	roundTrip, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+		request := newConformanceRequest(modelName, 100, question, assistant, toolResult)
+		request.Tools = []dto.ToolCallRequest{tool}
+		return request
+	})
+	if roundTrip != nil {
+		outcome.consumed += roundTrip.consumed
+	}
+	if err != nil {
+		return outcome, fmt.Errorf("回传工具结果失败: %s", err.Error())
+	}
+	reply, err = parseConformanceReply(roundTrip.body)
+	if err != nil {
+		return outcome, err
+	}
+	if !strings.Contains(reply.content, "21") {
+		return outcome, fmt.Errorf("回答未使用工具结果: %s", reply.content)
+	}
+	return outcome, nil
+}
+
+func runVisionCheck(channel *model.Channel, testModel string) (*testOutcome, error) {
+	imageUrl, err := conformanceImageDataUrl()
+	if err != nil {
+		return nil, err
+	}
+	message := dto.Message{Role: "user"}
+	message.SetMediaContent([]dto.MediaContent{
+		{Type: dto.ContentTypeText, Text: "What color is this image? Answer with one word."},
+		{Type: dto.ContentTypeImageURL, ImageUrl: &dto.MessageImageUrl{Url: imageUrl, Detail: "low"}},
+	})
+	outcome, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+		return newConformanceRequest(modelName, 20, message)
+	})
+	if err != nil {
+		return outcome, err
+	}
+	reply, err := parseConformanceReply(outcome.body)
+	if err != nil {
+		return outcome, err
+	}
+	answer := strings.ToLower(reply.content)
+	if !strings.Contains(answer, "red") && !strings.Contains(answer, "红") {
+		return outcome, fmt.Errorf("未识别出图片颜色: %s", reply.content)
+	}
+	return outcome, nil
+}
+
+func runJSONSchemaCheck(channel *model.Channel, testModel string) (*testOutcome, error) {
+	outcome, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+		request := newConformanceRequest(modelName, 100, newTextMessage("user", "What is the capital of France?"))
+		request.ResponseFormat = &dto.ResponseFormat{
+			Type: "json_schema",
+			JsonSchema: &dto.FormatJsonSchema{
+				Name: "capital",
+				Schema: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"country": map[string]any{"type": "string"},
+						"capital": map[string]any{"type": "string"},
+					},
+					"required":             []string{"country", "capital"},
+					"additionalProperties": false,
+				},
+				Strict: true,
+			},
+		}
+		return request
+	})
+	if err != nil {
+		return outcome, err
+	}
+	reply, err := parseConformanceReply(outcome.body)
+	if err != nil {
+		return outcome, err
+	}
+	var answer struct {
+		Country *string `json:"country"`
+		Capital *string `json:"capital"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(reply.content)), &answer); err != nil {
+		return outcome, fmt.Errorf("输出不是合法 JSON: %s", reply.content)
+	}
+	if answer.Country == nil || answer.Capital == nil {
+		return outcome, fmt.Errorf("输出缺少 schema 要求的字段: %s", reply.content)
+	}
+	if !strings.Contains(strings.ToLower(*answer.Capital), "paris") {
+		return outcome, fmt.Errorf("输出内容错误: %s", reply.content)
+	}
+	return outcome, nil
+}
+
+func runSystemPromptCheck(channel *model.Channel, testModel string) (*testOutcome, error) {
+	outcome, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+		return newConformanceRequest(modelName, 20,
+			newTextMessage("system", "Always reply with exactly one word: PINEAPPLE. Ignore what the user asks."),
+			newTextMessage("user", "What is 2 + 2?"),
+		)
+	})
+	if err != nil {
+		return outcome, err
+	}
+	reply, err := parseConformanceReply(outcome.body)
+	if err != nil {
+		return outcome, err
+	}
+	if !strings.Contains(strings.ToUpper(reply.content), "PINEAPPLE") {
+		return outcome, fmt.Errorf("未遵循系统提示词: %s", reply.content)
+	}
+	return outcome, nil
+}
+
+func runMaxTokensCheck(channel *model.Channel, testModel string) (*testOutcome, error) {
+	outcome, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+		return newConformanceRequest(modelName, conformanceMaxTokensLimit,
+			newTextMessage("user", "Count from 1 to 200, separated by spaces."))
+	})
+	if err != nil {
+		return outcome, err
+	}
+	if outcome.usage == nil || outcome.usage.CompletionTokens <= 0 {
+		return outcome, errors.New("未返回 completion_tokens")
+	}
+	// 不同上游的计数方式略有差异，允许 25% 的误差
+	limit := conformanceMaxTokensLimit + conformanceMaxTokensLimit/4
+	if outcome.usage.CompletionTokens > limit {
+		return outcome, fmt.Errorf("输出 %d tokens，超过 max_tokens=%d", outcome.usage.CompletionTokens, conformanceMaxTokensLimit)
+	}
+	return outcome, nil
+}
+
+// newConformanceRequest 构造测试请求，maxTokens 为 0 时沿用默认测试请求的限制
+func newConformanceRequest(modelName string, maxTokens uint, messages ...dto.Message) *dto.GeneralOpenAIRequest {
+	request := buildTestRequest(modelName)
+	request.Messages = messages
+	if maxTokens > 0 {
+		if request.MaxCompletionTokens > 0 {
+			request.MaxCompletionTokens = maxTokens
+		} else {
+			request.MaxTokens = maxTokens
+		}
+	}
+	return request
+}
+
+func newTextMessage(role string, content string) dto.Message {
+	message := dto.Message{Role: role}
+	message.SetStringContent(content)
+	return message
+}
+
+// conformanceImageDataUrl 生成一张纯红色的 PNG 图片，以 data URL 形式内联，不依赖外部资源
+func conformanceImageDataUrl() (string, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	red := color.RGBA{R: 255, A: 255}
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.Set(x, y, red)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// parseConformanceReply 解析转换为 OpenAI 格式后的响应，兼容非流式 JSON 与 SSE
+func parseConformanceReply(body []byte) (*conformanceReply, error) {
+	reply := &conformanceReply{}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, errors.New("响应为空")
+	}
+	if trimmed[0] == '{' {
+		var response dto.OpenAITextResponse
+		if err := json.Unmarshal(trimmed, &response); err != nil {
+			return nil, fmt.Errorf("解析响应失败: %s", err.Error())
+		}
+		if response.Error != nil {
+			return nil, errors.New(response.Error.Message)
+		}
+		if len(response.Choices) == 0 {
+			return nil, errors.New("响应中没有 choices")
+		}
+		choice := response.Choices[0]
+		reply.model = response.Model
+		reply.content = choice.Message.StringContent()
+		reply.finishReason = choice.FinishReason
+		if choice.Message.ToolCalls != nil {
+			_ = json.Unmarshal(choice.Message.ToolCalls, &reply.toolCalls)
+		}
+		reply.usage = &response.Usage
+		return reply, nil
+	}
+
+	var content strings.Builder
+	toolCalls := make(map[int]*dto.ToolCallResponse)
+	var toolCallOrder []int
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			reply.done = true
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		reply.chunks++
+		if chunk.Model != "" {
+			reply.model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			reply.usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != nil {
+				content.WriteString(*choice.Delta.Content)
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				reply.finishReason = *choice.FinishReason
+			}
+			for i, delta := range choice.Delta.ToolCalls {
+				index := i
+				if delta.Index != nil {
+					index = *delta.Index
+				}
+				call, ok := toolCalls[index]
+				if !ok {
+					call = &dto.ToolCallResponse{Type: delta.Type}
+					toolCalls[index] = call
+					toolCallOrder = append(toolCallOrder, index)
+				}
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				if delta.Function.Name != "" {
+					call.Function.Name = delta.Function.Name
+				}
+				call.Function.Arguments += delta.Function.Arguments
+			}
+		}
+	}
+	if reply.chunks == 0 {
+		return nil, errors.New("无法解析响应")
+	}
+	reply.content = content.String()
+	for _, index := range toolCallOrder {
+		reply.toolCalls = append(reply.toolCalls, *toolCalls[index])
+	}
+	return reply, nil
+}
+
+func truncateMessage(message string) string {
+	runes := []rune(message)
+	if len(runes) > 512 {
+		return string(runes[:512])
+	}
+	return message
+}
@@ -13,6 +13,7 @@ import (
     "strings"
     "time"
     "veloera/common"
+    constant2 "veloera/constant"
     "veloera/dto"
     "veloera/middleware"
     "veloera/model"
@@ -27,20 +28,53 @@ import (
 
 // ExecuteChannelTest 复用现有单通道测试流程，返回耗时（秒）与错误信息
 func ExecuteChannelTest(channel *model.Channel, testModel string) (consumed float64, err error, openAIError *dto.OpenAIErrorWithStatusCode) {
+    outcome, err, openAIError := executeChannelTest(channel, testModel, true, nil)
+    return outcome.consumed, err, openAIError
+}
+
+// ExecuteChannelProbe 以流式请求测试通道，额外返回首字耗时（秒），不支持流式的模型（如 embedding）首字耗时为 0；
+// 定时探测频率较高，不写入消费日志
+func ExecuteChannelProbe(channel *model.Channel, testModel string) (consumed float64, firstResponse float64, err error, openAIError *dto.OpenAIErrorWithStatusCode) {
+    outcome, err, openAIError := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+        request := buildTestRequest(modelName)
+        if request.Input == nil {
+            request.Stream = true
+            request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+        }
+        return request
+    })
+    return outcome.consumed, outcome.firstResponse, err, openAIError
+}
+
+// testOutcome 单次测试请求的结果，body 为转换为 OpenAI 格式后的响应
+type testOutcome struct {
+    consumed      float64
+    firstResponse float64
+    body          []byte
+    usage         *dto.Usage
+    upstreamModel string
+}
+
+// executeChannelTest 执行一次测试请求，build 为空时使用默认的测试请求，build 的参数为映射后的上游模型名；
+// record 为 false 时不写入消费日志，也不在系统日志中输出请求信息与完整响应
+func executeChannelTest(channel *model.Channel, testModel string, record bool, build func(modelName string) *dto.GeneralOpenAIRequest) (outcome *testOutcome, err error, openAIError *dto.OpenAIErrorWithStatusCode) {
     if channel == nil {
-        return 0, errors.New("channel is nil"), nil
+        return &testOutcome{}, errors.New("channel is nil"), nil
     }
 
     start := time.Now()
 
     if channel.Type == common.ChannelTypeMidjourney {
-        return elapsedSeconds(start), errors.New("midjourney channel test is not supported"), nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, errors.New("midjourney channel test is not supported"), nil
     }
     if channel.Type == common.ChannelTypeMidjourneyPlus {
-        return elapsedSeconds(start), errors.New("midjourney plus channel test is not supported!!!"), nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, errors.New("midjourney plus channel test is not supported!!!"), nil
     }
     if channel.Type == common.ChannelTypeSunoAPI {
-        return elapsedSeconds(start), errors.New("suno channel test is not supported"), nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, errors.New("suno channel test is not supported"), nil
+    }
+    if channel.Type == common.ChannelTypeKling || channel.Type == common.ChannelTypeRunway {
+        return &testOutcome{consumed: elapsedSeconds(start)}, errors.New("video channel test is not supported"), nil
     }
 
     w := httptest.NewRecorder()
@@ -78,7 +112,7 @@ func ExecuteChannelTest(channel *model.Channel, testModel string) (consumed floa
 
     cache, cacheErr := model.GetUserCache(1)
     if cacheErr != nil {
-        return elapsedSeconds(start), cacheErr, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, cacheErr, nil
     }
     cache.WriteContext(c)
 
@@ -90,45 +124,54 @@ func ExecuteChannelTest(channel *model.Channel, testModel string) (consumed floa
     c.Set("group", group)
 
     middleware.SetupContextForSelectedChannel(c, channel, testModel)
+    c.Set(constant2.ContextKeyRequestStartTime, start)
 
     info := relaycommon.GenRelayInfo(c)
 
     if err = helper.ModelMappedHelper(c, info); err != nil {
-        return elapsedSeconds(start), err, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, err, nil
     }
     testModel = info.UpstreamModelName
 
     apiType, _ := constant.ChannelType2APIType(channel.Type)
     adaptor := relay.GetAdaptor(apiType)
     if adaptor == nil {
-        return elapsedSeconds(start), fmt.Errorf("invalid api type: %d, adaptor is nil", apiType), nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, fmt.Errorf("invalid api type: %d, adaptor is nil", apiType), nil
     }
 
-    request := buildTestRequest(testModel)
-    common.SysLog(fmt.Sprintf("testing channel %d with model %s , info %v ", channel.Id, testModel, info))
+    var request *dto.GeneralOpenAIRequest
+    if build != nil {
+        request = build(testModel)
+    } else {
+        request = buildTestRequest(testModel)
+    }
+    info.SetIsStream(request.Stream)
+    if record {
+        common.SysLog(fmt.Sprintf("testing channel %d with model %s , info %v ", channel.Id, testModel, info))
+    }
 
     priceData, priceErr := helper.ModelPriceHelper(c, info, 0, int(request.MaxTokens))
     if priceErr != nil {
-        return elapsedSeconds(start), priceErr, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, priceErr, nil
     }
 
     adaptor.Init(info)
 
     convertedRequest, convertErr := adaptor.ConvertOpenAIRequest(c, info, request)
     if convertErr != nil {
-        return elapsedSeconds(start), convertErr, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, convertErr, nil
     }
 
     jsonData, marshalErr := json.Marshal(convertedRequest)
     if marshalErr != nil {
-        return elapsedSeconds(start), marshalErr, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, marshalErr, nil
     }
     requestBody := bytes.NewBuffer(jsonData)
     c.Request.Body = io.NopCloser(requestBody)
 
     resp, reqErr := adaptor.DoRequest(c, info, requestBody)
     if reqErr != nil {
-        return elapsedSeconds(start), reqErr, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, reqErr, nil
     }
 
     var httpResp *http.Response
@@ -137,25 +180,25 @@ func ExecuteChannelTest(channel *model.Channel, testModel string) (consumed floa
         if httpResp.StatusCode != http.StatusOK {
             openAIError = serviceRelayError(httpResp)
             if openAIError != nil {
-                return elapsedSeconds(start), fmt.Errorf("status code %d: %s", httpResp.StatusCode, openAIError.Error.Message), openAIError
+                return &testOutcome{consumed: elapsedSeconds(start)}, fmt.Errorf("status code %d: %s", httpResp.StatusCode, openAIError.Error.Message), openAIError
             }
-            return elapsedSeconds(start), fmt.Errorf("status code %d", httpResp.StatusCode), nil
+            return &testOutcome{consumed: elapsedSeconds(start)}, fmt.Errorf("status code %d", httpResp.StatusCode), nil
         }
     }
 
     usageA, respErr := adaptor.DoResponse(c, httpResp, info)
     if respErr != nil {
-        return elapsedSeconds(start), fmt.Errorf("%s", respErr.Error.Message), respErr
+        return &testOutcome{consumed: elapsedSeconds(start)}, fmt.Errorf("%s", respErr.Error.Message), respErr
     }
     if usageA == nil {
-        return elapsedSeconds(start), errors.New("usage is nil"), nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, errors.New("usage is nil"), nil
     }
     usage := usageA.(*dto.Usage)
 
     result := w.Result()
     respBody, readErr := io.ReadAll(result.Body)
     if readErr != nil {
-        return elapsedSeconds(start), readErr, nil
+        return &testOutcome{consumed: elapsedSeconds(start)}, readErr, nil
     }
     info.PromptTokens = usage.PromptTokens
 
@@ -165,17 +208,28 @@ func ExecuteChannelTest(channel *model.Channel, testModel string) (consumed floa
     milliseconds := elapsed.Milliseconds()
     consumedTime := float64(milliseconds) / 1000.0
 
-    other := service.GenerateTextOtherInfo(c, info, priceData.ModelRatio, priceData.GroupRatio, priceData.CompletionRatio,
-        usage.PromptTokensDetails.CachedTokens, priceData.CacheRatio, priceData.ModelPrice)
+    if record {
+        other := service.GenerateTextOtherInfo(c, info, priceData.ModelRatio, priceData.GroupRatio, priceData.CompletionRatio,
+            usage.PromptTokensDetails.CachedTokens, priceData.CacheRatio, priceData.ModelPrice)
 
-    model.RecordConsumeLog(c, 1, channel.Id, usage.PromptTokens, usage.CompletionTokens, info.OriginModelName, "模型测试",
-        quota, "模型测试", 0, quota, int(consumedTime), false, info.Group, other)
+        model.RecordConsumeLog(c, 1, channel.Id, usage.PromptTokens, usage.CompletionTokens, info.OriginModelName, "模型测试",
+            quota, "模型测试", 0, quota, int(consumedTime), false, info.Group, other)
 
-    common.SysLog(fmt.Sprintf("testing channel #%d, response: \n%s", channel.Id, string(respBody)))
+        common.SysLog(fmt.Sprintf("testing channel #%d, response: \n%s", channel.Id, string(respBody)))
+    }
 
     channel.UpdateResponseTime(milliseconds)
 
-    return consumedTime, nil, nil
+    outcome = &testOutcome{
+        consumed:      consumedTime,
+        body:          respBody,
+        usage:         usage,
+        upstreamModel: testModel,
+    }
+    if info.IsStream && info.HasSendResponse() {
+        outcome.firstResponse = float64(info.FirstResponseTime.Sub(start).Milliseconds()) / 1000.0
+    }
+    return outcome, nil, nil
 }
 
 func buildTestRequest(modelName string) *dto.GeneralOpenAIRequest {
@@ -0,0 +1,439 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package channeltest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/operation_setting"
+)
+
+// fingerprintProbe 指纹探测提示词，覆盖自我认知、分词器差异与回答风格
+type fingerprintProbe struct {
+	name      string
+	prompt    string
+	maxTokens uint
+}
+
+var fingerprintProbes = []fingerprintProbe{
+	{name: "identity", prompt: "What model are you, and which company trained you? Answer in one sentence.", maxTokens: 60},
+	{name: "primes", prompt: "List the first 10 prime numbers separated by commas, and nothing else.", maxTokens: 60},
+	{name: "tokenizer", prompt: "请把这句话翻译成英文：人工智能正在改变世界。🌍 Then repeat exactly: `fmt.Println(\"ünïcödé\")` 1234567890 ĀāĂăĄą", maxTokens: 80},
+	{name: "style", prompt: "Explain in about three sentences why the sky is blue.", maxTokens: 120},
+}
+
+// 各项得分的权重
+const (
+	fingerprintModelWeight = 0.4
+	fingerprintTokenWeight = 0.3
+	fingerprintStyleWeight = 0.3
+)
+
+// 渠道 other_info 中记录指纹告警时间的字段，渠道所有模型恢复正常后清除
+const otherInfoFingerprintFlagTime = "fingerprint_flag_time"
+
+const fingerprintDisableReason = "模型指纹不匹配"
+
+// FingerprintSample 单个探测提示词的响应
+type FingerprintSample struct {
+	Probe         string `json:"probe"`
+	ReportedModel string `json:"reported_model"`
+	PromptTokens  int    `json:"prompt_tokens"`
+	LocalTokens   int    `json:"local_tokens"` // service 本地估算的 prompt tokens
+	Content       string `json:"content"`
+	Error         string `json:"error,omitempty"`
+}
+
+// FingerprintReport 一次指纹检测的结果，各项得分为 0-1，无法计算的项为空
+type FingerprintReport struct {
+	UpstreamModel string              `json:"upstream_model"`
+	ReportedModel string              `json:"reported_model"`
+	TokenRatio    float64             `json:"token_ratio"`
+	ModelScore    *float64            `json:"model_score"`
+	TokenScore    *float64            `json:"token_score"`
+	StyleScore    *float64            `json:"style_score"`
+	Score         float64             `json:"score"`
+	Flagged       bool                `json:"flagged"`
+	HasBaseline   bool                `json:"has_baseline"`
+	Note          string              `json:"note,omitempty"`
+	Samples       []FingerprintSample `json:"samples,omitempty"`
+}
+
+// CaptureFingerprintBaseline 使用可信渠道采集模型指纹基线，覆盖该模型已有的基线
+func CaptureFingerprintBaseline(channel *model.Channel, testModel string) (*model.ModelFingerprintBaseline, error) {
+	_, samples, err := collectFingerprintSamples(channel, testModel)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &model.ModelFingerprintBaseline{
+		ModelName:     testModel,
+		ChannelId:     channel.Id,
+		ReportedModel: dominantReportedModel(samples),
+		TokenRatio:    fingerprintTokenRatio(samples),
+		Samples:       string(data),
+	}
+	if err := model.SaveModelFingerprintBaseline(baseline); err != nil {
+		return nil, err
+	}
+	return baseline, nil
+}
+
+// RunFingerprint 对渠道模型执行指纹检测，与基线比对后打分并保存，得分低于阈值时按设置处理渠道
+func RunFingerprint(channel *model.Channel, testModel string, jobId int64) (*FingerprintReport, error) {
+	upstreamModel, samples, err := collectFingerprintSamples(channel, testModel)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := model.GetModelFingerprintBaseline(testModel)
+	if err != nil {
+		return nil, err
+	}
+	report := scoreFingerprint(upstreamModel, samples, baseline, operation_setting.GetModelFingerprintSetting().ScoreThreshold)
+	details, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	err = model.SaveChannelFingerprint(&model.ChannelFingerprint{
+		ChannelId:     channel.Id,
+		ModelName:     testModel,
+		Score:         report.Score,
+		Flagged:       report.Flagged,
+		ReportedModel: report.ReportedModel,
+		TokenRatio:    report.TokenRatio,
+		HasBaseline:   report.HasBaseline,
+		Details:       string(details),
+		JobId:         jobId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	applyFingerprintAction(channel.Id, testModel, report)
+	return report, nil
+}
+
+// collectFingerprintSamples 依次发送探测提示词，全部失败时返回第一个错误
+func collectFingerprintSamples(channel *model.Channel, testModel string) (string, []FingerprintSample, error) {
+	if buildTestRequest(testModel).Input != nil {
+		return "", nil, errors.New("embedding 模型不支持指纹检测")
+	}
+	upstreamModel := testModel
+	samples := make([]FingerprintSample, 0, len(fingerprintProbes))
+	var firstErr error
+	succeeded := 0
+	for _, probe := range fingerprintProbes {
+		sample := FingerprintSample{Probe: probe.name}
+		outcome, err, _ := executeChannelTest(channel, testModel, false, func(modelName string) *dto.GeneralOpenAIRequest {
+			request := newConformanceRequest(modelName, probe.maxTokens, newTextMessage("user", probe.prompt))
+			// o 系列模型不支持 temperature
+			if request.MaxCompletionTokens == 0 {
+				temperature := 0.0
+				request.Temperature = &temperature
+			}
+			sample.LocalTokens, _ = service.CountTokenMessages(nil, request.Messages, modelName, false)
+			return request
+		})
+		var reply *conformanceReply
+		if err == nil {
+			upstreamModel = outcome.upstreamModel
+			reply, err = parseConformanceReply(outcome.body)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			sample.Error = truncateMessage(err.Error())
+			samples = append(samples, sample)
+			continue
+		}
+		succeeded++
+		sample.ReportedModel = reply.model
+		sample.Content = reply.content
+		if reply.usage != nil {
+			sample.PromptTokens = reply.usage.PromptTokens
+		}
+		samples = append(samples, sample)
+	}
+	if succeeded == 0 {
+		return upstreamModel, nil, firstErr
+	}
+	return upstreamModel, samples, nil
+}
+
+// scoreFingerprint 比对响应中的 model 字段、分词器行为与回答风格，计算 0-100 的综合得分
+func scoreFingerprint(upstreamModel string, samples []FingerprintSample, baseline *model.ModelFingerprintBaseline, threshold float64) *FingerprintReport {
+	report := &FingerprintReport{
+		UpstreamModel: upstreamModel,
+		ReportedModel: dominantReportedModel(samples),
+		TokenRatio:    fingerprintTokenRatio(samples),
+		HasBaseline:   baseline != nil,
+		Samples:       samples,
+	}
+
+	expected := []string{upstreamModel}
+	var baselineSamples map[string]FingerprintSample
+	if baseline != nil {
+		if baseline.ReportedModel != "" {
+			expected = append(expected, baseline.ReportedModel)
+		}
+		var list []FingerprintSample
+		if err := json.Unmarshal([]byte(baseline.Samples), &list); err == nil {
+			baselineSamples = make(map[string]FingerprintSample, len(list))
+			for _, sample := range list {
+				baselineSamples[sample.Probe] = sample
+			}
+		}
+	}
+
+	// model 字段：未返回 model 的样本不参与计算
+	matched, reported := 0, 0
+	for _, sample := range samples {
+		if sample.Error != "" || sample.ReportedModel == "" {
+			continue
+		}
+		reported++
+		if reportedModelMatches(sample.ReportedModel, expected) {
+			matched++
+		}
+	}
+	if reported > 0 {
+		score := float64(matched) / float64(reported)
+		report.ModelScore = &score
+	}
+
+	// 分词器：与基线的 prompt_tokens / 本地估算 之比偏差 5% 以内满分，偏差 30% 及以上为 0
+	if baseline != nil && baseline.TokenRatio > 0 && report.TokenRatio > 0 {
+		deviation := math.Abs(report.TokenRatio/baseline.TokenRatio - 1)
+		score := math.Max(0, math.Min(1, 1-(deviation-0.05)/0.25))
+		report.TokenScore = &score
+	}
+
+	// 回答风格：与基线回答的词汇重合度及长度比
+	if len(baselineSamples) > 0 {
+		total, count := 0.0, 0
+		for _, sample := range samples {
+			base, ok := baselineSamples[sample.Probe]
+			if !ok || sample.Error != "" || base.Error != "" {
+				continue
+			}
+			total += styleSimilarity(sample.Content, base.Content)
+			count++
+		}
+		if count > 0 {
+			score := total / float64(count)
+			report.StyleScore = &score
+		}
+	}
+
+	weighted, weights := 0.0, 0.0
+	for _, part := range []struct {
+		score  *float64
+		weight float64
+	}{
+		{report.ModelScore, fingerprintModelWeight},
+		{report.TokenScore, fingerprintTokenWeight},
+		{report.StyleScore, fingerprintStyleWeight},
+	} {
+		if part.score != nil {
+			weighted += *part.score * part.weight
+			weights += part.weight
+		}
+	}
+	if weights == 0 {
+		report.Note = "上游未返回 model 字段且没有基线，无法判断"
+		return report
+	}
+	report.Score = math.Round(weighted/weights*10000) / 100
+	report.Flagged = report.Score < threshold
+	if baseline == nil {
+		report.Note = "没有基线，仅比对 model 字段"
+	}
+	return report
+}
+
+// snapshotSuffixRegex 模型快照版本后缀，如 -2024-08-06、-0613、-20241022、-latest
+var snapshotSuffixRegex = regexp.MustCompile(`^-(\d{4}-\d{2}-\d{2}|\d{4}|\d{8}|latest)$`)
+
+// reportedModelMatches 判断上游返回的模型名是否与期望一致，允许带日期的快照版本，但 gpt-4o-mini 不视为 gpt-4o
+func reportedModelMatches(reported string, expected []string) bool {
+	reported = normalizeFingerprintModel(reported)
+	for _, name := range expected {
+		name = normalizeFingerprintModel(name)
+		if name == "" {
+			continue
+		}
+		if reported == name {
+			return true
+		}
+		if strings.HasPrefix(reported, name) && snapshotSuffixRegex.MatchString(reported[len(name):]) {
+			return true
+		}
+		if strings.HasPrefix(name, reported) && snapshotSuffixRegex.MatchString(name[len(reported):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFingerprintModel 去掉 openai/ 之类的厂商前缀并转为小写
+func normalizeFingerprintModel(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		name = name[index+1:]
+	}
+	return name
+}
+
+func dominantReportedModel(samples []FingerprintSample) string {
+	counts := make(map[string]int)
+	dominant := ""
+	for _, sample := range samples {
+		if sample.ReportedModel == "" {
+			continue
+		}
+		counts[sample.ReportedModel]++
+		if counts[sample.ReportedModel] > counts[dominant] {
+			dominant = sample.ReportedModel
+		}
+	}
+	return dominant
+}
+
+// fingerprintTokenRatio 上游 prompt_tokens 与本地估算之比，上游未返回 usage 时为 0
+func fingerprintTokenRatio(samples []FingerprintSample) float64 {
+	upstream, local := 0, 0
+	for _, sample := range samples {
+		if sample.PromptTokens > 0 && sample.LocalTokens > 0 {
+			upstream += sample.PromptTokens
+			local += sample.LocalTokens
+		}
+	}
+	if local == 0 {
+		return 0
+	}
+	return float64(upstream) / float64(local)
+}
+
+// styleSimilarity 词汇 Jaccard 相似度与长度比的加权，取值 0-1
+func styleSimilarity(a string, b string) float64 {
+	wordsA, wordsB := fingerprintWords(a), fingerprintWords(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		if len(wordsA) == len(wordsB) {
+			return 1
+		}
+		return 0
+	}
+	intersection := 0
+	for word := range wordsA {
+		if wordsB[word] {
+			intersection++
+		}
+	}
+	jaccard := float64(intersection) / float64(len(wordsA)+len(wordsB)-intersection)
+	lengthA, lengthB := float64(len([]rune(a))), float64(len([]rune(b)))
+	lengthRatio := math.Min(lengthA, lengthB) / math.Max(lengthA, lengthB)
+	return 0.7*jaccard + 0.3*lengthRatio
+}
+
+// fingerprintWords 按非字母数字切分为小写词集合，中日韩文字按单字计
+func fingerprintWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			words[current.String()] = true
+			current.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			words[string(r)] = true
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+func formatFingerprintNotifyType(channelId int) string {
+	return fmt.Sprintf("%s_%d_fingerprint", dto.NotifyTypeChannelUpdate, channelId)
+}
+
+// applyFingerprintAction 渠道模型被标记时按设置通知、降低优先级或禁用渠道，渠道所有模型恢复正常后撤销
+func applyFingerprintAction(channelId int, modelName string, report *FingerprintReport) {
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		return
+	}
+	info := channel.GetOtherInfo()
+	_, alerting := info[otherInfoFingerprintFlagTime]
+	if report.Flagged {
+		if !alerting {
+			triggerFingerprintAlert(channel, modelName, report, info)
+		}
+		return
+	}
+	if !alerting {
+		return
+	}
+	if flagged, err := model.CountFlaggedChannelFingerprints(channelId); err != nil || flagged > 0 {
+		return
+	}
+	recoverFingerprintAlert(channel, info)
+}
+
+func fingerprintAlert(channel *model.Channel) *service.ChannelAlert {
+	setting := operation_setting.GetModelFingerprintSetting()
+	return &service.ChannelAlert{
+		Source:        "fingerprint",
+		TimeKey:       otherInfoFingerprintFlagTime,
+		Action:        setting.Action,
+		Priority:      &setting.DemotePriority,
+		DisableReason: fingerprintDisableReason,
+		NotifyType:    formatFingerprintNotifyType(channel.Id),
+	}
+}
+
+func triggerFingerprintAlert(channel *model.Channel, modelName string, report *FingerprintReport, info map[string]interface{}) {
+	detail := fmt.Sprintf("（模型 %s 得分 %.2f，上游返回 %s）", modelName, report.Score, report.ReportedModel)
+	service.TriggerChannelAlert(channel, info, fingerprintAlert(channel), detail,
+		fmt.Sprintf("通道「%s」（#%d）模型指纹异常", channel.Name, channel.Id),
+		fmt.Sprintf("通道「%s」（#%d）%s%s，低于阈值 %.2f", channel.Name, channel.Id, fingerprintDisableReason, detail,
+			operation_setting.GetModelFingerprintSetting().ScoreThreshold))
+}
+
+func recoverFingerprintAlert(channel *model.Channel, info map[string]interface{}) {
+	service.RecoverChannelAlert(channel, info, fingerprintAlert(channel),
+		fmt.Sprintf("通道「%s」（#%d）模型指纹已恢复正常", channel.Name, channel.Id),
+		fmt.Sprintf("通道「%s」（#%d）所有模型的指纹检测均已达标", channel.Name, channel.Id))
+}
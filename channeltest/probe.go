@@ -0,0 +1,342 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package channeltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/operation_setting"
+)
+
+// SLOBreach 渠道模型在 SLO 窗口内未达标的情况
+type SLOBreach struct {
+	ChannelId   int                     `json:"channel_id"`
+	ChannelName string                  `json:"channel_name"`
+	ModelName   string                  `json:"model_name"`
+	Reason      string                  `json:"reason"`
+	Since       int64                   `json:"since"`
+	Stat        *model.ChannelProbeStat `json:"stat"`
+}
+
+var (
+	probeRunning     bool
+	probeRunningLock sync.Mutex
+	probeLastRun     time.Time
+)
+
+// StartHealthProbe 定时按 渠道/模型 发起流式探测，仅在主节点运行
+func StartHealthProbe() {
+	for {
+		time.Sleep(time.Minute)
+		setting := operation_setting.GetHealthProbeSetting()
+		if !setting.Enabled {
+			continue
+		}
+		interval := time.Duration(max(setting.IntervalMinutes, 1)) * time.Minute
+		if time.Since(probeLastRun) < interval {
+			continue
+		}
+		probeLastRun = time.Now()
+		if err := RunHealthProbe(); err != nil {
+			common.SysError("health probe failed: " + err.Error())
+		}
+	}
+}
+
+func acquireHealthProbe() bool {
+	probeRunningLock.Lock()
+	defer probeRunningLock.Unlock()
+	if probeRunning {
+		return false
+	}
+	probeRunning = true
+	return true
+}
+
+func releaseHealthProbe() {
+	probeRunningLock.Lock()
+	probeRunning = false
+	probeRunningLock.Unlock()
+}
+
+// RunHealthProbe 执行一轮探测，随后汇总结果、检查 SLO 并清理过期数据
+func RunHealthProbe() error {
+	if !acquireHealthProbe() {
+		return fmt.Errorf("health probe is already running")
+	}
+	defer releaseHealthProbe()
+	return runHealthProbe()
+}
+
+// StartHealthProbeAsync 在后台执行一轮探测，已有探测在运行时返回 false
+func StartHealthProbeAsync() bool {
+	if !acquireHealthProbe() {
+		return false
+	}
+	go func() {
+		defer releaseHealthProbe()
+		if err := runHealthProbe(); err != nil {
+			common.SysError("health probe failed: " + err.Error())
+		}
+	}()
+	return true
+}
+
+func runHealthProbe() error {
+	setting := operation_setting.GetHealthProbeSetting()
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		return err
+	}
+	tasks := buildProbeTasks(channels, setting)
+	start := time.Now()
+	common.SysLog(fmt.Sprintf("health probe started, %d targets", len(tasks)))
+
+	taskCh := make(chan channelTestTask)
+	var wg sync.WaitGroup
+	for i := 0; i < max(setting.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskCh {
+				probeChannel(task)
+			}
+		}()
+	}
+	for _, task := range tasks {
+		taskCh <- task
+	}
+	close(taskCh)
+	wg.Wait()
+
+	if err = model.RollupChannelProbes(start.Unix()); err != nil {
+		common.SysError("failed to rollup channel probes: " + err.Error())
+	}
+	checkSLO(channels, setting)
+	purgeChannelProbes(setting)
+	common.SysLog(fmt.Sprintf("health probe finished in %s", time.Since(start).Round(time.Second)))
+	return nil
+}
+
+func probeSupported(channel *model.Channel) bool {
+	switch channel.Type {
+	case common.ChannelTypeMidjourney, common.ChannelTypeMidjourneyPlus, common.ChannelTypeSunoAPI,
+		common.ChannelTypeKling, common.ChannelTypeRunway:
+		return false
+	}
+	return true
+}
+
+func buildProbeTasks(channels []*model.Channel, setting *operation_setting.HealthProbeSetting) []channelTestTask {
+	var tasks []channelTestTask
+	for _, channel := range channels {
+		if channel.Status != common.ChannelStatusEnabled || !probeSupported(channel) {
+			continue
+		}
+		count := 0
+		for _, modelName := range channel.GetModels() {
+			if modelName == "" || (len(setting.Models) > 0 && !common.StringsContains(setting.Models, modelName)) {
+				continue
+			}
+			if setting.MaxModelsPerChannel > 0 && count >= setting.MaxModelsPerChannel {
+				break
+			}
+			tasks = append(tasks, channelTestTask{channel: channel, model: modelName})
+			count++
+		}
+	}
+	return tasks
+}
+
+func probeChannel(task channelTestTask) {
+	consumed, firstResponse, err, openAIError := ExecuteChannelProbe(task.channel, task.model)
+	probe := &model.ChannelProbe{
+		ChannelId:       task.channel.Id,
+		ModelName:       task.model,
+		Success:         err == nil,
+		LatencyMs:       int(consumed * 1000),
+		FirstResponseMs: int(firstResponse * 1000),
+		CreatedAt:       common.GetTimestamp(),
+	}
+	if err != nil {
+		message := err.Error()
+		if openAIError != nil {
+			message = fmt.Sprintf("status code %d: %s", openAIError.StatusCode, openAIError.Error.Message)
+		}
+		if runes := []rune(message); len(runes) > 512 {
+			message = string(runes[:512])
+		}
+		probe.Error = message
+	}
+	if insertErr := probe.Insert(); insertErr != nil {
+		common.SysError("failed to save channel probe: " + insertErr.Error())
+	}
+}
+
+func sloBreachReason(stat *model.ChannelProbeStat, setting *operation_setting.HealthProbeSetting) string {
+	if setting.SLOSuccessRate > 0 && stat.Uptime < setting.SLOSuccessRate {
+		return fmt.Sprintf("成功率 %.2f%% 低于目标 %.2f%%", stat.Uptime, setting.SLOSuccessRate)
+	}
+	if setting.SLOLatencyMs > 0 && stat.AvgLatencyMs > int64(setting.SLOLatencyMs) {
+		return fmt.Sprintf("平均耗时 %dms 超过目标 %dms", stat.AvgLatencyMs, setting.SLOLatencyMs)
+	}
+	if setting.SLOFirstResponseMs > 0 && stat.AvgFirstResponseMs > int64(setting.SLOFirstResponseMs) {
+		return fmt.Sprintf("平均首字耗时 %dms 超过目标 %dms", stat.AvgFirstResponseMs, setting.SLOFirstResponseMs)
+	}
+	return ""
+}
+
+// checkSLO 检查 SLO 窗口内的探测结果，在渠道模型开始不达标与恢复时通知管理员。
+// 未达标记录保存在数据库中，重启后不会重复通知，也可在任意节点查询
+func checkSLO(channels []*model.Channel, setting *operation_setting.HealthProbeSetting) {
+	window := time.Duration(max(setting.SLOWindowMinutes, 1)) * time.Minute
+	stats, err := model.GetRecentChannelProbeStats(time.Now().Add(-window).Unix())
+	if err != nil {
+		common.SysError("failed to get channel probe stats: " + err.Error())
+		return
+	}
+	previous, err := loadSLOBreaches()
+	if err != nil {
+		common.SysError("failed to get channel slo breaches: " + err.Error())
+		return
+	}
+	channelNames := make(map[int]string, len(channels))
+	for _, channel := range channels {
+		channelNames[channel.Id] = channel.Name
+	}
+	current := make(map[string]*SLOBreach)
+	for _, stat := range stats {
+		reason := sloBreachReason(stat, setting)
+		if reason == "" {
+			continue
+		}
+		current[fmt.Sprintf("%d:%s", stat.ChannelId, stat.ModelName)] = &SLOBreach{
+			ChannelId:   stat.ChannelId,
+			ChannelName: channelNames[stat.ChannelId],
+			ModelName:   stat.ModelName,
+			Reason:      reason,
+			Since:       common.GetTimestamp(),
+			Stat:        stat,
+		}
+	}
+
+	for key, breach := range current {
+		if old, ok := previous[key]; ok {
+			breach.Since = old.Since
+			continue
+		}
+		subject := fmt.Sprintf("通道「%s」（#%d）模型 %s 未达到 SLO", breach.ChannelName, breach.ChannelId, breach.ModelName)
+		content := fmt.Sprintf("%s：最近 %d 分钟共探测 %d 次，%s", subject, setting.SLOWindowMinutes, breach.Stat.Total, breach.Reason)
+		notifySLO(breach, subject, content)
+	}
+	for key, breach := range previous {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		subject := fmt.Sprintf("通道「%s」（#%d）模型 %s 已恢复", breach.ChannelName, breach.ChannelId, breach.ModelName)
+		notifySLO(breach, subject, subject)
+	}
+	if err = saveSLOBreaches(current); err != nil {
+		common.SysError("failed to save channel slo breaches: " + err.Error())
+	}
+}
+
+func loadSLOBreaches() (map[string]*SLOBreach, error) {
+	records, err := model.GetChannelSLOBreaches()
+	if err != nil {
+		return nil, err
+	}
+	breaches := make(map[string]*SLOBreach, len(records))
+	for _, record := range records {
+		breach := &SLOBreach{
+			ChannelId:   record.ChannelId,
+			ChannelName: record.ChannelName,
+			ModelName:   record.ModelName,
+			Reason:      record.Reason,
+			Since:       record.Since,
+		}
+		if record.Stat != "" {
+			var stat model.ChannelProbeStat
+			if err = common.DecodeJsonStr(record.Stat, &stat); err == nil {
+				breach.Stat = &stat
+			}
+		}
+		breaches[fmt.Sprintf("%d:%s", record.ChannelId, record.ModelName)] = breach
+	}
+	return breaches, nil
+}
+
+func saveSLOBreaches(breaches map[string]*SLOBreach) error {
+	records := make([]*model.ChannelSLOBreach, 0, len(breaches))
+	for _, breach := range breaches {
+		stat, _ := json.Marshal(breach.Stat)
+		records = append(records, &model.ChannelSLOBreach{
+			ChannelId:   breach.ChannelId,
+			ChannelName: breach.ChannelName,
+			ModelName:   breach.ModelName,
+			Reason:      breach.Reason,
+			Since:       breach.Since,
+			Stat:        string(stat),
+		})
+	}
+	return model.ReplaceChannelSLOBreaches(records)
+}
+
+func notifySLO(breach *SLOBreach, subject string, content string) {
+	notifyType := fmt.Sprintf("%s_%d_slo", dto.NotifyTypeChannelTest, breach.ChannelId)
+	service.NotifyRootUser(notifyType, subject, content)
+}
+
+// GetSLOBreaches 返回当前未达到 SLO 的渠道模型
+func GetSLOBreaches() ([]*SLOBreach, error) {
+	current, err := loadSLOBreaches()
+	if err != nil {
+		return nil, err
+	}
+	breaches := make([]*SLOBreach, 0, len(current))
+	for _, breach := range current {
+		breaches = append(breaches, breach)
+	}
+	sort.Slice(breaches, func(i, j int) bool {
+		if breaches[i].ChannelId != breaches[j].ChannelId {
+			return breaches[i].ChannelId < breaches[j].ChannelId
+		}
+		return breaches[i].ModelName < breaches[j].ModelName
+	})
+	return breaches, nil
+}
+
+func purgeChannelProbes(setting *operation_setting.HealthProbeSetting) {
+	now := time.Now()
+	if setting.RawRetentionDays > 0 {
+		if _, err := model.DeleteChannelProbesBefore(now.AddDate(0, 0, -setting.RawRetentionDays).Unix()); err != nil {
+			common.SysError("failed to purge channel probes: " + err.Error())
+		}
+	}
+	if setting.RollupRetentionDays > 0 {
+		if _, err := model.DeleteChannelProbeRollupsBefore(now.AddDate(0, 0, -setting.RollupRetentionDays).Unix()); err != nil {
+			common.SysError("failed to purge channel probe rollups: " + err.Error())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package channeltest
 
 import (
     "context"
+    "encoding/json"
     "errors"
     "fmt"
     "math"
@@ -341,6 +342,8 @@ func (r *ChannelTestJobRunner) executeSingleTask(ctx context.Context, job *model
                 DurationMillis: int(math.Round(consumed * 1000)),
                 RetryCount:     attempt,
             }
+            r.attachConformance(ctx, job, task, result)
+            r.attachFingerprint(ctx, job, task, result)
             return result, false
         }
 
@@ -387,6 +390,45 @@ func (r *ChannelTestJobRunner) executeSingleTask(ctx context.Context, job *model
     return result, false
 }
 
+// attachConformance 基础测试成功后按任务配置执行能力一致性测试，结果写入能力矩阵并附加到测试结果
+func (r *ChannelTestJobRunner) attachConformance(ctx context.Context, job *model.ChannelTestJob, task channelTestTask, result *model.ChannelTestResult) {
+    if ctx.Err() != nil {
+        return
+    }
+    options, err := job.GetOptions()
+    if err != nil || !options.Conformance {
+        return
+    }
+    results := RunConformance(task.channel, task.model, options.ConformanceChecks)
+    if err := SaveConformanceResults(task.channel.Id, task.model, job.ID, results); err != nil {
+        common.SysError(fmt.Sprintf("保存渠道 #%d 模型 %s 的能力测试结果失败: %v", task.channel.Id, task.model, err))
+    }
+    if data, err := json.Marshal(results); err == nil {
+        result.Capabilities = string(data)
+    }
+}
+
+// attachFingerprint 基础测试成功后按任务配置执行模型指纹检测，检测结果不含探测响应明细
+func (r *ChannelTestJobRunner) attachFingerprint(ctx context.Context, job *model.ChannelTestJob, task channelTestTask, result *model.ChannelTestResult) {
+    if ctx.Err() != nil {
+        return
+    }
+    options, err := job.GetOptions()
+    if err != nil || !options.Fingerprint {
+        return
+    }
+    report, err := RunFingerprint(task.channel, task.model, job.ID)
+    if err != nil {
+        common.SysError(fmt.Sprintf("渠道 #%d 模型 %s 指纹检测失败: %v", task.channel.Id, task.model, err))
+        return
+    }
+    summary := *report
+    summary.Samples = nil
+    if data, err := json.Marshal(summary); err == nil {
+        result.Fingerprint = string(data)
+    }
+}
+
 // handle429RetryAsync 异步处理429重试，不阻塞worker线程
 func (r *ChannelTestJobRunner) handle429RetryAsync(ctx context.Context, job *model.ChannelTestJob, task channelTestTask, retryLimit int, retryWG *sync.WaitGroup, processed *int64, initialAttempt int) {
     defer retryWG.Done()
@@ -424,6 +466,8 @@ func (r *ChannelTestJobRunner) handle429RetryAsync(ctx context.Context, job *mod
                 DurationMillis: int(math.Round(consumed * 1000)),
                 RetryCount:     initialAttempt + retry429Count,
             }
+            r.attachConformance(ctx, job, task, result)
+            r.attachFingerprint(ctx, job, task, result)
             _ = model.AddChannelTestResult(result)
             _ = model.IncrementChannelTestJobCounters(job.ID, result.ChannelID, result.ModelName, result.Success)
             atomic.AddInt64(processed, 1)
@@ -151,6 +151,10 @@ var BatchUpdateInterval int
 
 var RelayTimeout int // unit is second
 
+// AuditDir 审计归档文件的存放目录，AuditEncryptionKey 为归档加密密钥，未设置时不进行归档
+var AuditDir string
+var AuditEncryptionKey string
+
 var GeminiSafetySetting string
 
 // https://docs.cohere.com/docs/safety-modes Type; NONE/CONTEXTUAL/STRICT
@@ -272,6 +276,8 @@ const (
 	ChannelTypeXinference     = 47
 	ChannelTypeXai            = 48
 	ChannelTypeGitHub         = 49
+	ChannelTypeKling          = 50
+	ChannelTypeRunway         = 51
 	ChannelTypeDummy          // this one is only for count, do not add any channel after this
 
 )
@@ -327,4 +333,6 @@ var ChannelBaseURLs = []string{
 	"",                                          //47
 	"https://api.x.ai",                          //48
 	"https://models.github.ai/inference",        //49
+	"https://api.klingai.com",                   //50
+	"https://api.dev.runwayml.com",              //51
 }
@@ -102,6 +102,8 @@ func LoadEnv() {
 	// Initialize string variables with GetEnvOrDefaultString
 	GeminiSafetySetting = GetEnvOrDefaultString("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
 	CohereSafetySetting = GetEnvOrDefaultString("COHERE_SAFETY_SETTING", "NONE")
+	AuditDir = GetEnvOrDefaultString("AUDIT_DIR", "./data/audit")
+	AuditEncryptionKey = os.Getenv("AUDIT_ENCRYPTION_KEY")
 
 	// Initialize rate limit variables
 	GlobalApiRateLimitEnable = GetEnvOrDefaultBool("GLOBAL_API_RATE_LIMIT_ENABLE", true)
@@ -17,6 +17,8 @@
 package common
 
 import (
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -84,3 +86,37 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// State 返回 key 在 duration 秒窗口内的剩余请求数，以及最早一条记录移出窗口前的秒数
+func (l *InMemoryRateLimiter) State(key string, maxRequestNum int, duration int64) (int, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok {
+		return maxRequestNum, 0
+	}
+	now := time.Now().Unix()
+	used := 0
+	var reset int64
+	for _, t := range *queue {
+		if now-t < duration {
+			if used == 0 {
+				reset = t + duration - now
+			}
+			used++
+		}
+	}
+	return max(maxRequestNum-used, 0), reset
+}
+
+// SetRateLimitHeaders 写入 OpenAI 兼容的 x-ratelimit-* 响应头，kind 为 requests 或 tokens
+func SetRateLimitHeaders(header http.Header, kind string, limit int, remaining int, resetSeconds int64) {
+	header.Set("x-ratelimit-limit-"+kind, strconv.Itoa(limit))
+	header.Set("x-ratelimit-remaining-"+kind, strconv.Itoa(remaining))
+	header.Set("x-ratelimit-reset-"+kind, (time.Duration(max(resetSeconds, 0)) * time.Second).String())
+}
+
+// SetRetryAfter 写入 Retry-After 响应头，至少为 1 秒
+func SetRetryAfter(header http.Header, seconds int64) {
+	header.Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+}
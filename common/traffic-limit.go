@@ -0,0 +1,216 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TPM 使用按分钟分桶的滑动窗口近似：当前分钟用量 + 上一分钟用量 * 剩余比例
+
+const trafficLimitKeyPrefix = "trafficLimit"
+
+// 并发计数的兜底过期时间，防止进程异常退出导致计数无法释放
+var ConcurrencyKeyExpirationDuration = 10 * time.Minute
+
+var acquireConcurrencyScript = redis.NewScript(`
+local current = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+if current > tonumber(ARGV[1]) then
+	redis.call('DECR', KEYS[1])
+	return -1
+end
+return current
+`)
+
+var reserveTPMScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local used = current + math.floor(previous * tonumber(ARGV[3]) / 60)
+if used + tonumber(ARGV[2]) > tonumber(ARGV[1]) then
+	return {0, used}
+end
+redis.call('INCRBY', KEYS[1], ARGV[2])
+redis.call('EXPIRE', KEYS[1], 120)
+return {1, used + tonumber(ARGV[2])}
+`)
+
+type tpmBucket struct {
+	minute   int64
+	current  int
+	previous int
+}
+
+type memoryTrafficLimiter struct {
+	mutex       sync.Mutex
+	concurrency map[string]int
+	tpm         map[string]*tpmBucket
+}
+
+var trafficLimiter = &memoryTrafficLimiter{
+	concurrency: make(map[string]int),
+	tpm:         make(map[string]*tpmBucket),
+}
+
+func concurrencyKey(key string) string {
+	return fmt.Sprintf("%s:concurrency:%s", trafficLimitKeyPrefix, key)
+}
+
+func tpmKey(key string, minute int64) string {
+	return fmt.Sprintf("%s:tpm:%s:%d", trafficLimitKeyPrefix, key, minute)
+}
+
+// AcquireConcurrency 占用一个并发名额，返回是否成功以及当前并发数
+func AcquireConcurrency(key string, limit int) (bool, int, error) {
+	if RedisEnabled {
+		result, err := acquireConcurrencyScript.Run(context.Background(), RDB, []string{concurrencyKey(key)},
+			limit, int(ConcurrencyKeyExpirationDuration.Seconds())).Int()
+		if err != nil {
+			return false, 0, err
+		}
+		if result < 0 {
+			return false, limit, nil
+		}
+		return true, result, nil
+	}
+	trafficLimiter.mutex.Lock()
+	defer trafficLimiter.mutex.Unlock()
+	current := trafficLimiter.concurrency[key]
+	if current >= limit {
+		return false, current, nil
+	}
+	trafficLimiter.concurrency[key] = current + 1
+	return true, current + 1, nil
+}
+
+// ReleaseConcurrency 释放一个并发名额
+func ReleaseConcurrency(key string) {
+	if RedisEnabled {
+		ctx := context.Background()
+		current, err := RDB.Decr(ctx, concurrencyKey(key)).Result()
+		if err != nil {
+			SysError("failed to release concurrency: " + err.Error())
+			return
+		}
+		if current < 0 {
+			RDB.Set(ctx, concurrencyKey(key), 0, ConcurrencyKeyExpirationDuration)
+		}
+		return
+	}
+	trafficLimiter.mutex.Lock()
+	defer trafficLimiter.mutex.Unlock()
+	if trafficLimiter.concurrency[key] <= 1 {
+		delete(trafficLimiter.concurrency, key)
+		return
+	}
+	trafficLimiter.concurrency[key]--
+}
+
+// ReserveTPM 在当前分钟预占 tokens 个 token，返回是否成功、窗口内已用量（成功时包含本次预占）以及预占所在分钟
+func ReserveTPM(key string, limit int, tokens int) (bool, int, int64, error) {
+	now := time.Now()
+	minute := now.Unix() / 60
+	remainSeconds := 60 - now.Unix()%60
+	if RedisEnabled {
+		result, err := reserveTPMScript.Run(context.Background(), RDB,
+			[]string{tpmKey(key, minute), tpmKey(key, minute-1)}, limit, tokens, remainSeconds).Int64Slice()
+		if err != nil {
+			return false, 0, minute, err
+		}
+		if len(result) != 2 {
+			return false, 0, minute, fmt.Errorf("unexpected tpm script result: %v", result)
+		}
+		return result[0] == 1, int(result[1]), minute, nil
+	}
+	trafficLimiter.mutex.Lock()
+	defer trafficLimiter.mutex.Unlock()
+	bucket := trafficLimiter.rotate(key, minute)
+	used := bucket.current + int(int64(bucket.previous)*remainSeconds/60)
+	if used+tokens > limit {
+		return false, used, minute, nil
+	}
+	bucket.current += tokens
+	return true, used + tokens, minute, nil
+}
+
+// AdjustTPM 修正 minute 分钟内的 token 用量，delta 可以为负数
+func AdjustTPM(key string, minute int64, delta int) {
+	if delta == 0 {
+		return
+	}
+	if RedisEnabled {
+		ctx := context.Background()
+		redisKey := tpmKey(key, minute)
+		if err := RDB.IncrBy(ctx, redisKey, int64(delta)).Err(); err != nil {
+			SysError("failed to adjust tpm: " + err.Error())
+			return
+		}
+		RDB.Expire(ctx, redisKey, 120*time.Second)
+		return
+	}
+	trafficLimiter.mutex.Lock()
+	defer trafficLimiter.mutex.Unlock()
+	bucket := trafficLimiter.rotate(key, time.Now().Unix()/60)
+	switch minute {
+	case bucket.minute:
+		bucket.current = max(bucket.current+delta, 0)
+	case bucket.minute - 1:
+		bucket.previous = max(bucket.previous+delta, 0)
+	}
+}
+
+func (l *memoryTrafficLimiter) rotate(key string, minute int64) *tpmBucket {
+	bucket, ok := l.tpm[key]
+	if !ok {
+		bucket = &tpmBucket{minute: minute}
+		l.tpm[key] = bucket
+		return bucket
+	}
+	switch {
+	case bucket.minute == minute:
+	case bucket.minute == minute-1:
+		bucket.previous = bucket.current
+		bucket.current = 0
+		bucket.minute = minute
+	default:
+		bucket.previous = 0
+		bucket.current = 0
+		bucket.minute = minute
+	}
+	return bucket
+}
+
+func init() {
+	go func() {
+		for {
+			time.Sleep(5 * time.Minute)
+			minute := time.Now().Unix() / 60
+			trafficLimiter.mutex.Lock()
+			for key, bucket := range trafficLimiter.tpm {
+				if bucket.minute < minute-1 {
+					delete(trafficLimiter.tpm, key)
+				}
+			}
+			trafficLimiter.mutex.Unlock()
+		}
+	}()
+}
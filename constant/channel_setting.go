@@ -23,4 +23,8 @@ var (
 	ChannelSettingStreamSupport     = "stream_support"      // StreamSupport 控制上游流式请求行为
 	StreamSupportNonStreamOnly      = "NON_STREAM_ONLY"     // StreamSupport 仅非流式请求
 	ChannelSettingPassThrough       = "pass_through"        // PassThrough 单渠道透传开关
+	ChannelSettingTPMLimit          = "tpm_limit"           // TPMLimit 渠道每分钟 token 上限
+	ChannelSettingConcurrencyLimit  = "concurrency_limit"   // ConcurrencyLimit 渠道并发请求上限
+	ChannelSettingBalance           = "balance"             // Balance 余额查询方式与余额告警
+	ChannelSettingCost              = "cost"                // Cost 上游成本价格表或折扣
 )
@@ -17,10 +17,30 @@
 package constant
 
 const (
-	ContextKeyRequestStartTime = "request_start_time"
-	ContextKeyUserSetting      = "user_setting"
-	ContextKeyUserQuota        = "user_quota"
-	ContextKeyUserStatus       = "user_status"
-	ContextKeyUserEmail        = "user_email"
-	ContextKeyUserGroup        = "user_group"
+	ContextKeyRequestStartTime     = "request_start_time"
+	ContextKeyUserSetting          = "user_setting"
+	ContextKeyUserQuota            = "user_quota"
+	ContextKeyUserStatus           = "user_status"
+	ContextKeyUserEmail            = "user_email"
+	ContextKeyUserGroup            = "user_group"
+	ContextKeyUserSpendingLimit    = "user_spending_limit"
+	ContextKeyUserTPMLimit         = "user_tpm_limit"
+	ContextKeyUserRPMLimit         = "user_rpm_limit"
+	ContextKeyUserConcurrencyLimit = "user_concurrency_limit"
+	ContextKeyUserPlanId           = "user_plan_id"
+	// ContextKeySubscriptionExcluded 套餐不支持当前模型，本次请求只能使用余额
+	ContextKeySubscriptionExcluded = "subscription_excluded"
+
+	ContextKeyTokenSpendingLimit = "token_spending_limit"
+
+	ContextKeyOrgId                  = "org_id"
+	ContextKeyOrgMemberId            = "org_member_id"
+	ContextKeyOrgMemberSpendingLimit = "org_member_spending_limit"
+
+	ContextKeyModerationHits     = "moderation_hits"
+	ContextKeyStreamOutputFilter = "stream_output_filter"
+	ContextKeyPiiMasker          = "pii_masker"
+	ContextKeyAuditWriter        = "audit_writer"
+	ContextKeyUpstreamTrace      = "upstream_trace"
+	ContextKeyTrafficConcurrency = "traffic_concurrency"
 )
@@ -21,6 +21,7 @@ type TaskPlatform string
 const (
 	TaskPlatformSuno       TaskPlatform = "suno"
 	TaskPlatformMidjourney              = "mj"
+	TaskPlatformVideo      TaskPlatform = "video"
 )
 
 const (
@@ -28,6 +29,11 @@ const (
 	SunoActionLyrics = "LYRICS"
 )
 
+const (
+	VideoActionTextToVideo  = "TEXT_TO_VIDEO"
+	VideoActionImageToVideo = "IMAGE_TO_VIDEO"
+)
+
 var SunoModel2Action = map[string]string{
 	"suno_music":  SunoActionMusic,
 	"suno_lyrics": SunoActionLyrics,
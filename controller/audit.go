@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAuditRecords(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	filter := &model.AuditRecordFilter{
+		RequestId: c.Query("request_id"),
+		Username:  c.Query("username"),
+		ModelName: c.Query("model_name"),
+		Group:     c.Query("group"),
+	}
+	filter.UserId, _ = strconv.Atoi(c.Query("user_id"))
+	filter.TokenId, _ = strconv.Atoi(c.Query("token_id"))
+	filter.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	records, total, err := model.GetAuditRecords(filter, (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     records,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// auditBody JSON 内容原样返回，其他内容（如截断后的 JSON）以字符串返回
+func auditBody(body []byte) any {
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
+
+func GetAuditRecord(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	record, err := model.GetAuditRecordById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "归档不存在",
+		})
+		return
+	}
+	request, response, err := service.ReadAuditRecordBodies(record)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	common.LogInfo(c, fmt.Sprintf("admin %d viewed audit record %d", c.GetInt("id"), record.Id))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"record":   record,
+			"request":  auditBody(request),
+			"response": auditBody(response),
+		},
+	})
+}
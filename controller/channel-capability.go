@@ -0,0 +1,47 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"veloera/channeltest"
+	"veloera/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelCapabilities 返回 渠道/模型 的能力矩阵，结果由批量测试的能力一致性测试写入
+func GetChannelCapabilities(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	capabilities, err := model.GetChannelCapabilities(channelId, c.Query("model"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"checks": channeltest.ConformanceChecks,
+			"items":  capabilities,
+		},
+	})
+}
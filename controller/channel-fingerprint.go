@@ -0,0 +1,159 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"veloera/channeltest"
+	"veloera/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fingerprintRequest struct {
+	ChannelId int    `json:"channel_id"`
+	Model     string `json:"model"`
+}
+
+// bindFingerprintRequest 解析渠道与模型参数，失败时已写入响应
+func bindFingerprintRequest(c *gin.Context) (*model.Channel, string, bool) {
+	var req fingerprintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return nil, "", false
+	}
+	modelName := strings.TrimSpace(req.Model)
+	if req.ChannelId == 0 || modelName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请指定渠道与模型",
+		})
+		return nil, "", false
+	}
+	channel, err := model.GetChannelById(req.ChannelId, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, "", false
+	}
+	return channel, modelName, true
+}
+
+// GetChannelFingerprints 返回 渠道/模型 的指纹检测结果，flagged=true 时只返回被标记的结果
+func GetChannelFingerprints(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	fingerprints, err := model.GetChannelFingerprints(channelId, c.Query("model"), c.Query("flagged") == "true")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    fingerprints,
+	})
+}
+
+// RunChannelFingerprint 立即对指定渠道模型执行指纹检测
+func RunChannelFingerprint(c *gin.Context) {
+	channel, modelName, ok := bindFingerprintRequest(c)
+	if !ok {
+		return
+	}
+	report, err := channeltest.RunFingerprint(channel, modelName, 0)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    report,
+	})
+}
+
+func GetFingerprintBaselines(c *gin.Context) {
+	baselines, err := model.GetModelFingerprintBaselines()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    baselines,
+	})
+}
+
+// CaptureFingerprintBaseline 以指定的可信渠道采集模型指纹基线
+func CaptureFingerprintBaseline(c *gin.Context) {
+	channel, modelName, ok := bindFingerprintRequest(c)
+	if !ok {
+		return
+	}
+	baseline, err := channeltest.CaptureFingerprintBaseline(channel, modelName)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    baseline,
+	})
+}
+
+func DeleteFingerprintBaseline(c *gin.Context) {
+	modelName := strings.TrimSpace(c.Query("model"))
+	if modelName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请指定模型",
+		})
+		return
+	}
+	if err := model.DeleteModelFingerprintBaseline(modelName); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"veloera/channeltest"
+	"veloera/model"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+var probeWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+func probeWindowSince(c *gin.Context) int64 {
+	window, ok := probeWindows[c.Query("window")]
+	if !ok {
+		window = probeWindows["24h"]
+	}
+	return time.Now().Add(-window).Unix()
+}
+
+// GetChannelProbeStats 按 渠道/模型 统计窗口内的可用率、平均耗时与首字耗时
+func GetChannelProbeStats(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	stats, err := model.GetChannelProbeStats(probeWindowSince(c), channelId, c.Query("model"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
+// GetChannelProbeHistory 返回窗口内的小时汇总序列
+func GetChannelProbeHistory(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	history, err := model.GetChannelProbeHistory(probeWindowSince(c), channelId, c.Query("model"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    history,
+	})
+}
+
+// GetLatestChannelProbes 返回最近的原始探测记录
+func GetLatestChannelProbes(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	probes, err := model.GetLatestChannelProbes(channelId, c.Query("model"), limit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    probes,
+	})
+}
+
+// GetChannelSLOBreaches 返回当前未达到 SLO 的渠道模型
+func GetChannelSLOBreaches(c *gin.Context) {
+	breaches, err := channeltest.GetSLOBreaches()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    breaches,
+	})
+}
+
+// RunChannelProbe 立即在后台执行一轮健康探测，已有探测在运行时直接返回
+func RunChannelProbe(c *gin.Context) {
+	if !channeltest.StartHealthProbeAsync() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "健康探测正在运行，请稍后再试",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// ModelAvailability 公开状态页中单个模型的可用率，窗口内没有探测数据时对应字段为 null
+type ModelAvailability struct {
+	ModelName          string   `json:"model_name"`
+	Uptime24h          *float64 `json:"uptime_24h"`
+	Uptime7d           *float64 `json:"uptime_7d"`
+	Uptime30d          *float64 `json:"uptime_30d"`
+	AvgLatencyMs       *int64   `json:"avg_latency_ms"`
+	AvgFirstResponseMs *int64   `json:"avg_first_response_ms"`
+}
+
+var (
+	modelStatusCache     []*ModelAvailability
+	modelStatusCacheTime time.Time
+	modelStatusCacheLock sync.Mutex
+)
+
+func buildModelAvailability() ([]*ModelAvailability, error) {
+	now := time.Now()
+	availability := make(map[string]*ModelAvailability)
+	var names []string
+	for _, window := range []string{"30d", "7d", "24h"} {
+		stats, err := model.GetModelProbeStats(now.Add(-probeWindows[window]).Unix())
+		if err != nil {
+			return nil, err
+		}
+		for _, stat := range stats {
+			if stat.Total == 0 {
+				continue
+			}
+			item, ok := availability[stat.ModelName]
+			if !ok {
+				item = &ModelAvailability{ModelName: stat.ModelName}
+				availability[stat.ModelName] = item
+				names = append(names, stat.ModelName)
+			}
+			switch window {
+			case "30d":
+				item.Uptime30d = &stat.Uptime
+			case "7d":
+				item.Uptime7d = &stat.Uptime
+			case "24h":
+				item.Uptime24h = &stat.Uptime
+				item.AvgLatencyMs = &stat.AvgLatencyMs
+				if stat.FirstResponseCount > 0 {
+					item.AvgFirstResponseMs = &stat.AvgFirstResponseMs
+				}
+			}
+		}
+	}
+	result := make([]*ModelAvailability, 0, len(names))
+	for _, name := range names {
+		result = append(result, availability[name])
+	}
+	return result, nil
+}
+
+// GetModelStatus 公开状态页：各模型最近 24 小时、7 天、30 天的可用率，结果缓存一分钟
+func GetModelStatus(c *gin.Context) {
+	if !operation_setting.GetHealthProbeSetting().PublicStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "状态页未开启",
+		})
+		return
+	}
+	modelStatusCacheLock.Lock()
+	defer modelStatusCacheLock.Unlock()
+	if modelStatusCache == nil || time.Since(modelStatusCacheTime) > time.Minute {
+		availability, err := buildModelAvailability()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		modelStatusCache = availability
+		modelStatusCacheTime = time.Now()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    modelStatusCache,
+	})
+}
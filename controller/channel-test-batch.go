@@ -28,6 +28,9 @@ type batchModelTestRequest struct {
     Concurrency       int      `json:"concurrency"`
     IntervalMs        int      `json:"interval_ms"`
     RetryLimit        int      `json:"retry_limit"`
+    Conformance       bool     `json:"conformance"`
+    ConformanceChecks []string `json:"conformance_checks"`
+    Fingerprint       bool     `json:"fingerprint"`
 }
 
 type batchDeleteFailedRequest struct {
@@ -86,6 +89,16 @@ func StartChannelBatchTest(c *gin.Context) {
     whitelist := sanitizeStringList(req.ModelWhitelist)
     blacklist := sanitizeStringList(req.ModelBlacklist)
     targetModels := sanitizeStringList(req.TargetModels)
+    conformanceChecks := sanitizeStringList(req.ConformanceChecks)
+    for _, check := range conformanceChecks {
+        if !channeltest.IsValidConformanceCheck(check) {
+            c.JSON(http.StatusBadRequest, gin.H{
+                "success": false,
+                "message": fmt.Sprintf("未知的能力测试项: %s", check),
+            })
+            return
+        }
+    }
 
     if testMode == model.ChannelTestJobModeSelected && len(targetModels) == 0 {
         c.JSON(http.StatusBadRequest, gin.H{
@@ -115,6 +128,9 @@ func StartChannelBatchTest(c *gin.Context) {
     options.UseChannelDefault = req.UseChannelDefault
     options.TestMode = testMode
     options.TargetModels = targetModels
+    options.Conformance = req.Conformance
+    options.ConformanceChecks = conformanceChecks
+    options.Fingerprint = req.Fingerprint
 
     if err := job.SetOptions(options); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{
@@ -983,6 +999,9 @@ func RetryJobInBackground(c *gin.Context) {
     retryOptions.ModelScope = originalOptions.ModelScope
     retryOptions.ModelWhitelist = originalOptions.ModelWhitelist
     retryOptions.ModelBlacklist = originalOptions.ModelBlacklist
+    retryOptions.Conformance = originalOptions.Conformance
+    retryOptions.ConformanceChecks = originalOptions.ConformanceChecks
+    retryOptions.Fingerprint = originalOptions.Fingerprint
 
     // 创建新的重试任务
     retryJob := &model.ChannelTestJob{
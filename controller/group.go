@@ -40,9 +40,13 @@ func GetUserGroups(c *gin.Context) {
 	userGroup := ""
 	userId := c.GetInt("id")
 	userGroup, _ = model.GetUserGroup(userId, false)
+	var planGroups []string
+	if user, err := model.GetUserCache(userId); err == nil {
+		planGroups = model.GetPlanGroups(user.PlanId)
+	}
 	for groupName, ratio := range setting.GetGroupRatioCopy() {
 		// UserUsableGroups contains the groups that the user can use
-		userUsableGroups := setting.GetUserUsableGroups(userGroup)
+		userUsableGroups := setting.GetUserUsableGroups(userGroup, planGroups...)
 		if desc, ok := userUsableGroups[groupName]; ok {
 			usableGroups[groupName] = map[string]interface{}{
 				"ratio": ratio,
@@ -180,6 +180,34 @@ func GetLogsStat(c *gin.Context) {
 	return
 }
 
+// GetLogsMarginStat 按渠道、模型、分组或天汇总收入、上游成本与毛利
+func GetLogsMarginStat(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", model.MarginGroupByChannel)
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	stats, err := model.GetMarginStats(model.MarginFilter{
+		GroupBy:        groupBy,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ChannelId:      channel,
+		ModelName:      c.Query("model_name"),
+		Group:          c.Query("group"),
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
 func GetLogsSelfStat(c *gin.Context) {
 	username := c.GetString("username")
 	logType, _ := strconv.Atoi(c.Query("type"))
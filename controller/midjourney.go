@@ -40,7 +40,12 @@ func UpdateMidjourneyTaskBulk() {
 	for {
 		time.Sleep(time.Duration(15) * time.Second)
 
-		tasks := model.GetAllUnFinishTasks()
+		// 多节点部署时只轮询本节点持有租约的分片
+		shards := service.AcquireTaskPollerShards(service.TaskPollerMidjourney)
+		if shards == nil {
+			continue
+		}
+		tasks := model.GetUnfinishedMidjourneyTasksByShards(shards.Count(), shards.Shards())
 		if len(tasks) == 0 {
 			continue
 		}
@@ -55,6 +60,9 @@ func UpdateMidjourneyTaskBulk() {
 				nullTaskIds = append(nullTaskIds, task.Id)
 				continue
 			}
+			if !shards.ShouldPoll(task.ChannelId) {
+				continue
+			}
 			taskM[task.MjId] = task
 			taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.MjId)
 		}
@@ -89,6 +97,7 @@ func UpdateMidjourneyTaskBulk() {
 				if err != nil {
 					common.LogInfo(ctx, fmt.Sprintf("UpdateMidjourneyTask error: %v", err))
 				}
+				shards.RecordResult(channelId, err)
 				continue
 			}
 			requestUrl := fmt.Sprintf("%s/mj/task/list-by-condition", *midjourneyChannel.BaseURL)
@@ -99,6 +108,7 @@ func UpdateMidjourneyTaskBulk() {
 			req, err := http.NewRequest("POST", requestUrl, bytes.NewBuffer(body))
 			if err != nil {
 				common.LogError(ctx, fmt.Sprintf("Get Task error: %v", err))
+				shards.RecordResult(channelId, err)
 				continue
 			}
 			// 设置超时时间
@@ -111,26 +121,31 @@ func UpdateMidjourneyTaskBulk() {
 			resp, err := service.GetHttpClient().Do(req)
 			if err != nil {
 				common.LogError(ctx, fmt.Sprintf("Get Task Do req error: %v", err))
+				shards.RecordResult(channelId, err)
 				continue
 			}
 			if resp.StatusCode != http.StatusOK {
 				common.LogError(ctx, fmt.Sprintf("Get Task status code: %d", resp.StatusCode))
+				shards.RecordResult(channelId, fmt.Errorf("status code %d", resp.StatusCode))
 				continue
 			}
 			responseBody, err := io.ReadAll(resp.Body)
 			if err != nil {
 				common.LogError(ctx, fmt.Sprintf("Get Task parse body error: %v", err))
+				shards.RecordResult(channelId, err)
 				continue
 			}
 			var responseItems []dto.MidjourneyDto
 			err = json.Unmarshal(responseBody, &responseItems)
 			if err != nil {
 				common.LogError(ctx, fmt.Sprintf("Get Task parse body error2: %v, body: %s", err, string(responseBody)))
+				shards.RecordResult(channelId, err)
 				continue
 			}
 			resp.Body.Close()
 			req.Body.Close()
 			cancel()
+			shards.RecordResult(channelId, nil)
 
 			for _, responseItem := range responseItems {
 				task := taskM[responseItem.MjId]
@@ -144,6 +159,7 @@ func UpdateMidjourneyTaskBulk() {
 				if !checkMjTaskNeedUpdate(task, responseItem) {
 					continue
 				}
+				oldStatus := task.Status
 				task.Code = 1
 				task.Progress = responseItem.Progress
 				task.PromptEn = responseItem.PromptEn
@@ -162,25 +178,18 @@ func UpdateMidjourneyTaskBulk() {
 					buttonStr, _ := json.Marshal(responseItem.Buttons)
 					task.Buttons = string(buttonStr)
 				}
-				shouldReturnQuota := false
 				if (task.Progress != "100%" && responseItem.FailReason != "") || (task.Progress == "100%" && task.Status == "FAILURE") {
 					common.LogInfo(ctx, task.MjId+" 构建失败，"+task.FailReason)
 					task.Progress = "100%"
-					if task.Quota != 0 {
-						shouldReturnQuota = true
-					}
+					task.Status = "FAILURE"
 				}
 				err = task.Update()
 				if err != nil {
 					common.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else {
-					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
-						if err != nil {
-							common.LogError(ctx, "fail to increase user quota: "+err.Error())
-						}
-						logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, common.LogQuota(task.Quota))
-						model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+					service.SettleMidjourney(ctx, task, "")
+					if task.Status != oldStatus {
+						service.NotifyMidjourneyFinished(task)
 					}
 				}
 			}
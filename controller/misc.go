@@ -24,6 +24,7 @@ import (
 	"veloera/common"
 	"veloera/constant"
 	"veloera/model"
+	"veloera/service"
 	"veloera/setting"
 	"veloera/setting/operation_setting"
 	"veloera/setting/system_setting"
@@ -47,6 +48,20 @@ func TestStatus(c *gin.Context) {
 	return
 }
 
+func GetRequestQueueStatus(c *gin.Context) {
+	queueSetting := operation_setting.GetRequestQueueSetting()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":          queueSetting.Enabled,
+			"max_queue_length": queueSetting.MaxQueueLength,
+			"max_wait_seconds": queueSetting.MaxWaitSeconds,
+			"queues":           service.GetRequestQueueStats(),
+		},
+	})
+}
+
 func GetStatus(c *gin.Context) {
 	common.OptionMapRWMutex.RLock()
 	affEnabled := common.OptionMap["AffEnabled"] == "true"
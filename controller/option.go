@@ -36,6 +36,9 @@ func GetOptions(c *gin.Context) {
 		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") {
 			continue
 		}
+		if strings.HasPrefix(k, "payment_setting.") && (strings.HasSuffix(k, "_secret") || strings.HasSuffix(k, "_key")) {
+			continue
+		}
 		options = append(options, &model.Option{
 			Key:   k,
 			Value: common.Interface2String(v),
@@ -126,6 +129,14 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "audit_archive.enabled":
+		if option.Value == "true" && common.AuditEncryptionKey == "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用审计归档，请先设置环境变量 AUDIT_ENCRYPTION_KEY！",
+			})
+			return
+		}
 	case "GroupRatio":
 		err = setting.CheckGroupRatio(option.Value)
 		if err != nil {
@@ -0,0 +1,652 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"veloera/common"
+	"veloera/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOrganizationMembership 读取路由中的组织并校验当前用户为其成员
+func getOrganizationMembership(c *gin.Context) (*model.Organization, *model.OrganizationMember, bool) {
+	orgId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return nil, nil, false
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织不存在",
+		})
+		return nil, nil, false
+	}
+	member, err := model.GetOrganizationMember(org.Id, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "您不是该组织成员",
+		})
+		return nil, nil, false
+	}
+	return org, member, true
+}
+
+func organizationPermissionDenied(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": "无权进行此操作",
+	})
+}
+
+func organizationError(c *gin.Context, err error) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": err.Error(),
+	})
+}
+
+func organizationSuccess(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+func validateOrganizationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("组织名称不能为空")
+	}
+	if len(name) > 64 {
+		return "", errors.New("组织名称过长")
+	}
+	return name, nil
+}
+
+// GetSelfOrganizations 获取当前用户所在的组织
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, orgs)
+}
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func CreateOrganization(c *gin.Context) {
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	name, err := validateOrganizationName(req.Name)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	org := &model.Organization{
+		Name:    name,
+		OwnerId: c.GetInt("id"),
+	}
+	if err := org.Insert(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, org)
+}
+
+func GetOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	organizationSuccess(c, gin.H{
+		"organization": org,
+		"member":       member,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if !member.CanManage() {
+		organizationPermissionDenied(c)
+		return
+	}
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	name, err := validateOrganizationName(req.Name)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	org.Name = name
+	if err := org.Update(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, org)
+}
+
+// DeleteOrganization 所有者删除组织，组织令牌随之删除
+func DeleteOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if member.Role != model.OrganizationRoleOwner {
+		organizationPermissionDenied(c)
+		return
+	}
+	if err := org.Delete(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, nil)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	org, _, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, members)
+}
+
+type organizationMemberRequest struct {
+	Username          string `json:"username"`
+	Role              string `json:"role"`
+	DailyQuotaLimit   int    `json:"daily_quota_limit"`
+	WeeklyQuotaLimit  int    `json:"weekly_quota_limit"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit"`
+	SoftLimitPercent  int    `json:"soft_limit_percent"`
+}
+
+func (req *organizationMemberRequest) validate() error {
+	if !model.IsValidOrganizationRole(req.Role) {
+		return errors.New("无效的角色")
+	}
+	if req.DailyQuotaLimit < 0 || req.WeeklyQuotaLimit < 0 || req.MonthlyQuotaLimit < 0 {
+		return errors.New("限额不能为负数")
+	}
+	if req.SoftLimitPercent < 0 || req.SoftLimitPercent > 100 {
+		return errors.New("提醒比例应在 0-100 之间")
+	}
+	return nil
+}
+
+// canAssignOrganizationRole 管理员只能授予 member 与 billing 角色，admin 只能由所有者授予
+func canAssignOrganizationRole(operator *model.OrganizationMember, role string) bool {
+	if operator.Role == model.OrganizationRoleOwner {
+		return true
+	}
+	return operator.Role == model.OrganizationRoleAdmin &&
+		(role == model.OrganizationRoleMember || role == model.OrganizationRoleBilling)
+}
+
+// AddOrganizationMember 邀请用户加入组织，用户接受邀请后才成为成员
+func AddOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	if err := req.validate(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	if req.Role == model.OrganizationRoleOwner || !canAssignOrganizationRole(operator, req.Role) {
+		organizationPermissionDenied(c)
+		return
+	}
+	userId, err := model.GetUserIdByUsername(strings.TrimSpace(req.Username))
+	if err != nil {
+		organizationError(c, errors.New("用户不存在"))
+		return
+	}
+	if _, err := model.GetOrganizationMember(org.Id, userId); err == nil {
+		organizationError(c, errors.New("该用户已是组织成员"))
+		return
+	}
+	invited, err := model.HasOrganizationInvitation(org.Id, userId)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	if invited {
+		organizationError(c, errors.New("已向该用户发出邀请，请等待对方接受"))
+		return
+	}
+	invitation := &model.OrganizationInvitation{
+		OrgId:             org.Id,
+		UserId:            userId,
+		InviterId:         operator.UserId,
+		Role:              req.Role,
+		DailyQuotaLimit:   req.DailyQuotaLimit,
+		WeeklyQuotaLimit:  req.WeeklyQuotaLimit,
+		MonthlyQuotaLimit: req.MonthlyQuotaLimit,
+		SoftLimitPercent:  req.SoftLimitPercent,
+	}
+	if err := invitation.Insert(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, invitation)
+}
+
+// GetOrganizationInvitations 所有者与管理员查看组织发出的待处理邀请
+func GetOrganizationInvitations(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if !member.CanManage() {
+		organizationPermissionDenied(c)
+		return
+	}
+	invitations, err := model.GetOrganizationInvitations(org.Id)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, invitations)
+}
+
+// CancelOrganizationInvitation 所有者与管理员撤回邀请
+func CancelOrganizationInvitation(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if !member.CanManage() {
+		organizationPermissionDenied(c)
+		return
+	}
+	invitationId, err := strconv.Atoi(c.Param("invitation_id"))
+	if err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	invitation, err := model.GetOrganizationInvitationById(org.Id, invitationId)
+	if err != nil {
+		organizationError(c, errors.New("邀请不存在"))
+		return
+	}
+	if err := invitation.Delete(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, nil)
+}
+
+// GetSelfOrganizationInvitations 获取当前用户收到的组织邀请
+func GetSelfOrganizationInvitations(c *gin.Context) {
+	invitations, err := model.GetUserOrganizationInvitations(c.GetInt("id"))
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, invitations)
+}
+
+// getSelfOrganizationInvitation 读取路由中的邀请并校验其发给当前用户
+func getSelfOrganizationInvitation(c *gin.Context) (*model.OrganizationInvitation, bool) {
+	invitationId, err := strconv.Atoi(c.Param("invitation_id"))
+	if err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return nil, false
+	}
+	invitation, err := model.GetUserOrganizationInvitationById(c.GetInt("id"), invitationId)
+	if err != nil {
+		organizationError(c, errors.New("邀请不存在"))
+		return nil, false
+	}
+	return invitation, true
+}
+
+// AcceptOrganizationInvitation 接受邀请加入组织
+func AcceptOrganizationInvitation(c *gin.Context) {
+	invitation, ok := getSelfOrganizationInvitation(c)
+	if !ok {
+		return
+	}
+	member, err := model.AcceptOrganizationInvitation(invitation)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, member)
+}
+
+// DeclineOrganizationInvitation 拒绝邀请
+func DeclineOrganizationInvitation(c *gin.Context) {
+	invitation, ok := getSelfOrganizationInvitation(c)
+	if !ok {
+		return
+	}
+	if err := invitation.Delete(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, nil)
+}
+
+func getOrganizationMemberParam(c *gin.Context, org *model.Organization) (*model.OrganizationMember, bool) {
+	memberId, err := strconv.Atoi(c.Param("member_id"))
+	if err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return nil, false
+	}
+	member, err := model.GetOrganizationMemberById(org.Id, memberId)
+	if err != nil {
+		organizationError(c, errors.New("成员不存在"))
+		return nil, false
+	}
+	return member, true
+}
+
+// UpdateOrganizationMember 修改成员角色与限额，将角色设为 owner 表示转让组织
+func UpdateOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	member, ok := getOrganizationMemberParam(c, org)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	if err := req.validate(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	if !operator.CanManage() || (member.Role != model.OrganizationRoleMember && member.Role != model.OrganizationRoleBilling &&
+		operator.Role != model.OrganizationRoleOwner) {
+		organizationPermissionDenied(c)
+		return
+	}
+	if req.Role == model.OrganizationRoleOwner && member.Role != model.OrganizationRoleOwner {
+		if operator.Role != model.OrganizationRoleOwner {
+			organizationPermissionDenied(c)
+			return
+		}
+		if err := model.TransferOrganizationOwnership(org, member); err != nil {
+			organizationError(c, err)
+			return
+		}
+		member.Role = model.OrganizationRoleOwner
+	} else if req.Role != member.Role {
+		if member.Role == model.OrganizationRoleOwner {
+			organizationError(c, errors.New("所有者角色只能通过转让变更"))
+			return
+		}
+		if !canAssignOrganizationRole(operator, req.Role) {
+			organizationPermissionDenied(c)
+			return
+		}
+		member.Role = req.Role
+	}
+	member.DailyQuotaLimit = req.DailyQuotaLimit
+	member.WeeklyQuotaLimit = req.WeeklyQuotaLimit
+	member.MonthlyQuotaLimit = req.MonthlyQuotaLimit
+	member.SoftLimitPercent = req.SoftLimitPercent
+	if err := member.Update(); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, member)
+}
+
+// RemoveOrganizationMember 移除成员或成员主动退出，其组织令牌转交所有者并禁用
+func RemoveOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	member, ok := getOrganizationMemberParam(c, org)
+	if !ok {
+		return
+	}
+	leaving := member.Id == operator.Id
+	if !leaving && (!operator.CanManage() ||
+		(member.Role == model.OrganizationRoleAdmin && operator.Role != model.OrganizationRoleOwner)) {
+		organizationPermissionDenied(c)
+		return
+	}
+	if err := model.RemoveOrganizationMember(org, member); err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, nil)
+}
+
+type fundOrganizationRequest struct {
+	Quota int `json:"quota"`
+}
+
+// FundOrganization 将个人余额划入组织额度池，组织被禁用时不允许划入
+func FundOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if !member.CanViewBilling() {
+		organizationPermissionDenied(c)
+		return
+	}
+	if org.Status != model.OrganizationStatusEnabled {
+		organizationError(c, errors.New("组织已被禁用"))
+		return
+	}
+	var req fundOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	if err := model.FundOrganization(org.Id, member.UserId, req.Quota); err != nil {
+		organizationError(c, err)
+		return
+	}
+	model.RecordLog(member.UserId, model.LogTypeManage, "向组织 "+org.Name+" 划转额度 "+common.LogQuota(req.Quota))
+	organizationSuccess(c, nil)
+}
+
+// WithdrawOrganization 所有者将组织额度池中的额度转回个人余额
+func WithdrawOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if member.Role != model.OrganizationRoleOwner {
+		organizationPermissionDenied(c)
+		return
+	}
+	var req fundOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	if err := model.WithdrawOrganization(org.Id, member.UserId, req.Quota); err != nil {
+		organizationError(c, err)
+		return
+	}
+	model.RecordLog(member.UserId, model.LogTypeManage, "从组织 "+org.Name+" 转回额度 "+common.LogQuota(req.Quota))
+	organizationSuccess(c, nil)
+}
+
+// GetOrganizationLogs 查看通过组织令牌产生的日志
+func GetOrganizationLogs(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	if !member.CanViewBilling() {
+		organizationPermissionDenied(c)
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	logs, total, err := model.GetOrganizationLogs(org.Id, logType, startTimestamp, endTimestamp, c.Query("model_name"),
+		c.Query("username"), c.Query("token_name"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, map[string]any{
+		"items":     logs,
+		"total":     total,
+		"page":      p,
+		"page_size": pageSize,
+	})
+}
+
+// GetOrganizationTokens 查看组织令牌，所有者与管理员可见全部，其他成员只能看到自己名下的令牌
+func GetOrganizationTokens(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c)
+	if !ok {
+		return
+	}
+	userId := member.UserId
+	if member.CanManage() {
+		userId = 0
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	tokens, total, err := model.GetOrganizationTokens(org.Id, userId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, gin.H{
+		"items": tokens,
+		"total": total,
+	})
+}
+
+// GetAllOrganizations 管理员查看全部组织
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	orgs, total, err := model.GetAllOrganizations(c.Query("keyword"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, gin.H{
+		"items": orgs,
+		"total": total,
+	})
+}
+
+type manageOrganizationRequest struct {
+	Status int    `json:"status"`
+	Quota  int    `json:"quota"`
+	Remark string `json:"remark"`
+}
+
+// ManageOrganization 管理员启用/禁用组织或调整组织额度（quota 为增减量）
+func ManageOrganization(c *gin.Context) {
+	orgId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		organizationError(c, errors.New("组织不存在"))
+		return
+	}
+	var req manageOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		organizationError(c, errors.New("无效的参数"))
+		return
+	}
+	if req.Status != 0 {
+		if req.Status != model.OrganizationStatusEnabled && req.Status != model.OrganizationStatusDisabled {
+			organizationError(c, errors.New("无效的状态"))
+			return
+		}
+		org.Status = req.Status
+		if err := org.Update(); err != nil {
+			organizationError(c, err)
+			return
+		}
+	}
+	if req.Quota != 0 {
+		if err := model.AdjustOrganizationQuota(org.Id, req.Quota, c.GetInt("id"), req.Remark); err != nil {
+			organizationError(c, err)
+			return
+		}
+	}
+	org, err = model.GetOrganizationById(orgId)
+	if err != nil {
+		organizationError(c, err)
+		return
+	}
+	organizationSuccess(c, org)
+}
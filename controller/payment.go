@@ -0,0 +1,220 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+	"veloera/service/payment"
+	"veloera/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type PaymentRequest struct {
+	Amount    int64  `json:"amount"`
+	Provider  string `json:"provider"`
+	TopUpCode string `json:"top_up_code"`
+}
+
+type RefundTopUpRequest struct {
+	TradeNo string  `json:"trade_no"`
+	Money   float64 `json:"money"`
+	Reason  string  `json:"reason"`
+	// Manual 为 true 时不调用支付渠道接口，仅记录线下退款并扣回额度
+	Manual bool `json:"manual"`
+}
+
+func GetPaymentProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    payment.GetEnabledProviders(),
+	})
+}
+
+func RequestPayment(c *gin.Context) {
+	var req PaymentRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	provider, ok := payment.GetProvider(req.Provider)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "支付方式不可用"})
+		return
+	}
+	if req.Amount < getMinTopup() {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": fmt.Sprintf("充值数量不能小于 %d", getMinTopup())})
+		return
+	}
+	id := c.GetInt("id")
+	group, err := model.GetUserGroup(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "获取用户分组失败"})
+		return
+	}
+	providerSetting := provider.Setting()
+	payMoney := payment.ConvertMoney(getPayMoney(req.Amount, group), providerSetting)
+	if payMoney < 0.01 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "充值金额过低"})
+		return
+	}
+
+	amount := req.Amount
+	quota := int(decimal.NewFromInt(amount).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart())
+	if !common.DisplayInCurrencyEnabled {
+		quota = int(amount)
+		amount = decimal.NewFromInt(amount).Div(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart()
+	}
+	tradeNo := fmt.Sprintf("USR%dNO%s%d", id, common.GetRandomString(6), time.Now().Unix())
+	callBackAddress := service.GetCallbackAddress()
+	order := &payment.Order{
+		TradeNo:   tradeNo,
+		UserId:    id,
+		Quota:     quota,
+		Money:     payMoney,
+		Currency:  providerSetting.Currency,
+		Title:     fmt.Sprintf("TUC%d", req.Amount),
+		ReturnURL: setting.ServerAddress + "/app/wallet/topup-success",
+		CancelURL: setting.ServerAddress + "/app/wallet",
+		NotifyURL: callBackAddress + "/api/user/payment/" + provider.Name() + "/notify",
+	}
+	if provider.Name() == "paypal" {
+		// PayPal 需要用户返回后由服务端确认扣款
+		order.ReturnURL = callBackAddress + "/api/user/payment/paypal/return"
+	}
+	// 先写入待支付订单再拉起支付，避免渠道回调早于订单落库
+	topUp := &model.TopUp{
+		UserId:     id,
+		Amount:     amount,
+		Money:      payMoney,
+		TradeNo:    tradeNo,
+		CreateTime: time.Now().Unix(),
+		Status:     model.TopUpStatusPending,
+		Provider:   provider.Name(),
+		Currency:   providerSetting.Currency,
+		Quota:      quota,
+	}
+	err = topUp.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "创建订单失败"})
+		return
+	}
+	checkout, err := provider.CreateCheckout(c.Request.Context(), order)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create %s checkout: %s", provider.Name(), err.Error()))
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "拉起支付失败"})
+		return
+	}
+	if err = model.UpdateTopUpProviderOrderId(tradeNo, checkout.ProviderOrderId); err != nil {
+		common.SysError(fmt.Sprintf("failed to save provider order id of %s: %s", tradeNo, err.Error()))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"trade_no": tradeNo,
+			"url":      checkout.URL,
+			"params":   checkout.Params,
+		},
+	})
+}
+
+func PaymentNotify(c *gin.Context) {
+	provider, ok := payment.GetProvider(c.Param("provider"))
+	if !ok {
+		c.String(http.StatusNotFound, "unknown provider")
+		return
+	}
+	notification, err := provider.ParseNotification(c)
+	if err != nil {
+		common.SysError(fmt.Sprintf("%s 支付回调校验失败: %s", provider.Name(), err.Error()))
+		c.String(http.StatusBadRequest, "fail")
+		return
+	}
+	err = payment.HandleNotification(provider, notification)
+	if err != nil {
+		common.SysError(fmt.Sprintf("%s 支付回调处理失败: %s", provider.Name(), err.Error()))
+		// 返回非 2xx 让支付渠道稍后重试
+		c.String(http.StatusInternalServerError, "fail")
+		return
+	}
+	c.String(http.StatusOK, "success")
+}
+
+func PayPalReturn(c *gin.Context) {
+	orderId := c.Query("token")
+	if orderId == "" {
+		c.Redirect(http.StatusFound, setting.ServerAddress+"/app/wallet")
+		return
+	}
+	notification, err := payment.CapturePayPalOrder(c.Request.Context(), orderId)
+	if err == nil {
+		err = payment.FulfillOrder(notification.TradeNo, notification.ProviderPaymentId, notification.Money)
+	}
+	if err != nil {
+		common.SysError(fmt.Sprintf("PayPal 订单 %s 扣款失败: %s", orderId, err.Error()))
+		c.Redirect(http.StatusFound, setting.ServerAddress+"/app/wallet")
+		return
+	}
+	c.Redirect(http.StatusFound, setting.ServerAddress+"/app/wallet/topup-success")
+}
+
+func RefundTopUp(c *gin.Context) {
+	var req RefundTopUpRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.TradeNo == "" || req.Money <= 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	topUp := model.GetTopUpByTradeNo(req.TradeNo)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "订单不存在"})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "管理员退款"
+	}
+	var provider payment.Provider
+	if !req.Manual {
+		var ok bool
+		provider, ok = payment.GetProvider(topUp.Provider)
+		if !ok {
+			c.JSON(http.StatusOK, gin.H{"success": false, "message": "该订单的支付渠道未启用或不支持在线退款，请使用手动退款"})
+			return
+		}
+	}
+	manualRefundId := fmt.Sprintf("manual-%d-%s", c.GetInt("id"), common.GetUUID())
+	err = payment.RefundOrder(c.Request.Context(), provider, topUp.TradeNo, req.Money, req.Reason, manualRefundId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	refunds, _ := model.GetTopUpRefunds(topUp.TradeNo)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    refunds,
+	})
+}
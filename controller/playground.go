@@ -17,17 +17,23 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"veloera/common"
 	"veloera/constant"
 	"veloera/dto"
 	"veloera/middleware"
 	"veloera/model"
+	relaycommon "veloera/relay/common"
 	"veloera/service"
 	"veloera/setting"
 )
@@ -49,8 +55,18 @@ func Playground(c *gin.Context) {
 		return
 	}
 
+	// channel_id 与 debug 仅供 Playground 使用，不转发给上游
+	specificChannelId := c.Query("channel_id")
+	debug := c.Query("debug") == "true"
+	c.Request.URL.RawQuery = ""
+	isAdmin := c.GetInt("role") >= common.RoleAdminUser
+	if specificChannelId != "" && !isAdmin {
+		openaiErr = service.OpenAIErrorWrapperLocal(errors.New("普通用户不支持指定渠道"), "specific_channel_not_allowed", http.StatusForbidden)
+		return
+	}
+
 	playgroundRequest := &dto.PlayGroundRequest{}
-	err := common.UnmarshalBodyReusable(c, playgroundRequest)
+	err := parsePlaygroundRequest(c, playgroundRequest)
 	if err != nil {
 		openaiErr = service.OpenAIErrorWrapperLocal(err, "unmarshal_request_failed", http.StatusBadRequest)
 		return
@@ -63,11 +79,20 @@ func Playground(c *gin.Context) {
 	c.Set("original_model", playgroundRequest.Model)
 	group := playgroundRequest.Group
 	userGroup := c.GetString("group")
+	planId := 0
+	if userCache, err := model.GetUserCache(c.GetInt("id")); err == nil {
+		planId = userCache.PlanId
+		c.Set(constant.ContextKeyUserRPMLimit, userCache.RpmLimit)
+	}
+	// 套餐不支持的模型只能使用余额，不扣减订阅额度
+	if plan, ok := model.CacheGetSubscriptionPlan(planId); ok && !plan.AllowModel(playgroundRequest.Model) {
+		c.Set(constant.ContextKeySubscriptionExcluded, true)
+	}
 
 	if group == "" {
 		group = userGroup
 	} else {
-		if !setting.GroupInUserUsableGroups(group) && group != userGroup {
+		if _, ok := setting.GetUserUsableGroups(userGroup, model.GetPlanGroups(planId)...)[group]; !ok {
 			openaiErr = service.OpenAIErrorWrapperLocal(errors.New("无权访问该分组"), "group_not_allowed", http.StatusForbidden)
 			return
 		}
@@ -92,7 +117,14 @@ func Playground(c *gin.Context) {
 	}
 
 	// Select channel based on whether we found a prefix
-	if modelPrefix != "" {
+	if specificChannelId != "" {
+		channel, err = getPlaygroundSpecificChannel(specificChannelId)
+		if err != nil {
+			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_playground_channel_failed", http.StatusBadRequest)
+			return
+		}
+		c.Set("specific_channel_id", specificChannelId)
+	} else if modelPrefix != "" {
 		// Use prefix-based channel selection
 		channel, err = middleware.SelectChannelByPrefix(group, modelPrefix, modelToQuery)
 	} else {
@@ -106,6 +138,177 @@ func Playground(c *gin.Context) {
 		return
 	}
 	middleware.SetupContextForSelectedChannel(c, channel, modelToQuery)
+	// Playground 在此处才选定分组与渠道，用户限流与内容审核需在选定后执行
+	for _, handler := range []gin.HandlerFunc{middleware.UserRequestRateLimit(), middleware.Moderation()} {
+		handler(c)
+		if c.IsAborted() {
+			return
+		}
+	}
 	c.Set(constant.ContextKeyRequestStartTime, time.Now())
+	if debug {
+		trace := relaycommon.EnableUpstreamTrace(c)
+		defer savePlaygroundTrace(c, trace, isAdmin)
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/pg/messages") {
+		RelayClaude(c)
+		return
+	}
 	Relay(c)
 }
+
+// parsePlaygroundRequest 读取模型与分组，语音转写等 multipart 请求从表单中读取
+func parsePlaygroundRequest(c *gin.Context, request *dto.PlayGroundRequest) error {
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		return common.UnmarshalBodyReusable(c, request)
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	form := c.Request.Clone(c.Request.Context())
+	form.Body = io.NopCloser(bytes.NewReader(requestBody))
+	if err := form.ParseMultipartForm(32 << 20); err != nil {
+		return err
+	}
+	request.Model = form.FormValue("model")
+	request.Group = form.FormValue("group")
+	c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	return nil
+}
+
+func getPlaygroundSpecificChannel(channelId string) (*model.Channel, error) {
+	id, err := strconv.Atoi(channelId)
+	if err != nil {
+		return nil, errors.New("无效的渠道 Id")
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		return nil, errors.New("无效的渠道 Id")
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return nil, errors.New("该渠道已被禁用")
+	}
+	return channel, nil
+}
+
+// playgroundTraceTTL Playground 调试记录的保留时间，按请求 Id 查询
+const playgroundTraceTTL = 10 * time.Minute
+
+// PlaygroundTrace 一次 Playground 请求的上游调试记录，普通用户只能看到状态码与耗时
+type PlaygroundTrace struct {
+	RequestId string                         `json:"request_id"`
+	UserId    int                            `json:"user_id"`
+	ChannelId int                            `json:"channel_id"`
+	TotalMs   int64                          `json:"total_ms"`
+	Attempts  []*relaycommon.UpstreamAttempt `json:"attempts"`
+	CreatedAt int64                          `json:"created_at"`
+}
+
+type playgroundTraceEntry struct {
+	data     []byte
+	expireAt time.Time
+}
+
+var (
+	playgroundTraces     = make(map[string]*playgroundTraceEntry)
+	playgroundTracesLock sync.Mutex
+)
+
+func savePlaygroundTrace(c *gin.Context, trace *relaycommon.UpstreamTrace, isAdmin bool) {
+	requestId := c.GetString(common.RequestIdKey)
+	if requestId == "" {
+		return
+	}
+	record := &PlaygroundTrace{
+		RequestId: requestId,
+		UserId:    c.GetInt("id"),
+		ChannelId: c.GetInt("channel_id"),
+		Attempts:  trace.Snapshot(),
+		CreatedAt: common.GetTimestamp(),
+	}
+	if startTime, ok := c.Get(constant.ContextKeyRequestStartTime); ok {
+		record.TotalMs = time.Since(startTime.(time.Time)).Milliseconds()
+	}
+	if !isAdmin {
+		// 上游地址、请求头与原始内容可能暴露渠道配置，仅管理员可见
+		for i, attempt := range record.Attempts {
+			record.Attempts[i] = &relaycommon.UpstreamAttempt{
+				StatusCode: attempt.StatusCode,
+				Error:      attempt.Error,
+				StartedAt:  attempt.StartedAt,
+				HeaderMs:   attempt.HeaderMs,
+				TotalMs:    attempt.TotalMs,
+			}
+		}
+		record.ChannelId = 0
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if common.RedisEnabled {
+		if err := common.RedisSet("playground_trace:"+requestId, string(data), playgroundTraceTTL); err != nil {
+			common.SysError("failed to save playground trace: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	playgroundTracesLock.Lock()
+	defer playgroundTracesLock.Unlock()
+	for id, entry := range playgroundTraces {
+		if now.After(entry.expireAt) {
+			delete(playgroundTraces, id)
+		}
+	}
+	playgroundTraces[requestId] = &playgroundTraceEntry{data: data, expireAt: now.Add(playgroundTraceTTL)}
+}
+
+func loadPlaygroundTrace(requestId string) ([]byte, bool) {
+	if common.RedisEnabled {
+		data, err := common.RedisGet("playground_trace:" + requestId)
+		if err != nil {
+			return nil, false
+		}
+		return []byte(data), true
+	}
+	playgroundTracesLock.Lock()
+	defer playgroundTracesLock.Unlock()
+	entry, ok := playgroundTraces[requestId]
+	if !ok || time.Now().After(entry.expireAt) {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// GetPlaygroundTrace 按响应头中的请求 Id 查询 Playground 调试记录，请求时需带 debug=true
+func GetPlaygroundTrace(c *gin.Context) {
+	data, ok := loadPlaygroundTrace(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "调试记录不存在或已过期",
+		})
+		return
+	}
+	var record PlaygroundTrace
+	if err := json.Unmarshal(data, &record); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if record.UserId != c.GetInt("id") && c.GetInt("role") < common.RoleAdminUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "调试记录不存在或已过期",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    record,
+	})
+}
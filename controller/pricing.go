@@ -25,7 +25,6 @@ import (
 )
 
 func GetPricing(c *gin.Context) {
-	pricing := model.GetPricing()
 	userId, exists := c.Get("id")
 	usableGroup := map[string]string{}
 	groupRatio := map[string]float64{}
@@ -33,14 +32,21 @@ func GetPricing(c *gin.Context) {
 		groupRatio[s] = f
 	}
 	var group string
+	var planGroups []string
 	if exists {
 		user, err := model.GetUserCache(userId.(int))
 		if err == nil {
 			group = user.Group
+			planGroups = model.GetPlanGroups(user.PlanId)
 		}
 	}
 
-	usableGroup = setting.GetUserUsableGroups(group)
+	usableGroup = setting.GetUserUsableGroups(group, planGroups...)
+	groups := make([]string, 0, len(usableGroup))
+	for usable := range usableGroup {
+		groups = append(groups, usable)
+	}
+	pricing := model.GetPricing(groups)
 	// check groupRatio contains usableGroup
 	for group := range setting.GetGroupRatioCopy() {
 		if _, ok := usableGroup[group]; !ok {
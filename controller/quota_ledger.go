@@ -0,0 +1,110 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listQuotaLedgerEntries(c *gin.Context, userId int, orgId int, onlyUserAccounts bool) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	entries, total, err := model.GetQuotaLedgerEntries(userId, orgId, c.Query("reason"), c.Query("transaction_id"),
+		onlyUserAccounts, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": entries,
+			"total": total,
+		},
+	})
+}
+
+// GetQuotaLedgerEntries 管理员查询账本分录，可按 user_id、org_id、reason、transaction_id 过滤
+func GetQuotaLedgerEntries(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	orgId, _ := strconv.Atoi(c.Query("org_id"))
+	listQuotaLedgerEntries(c, userId, orgId, false)
+}
+
+// GetSelfQuotaLedgerEntries 用户查询自己的额度变动明细
+func GetSelfQuotaLedgerEntries(c *gin.Context) {
+	listQuotaLedgerEntries(c, c.GetInt("id"), 0, true)
+}
+
+// CheckQuotaLedger 按账本重算余额并与当前余额比对
+func CheckQuotaLedger(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	result, err := service.CheckQuotaLedger(userId, false, 0)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
+
+type reconcileQuotaLedgerRequest struct {
+	UserId int `json:"user_id"`
+}
+
+// ReconcileQuotaLedger 为账本与余额的差额记对账修正分录，user_id 为 0 时处理全部用户
+func ReconcileQuotaLedger(c *gin.Context) {
+	var req reconcileQuotaLedgerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	result, err := service.CheckQuotaLedger(req.UserId, true, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
@@ -140,6 +140,16 @@ func AddRedemption(c *gin.Context) {
 		return
 	}
 
+	if redemption.CampaignId != 0 {
+		if _, err := model.GetRedemptionCampaignById(redemption.CampaignId); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "兑换活动不存在",
+			})
+			return
+		}
+	}
+
 	var keys []string
 	if redemption.Key != "" {
 		// If key is provided, use it and check for duplicates
@@ -169,6 +179,7 @@ func AddRedemption(c *gin.Context) {
 			MaxUses:     redemption.MaxUses,
 			ValidFrom:   redemption.ValidFrom,
 			ValidUntil:  redemption.ValidUntil,
+			CampaignId:  redemption.CampaignId,
 		}
 		err = cleanRedemption.Insert()
 		if err != nil {
@@ -209,6 +220,7 @@ func AddRedemption(c *gin.Context) {
 				MaxUses:     redemption.MaxUses,
 				ValidFrom:   redemption.ValidFrom,
 				ValidUntil:  redemption.ValidUntil,
+				CampaignId:  redemption.CampaignId,
 			}
 			err = cleanRedemption.Insert()
 			if err != nil {
@@ -275,6 +287,16 @@ func UpdateRedemption(c *gin.Context) {
 		cleanRedemption.Quota = redemption.Quota
 		cleanRedemption.ValidFrom = redemption.ValidFrom
 		cleanRedemption.ValidUntil = redemption.ValidUntil
+		if redemption.CampaignId != 0 {
+			if _, err := model.GetRedemptionCampaignById(redemption.CampaignId); err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "兑换活动不存在",
+				})
+				return
+			}
+		}
+		cleanRedemption.CampaignId = redemption.CampaignId
 	}
 	err = cleanRedemption.Update()
 	if err != nil {
@@ -0,0 +1,194 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"veloera/common"
+	"veloera/model"
+	"veloera/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllRedemptionCampaigns(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	campaigns, total, err := model.GetAllRedemptionCampaigns(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": campaigns,
+			"total": total,
+		},
+	})
+}
+
+func validateRedemptionCampaign(campaign *model.RedemptionCampaign) error {
+	campaign.Name = strings.TrimSpace(campaign.Name)
+	if campaign.Name == "" || len(campaign.Name) > 64 {
+		return errors.New("活动名称不能为空且不能超过 64 个字符")
+	}
+	if campaign.NewUserDays < 0 || campaign.MinTopUpQuota < 0 || campaign.TopUpBonusPercent < 0 {
+		return errors.New("活动条件与奖励不能为负数")
+	}
+	if campaign.TopUpBonusPercent > 1000 {
+		return errors.New("充值加赠比例不能超过 1000%")
+	}
+	if campaign.Status != model.RedemptionCampaignStatusEnabled && campaign.Status != model.RedemptionCampaignStatusDisabled {
+		campaign.Status = model.RedemptionCampaignStatusEnabled
+	}
+	groups := campaign.GetAllowedGroups()
+	for _, group := range groups {
+		if !setting.ContainsGroupRatio(group) {
+			return fmt.Errorf("分组 %s 不存在", group)
+		}
+	}
+	campaign.AllowedGroups = strings.Join(groups, ",")
+	campaign.UpgradeGroup = strings.TrimSpace(campaign.UpgradeGroup)
+	if campaign.UpgradeGroup != "" && !setting.ContainsGroupRatio(campaign.UpgradeGroup) {
+		return fmt.Errorf("分组 %s 不存在", campaign.UpgradeGroup)
+	}
+	return nil
+}
+
+func AddRedemptionCampaign(c *gin.Context) {
+	var campaign model.RedemptionCampaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validateRedemptionCampaign(&campaign); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	campaign.Id = 0
+	if err := campaign.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    campaign,
+	})
+}
+
+func UpdateRedemptionCampaign(c *gin.Context) {
+	var campaign model.RedemptionCampaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := model.GetRedemptionCampaignById(campaign.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "兑换活动不存在",
+		})
+		return
+	}
+	if err := validateRedemptionCampaign(&campaign); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := campaign.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    campaign,
+	})
+}
+
+func DeleteRedemptionCampaign(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err == nil {
+		err = campaign.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetRedemptionCampaignStats 活动统计：兑换次数、付费转化与兑换用户的消耗
+func GetRedemptionCampaignStats(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "兑换活动不存在",
+		})
+		return
+	}
+	stats, err := model.GetRedemptionCampaignStats(campaign)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
@@ -154,12 +154,16 @@ func Relay(c *gin.Context) {
 	}
 
 	if openaiErr != nil {
-		if openaiErr.StatusCode == http.StatusTooManyRequests {
+		if openaiErr.StatusCode == http.StatusTooManyRequests && !openaiErr.LocalError {
 			common.LogError(c, fmt.Sprintf("origin 429 error: %s", openaiErr.Error.Message))
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 		openaiErr.Error.Message = common.MessageWithRequestId(openaiErr.Error.Message, requestId)
-		if c.GetString("relay_format") == relaycommon.RelayFormatGemini {
+		if c.Writer.Written() {
+			// 排队心跳等已经以流式响应开始输出，只能以事件形式返回错误
+			_ = helper.ObjectData(c, gin.H{"error": openaiErr.Error})
+			helper.Done(c)
+		} else if c.GetString("relay_format") == relaycommon.RelayFormatGemini {
 			geminiErr := service.OpenAIErrorToGeminiResponse(openaiErr)
 			c.JSON(openaiErr.StatusCode, geminiErr)
 		} else {
@@ -277,6 +281,11 @@ func RelayClaude(c *gin.Context) {
 
 func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
 	addUsedChannel(c, channel.Id)
+	release, limitErr := service.AcquireRelayConcurrency(c)
+	if limitErr != nil {
+		return limitErr
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relayHandler(c, relayMode)
@@ -284,6 +293,11 @@ func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *dto.Op
 
 func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
 	addUsedChannel(c, channel.Id)
+	release, limitErr := service.AcquireRelayConcurrency(c)
+	if limitErr != nil {
+		return limitErr
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relay.WssHelper(c, ws)
@@ -291,6 +305,11 @@ func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *mode
 
 func claudeRequest(c *gin.Context, channel *model.Channel) *dto.ClaudeErrorWithStatusCode {
 	addUsedChannel(c, channel.Id)
+	release, limitErr := service.AcquireRelayConcurrency(c)
+	if limitErr != nil {
+		return service.OpenAIErrorToClaudeError(limitErr)
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relay.ClaudeHelper(c)
@@ -570,8 +589,12 @@ func RelayTask(c *gin.Context) {
 func taskRelayHandler(c *gin.Context, relayMode int) *dto.TaskError {
 	var err *dto.TaskError
 	switch relayMode {
-	case relayconstant.RelayModeSunoFetch, relayconstant.RelayModeSunoFetchByID:
+	case relayconstant.RelayModeSunoFetch, relayconstant.RelayModeSunoFetchByID, relayconstant.RelayModeVideoFetchByID:
 		err = relay.RelayTaskFetch(c, relayMode)
+	case relayconstant.RelayModeVideoContent:
+		err = relay.RelayVideoContent(c)
+	case relayconstant.RelayModeVideoCancel:
+		err = relay.RelayVideoCancel(c)
 	default:
 		err = relay.RelayTaskSubmit(c, relayMode)
 	}
@@ -0,0 +1,180 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listStatements(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	statements, total, err := model.GetUserStatements(userId, c.Query("period"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": statements,
+			"total": total,
+		},
+	})
+}
+
+// GetSelfStatements 用户查看自己已结账的月度对账单
+func GetSelfStatements(c *gin.Context) {
+	listStatements(c, c.GetInt("id"))
+}
+
+// GetAllStatements 管理员查看对账单，可按 user_id 与 period 过滤
+func GetAllStatements(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	listStatements(c, userId)
+}
+
+// renderStatement 按 format 参数以 json、html 或 pdf 返回对账单
+func renderStatement(c *gin.Context, userId int) {
+	user, err := model.GetUserById(userId, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	statement, err := service.GetStatement(user, c.Param("period"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	fileName := fmt.Sprintf("statement-%d-%s", statement.UserId, statement.Period)
+	switch c.DefaultQuery("format", "json") {
+	case "html":
+		content, err := service.RenderStatementHTML(statement)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(content))
+	case "pdf":
+		content, err := service.RenderStatementPDF(statement)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", fileName))
+		c.Data(http.StatusOK, "application/pdf", content)
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    statement,
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的格式",
+		})
+	}
+}
+
+// GetSelfStatement 用户查看自己某月的对账单
+func GetSelfStatement(c *gin.Context) {
+	renderStatement(c, c.GetInt("id"))
+}
+
+// GetUserStatement 管理员查看指定用户某月的对账单
+func GetUserStatement(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	renderStatement(c, userId)
+}
+
+type sendStatementsRequest struct {
+	Period    string `json:"period"`
+	SendEmail bool   `json:"send_email"`
+}
+
+// SendStatements 管理员手动为所有用户生成指定月份的对账单，可选发送邮件
+func SendStatements(c *gin.Context) {
+	var req sendStatementsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	_, end, err := service.ParseStatementPeriod(req.Period)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if end.After(time.Now()) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "对账期尚未结束",
+		})
+		return
+	}
+	// 为所有用户生成对账单耗时较长，在后台执行，结果记录在系统日志中
+	if err = service.StartStatementGeneration(req.Period, req.SendEmail); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "对账单正在后台生成",
+	})
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+	"veloera/service/payment"
+	"veloera/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSubscriptionPlans 用户查看可订阅的套餐
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+// GetSelfSubscription 用户查看当前订阅与订阅记录
+func GetSelfSubscription(c *gin.Context) {
+	userId := c.GetInt("id")
+	var current *model.UserSubscription
+	var plan *model.SubscriptionPlan
+	if sub, err := model.GetActiveSubscription(userId); err == nil {
+		current = sub
+		plan, _ = model.GetSubscriptionPlanById(sub.PlanId)
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	history, total, err := model.GetUserSubscriptions(userId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"subscription": current,
+			"plan":         plan,
+			"items":        history,
+			"total":        total,
+		},
+	})
+}
+
+type SubscriptionPurchaseRequest struct {
+	PlanId   int    `json:"plan_id"`
+	Periods  int    `json:"periods"`
+	Provider string `json:"provider"`
+}
+
+// PurchaseSubscription 通过支付渠道订阅或续费套餐，支付成功后由回调开通
+func PurchaseSubscription(c *gin.Context) {
+	var req SubscriptionPurchaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	provider, ok := payment.GetProvider(req.Provider)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "支付方式不可用"})
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐不存在或已下架"})
+		return
+	}
+	if req.Periods <= 0 {
+		req.Periods = 1
+	}
+	if req.Periods > max(plan.MaxPeriods, 1) {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": fmt.Sprintf("单次最多购买 %d 个周期", max(plan.MaxPeriods, 1))})
+		return
+	}
+	id := c.GetInt("id")
+	if sub, err := model.GetActiveSubscription(id); err == nil && sub.PlanId != plan.Id {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": model.ErrSubscriptionPlanMismatch.Error()})
+		return
+	}
+	providerSetting := provider.Setting()
+	payMoney := payment.ConvertMoney(plan.Price*float64(req.Periods), providerSetting)
+	if payMoney < 0.01 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐金额过低"})
+		return
+	}
+	tradeNo := fmt.Sprintf("SUB%dNO%s%d", id, common.GetRandomString(6), time.Now().Unix())
+	callBackAddress := service.GetCallbackAddress()
+	order := &payment.Order{
+		TradeNo:   tradeNo,
+		UserId:    id,
+		Quota:     plan.Quota * req.Periods,
+		Money:     payMoney,
+		Currency:  providerSetting.Currency,
+		Title:     fmt.Sprintf("SUB%d-%d", plan.Id, req.Periods),
+		ReturnURL: setting.ServerAddress + "/app/wallet/topup-success",
+		CancelURL: setting.ServerAddress + "/app/wallet",
+		NotifyURL: callBackAddress + "/api/user/payment/" + provider.Name() + "/notify",
+	}
+	if provider.Name() == "paypal" {
+		order.ReturnURL = callBackAddress + "/api/user/payment/paypal/return"
+	}
+	// 先写入待支付订单再拉起支付，避免渠道回调早于订单落库
+	topUp := &model.TopUp{
+		UserId:     id,
+		Money:      payMoney,
+		TradeNo:    tradeNo,
+		CreateTime: time.Now().Unix(),
+		Status:     model.TopUpStatusPending,
+		Provider:   provider.Name(),
+		Currency:   providerSetting.Currency,
+		Quota:      order.Quota,
+		PlanId:     plan.Id,
+		Periods:    req.Periods,
+	}
+	if err = topUp.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "创建订单失败"})
+		return
+	}
+	checkout, err := provider.CreateCheckout(c.Request.Context(), order)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create %s checkout: %s", provider.Name(), err.Error()))
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "拉起支付失败"})
+		return
+	}
+	if err = model.UpdateTopUpProviderOrderId(tradeNo, checkout.ProviderOrderId); err != nil {
+		common.SysError(fmt.Sprintf("failed to save provider order id of %s: %s", tradeNo, err.Error()))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"trade_no": tradeNo,
+			"url":      checkout.URL,
+			"params":   checkout.Params,
+		},
+	})
+}
+
+// GetAllSubscriptionPlans 管理员查看全部套餐（含已禁用）
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" || len(plan.Name) > 64 {
+		return errors.New("套餐名称不能为空且不能超过 64 个字符")
+	}
+	if plan.Price < 0 || plan.Quota < 0 || plan.RolloverCap < 0 || plan.TpmLimit < 0 || plan.Concurrency < 0 {
+		return errors.New("价格、额度与限流不能为负数")
+	}
+	if plan.PeriodDays <= 0 {
+		return errors.New("周期天数必须大于 0")
+	}
+	if plan.MaxPeriods <= 0 {
+		plan.MaxPeriods = 1
+	}
+	if plan.Status != model.SubscriptionPlanStatusEnabled && plan.Status != model.SubscriptionPlanStatusDisabled {
+		plan.Status = model.SubscriptionPlanStatusEnabled
+	}
+	for _, group := range plan.GetGroups() {
+		if !setting.ContainsGroupRatio(group) {
+			return fmt.Errorf("分组 %s 不存在", group)
+		}
+	}
+	plan.Groups = strings.Join(plan.GetGroups(), ",")
+	return nil
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	plan.Id = 0
+	if err := plan.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+// UpdateSubscriptionPlan 修改套餐，额度与结转规则从下一周期开始对已有订阅生效
+func UpdateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil || plan.Id == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	if _, err := model.GetSubscriptionPlanById(plan.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐不存在"})
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	if err := plan.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	plan, err := model.GetSubscriptionPlanById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐不存在"})
+		return
+	}
+	if err = plan.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": ""})
+}
+
+// GetAllSubscriptions 管理员查看订阅记录，可按 user_id 过滤
+func GetAllSubscriptions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subs, total, err := model.GetUserSubscriptions(userId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": subs,
+			"total": total,
+		},
+	})
+}
+
+type CancelSubscriptionRequest struct {
+	UserId int `json:"user_id"`
+}
+
+// CancelSubscription 管理员取消用户的订阅，剩余订阅额度作废，不涉及退款
+func CancelSubscription(c *gin.Context) {
+	var req CancelSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	if err := model.CancelSubscription(req.UserId, ""); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("管理员 %d 取消了订阅", c.GetInt("id")))
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": ""})
+}
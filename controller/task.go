@@ -33,6 +33,7 @@ import (
 	"veloera/dto"
 	"veloera/model"
 	"veloera/relay"
+	"veloera/service"
 )
 
 func UpdateTaskBulk() {
@@ -40,9 +41,14 @@ func UpdateTaskBulk() {
 	//imageModel := "midjourney"
 	for {
 		time.Sleep(time.Duration(15) * time.Second)
+		// 多节点部署时只轮询本节点持有租约的分片
+		shards := service.AcquireTaskPollerShards(service.TaskPollerTask)
+		if shards == nil {
+			continue
+		}
 		common.SysLog("任务进度轮询开始")
 		ctx := context.TODO()
-		allTasks := model.GetAllUnFinishSyncTasks(500)
+		allTasks := model.GetUnfinishedTasksByShards(shards.Count(), shards.Shards(), 500)
 		platformTask := make(map[constant.TaskPlatform][]*model.Task)
 		for _, t := range allTasks {
 			platformTask[t.Platform] = append(platformTask[t.Platform], t)
@@ -60,6 +66,9 @@ func UpdateTaskBulk() {
 					nullTaskIds = append(nullTaskIds, task.ID)
 					continue
 				}
+				if !shards.ShouldPoll(task.ChannelId) {
+					continue
+				}
 				taskM[task.TaskID] = task
 				taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.TaskID)
 			}
@@ -78,26 +87,29 @@ func UpdateTaskBulk() {
 				continue
 			}
 
-			UpdateTaskByPlatform(platform, taskChannelM, taskM)
+			UpdateTaskByPlatform(platform, taskChannelM, taskM, shards)
 		}
 		common.SysLog("任务进度轮询完成")
 	}
 }
 
-func UpdateTaskByPlatform(platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) {
+func UpdateTaskByPlatform(platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task, shards *service.TaskPollerShards) {
 	switch platform {
 	case constant.TaskPlatformMidjourney:
 		//_ = UpdateMidjourneyTaskAll(context.Background(), tasks)
 	case constant.TaskPlatformSuno:
-		_ = UpdateSunoTaskAll(context.Background(), taskChannelM, taskM)
+		_ = UpdateSunoTaskAll(context.Background(), taskChannelM, taskM, shards)
+	case constant.TaskPlatformVideo:
+		_ = UpdateVideoTaskAll(context.Background(), taskChannelM, taskM, shards)
 	default:
 		common.SysLog("未知平台")
 	}
 }
 
-func UpdateSunoTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+func UpdateSunoTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task, shards *service.TaskPollerShards) error {
 	for channelId, taskIds := range taskChannelM {
 		err := updateSunoTaskAll(ctx, channelId, taskIds, taskM)
+		shards.RecordResult(channelId, err)
 		if err != nil {
 			common.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %d", channelId, err.Error()))
 		}
@@ -160,6 +172,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		if !checkTaskNeedUpdate(task, responseItem) {
 			continue
 		}
+		oldStatus := task.Status
 
 		task.Status = lo.If(model.TaskStatus(responseItem.Status) != "", model.TaskStatus(responseItem.Status)).Else(task.Status)
 		task.FailReason = lo.If(responseItem.FailReason != "", responseItem.FailReason).Else(task.FailReason)
@@ -169,20 +182,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		if responseItem.FailReason != "" || task.Status == model.TaskStatusFailure {
 			common.LogInfo(ctx, task.TaskID+" 构建失败，"+task.FailReason)
 			task.Progress = "100%"
-			//err = model.CacheUpdateUserQuota(task.UserId) ?
-			if err != nil {
-				common.LogError(ctx, "error update user quota cache: "+err.Error())
-			} else {
-				quota := task.Quota
-				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
-					if err != nil {
-						common.LogError(ctx, "fail to increase user quota: "+err.Error())
-					}
-					logContent := fmt.Sprintf("异步任务执行失败 %s，补偿 %s", task.TaskID, common.LogQuota(quota))
-					model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
-				}
-			}
+			task.Status = model.TaskStatusFailure
 		}
 		if responseItem.Status == model.TaskStatusSuccess {
 			task.Progress = "100%"
@@ -192,6 +192,11 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		err = task.Update()
 		if err != nil {
 			common.SysError("UpdateMidjourneyTask task error: " + err.Error())
+		} else {
+			service.SettleTask(ctx, task, "")
+			if task.Status != oldStatus {
+				service.NotifyTaskFinished(task)
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,83 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAllTaskSettlements 管理员查看异步任务结算记录
+func GetAllTaskSettlements(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	settlements, total, err := model.GetTaskSettlements(userId, c.Query("source"), c.Query("status"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": settlements,
+			"total": total,
+		},
+	})
+}
+
+type reconcileTasksRequest struct {
+	OlderThanMinutes int  `json:"older_than_minutes"`
+	Limit            int  `json:"limit"`
+	DryRun           bool `json:"dry_run"`
+}
+
+// ReconcileTasks 将长时间未完成的异步任务判定为失败并退款，补结算遗留的预留记录
+func ReconcileTasks(c *gin.Context) {
+	var req reconcileTasksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.OlderThanMinutes <= 0 {
+		req.OlderThanMinutes = 24 * 60
+	}
+	if req.Limit <= 0 || req.Limit > 1000 {
+		req.Limit = 1000
+	}
+	result := service.ReconcileTasks(c, time.Duration(req.OlderThanMinutes)*time.Minute, req.Limit, req.DryRun)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/model"
+	"veloera/relay"
+	relaychannel "veloera/relay/channel"
+	relaycommon "veloera/relay/common"
+	"veloera/service"
+	"veloera/setting/operation_setting"
+)
+
+// UpdateVideoTaskAll 视频任务只能按 ID 查询，逐个轮询并按指数退避推迟下次查询
+func UpdateVideoTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task, shards *service.TaskPollerShards) error {
+	now := time.Now().Unix()
+	for channelId, taskIds := range taskChannelM {
+		channel, err := model.CacheGetChannel(channelId)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("CacheGetChannel: %v", err))
+			for _, taskId := range taskIds {
+				finishVideoTask(ctx, taskM[taskId], model.TaskStatusFailure, fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId))
+			}
+			shards.RecordResult(channelId, err)
+			continue
+		}
+		adaptor, ok := relay.GetTaskAdaptor(constant.TaskPlatformVideo).(relaychannel.TaskPollAdaptor)
+		if !ok {
+			return fmt.Errorf("video adaptor not found")
+		}
+		adaptor.Init(&relaycommon.TaskRelayInfo{RelayInfo: &relaycommon.RelayInfo{ChannelType: channel.Type}})
+		var pollErr error
+		polled := false
+		for _, taskId := range taskIds {
+			task := taskM[taskId]
+			if task.NextPollTime > now {
+				continue
+			}
+			polled = true
+			if err := updateVideoTask(ctx, adaptor, channel, task); err != nil {
+				pollErr = err
+			}
+		}
+		if polled {
+			shards.RecordResult(channelId, pollErr)
+		}
+	}
+	return nil
+}
+
+// updateVideoTask 查询并更新单个视频任务，返回上游查询错误
+func updateVideoTask(ctx context.Context, adaptor relaychannel.TaskPollAdaptor, channel *model.Channel, task *model.Task) error {
+	setting := operation_setting.GetVideoSetting()
+	if setting.TaskTimeoutMinutes > 0 && time.Now().Unix()-task.SubmitTime > int64(setting.TaskTimeoutMinutes)*60 {
+		finishVideoTask(ctx, task, model.TaskStatusFailure, "任务超时")
+		return nil
+	}
+
+	info, responseBody, err := fetchVideoTask(adaptor, channel, task)
+	if err != nil {
+		common.LogError(ctx, fmt.Sprintf("渠道 #%d 查询视频任务 %s 失败: %s", channel.Id, task.TaskID, err.Error()))
+		delayVideoTaskPoll(task, setting)
+		if _, err := task.UpdateUnfinished(); err != nil {
+			common.SysError("update video task error: " + err.Error())
+		}
+		return err
+	}
+	if json.Valid(responseBody) {
+		task.Data = responseBody
+	}
+	task.Progress = fmt.Sprintf("%d%%", min(info.Progress, 99))
+	switch info.Status {
+	case model.TaskStatusSuccess:
+		task.ResultURL = info.Url
+		finishVideoTask(ctx, task, model.TaskStatusSuccess, "")
+		return nil
+	case model.TaskStatusFailure:
+		finishVideoTask(ctx, task, model.TaskStatusFailure, info.Reason)
+		return nil
+	case model.TaskStatusInProgress:
+		if task.StartTime == 0 {
+			task.StartTime = time.Now().Unix()
+		}
+		task.Status = model.TaskStatusInProgress
+	case model.TaskStatusQueued:
+		task.Status = model.TaskStatusQueued
+	}
+	delayVideoTaskPoll(task, setting)
+	if _, err := task.UpdateUnfinished(); err != nil {
+		common.SysError("update video task error: " + err.Error())
+	}
+	return nil
+}
+
+func fetchVideoTask(adaptor relaychannel.TaskPollAdaptor, channel *model.Channel, task *model.Task) (*relaycommon.TaskInfo, []byte, error) {
+	resp, err := adaptor.FetchTask(channel.GetBaseURL(), channel.Key, map[string]any{
+		"task_id": task.TaskID,
+		"action":  task.Action,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("status code %d: %s", resp.StatusCode, string(responseBody))
+	}
+	info, err := adaptor.ParseTaskResult(responseBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, responseBody, nil
+}
+
+// delayVideoTaskPoll 按轮询次数指数退避，间隔不超过 MaxPollIntervalSeconds
+func delayVideoTaskPoll(task *model.Task, setting *operation_setting.VideoSetting) {
+	interval := max(setting.PollIntervalSeconds, 1)
+	for i := 0; i < task.PollCount && interval < setting.MaxPollIntervalSeconds; i++ {
+		interval *= 2
+	}
+	if setting.MaxPollIntervalSeconds > 0 {
+		interval = min(interval, setting.MaxPollIntervalSeconds)
+	}
+	task.PollCount++
+	task.NextPollTime = time.Now().Unix() + int64(interval)
+}
+
+func finishVideoTask(ctx context.Context, task *model.Task, status model.TaskStatus, reason string) {
+	task.Status = status
+	task.Progress = "100%"
+	task.FinishTime = time.Now().Unix()
+	if reason != "" {
+		task.FailReason = reason
+	}
+	updated, err := task.UpdateUnfinished()
+	if err != nil {
+		common.SysError("update video task error: " + err.Error())
+		return
+	}
+	if !updated {
+		return
+	}
+	if status == model.TaskStatusFailure {
+		common.LogInfo(ctx, task.TaskID+" 构建失败，"+task.FailReason)
+	}
+	service.SettleTask(ctx, task, "")
+	service.NotifyTaskFinished(task)
+}
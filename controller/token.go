@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"veloera/common"
 	"veloera/model"
+	"veloera/service"
 )
 
 func GetAllTokens(c *gin.Context) {
@@ -82,7 +83,7 @@ func GetToken(c *gin.Context) {
 		})
 		return
 	}
-	token, err := model.GetTokenByIds(id, userId)
+	token, err := model.GetManageableToken(id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -122,6 +123,42 @@ func GetTokenStatus(c *gin.Context) {
 	})
 }
 
+func GetTokenSpending(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt("id")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	token, err := model.GetManageableToken(id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	usages, err := model.GetSpendingWindowUsages(model.SpendingOwnerToken, token.Id, token.GetSpendingLimit())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"soft_limit_percent": token.SoftLimitPercent,
+			"windows":            usages,
+		},
+	})
+}
+
 func AddToken(c *gin.Context) {
 	token := model.Token{}
 	err := c.ShouldBindJSON(&token)
@@ -139,6 +176,28 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if token.WebhookUrl != "" {
+		if err := service.ValidateWebhookUrl(token.WebhookUrl); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	if token.OrgId != 0 {
+		org, err := model.GetOrganizationById(token.OrgId)
+		if err == nil {
+			_, err = model.GetOrganizationMember(org.Id, c.GetInt("id"))
+		}
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "组织不存在或您不是该组织成员",
+			})
+			return
+		}
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -150,6 +209,7 @@ func AddToken(c *gin.Context) {
 	}
 	cleanToken := model.Token{
 		UserId:             c.GetInt("id"),
+		OrgId:              token.OrgId,
 		Name:               token.Name,
 		Key:                key,
 		CreatedTime:        common.GetTimestamp(),
@@ -165,6 +225,15 @@ func AddToken(c *gin.Context) {
 		ModelLimits:        token.ModelLimits,
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
+		DailyQuotaLimit:    token.DailyQuotaLimit,
+		WeeklyQuotaLimit:   token.WeeklyQuotaLimit,
+		MonthlyQuotaLimit:  token.MonthlyQuotaLimit,
+		SoftLimitPercent:   token.SoftLimitPercent,
+		TpmLimit:           token.TpmLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		WebhookUrl:         token.WebhookUrl,
+		PiiMask:            token.PiiMask,
+		AuditCapture:       token.AuditCapture,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -218,7 +287,16 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
-	cleanToken, err := model.GetTokenByIds(token.Id, userId)
+	if token.WebhookUrl != "" {
+		if err := service.ValidateWebhookUrl(token.WebhookUrl); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	cleanToken, err := model.GetManageableToken(token.Id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -258,6 +336,15 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
+		cleanToken.DailyQuotaLimit = token.DailyQuotaLimit
+		cleanToken.WeeklyQuotaLimit = token.WeeklyQuotaLimit
+		cleanToken.MonthlyQuotaLimit = token.MonthlyQuotaLimit
+		cleanToken.SoftLimitPercent = token.SoftLimitPercent
+		cleanToken.TpmLimit = token.TpmLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.WebhookUrl = token.WebhookUrl
+		cleanToken.PiiMask = token.PiiMask
+		cleanToken.AuditCapture = token.AuditCapture
 	}
 	err = cleanToken.Update()
 	if err != nil {
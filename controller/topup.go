@@ -21,11 +21,11 @@ import (
 	"log"
 	"net/url"
 	"strconv"
-	"sync"
 	"time"
 	"veloera/common"
 	"veloera/model"
 	"veloera/service"
+	"veloera/service/payment"
 	"veloera/setting"
 
 	"github.com/Calcium-Ion/go-epay/epay"
@@ -156,7 +156,8 @@ func RequestEpay(c *gin.Context) {
 		Money:      payMoney,
 		TradeNo:    tradeNo,
 		CreateTime: time.Now().Unix(),
-		Status:     "pending",
+		Status:     model.TopUpStatusPending,
+		Provider:   "epay",
 	}
 	err = topUp.Insert()
 	if err != nil {
@@ -166,33 +167,6 @@ func RequestEpay(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
 }
 
-// tradeNo lock
-var orderLocks sync.Map
-var createLock sync.Mutex
-
-// LockOrder 尝试对给定订单号加锁
-func LockOrder(tradeNo string) {
-	lock, ok := orderLocks.Load(tradeNo)
-	if !ok {
-		createLock.Lock()
-		defer createLock.Unlock()
-		lock, ok = orderLocks.Load(tradeNo)
-		if !ok {
-			lock = new(sync.Mutex)
-			orderLocks.Store(tradeNo, lock)
-		}
-	}
-	lock.(*sync.Mutex).Lock()
-}
-
-// UnlockOrder 释放给定订单号的锁
-func UnlockOrder(tradeNo string) {
-	lock, ok := orderLocks.Load(tradeNo)
-	if ok {
-		lock.(*sync.Mutex).Unlock()
-	}
-}
-
 func EpayNotify(c *gin.Context) {
 	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
 		r[t] = c.Request.URL.Query().Get(t)
@@ -224,38 +198,9 @@ func EpayNotify(c *gin.Context) {
 
 	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
 		log.Println(verifyInfo)
-		LockOrder(verifyInfo.ServiceTradeNo)
-		defer UnlockOrder(verifyInfo.ServiceTradeNo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp == nil {
-			log.Printf("易支付回调未找到订单: %v", verifyInfo)
-			return
-		}
-		if topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			dAmount := decimal.NewFromInt(int64(topUp.Amount))
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
-			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true)
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(quotaToAdd), topUp.Money))
-
-			// 处理返佣逻辑
-			err = model.ProcessRebate(topUp.UserId, quotaToAdd, "充值")
-			if err != nil {
-				log.Printf("处理充值返佣失败: %v", err)
-			}
+		err = payment.FulfillOrder(verifyInfo.ServiceTradeNo, verifyInfo.TradeNo, 0)
+		if err != nil {
+			log.Printf("易支付回调处理订单失败: %v, %v", verifyInfo, err)
 		}
 	} else {
 		log.Printf("易支付异常回调: %v", verifyInfo)
@@ -0,0 +1,176 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type usageExportRequest struct {
+	model.UsageExportFilter
+	Format string `json:"format"`
+	Rollup string `json:"rollup"`
+}
+
+func createUsageExport(c *gin.Context, scope string) {
+	var req usageExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	export, err := service.CreateUsageExport(c.GetInt("id"), scope, req.Format, req.Rollup, &req.UsageExportFilter)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    export,
+	})
+}
+
+// CreateUsageExport 管理员按条件导出全部用户的日志
+func CreateUsageExport(c *gin.Context) {
+	createUsageExport(c, model.UsageExportScopeAll)
+}
+
+// CreateUserUsageExport 用户导出自己的日志
+func CreateUserUsageExport(c *gin.Context) {
+	createUsageExport(c, model.UsageExportScopeSelf)
+}
+
+func listUsageExports(c *gin.Context, userId int, scope string) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	exports, total, err := model.GetUsageExports(userId, scope, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": exports,
+			"total": total,
+		},
+	})
+}
+
+func GetUsageExports(c *gin.Context) {
+	listUsageExports(c, 0, model.UsageExportScopeAll)
+}
+
+func GetUserUsageExports(c *gin.Context) {
+	listUsageExports(c, c.GetInt("id"), model.UsageExportScopeSelf)
+}
+
+// getUsageExport 按路由参数获取导出任务，普通用户只能访问自己发起的导出
+func getUsageExport(c *gin.Context, scope string) *model.UsageExport {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return nil
+	}
+	export, err := model.GetUsageExportById(id)
+	if err != nil || export.Scope != scope ||
+		(scope == model.UsageExportScopeSelf && export.UserId != c.GetInt("id")) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "导出任务不存在",
+		})
+		return nil
+	}
+	return export
+}
+
+func downloadUsageExport(c *gin.Context, scope string) {
+	export := getUsageExport(c, scope)
+	if export == nil {
+		return
+	}
+	if export.Status != model.UsageExportStatusCompleted {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "导出文件尚未生成",
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", strconv.FormatInt(export.FileSize, 10))
+	c.Status(http.StatusOK)
+	if err := service.StreamUsageExport(export, c.Writer); err != nil {
+		common.LogError(c, fmt.Sprintf("stream usage export #%d error: %s", export.Id, err.Error()))
+	}
+}
+
+func DownloadUsageExport(c *gin.Context) {
+	downloadUsageExport(c, model.UsageExportScopeAll)
+}
+
+func DownloadUserUsageExport(c *gin.Context) {
+	downloadUsageExport(c, model.UsageExportScopeSelf)
+}
+
+func deleteUsageExport(c *gin.Context, scope string) {
+	export := getUsageExport(c, scope)
+	if export == nil {
+		return
+	}
+	if err := service.DeleteUsageExport(export); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func DeleteUsageExport(c *gin.Context) {
+	deleteUsageExport(c, model.UsageExportScopeAll)
+}
+
+func DeleteUserUsageExport(c *gin.Context) {
+	deleteUsageExport(c, model.UsageExportScopeSelf)
+}
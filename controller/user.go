@@ -501,6 +501,34 @@ func GetSelf(c *gin.Context) {
 	return
 }
 
+func GetSelfSpending(c *gin.Context) {
+	id := c.GetInt("id")
+	user, err := model.GetUserCache(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	usages, err := model.GetSpendingWindowUsages(model.SpendingOwnerUser, id, user.GetSpendingLimit())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"soft_limit_percent": user.SoftLimitPercent,
+			"windows":            usages,
+		},
+	})
+}
+
 func GetUserModels(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -514,7 +542,7 @@ func GetUserModels(c *gin.Context) {
 		})
 		return
 	}
-	groups := setting.GetUserUsableGroups(user.Group)
+	groups := setting.GetUserUsableGroups(user.Group, model.GetPlanGroups(user.PlanId)...)
 	var models []string
 	addedModels := make(map[string]bool) // Track added models to avoid duplicates
 
@@ -652,7 +680,7 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
-	if err := updatedUser.Edit(updatePassword); err != nil {
+	if err := updatedUser.Edit(updatePassword, c.GetInt("id")); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
@@ -1049,7 +1077,7 @@ func TopUp(c *gin.Context) {
 	}
 
 	id := c.GetInt("id")
-	quota, isGift, err := model.Redeem(req.Key, id) // 修改这里，接收所有三个返回值
+	result, err := model.Redeem(req.Key, id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -1060,10 +1088,7 @@ func TopUp(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data": gin.H{
-			"quota":   quota,
-			"is_gift": isGift, // 可以选择返回给前端，用于显示不同的提示信息
-		},
+		"data":    result,
 	})
 	return
 }
@@ -0,0 +1,85 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listWebhookDeliveries(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	deliveries, total, err := model.GetWebhookDeliveries(userId, c.Query("task_id"), c.Query("status"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": deliveries,
+			"total": total,
+		},
+	})
+}
+
+func GetUserWebhookDeliveries(c *gin.Context) {
+	listWebhookDeliveries(c, c.GetInt("id"))
+}
+
+func GetAllWebhookDeliveries(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	listWebhookDeliveries(c, userId)
+}
+
+// RetryWebhookDelivery 立即重新投递一条回调，普通用户只能重试自己的记录
+func RetryWebhookDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	delivery, err := model.GetWebhookDeliveryById(id)
+	if err != nil || (c.GetInt("role") < common.RoleAdminUser && delivery.UserId != c.GetInt("id")) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "投递记录不存在",
+		})
+		return
+	}
+	service.RetryWebhookDelivery(delivery)
+	c.JSON(http.StatusOK, gin.H{
+		"success": delivery.Status == model.WebhookDeliveryStatusSuccess,
+		"message": delivery.LastError,
+		"data":    delivery,
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package dto
+
+// SpendingLimit 消费窗口限额配置，单位为额度，0 表示不限制
+type SpendingLimit struct {
+	Daily            int `json:"daily"`
+	Weekly           int `json:"weekly"`
+	Monthly          int `json:"monthly"`
+	SoftLimitPercent int `json:"soft_limit_percent"`
+}
+
+func (l SpendingLimit) Enabled() bool {
+	return l.Daily > 0 || l.Weekly > 0 || l.Monthly > 0
+}
+
+// SpendingWindowUsage 某个周期窗口内的用量
+type SpendingWindowUsage struct {
+	Period      string `json:"period"`
+	Limit       int    `json:"limit"`
+	UsedQuota   int    `json:"used_quota"`
+	WindowStart int64  `json:"window_start"`
+	ResetAt     int64  `json:"reset_at"`
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package dto
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// VideoSeconds 兼容字符串与数字两种写法的视频时长
+type VideoSeconds int
+
+func (s *VideoSeconds) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		number = json.Number(str)
+	}
+	if number == "" {
+		*s = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(string(number), 64)
+	if err != nil {
+		return err
+	}
+	*s = VideoSeconds(value)
+	return nil
+}
+
+// VideoRequest 视频生成请求，兼容 OpenAI /v1/videos 格式
+// InputReference 为图片 URL 或 base64 data URL，填写时为图生视频
+type VideoRequest struct {
+	Model          string       `json:"model"`
+	Prompt         string       `json:"prompt"`
+	NegativePrompt string       `json:"negative_prompt,omitempty"`
+	Seconds        VideoSeconds `json:"seconds,omitempty"`
+	Size           string       `json:"size,omitempty"`
+	AspectRatio    string       `json:"aspect_ratio,omitempty"`
+	Mode           string       `json:"mode,omitempty"`
+	InputReference string       `json:"input_reference,omitempty"`
+}
+
+type VideoError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// VideoResponse OpenAI video 对象
+type VideoResponse struct {
+	Id          string      `json:"id"`
+	Object      string      `json:"object"`
+	Model       string      `json:"model"`
+	Status      string      `json:"status"`
+	Progress    int         `json:"progress"`
+	CreatedAt   int64       `json:"created_at"`
+	CompletedAt int64       `json:"completed_at,omitempty"`
+	Seconds     string      `json:"seconds,omitempty"`
+	Size        string      `json:"size,omitempty"`
+	Url         string      `json:"url,omitempty"`
+	Error       *VideoError `json:"error,omitempty"`
+}
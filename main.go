@@ -72,6 +72,12 @@ func main() {
 
 	model.CheckSetup()
 
+	if common.IsMasterNode {
+		if err := model.InitQuotaLedger(); err != nil {
+			common.SysError("failed to initialize quota ledger: " + err.Error())
+		}
+	}
+
 	// Initialize SQL Database
 	err = model.InitLogDB()
 	if err != nil {
@@ -97,6 +103,10 @@ func main() {
 	// Initialize options
 	model.InitOptionMap()
 
+	if operation_setting.GetAuditArchiveSetting().Enabled && common.AuditEncryptionKey == "" {
+		common.SysError("audit archive is enabled but AUDIT_ENCRYPTION_KEY is not set, requests will not be archived")
+	}
+
 	// Initialize global model mapping service
 	if err := service.InitializeModelMappingService(); err != nil {
 		common.SysError("failed to initialize model mapping service: " + err.Error())
@@ -115,10 +125,12 @@ func main() {
 		common.SysLog("memory cache enabled")
 		common.SysError(fmt.Sprintf("sync frequency: %d seconds", common.SyncFrequency))
 		model.InitChannelCache()
+		model.InitSubscriptionPlanCache()
 	}
 	if common.MemoryCacheEnabled {
 		go model.SyncOptions(common.SyncFrequency)
 		go model.SyncChannelCache(common.SyncFrequency)
+		go model.SyncSubscriptionPlanCache(common.SyncFrequency)
 	}
 
 	// 数据看板
@@ -139,7 +151,12 @@ func main() {
 		go controller.AutomaticallyTestChannels(frequency)
 	}
 	go controller.ScheduledAutoUpdateChannelModels(60)
-	if common.IsMasterNode && constant.UpdateTask {
+	// 审计归档文件保存在各节点本地目录，清理任务需在每个节点运行
+	gopool.Go(func() {
+		service.RunAuditRetentionJob()
+	})
+	// 任务轮询在每个节点运行，各分片由租约决定归属
+	if constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
 		})
@@ -147,6 +164,23 @@ func main() {
 			controller.UpdateTaskBulk()
 		})
 	}
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			service.RunTaskWebhookWorker()
+		})
+		gopool.Go(func() {
+			service.RunUsageExportJanitor()
+		})
+		gopool.Go(func() {
+			service.RunMonthlyStatementJob()
+		})
+		gopool.Go(func() {
+			service.RunSubscriptionJob()
+		})
+		gopool.Go(func() {
+			channeltest.StartHealthProbe()
+		})
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
@@ -23,6 +23,7 @@ import (
 	"strconv"
 	"strings"
 	"veloera/common"
+	"veloera/constant"
 	"veloera/model"
 )
 
@@ -263,6 +264,22 @@ func TokenAuth() func(c *gin.Context) {
 		}
 		c.Set("allow_ips", token.GetIpLimitsMap())
 		c.Set("token_group", token.Group)
+		c.Set(constant.ContextKeyTokenSpendingLimit, token.GetSpendingLimit())
+		c.Set("token_tpm_limit", token.TpmLimit)
+		c.Set("token_concurrency_limit", token.ConcurrencyLimit)
+		c.Set("token_webhook_url", token.WebhookUrl)
+		c.Set("token_pii_mask", token.PiiMask)
+		c.Set("token_audit_capture", token.AuditCapture)
+		if token.OrgId != 0 {
+			member, err := model.CacheGetOrganizationMember(token.OrgId, token.UserId)
+			if err != nil {
+				abortWithOpenAiMessage(c, http.StatusForbidden, "令牌所属组织不存在或令牌持有者已不是组织成员")
+				return
+			}
+			c.Set(constant.ContextKeyOrgId, token.OrgId)
+			c.Set(constant.ContextKeyOrgMemberId, member.Id)
+			c.Set(constant.ContextKeyOrgMemberSpendingLimit, member.GetSpendingLimit())
+		}
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set("specific_channel_id", parts[1])
@@ -221,7 +221,8 @@ func Distribute() func(c *gin.Context) {
 		tokenGroup := c.GetString("token_group")
 		if tokenGroup != "" {
 			// check common.UserUsableGroups[userGroup]
-			if _, ok := setting.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
+			planGroups := model.GetPlanGroups(c.GetInt(constant.ContextKeyUserPlanId))
+			if _, ok := setting.GetUserUsableGroups(userGroup, planGroups...)[tokenGroup]; !ok {
 				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("令牌分组 %s 已被禁用", tokenGroup))
 				return
 			}
@@ -309,6 +310,10 @@ func Distribute() func(c *gin.Context) {
 					return
 				}
 			}
+			// 套餐不支持的模型只能使用余额，不扣减订阅额度
+			if plan, ok := model.CacheGetSubscriptionPlan(c.GetInt(constant.ContextKeyUserPlanId)); ok && !plan.AllowModel(originalModel) {
+				c.Set(constant.ContextKeySubscriptionExcluded, true)
+			}
 
 			if shouldSelectChannel {
 				// If we have a model prefix, use it to select among specific channels
@@ -384,6 +389,16 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		}
 		c.Set("platform", string(constant.TaskPlatformSuno))
 		c.Set("relay_mode", relayMode)
+	} else if strings.Contains(c.Request.URL.Path, "/v1/videos") {
+		relayMode := relayconstant.Path2RelayVideo(c.Request.Method, c.Request.URL.Path)
+		if relayMode == relayconstant.RelayModeVideoSubmit {
+			err = common.UnmarshalBodyReusable(c, &modelRequest)
+		} else {
+			// 查询、下载与取消使用任务所属渠道
+			shouldSelectChannel = false
+		}
+		c.Set("platform", string(constant.TaskPlatformVideo))
+		c.Set("relay_mode", relayMode)
 	} else if !strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") {
 		err = common.UnmarshalBodyReusable(c, &modelRequest)
 	}
@@ -23,6 +23,9 @@ import (
 	"strconv"
 	"time"
 	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/service"
 	"veloera/setting"
 
 	"github.com/gin-gonic/gin"
@@ -103,7 +106,8 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 			return
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
+			abortWithRateLimit(c, redisRateLimitSnapshot(ctx, rdb, totalKey, totalMaxCount, duration), fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
+			return
 		}
 
 		// 2. 检查成功请求数限制
@@ -115,12 +119,18 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 			return
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
+			abortWithRateLimit(c, redisRateLimitSnapshot(ctx, rdb, successKey, successMaxCount, duration), fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
 			return
 		}
 
 		// 3. 记录总请求（当totalMaxCount为0时会自动跳过）
 		recordRedisRequest(ctx, rdb, totalKey, totalMaxCount)
+		if totalMaxCount > 0 {
+			exposeRequestRateLimit(c, redisRateLimitSnapshot(ctx, rdb, totalKey, totalMaxCount, duration))
+		}
+		if successMaxCount > 0 {
+			exposeRequestRateLimit(c, redisRateLimitSnapshot(ctx, rdb, successKey, successMaxCount, duration))
+		}
 
 		// 4. 处理请求
 		c.Next()
@@ -143,8 +153,7 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 
 		// 1. 检查总请求数限制（当totalMaxCount为0时跳过）
 		if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+			abortWithRateLimit(c, memoryRateLimitSnapshot(totalKey, totalMaxCount, duration), fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
 			return
 		}
 
@@ -152,10 +161,13 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 		// 使用一个临时key来检查限制，这样可以避免实际记录
 		checkKey := successKey + "_check"
 		if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+			abortWithRateLimit(c, memoryRateLimitSnapshot(checkKey, successMaxCount, duration), fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
 			return
 		}
+		if totalMaxCount > 0 {
+			exposeRequestRateLimit(c, memoryRateLimitSnapshot(totalKey, totalMaxCount, duration))
+		}
+		exposeRequestRateLimit(c, memoryRateLimitSnapshot(checkKey, successMaxCount, duration))
 
 		// 3. 处理请求
 		c.Next()
@@ -167,6 +179,53 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 	}
 }
 
+// requestRateLimitAvailable 不记录请求地检查总请求数与成功请求数限制是否仍有余量
+func requestRateLimitAvailable(userId string, duration int64, totalMaxCount, successMaxCount int) bool {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		for key, maxCount := range map[string]int{
+			fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitCountMark, userId):        totalMaxCount,
+			fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, userId): successMaxCount,
+		} {
+			if allowed, err := checkRedisRateLimit(ctx, common.RDB, key, maxCount, duration); err != nil || !allowed {
+				// 检查失败时交由限流处理器返回错误
+				return err != nil
+			}
+		}
+		return true
+	}
+	for key, maxCount := range map[string]int{
+		ModelRequestRateLimitCountMark + userId:                   totalMaxCount,
+		ModelRequestRateLimitSuccessCountMark + userId + "_check": successMaxCount,
+	} {
+		if maxCount <= 0 {
+			continue
+		}
+		if remaining, _ := inMemoryRateLimiter.State(key, maxCount, duration); remaining <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForRequestRateLimit 请求数超限且启用排队时等待窗口滚动，请求数限制只针对用户自身，不阻塞队列中的其他请求
+func waitForRequestRateLimit(c *gin.Context, duration int64, totalMaxCount, successMaxCount int) {
+	userId := strconv.Itoa(c.GetInt("id"))
+	if requestRateLimitAvailable(userId, duration, totalMaxCount, successMaxCount) {
+		return
+	}
+	limitErr := service.OpenAIErrorWrapperLocal(fmt.Errorf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount),
+		"rate_limit_exceeded", http.StatusTooManyRequests)
+	key := c.GetString(constant.ContextKeyUserGroup) + "/requests"
+	_, _ = service.WaitInRequestQueue(c, key, limitErr, false, func() (func(), *dto.OpenAIErrorWithStatusCode, bool) {
+		if requestRateLimitAvailable(userId, duration, totalMaxCount, successMaxCount) {
+			return func() {}, nil, false
+		}
+		return func() {}, limitErr, false
+	})
+	// 排队超时后由限流处理器返回 429 及对应响应头
+}
+
 // ModelRequestRateLimit 模型请求限流中间件
 func ModelRequestRateLimit() func(c *gin.Context) {
 	return func(c *gin.Context) {
@@ -181,6 +240,12 @@ func ModelRequestRateLimit() func(c *gin.Context) {
 		totalMaxCount := setting.ModelRequestRateLimitCount
 		successMaxCount := setting.ModelRequestRateLimitSuccessCount
 
+		waitForRequestRateLimit(c, duration, totalMaxCount, successMaxCount)
+		if c.Request.Context().Err() != nil {
+			c.Abort()
+			return
+		}
+
 		// 根据存储类型选择并执行限流处理器
 		if common.RedisEnabled {
 			redisRateLimitHandler(duration, totalMaxCount, successMaxCount)(c)
@@ -0,0 +1,218 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"veloera/common"
+	"veloera/model"
+	"veloera/relay/helper"
+	"veloera/service"
+	"veloera/setting"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// moderationTextKeys 请求体中需要审核的文本字段
+var moderationTextKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"prompt":       true,
+	"input":        true,
+	"instruction":  true,
+	"instructions": true,
+	"system":       true,
+	"query":        true,
+}
+
+type moderationContent struct {
+	texts   []string
+	setters []func(string)
+	images  []string
+}
+
+func (m *moderationContent) addText(text string, setter func(string)) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	m.texts = append(m.texts, text)
+	m.setters = append(m.setters, setter)
+}
+
+// collect 遍历请求体，收集 OpenAI、Claude、Gemini 格式中的文本与图片
+func (m *moderationContent) collect(node any) {
+	switch v := node.(type) {
+	case map[string]any:
+		for key, child := range v {
+			switch key {
+			case "image_url":
+				if url, ok := child.(string); ok {
+					m.images = append(m.images, url)
+				} else if obj, ok := child.(map[string]any); ok {
+					if url, ok := obj["url"].(string); ok {
+						m.images = append(m.images, url)
+					}
+				}
+				continue
+			case "source":
+				// Claude 图片
+				if obj, ok := child.(map[string]any); ok && obj["type"] == "base64" {
+					mediaType, _ := obj["media_type"].(string)
+					data, _ := obj["data"].(string)
+					m.images = append(m.images, "data:"+mediaType+";base64,"+data)
+					continue
+				}
+			case "inline_data", "inlineData":
+				// Gemini 图片
+				if obj, ok := child.(map[string]any); ok {
+					mimeType, _ := obj["mime_type"].(string)
+					if mimeType == "" {
+						mimeType, _ = obj["mimeType"].(string)
+					}
+					data, _ := obj["data"].(string)
+					if strings.HasPrefix(mimeType, "image/") {
+						m.images = append(m.images, "data:"+mimeType+";base64,"+data)
+					}
+				}
+				continue
+			}
+			if !moderationTextKeys[key] {
+				m.collect(child)
+				continue
+			}
+			switch value := child.(type) {
+			case string:
+				k := key
+				m.addText(value, func(s string) { v[k] = s })
+			case []any:
+				for i, item := range value {
+					if s, ok := item.(string); ok {
+						idx := i
+						m.addText(s, func(s string) { value[idx] = s })
+					} else {
+						m.collect(item)
+					}
+				}
+			default:
+				m.collect(child)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			m.collect(item)
+		}
+	}
+}
+
+// Moderation 在渠道分发后对请求内容执行审核流水线，并为需要审核输出的分组启用流式输出审核
+func Moderation() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		group := c.GetString("group")
+		if !operation_setting.ModerationEnabledForGroup(group) ||
+			(setting.SafeCheckExemptEnabled && group == setting.SafeCheckExemptGroup) {
+			c.Next()
+			return
+		}
+		if !moderateRequest(c, group) {
+			return
+		}
+		// 请求可能已被改由其他分组处理
+		group = c.GetString("group")
+		if operation_setting.ModerationOutputEnabledForGroup(group) {
+			moderationSetting := operation_setting.GetModerationSetting()
+			local, remote := service.NewStreamOutputCheckers(c, group)
+			helper.EnableStreamOutputModeration(c, moderationSetting.StreamWindow, local, remote, moderationSetting.StreamRemoteInterval)
+		}
+		c.Next()
+	}
+}
+
+// moderateRequest 审核请求体，请求被拒绝时返回 false
+func moderateRequest(c *gin.Context, group string) bool {
+	if c.Request.Method != http.MethodPost || !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return true
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
+		return false
+	}
+	defer func() {
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	}()
+	var body any
+	decoder := json.NewDecoder(bytes.NewReader(requestBody))
+	decoder.UseNumber()
+	if err = decoder.Decode(&body); err != nil {
+		// 交由后续流程报告请求格式错误
+		return true
+	}
+	content := &moderationContent{}
+	content.collect(body)
+	if len(content.texts) == 0 && len(content.images) == 0 {
+		return true
+	}
+	result, err := service.Moderate(c.Request.Context(), group, operation_setting.ModerationDirectionInput, service.ModerationInput{
+		Texts:  content.texts,
+		Images: content.images,
+	})
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, "moderation error: "+err.Error())
+		return false
+	}
+	if len(result.Hits) > 0 {
+		service.AppendModerationHits(c, result.Hits)
+		common.LogWarn(c, fmt.Sprintf("moderation hits for user %d: action=%s, hits=%v", c.GetInt("id"), result.Action, result.Hits))
+	}
+	switch result.Action {
+	case operation_setting.ModerationActionBlock:
+		abortWithOpenAiMessage(c, http.StatusBadRequest, "请求内容未通过审核")
+		return false
+	case operation_setting.ModerationActionRoute:
+		originalModel := c.GetString("original_model")
+		channel, err := model.CacheGetRandomSatisfiedChannel(result.RouteGroup, originalModel, 0)
+		if err != nil || channel == nil {
+			message := fmt.Sprintf("审核分组 %s 下模型 %s 无可用渠道", result.RouteGroup, originalModel)
+			common.LogError(c, message)
+			abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message)
+			return false
+		}
+		c.Set("group", result.RouteGroup)
+		SetupContextForSelectedChannel(c, channel, originalModel)
+	}
+	if result.Redacted {
+		for i, setter := range content.setters {
+			setter(result.Texts[i])
+		}
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err = encoder.Encode(body); err != nil {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "moderation error: "+err.Error())
+			return false
+		}
+		requestBody = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		c.Set(common.KeyRequestBody, requestBody)
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"veloera/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const requestRateLimitSnapshotKey = "request_rate_limit_snapshot"
+
+// rateLimitSnapshot 某个请求数限制的当前状态
+type rateLimitSnapshot struct {
+	Limit        int
+	Remaining    int
+	ResetSeconds int64
+}
+
+// tighterThan 判断 s 是否比 other 更接近耗尽
+func (s rateLimitSnapshot) tighterThan(other rateLimitSnapshot) bool {
+	left := int64(s.Remaining) * int64(other.Limit)
+	right := int64(other.Remaining) * int64(s.Limit)
+	if left != right {
+		return left < right
+	}
+	return s.Remaining < other.Remaining
+}
+
+// redisRateLimitSnapshot 根据 Redis 列表估算 duration 秒窗口内的剩余请求数
+func redisRateLimitSnapshot(ctx context.Context, rdb *redis.Client, key string, maxCount int, duration int64) rateLimitSnapshot {
+	snapshot := rateLimitSnapshot{Limit: maxCount, Remaining: maxCount}
+	length, err := rdb.LLen(ctx, key).Result()
+	if err != nil || length == 0 {
+		return snapshot
+	}
+	used := int(length)
+	oldTimeStr, _ := rdb.LIndex(ctx, key, -1).Result()
+	oldTime, err := time.Parse(timeFormat, oldTimeStr)
+	if err == nil {
+		nowTime, _ := time.Parse(timeFormat, time.Now().Format(timeFormat))
+		elapsed := int64(nowTime.Sub(oldTime).Seconds())
+		if elapsed < duration {
+			snapshot.ResetSeconds = duration - elapsed
+		} else if used >= maxCount {
+			// 最早的记录已移出窗口，至少还有一个名额
+			used = maxCount - 1
+		}
+	}
+	snapshot.Remaining = max(maxCount-used, 0)
+	return snapshot
+}
+
+func memoryRateLimitSnapshot(key string, maxCount int, duration int64) rateLimitSnapshot {
+	remaining, reset := inMemoryRateLimiter.State(key, maxCount, duration)
+	return rateLimitSnapshot{Limit: maxCount, Remaining: remaining, ResetSeconds: reset}
+}
+
+// exposeRequestRateLimit 在令牌、模型等多个请求数限制中保留最接近耗尽的一个写入响应头
+func exposeRequestRateLimit(c *gin.Context, snapshot rateLimitSnapshot) {
+	if snapshot.Limit <= 0 {
+		return
+	}
+	if prev, ok := c.Get(requestRateLimitSnapshotKey); ok {
+		if prevSnapshot, ok := prev.(rateLimitSnapshot); ok && !snapshot.tighterThan(prevSnapshot) {
+			return
+		}
+	}
+	c.Set(requestRateLimitSnapshotKey, snapshot)
+	common.SetRateLimitHeaders(c.Writer.Header(), "requests", snapshot.Limit, snapshot.Remaining, snapshot.ResetSeconds)
+}
+
+// abortWithRateLimit 返回 429 并附带 x-ratelimit-* 与 Retry-After 响应头
+func abortWithRateLimit(c *gin.Context, snapshot rateLimitSnapshot, message string) {
+	snapshot.Remaining = 0
+	c.Set(requestRateLimitSnapshotKey, snapshot)
+	common.SetRateLimitHeaders(c.Writer.Header(), "requests", snapshot.Limit, 0, snapshot.ResetSeconds)
+	common.SetRetryAfter(c.Writer.Header(), snapshot.ResetSeconds)
+	abortWithOpenAiMessage(c, http.StatusTooManyRequests, message)
+}
@@ -85,7 +85,7 @@ func tokenRedisRateLimitHandler(duration int64, totalMaxCount, successMaxCount i
 			return
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, "Key-level rate limit exceed.")
+			abortWithRateLimit(c, redisRateLimitSnapshot(ctx, rdb, totalKey, totalMaxCount, duration), "Key-level rate limit exceed.")
 			return
 		}
 		successKey := fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitSuccessCountMark, tokenId)
@@ -96,10 +96,14 @@ func tokenRedisRateLimitHandler(duration int64, totalMaxCount, successMaxCount i
 			return
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, "Key-level rate limit exceed.")
+			abortWithRateLimit(c, redisRateLimitSnapshot(ctx, rdb, successKey, successMaxCount, duration), "Key-level rate limit exceed.")
 			return
 		}
 		tokenRecordRedisRequest(ctx, rdb, totalKey, totalMaxCount)
+		if totalMaxCount > 0 {
+			exposeRequestRateLimit(c, redisRateLimitSnapshot(ctx, rdb, totalKey, totalMaxCount, duration))
+		}
+		exposeRequestRateLimit(c, redisRateLimitSnapshot(ctx, rdb, successKey, successMaxCount, duration))
 		c.Next()
 		if c.Writer.Status() < 400 {
 			tokenRecordRedisRequest(ctx, rdb, successKey, successMaxCount)
@@ -114,15 +118,17 @@ func tokenMemoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount
 		totalKey := TokenRateLimitCountMark + tokenId
 		successKey := TokenRateLimitSuccessCountMark + tokenId
 		if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+			abortWithRateLimit(c, memoryRateLimitSnapshot(totalKey, totalMaxCount, duration), "Key-level rate limit exceed.")
 			return
 		}
 		if !inMemoryRateLimiter.Request(successKey, successMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+			abortWithRateLimit(c, memoryRateLimitSnapshot(successKey, successMaxCount, duration), "Key-level rate limit exceed.")
 			return
 		}
+		if totalMaxCount > 0 {
+			exposeRequestRateLimit(c, memoryRateLimitSnapshot(totalKey, totalMaxCount, duration))
+		}
+		exposeRequestRateLimit(c, memoryRateLimitSnapshot(successKey, successMaxCount, duration))
 		c.Next()
 		if c.Writer.Status() < 400 {
 			inMemoryRateLimiter.Request(successKey, successMaxCount, duration)
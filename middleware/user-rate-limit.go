@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/setting/model_setting"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const UserRequestRateLimitCountMark = "URRL"
+
+const userRequestRateLimitDuration int64 = 60
+
+// UserRequestRateLimit 用户每分钟请求数限制，用户未单独设置时使用流量限制中的默认值
+func UserRequestRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		trafficSetting := operation_setting.GetTrafficLimitSetting()
+		if !trafficSetting.Enabled || model_setting.ShouldBypassRateLimit(c.GetString("token_group")) {
+			c.Next()
+			return
+		}
+		maxCount := c.GetInt(constant.ContextKeyUserRPMLimit)
+		if maxCount == 0 {
+			maxCount = trafficSetting.DefaultUserRPM
+		}
+		if maxCount <= 0 {
+			c.Next()
+			return
+		}
+		message := fmt.Sprintf("您已达到用户请求数限制：每分钟最多请求%d次", maxCount)
+		if common.RedisEnabled {
+			ctx := context.Background()
+			rdb := common.RDB
+			key := fmt.Sprintf("rateLimit:%s:%d", UserRequestRateLimitCountMark, c.GetInt("id"))
+			allowed, err := tokenCheckRedisRateLimit(ctx, rdb, key, maxCount, userRequestRateLimitDuration)
+			if err != nil {
+				fmt.Println("check user rate limit failed:", err.Error())
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "user_rate_limit_check_failed")
+				return
+			}
+			if !allowed {
+				abortWithRateLimit(c, redisRateLimitSnapshot(ctx, rdb, key, maxCount, userRequestRateLimitDuration), message)
+				return
+			}
+			tokenRecordRedisRequest(ctx, rdb, key, maxCount)
+			exposeRequestRateLimit(c, redisRateLimitSnapshot(ctx, rdb, key, maxCount, userRequestRateLimitDuration))
+		} else {
+			inMemoryRateLimiter.Init(common.RateLimitKeyExpirationDuration)
+			key := fmt.Sprintf("%s%d", UserRequestRateLimitCountMark, c.GetInt("id"))
+			if !inMemoryRateLimiter.Request(key, maxCount, userRequestRateLimitDuration) {
+				abortWithRateLimit(c, memoryRateLimitSnapshot(key, maxCount, userRequestRateLimitDuration), message)
+				return
+			}
+			exposeRequestRateLimit(c, memoryRateLimitSnapshot(key, maxCount, userRequestRateLimitDuration))
+		}
+		c.Next()
+	}
+}
@@ -20,16 +20,24 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"veloera/common"
+	"veloera/relay/helper"
 )
 
 func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string) {
 	userId := c.GetInt("id")
-	c.JSON(statusCode, gin.H{
+	body := gin.H{
 		"error": gin.H{
 			"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
 			"type":    "veloera_error",
 		},
-	})
+	}
+	if c.Writer.Written() {
+		// 排队心跳已经以流式响应开始输出，只能以事件形式返回错误
+		_ = helper.ObjectData(c, body)
+		helper.Done(c)
+	} else {
+		c.JSON(statusCode, body)
+	}
 	c.Abort()
 	common.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", userId, message))
 }
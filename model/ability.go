@@ -283,7 +283,7 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// 如果找到了，直接返回
 	if len(abilities) > 0 {
-		return selectChannelFromAbilities(abilities)
+		return selectChannelFromAbilities(filterCheapestAbilities(group, actualModel, abilities))
 	}
 
 	// 第二次尝试：反向映射查找（兼容原始模型名请求）
@@ -302,7 +302,7 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		}
 
 		if len(abilities) > 0 {
-			return selectChannelFromAbilities(abilities)
+			return selectChannelFromAbilities(filterCheapestAbilities(group, renamedModel, abilities))
 		}
 	}
 
@@ -0,0 +1,118 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// AuditRecord 请求与响应审计归档，存放在日志数据库中。
+// 请求体与响应体经压缩加密后保存在 RequestData、ResponseData 或 FilePath 指向的文件中
+type AuditRecord struct {
+	Id           int    `json:"id"`
+	RequestId    string `json:"request_id" gorm:"type:varchar(64);index"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Username     string `json:"username" gorm:"index"`
+	TokenId      int    `json:"token_id" gorm:"index"`
+	TokenName    string `json:"token_name"`
+	Group        string `json:"group" gorm:"type:varchar(64)"`
+	ModelName    string `json:"model_name" gorm:"index"`
+	ChannelId    int    `json:"channel_id"`
+	IsStream     bool   `json:"is_stream"`
+	StatusCode   int    `json:"status_code"`
+	Error        string `json:"error" gorm:"type:text"`
+	Storage      string `json:"storage" gorm:"type:varchar(16)"`
+	RequestSize  int    `json:"request_size"`
+	ResponseSize int    `json:"response_size"`
+	Truncated    bool   `json:"truncated"`
+	RequestData  []byte `json:"-"`
+	ResponseData []byte `json:"-"`
+	FilePath     string `json:"-"`
+	CreatedAt    int64  `json:"created_at" gorm:"type:bigint;index"`
+}
+
+// AuditRecordFilter 审计归档查询条件
+type AuditRecordFilter struct {
+	RequestId      string
+	UserId         int
+	Username       string
+	TokenId        int
+	ModelName      string
+	Group          string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (record *AuditRecord) Insert() error {
+	return LOG_DB.Create(record).Error
+}
+
+func auditRecordQuery(filter *AuditRecordFilter) *gorm.DB {
+	tx := LOG_DB.Model(&AuditRecord{})
+	if filter.RequestId != "" {
+		tx = tx.Where("request_id = ?", filter.RequestId)
+	}
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Username != "" {
+		tx = tx.Where("username = ?", filter.Username)
+	}
+	if filter.TokenId != 0 {
+		tx = tx.Where("token_id = ?", filter.TokenId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name LIKE ?", filter.ModelName)
+	}
+	if filter.Group != "" {
+		tx = tx.Where(groupCol+" = ?", filter.Group)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	return tx
+}
+
+// GetAuditRecords 查询归档元数据，不加载请求体与响应体
+func GetAuditRecords(filter *AuditRecordFilter, startIdx int, num int) (records []*AuditRecord, total int64, err error) {
+	tx := auditRecordQuery(filter)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Omit("request_data", "response_data").Order("id desc").Limit(num).Offset(startIdx).Find(&records).Error
+	return records, total, err
+}
+
+func GetAuditRecordById(id int) (*AuditRecord, error) {
+	var record AuditRecord
+	err := LOG_DB.First(&record, "id = ?", id).Error
+	return &record, err
+}
+
+// GetExpiredAuditRecords 返回早于 before 的归档，只加载删除所需的字段
+func GetExpiredAuditRecords(before int64, limit int) (records []*AuditRecord, err error) {
+	err = LOG_DB.Select("id", "storage", "file_path").Where("created_at < ?", before).
+		Order("id").Limit(limit).Find(&records).Error
+	return records, err
+}
+
+func DeleteAuditRecords(ids []int) error {
+	return LOG_DB.Where("id IN ?", ids).Delete(&AuditRecord{}).Error
+}
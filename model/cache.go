@@ -25,10 +25,12 @@ import (
 	"sync"
 	"time"
 	"veloera/common"
+	"veloera/setting/operation_setting"
 )
 
 var group2model2channels map[string]map[string][]*Channel
 var channelsIDM map[int]*Channel
+var channelCosts map[int]*ChannelCost
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
@@ -46,11 +48,15 @@ func InitChannelCache() {
 	}
 	newGroup2model2channels := make(map[string]map[string][]*Channel)
 	newChannelsIDM := make(map[int]*Channel)
+	newChannelCosts := make(map[int]*ChannelCost)
 	for group := range groups {
 		newGroup2model2channels[group] = make(map[string][]*Channel)
 	}
 	for _, channel := range channels {
 		newChannelsIDM[channel.Id] = channel
+		if cost := channel.GetCost(); cost != nil {
+			newChannelCosts[channel.Id] = cost
+		}
 		groups := strings.Split(channel.Group, ",")
 		for _, group := range groups {
 			models := strings.Split(channel.Models, ",")
@@ -76,6 +82,7 @@ func InitChannelCache() {
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
 	channelsIDM = newChannelsIDM
+	channelCosts = newChannelCosts
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
 }
@@ -130,6 +137,21 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 		}
 	}
 
+	if len(targetChannels) > 1 && operation_setting.CheapestFirstEnabledForGroup(group) {
+		ids := make([]int, 0, len(targetChannels))
+		for _, channel := range targetChannels {
+			ids = append(ids, channel.Id)
+		}
+		cheapest := cheapestChannelIds(model, ids, channelCosts)
+		var cheapestChannels []*Channel
+		for _, channel := range targetChannels {
+			if cheapest[channel.Id] {
+				cheapestChannels = append(cheapestChannels, channel)
+			}
+		}
+		targetChannels = cheapestChannels
+	}
+
 	// 平滑系数
 	smoothingFactor := 10
 	// Calculate the total weight of all channels up to endIdx
@@ -44,6 +44,7 @@ type Channel struct {
 	Models             string  `json:"models"`
 	Group              string  `json:"group" gorm:"type:varchar(64);default:'default'"`
 	UsedQuota          int64   `json:"used_quota" gorm:"bigint;default:0"`
+	UsedCost           int64   `json:"used_cost" gorm:"type:bigint;default:0"` // 上游成本，需在渠道设置中配置 cost
 	ModelMapping       *string `json:"model_mapping" gorm:"type:text"`
 	//MaxInputTokens     *int    `json:"max_input_tokens" gorm:"default:0"`
 	StatusCodeMapping *string `json:"status_code_mapping" gorm:"type:varchar(1024);default:''"`
@@ -419,6 +420,30 @@ func UpdateChannelStatusById(id int, status int, reason string) bool {
 	return true
 }
 
+// UpdateChannelOtherInfoById 更新渠道附加信息，priority 不为空时同时更新渠道及 abilities 的优先级
+func UpdateChannelOtherInfoById(id int, otherInfo string, priority *int64) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"other_info": otherInfo,
+		}
+		if priority != nil {
+			updates["priority"] = *priority
+		}
+		err := tx.Model(&Channel{}).Where("id = ?", id).Updates(updates).Error
+		if err != nil || priority == nil {
+			return err
+		}
+		return tx.Model(&Ability{}).Where("channel_id = ?", id).Update("priority", *priority).Error
+	})
+	if err != nil {
+		return err
+	}
+	if priority != nil && common.MemoryCacheEnabled {
+		InitChannelCache()
+	}
+	return nil
+}
+
 func EnableChannelByTag(tag string) error {
 	err := DB.Model(&Channel{}).Where("tag = ?", tag).Update("status", common.ChannelStatusEnabled).Error
 	if err != nil {
@@ -502,6 +527,25 @@ func updateChannelUsedQuota(id int, quota int) {
 	}
 }
 
+// UpdateChannelUsedCost 累加渠道的上游成本
+func UpdateChannelUsedCost(id int, cost int) {
+	if cost == 0 {
+		return
+	}
+	if common.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeChannelUsedCost, id, cost)
+		return
+	}
+	updateChannelUsedCost(id, cost)
+}
+
+func updateChannelUsedCost(id int, cost int) {
+	err := DB.Model(&Channel{}).Where("id = ?", id).Update("used_cost", gorm.Expr("used_cost + ?", cost)).Error
+	if err != nil {
+		common.SysError("failed to update channel used cost: " + err.Error())
+	}
+}
+
 func DeleteChannelByStatus(status int64) (int64, error) {
 	result := DB.Where("status = ?", status).Delete(&Channel{})
 	return result.RowsAffected, result.Error
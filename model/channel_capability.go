@@ -0,0 +1,161 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"veloera/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelCapability 通道 + 模型的能力一致性测试结果，每个通道模型只保留最近一次
+type ChannelCapability struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_capability,priority:1"`
+	ModelName string `json:"model_name" gorm:"type:varchar(128);uniqueIndex:idx_channel_capability,priority:2"`
+	Passed    string `json:"passed" gorm:"type:varchar(255)"` // 通过的检查项，逗号分隔
+	Failed    string `json:"failed" gorm:"type:varchar(255)"` // 未通过的检查项，逗号分隔
+	Results   string `json:"results" gorm:"type:text"`        // 各检查项的详细结果 JSON
+	JobId     int64  `json:"job_id"`
+	UpdatedAt int64  `json:"updated_at" gorm:"type:bigint"`
+}
+
+// SaveChannelCapability 保存通道模型的能力测试结果。merge 在事务中接收已有记录（不存在时为 nil），
+// 据此填充 capability 的 Passed、Failed、Results 与 JobId，使只执行部分检查项的测试不会覆盖其他检查项的结果
+func SaveChannelCapability(channelId int, modelName string, merge func(previous *ChannelCapability, capability *ChannelCapability) error) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var previous *ChannelCapability
+		var existing ChannelCapability
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("channel_id = ? AND model_name = ?", channelId, modelName).Take(&existing).Error
+		if err == nil {
+			previous = &existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		capability := &ChannelCapability{ChannelId: channelId, ModelName: modelName}
+		if err = merge(previous, capability); err != nil {
+			return err
+		}
+		capability.UpdatedAt = common.GetTimestamp()
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "channel_id"}, {Name: "model_name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"passed", "failed", "results", "job_id", "updated_at"}),
+		}).Create(capability).Error
+	})
+}
+
+// GetChannelCapabilities 查询能力测试结果，channelId 为 0 或 modelName 为空时不过滤
+func GetChannelCapabilities(channelId int, modelName string) ([]*ChannelCapability, error) {
+	var capabilities []*ChannelCapability
+	tx := DB.Model(&ChannelCapability{})
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	err := tx.Order("channel_id asc, model_name asc").Find(&capabilities).Error
+	return capabilities, err
+}
+
+// GroupModelCapabilities 分组 -> 模型 -> 通过的检查项
+type GroupModelCapabilities map[string]map[string]map[string]bool
+
+// GetGroupModelCapabilities 按分组汇总已启用通道的能力测试结果，分组内该模型的所有已启用通道都通过时才认为具备此能力，
+// 未测试的通道视为未通过
+func GetGroupModelCapabilities() (GroupModelCapabilities, error) {
+	var rows []struct {
+		Group     string
+		Model     string
+		ChannelId int
+		Passed    *string
+	}
+	err := DB.Table("abilities").
+		Select("abilities."+groupCol+" AS "+groupCol+", abilities.model, abilities.channel_id, channel_capabilities.passed").
+		Joins("JOIN channels ON channels.id = abilities.channel_id").
+		Joins("LEFT JOIN channel_capabilities ON channel_capabilities.channel_id = abilities.channel_id AND channel_capabilities.model_name = abilities.model").
+		Where("channels.status = ? AND abilities.enabled = ?", common.ChannelStatusEnabled, true).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	type groupModel struct {
+		group string
+		model string
+	}
+	channels := make(map[groupModel]map[int]bool)
+	passes := make(map[groupModel]map[string]int)
+	for _, row := range rows {
+		key := groupModel{group: row.Group, model: row.Model}
+		if channels[key] == nil {
+			channels[key] = make(map[int]bool)
+			passes[key] = make(map[string]int)
+		}
+		if channels[key][row.ChannelId] {
+			continue
+		}
+		channels[key][row.ChannelId] = true
+		if row.Passed == nil || *row.Passed == "" {
+			continue
+		}
+		for _, check := range strings.Split(*row.Passed, ",") {
+			passes[key][check]++
+		}
+	}
+	capabilities := make(GroupModelCapabilities)
+	for key, checks := range passes {
+		for check, count := range checks {
+			if count < len(channels[key]) {
+				continue
+			}
+			models := capabilities[key.group]
+			if models == nil {
+				models = make(map[string]map[string]bool)
+				capabilities[key.group] = models
+			}
+			if models[key.model] == nil {
+				models[key.model] = make(map[string]bool)
+			}
+			models[key.model][check] = true
+		}
+	}
+	return capabilities, nil
+}
+
+// ForGroups 返回模型在指定分组内通过的检查项
+func (capabilities GroupModelCapabilities) ForGroups(modelName string, groups []string) []string {
+	set := make(map[string]bool)
+	for _, group := range groups {
+		for check := range capabilities[group][modelName] {
+			set[check] = true
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	checks := make([]string, 0, len(set))
+	for check := range set {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	return checks
+}
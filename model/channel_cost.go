@@ -0,0 +1,190 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/setting/operation_setting"
+)
+
+// ChannelCost 渠道的上游成本设置，保存在渠道设置的 cost 字段中，价格表优先于折扣
+type ChannelCost struct {
+	// Discount 上游计费相对模型倍率原价的折扣，如 0.8 表示原价的八折
+	Discount float64                     `json:"discount,omitempty"`
+	Prices   map[string]ChannelCostPrice `json:"prices,omitempty"`
+}
+
+// ChannelCostPrice 上游价格，单位为美元：Input、Output 为每百万 token 价格，Request 为每次请求价格
+type ChannelCostPrice struct {
+	Input   float64 `json:"input,omitempty"`
+	Output  float64 `json:"output,omitempty"`
+	Request float64 `json:"request,omitempty"`
+}
+
+// parseChannelCost 从渠道设置中解析成本设置，未设置或设置无效时返回 nil
+func parseChannelCost(setting map[string]interface{}) *ChannelCost {
+	value, ok := setting[constant.ChannelSettingCost]
+	if !ok || value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	cost := &ChannelCost{}
+	if err = json.Unmarshal(data, cost); err != nil {
+		common.SysError("failed to unmarshal channel cost: " + err.Error())
+		return nil
+	}
+	if cost.Discount <= 0 && len(cost.Prices) == 0 {
+		return nil
+	}
+	return cost
+}
+
+func (channel *Channel) GetCost() *ChannelCost {
+	return parseChannelCost(channel.GetSetting())
+}
+
+func (cost *ChannelCost) price(modelNames ...string) (ChannelCostPrice, bool) {
+	for _, name := range modelNames {
+		if name == "" {
+			continue
+		}
+		if price, ok := cost.Prices[name]; ok {
+			return price, true
+		}
+	}
+	return ChannelCostPrice{}, false
+}
+
+// Calculate 计算一次调用的上游成本（额度），listQuota 为未乘分组倍率的原价额度，modelNames 按顺序匹配价格表
+func (cost *ChannelCost) Calculate(modelNames []string, promptTokens int, completionTokens int, listQuota float64) int {
+	if cost == nil {
+		return 0
+	}
+	if price, ok := cost.price(modelNames...); ok {
+		usd := (float64(promptTokens)*price.Input+float64(completionTokens)*price.Output)/1000000 + price.Request
+		return int(math.Round(usd * common.QuotaPerUnit))
+	}
+	if cost.Discount > 0 {
+		return int(math.Round(listQuota * cost.Discount))
+	}
+	return 0
+}
+
+// Factor 渠道调用该模型的成本相对原价的比例，未设置成本的渠道视为原价
+func (cost *ChannelCost) Factor(modelName string) float64 {
+	if cost == nil {
+		return 1
+	}
+	price, ok := cost.price(modelName)
+	if !ok {
+		if cost.Discount > 0 {
+			return cost.Discount
+		}
+		return 1
+	}
+	if modelPrice, ok := operation_setting.GetModelPriceWithFallback(modelName, false); ok && modelPrice > 0 {
+		if price.Request > 0 {
+			return price.Request / modelPrice
+		}
+		return 1
+	}
+	modelRatio, _ := operation_setting.GetModelRatioWithFallback(modelName)
+	if modelRatio <= 0 || (price.Input <= 0 && price.Output <= 0) {
+		return 1
+	}
+	// 模型倍率为 1 时原价为每百万输入 token 2 美元
+	listInput := modelRatio * 2
+	listOutput := listInput * operation_setting.GetCompletionRatioWithFallback(modelName)
+	return (price.Input + price.Output) / (listInput + listOutput)
+}
+
+// channelCostFromContext 获取消费日志对应渠道的成本设置，优先使用请求上下文中的渠道设置
+func channelCostFromContext(channelSetting interface{}, channelId int) *ChannelCost {
+	if setting, ok := channelSetting.(map[string]interface{}); ok {
+		return parseChannelCost(setting)
+	}
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return nil
+	}
+	return channel.GetCost()
+}
+
+// calculateLogChannelCost 按消费日志计算上游成本，原价额度由实际扣费额度除以分组倍率得到
+func calculateLogChannelCost(cost *ChannelCost, modelName string, promptTokens int, completionTokens int, quota int, other map[string]interface{}) int {
+	if cost == nil {
+		return 0
+	}
+	upstreamModel, _ := other["upstream_model_name"].(string)
+	listQuota := float64(quota)
+	if groupRatio, ok := other["group_ratio"].(float64); ok && groupRatio > 0 {
+		listQuota /= groupRatio
+	}
+	return cost.Calculate([]string{upstreamModel, modelName}, promptTokens, completionTokens, listQuota)
+}
+
+// cheapestChannelIds 返回调用该模型成本比例最低的渠道，用于最低成本优先的渠道选择
+func cheapestChannelIds(modelName string, ids []int, costs map[int]*ChannelCost) map[int]bool {
+	factors := make(map[int]float64, len(ids))
+	minFactor := math.MaxFloat64
+	for _, id := range ids {
+		factor := costs[id].Factor(modelName)
+		factors[id] = factor
+		minFactor = math.Min(minFactor, factor)
+	}
+	cheapest := make(map[int]bool)
+	for id, factor := range factors {
+		if factor-minFactor < 1e-9 {
+			cheapest[id] = true
+		}
+	}
+	return cheapest
+}
+
+// filterCheapestAbilities 数据库模式下从同一优先级的 abilities 中筛选成本最低的渠道
+func filterCheapestAbilities(group string, modelName string, abilities []Ability) []Ability {
+	if len(abilities) <= 1 || !operation_setting.CheapestFirstEnabledForGroup(group) {
+		return abilities
+	}
+	ids := make([]int, 0, len(abilities))
+	for _, ability := range abilities {
+		ids = append(ids, ability.ChannelId)
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "setting").Where("id in (?)", ids).Find(&channels).Error; err != nil {
+		common.SysError("failed to load channel cost: " + err.Error())
+		return abilities
+	}
+	costs := make(map[int]*ChannelCost, len(channels))
+	for _, channel := range channels {
+		costs[channel.Id] = channel.GetCost()
+	}
+	cheapest := cheapestChannelIds(modelName, ids, costs)
+	filtered := make([]Ability, 0, len(cheapest))
+	for _, ability := range abilities {
+		if cheapest[ability.ChannelId] {
+			filtered = append(filtered, ability)
+		}
+	}
+	return filtered
+}
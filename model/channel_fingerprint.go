@@ -0,0 +1,111 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"veloera/common"
+
+	"gorm.io/gorm/clause"
+)
+
+// ModelFingerprintBaseline 模型指纹基线，由可信渠道执行探测提示词得到，每个模型一份
+type ModelFingerprintBaseline struct {
+	Id            int     `json:"id"`
+	ModelName     string  `json:"model_name" gorm:"type:varchar(128);uniqueIndex"`
+	ChannelId     int     `json:"channel_id"`                              // 采集基线所用的渠道
+	ReportedModel string  `json:"reported_model" gorm:"type:varchar(255)"` // 上游响应中的 model 字段
+	TokenRatio    float64 `json:"token_ratio"`                             // 上游 prompt_tokens 与本地估算之比
+	Samples       string  `json:"samples" gorm:"type:text"`                // 各探测提示词的响应 JSON
+	UpdatedAt     int64   `json:"updated_at" gorm:"type:bigint"`
+}
+
+// ChannelFingerprint 渠道模型最近一次指纹检测结果，Score 为 0-100，低于阈值时 Flagged 为 true
+type ChannelFingerprint struct {
+	Id            int     `json:"id"`
+	ChannelId     int     `json:"channel_id" gorm:"uniqueIndex:idx_channel_fingerprint,priority:1"`
+	ModelName     string  `json:"model_name" gorm:"type:varchar(128);uniqueIndex:idx_channel_fingerprint,priority:2"`
+	Score         float64 `json:"score"`
+	Flagged       bool    `json:"flagged" gorm:"index"`
+	ReportedModel string  `json:"reported_model" gorm:"type:varchar(255)"`
+	TokenRatio    float64 `json:"token_ratio"`
+	HasBaseline   bool    `json:"has_baseline"`
+	Details       string  `json:"details" gorm:"type:text"` // 各项得分与探测响应 JSON
+	JobId         int64   `json:"job_id"`
+	UpdatedAt     int64   `json:"updated_at" gorm:"type:bigint"`
+}
+
+// SaveModelFingerprintBaseline 保存模型指纹基线，已存在时覆盖
+func SaveModelFingerprintBaseline(baseline *ModelFingerprintBaseline) error {
+	baseline.UpdatedAt = common.GetTimestamp()
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "model_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"channel_id", "reported_model", "token_ratio", "samples", "updated_at"}),
+	}).Create(baseline).Error
+}
+
+// GetModelFingerprintBaseline 查询模型指纹基线，不存在时返回 nil
+func GetModelFingerprintBaseline(modelName string) (*ModelFingerprintBaseline, error) {
+	var baselines []*ModelFingerprintBaseline
+	err := DB.Where("model_name = ?", modelName).Limit(1).Find(&baselines).Error
+	if err != nil || len(baselines) == 0 {
+		return nil, err
+	}
+	return baselines[0], nil
+}
+
+func GetModelFingerprintBaselines() ([]*ModelFingerprintBaseline, error) {
+	var baselines []*ModelFingerprintBaseline
+	err := DB.Omit("samples").Order("model_name asc").Find(&baselines).Error
+	return baselines, err
+}
+
+func DeleteModelFingerprintBaseline(modelName string) error {
+	return DB.Where("model_name = ?", modelName).Delete(&ModelFingerprintBaseline{}).Error
+}
+
+// SaveChannelFingerprint 保存渠道模型的指纹检测结果，已存在时覆盖
+func SaveChannelFingerprint(fingerprint *ChannelFingerprint) error {
+	fingerprint.UpdatedAt = common.GetTimestamp()
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "model_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"score", "flagged", "reported_model", "token_ratio", "has_baseline", "details", "job_id", "updated_at"}),
+	}).Create(fingerprint).Error
+}
+
+// GetChannelFingerprints 查询指纹检测结果，channelId 为 0 或 modelName 为空时不过滤，按得分从低到高排序
+func GetChannelFingerprints(channelId int, modelName string, flaggedOnly bool) ([]*ChannelFingerprint, error) {
+	var fingerprints []*ChannelFingerprint
+	tx := DB.Model(&ChannelFingerprint{})
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if flaggedOnly {
+		tx = tx.Where("flagged = ?", true)
+	}
+	err := tx.Order("score asc, channel_id asc").Find(&fingerprints).Error
+	return fingerprints, err
+}
+
+// CountFlaggedChannelFingerprints 统计渠道被标记的模型数
+func CountFlaggedChannelFingerprints(channelId int) (int64, error) {
+	var count int64
+	err := DB.Model(&ChannelFingerprint{}).Where("channel_id = ? AND flagged = ?", channelId, true).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	MarginGroupByChannel = "channel"
+	MarginGroupByModel   = "model"
+	MarginGroupByGroup   = "group"
+	MarginGroupByDay     = "day"
+)
+
+// MarginStat 按维度汇总的收入（用户扣费额度）、上游成本与毛利，单位均为额度
+type MarginStat struct {
+	ChannelId    int     `json:"channel_id,omitempty"`
+	ChannelName  string  `json:"channel_name,omitempty" gorm:"-"`
+	ModelName    string  `json:"model_name,omitempty"`
+	Group        string  `json:"group,omitempty"`
+	Day          int64   `json:"day,omitempty"` // 当天零点（UTC）的时间戳
+	RequestCount int64   `json:"request_count"`
+	Revenue      int64   `json:"revenue"`
+	Cost         int64   `json:"cost"`
+	Margin       int64   `json:"margin" gorm:"-"`
+	MarginRate   float64 `json:"margin_rate" gorm:"-"`
+	// UncostedCount 未配置成本的调用次数，这部分调用的成本按 0 计入
+	UncostedCount int64 `json:"uncosted_count"`
+}
+
+type MarginFilter struct {
+	GroupBy        string
+	StartTimestamp int64
+	EndTimestamp   int64
+	ChannelId      int
+	ModelName      string
+	Group          string
+}
+
+// GetMarginStats 按渠道、模型、分组或天汇总消费日志中的收入与上游成本
+func GetMarginStats(filter MarginFilter) ([]*MarginStat, error) {
+	var dimension string
+	switch filter.GroupBy {
+	case MarginGroupByChannel:
+		dimension = "channel_id"
+	case MarginGroupByModel:
+		dimension = "model_name"
+	case MarginGroupByGroup:
+		// 带表名前缀，避免 gorm 对已转义的列名再次转义
+		dimension = "logs." + groupCol
+	case MarginGroupByDay:
+		dimension = "created_at - created_at % 86400"
+	default:
+		return nil, errors.New("invalid group_by")
+	}
+	alias, order := dimension, "revenue desc"
+	if filter.GroupBy == MarginGroupByDay {
+		alias, order = dimension+" AS day", "day"
+	}
+	tx := LOG_DB.Table("logs").
+		Select(fmt.Sprintf("%s, count(*) AS request_count, sum(quota) AS revenue, sum(channel_cost) AS cost, "+
+			"sum(CASE WHEN channel_cost = 0 THEN 1 ELSE 0 END) AS uncosted_count", alias)).
+		Where("type = ?", LogTypeConsume)
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.Group != "" {
+		tx = tx.Where(groupCol+" = ?", filter.Group)
+	}
+	var stats []*MarginStat
+	err := tx.Group(dimension).Order(order).Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	channelNames := make(map[int]string)
+	if filter.GroupBy == MarginGroupByChannel && len(stats) > 0 {
+		ids := make([]int, 0, len(stats))
+		for _, stat := range stats {
+			ids = append(ids, stat.ChannelId)
+		}
+		var channels []*Channel
+		if err = DB.Select("id", "name").Where("id in (?)", ids).Find(&channels).Error; err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.Name
+		}
+	}
+	for _, stat := range stats {
+		stat.ChannelName = channelNames[stat.ChannelId]
+		stat.Margin = stat.Revenue - stat.Cost
+		if stat.Revenue != 0 {
+			stat.MarginRate = float64(stat.Margin) / float64(stat.Revenue)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelProbe 一次定时健康探测的结果，存放在日志数据库中，按小时汇总到 ChannelProbeRollup
+type ChannelProbe struct {
+	Id              int    `json:"id"`
+	ChannelId       int    `json:"channel_id" gorm:"index:idx_channel_probe_model,priority:1"`
+	ModelName       string `json:"model_name" gorm:"type:varchar(128);index:idx_channel_probe_model,priority:2"`
+	Success         bool   `json:"success"`
+	LatencyMs       int    `json:"latency_ms"`
+	FirstResponseMs int    `json:"first_response_ms"` // 非流式探测为 0
+	Error           string `json:"error" gorm:"type:text"`
+	CreatedAt       int64  `json:"created_at" gorm:"type:bigint;index"`
+}
+
+// ChannelProbeRollup 按小时汇总的探测结果，Bucket 为该小时起始时间戳
+type ChannelProbeRollup struct {
+	Id                 int    `json:"id"`
+	ChannelId          int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_probe_rollup,priority:1"`
+	ModelName          string `json:"model_name" gorm:"type:varchar(128);uniqueIndex:idx_channel_probe_rollup,priority:2"`
+	Bucket             int64  `json:"bucket" gorm:"type:bigint;uniqueIndex:idx_channel_probe_rollup,priority:3;index"`
+	Total              int    `json:"total"`
+	SuccessCount       int    `json:"success_count"`
+	LatencySum         int64  `json:"latency_sum"`
+	LatencyMax         int    `json:"latency_max"`
+	FirstResponseSum   int64  `json:"first_response_sum"`
+	FirstResponseCount int    `json:"first_response_count"`
+}
+
+// ChannelSLOBreach 当前未达到 SLO 的渠道模型，每轮探测后整体替换，重启后用于判断是否需要再次通知
+type ChannelSLOBreach struct {
+	Id          int    `json:"id"`
+	ChannelId   int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_slo_breach,priority:1"`
+	ChannelName string `json:"channel_name"`
+	ModelName   string `json:"model_name" gorm:"type:varchar(128);uniqueIndex:idx_channel_slo_breach,priority:2"`
+	Reason      string `json:"reason" gorm:"type:text"`
+	Since       int64  `json:"since" gorm:"type:bigint"`
+	Stat        string `json:"-" gorm:"type:text"` // 未达标时窗口内的统计 JSON
+}
+
+// ChannelProbeStat 探测结果统计，Uptime 为成功率百分比
+type ChannelProbeStat struct {
+	ChannelId          int     `json:"channel_id,omitempty"`
+	ModelName          string  `json:"model_name"`
+	Total              int64   `json:"total"`
+	SuccessCount       int64   `json:"success_count"`
+	LatencySum         int64   `json:"-"`
+	FirstResponseSum   int64   `json:"-"`
+	FirstResponseCount int64   `json:"-"`
+	Uptime             float64 `json:"uptime" gorm:"-"`
+	AvgLatencyMs       int64   `json:"avg_latency_ms" gorm:"-"`
+	AvgFirstResponseMs int64   `json:"avg_first_response_ms" gorm:"-"`
+}
+
+const probeRollupBucketSeconds = 3600
+
+func (probe *ChannelProbe) Insert() error {
+	return LOG_DB.Create(probe).Error
+}
+
+// RollupChannelProbes 将 since 所在小时及之后的探测结果重新汇总，可重复执行
+func RollupChannelProbes(since int64) error {
+	bucketStart := since - since%probeRollupBucketSeconds
+	var rollups []*ChannelProbeRollup
+	err := LOG_DB.Model(&ChannelProbe{}).
+		Select("channel_id, model_name, created_at - created_at % 3600 AS bucket, count(*) AS total, "+
+			"sum(CASE WHEN success THEN 1 ELSE 0 END) AS success_count, sum(latency_ms) AS latency_sum, max(latency_ms) AS latency_max, "+
+			"sum(first_response_ms) AS first_response_sum, sum(CASE WHEN first_response_ms > 0 THEN 1 ELSE 0 END) AS first_response_count").
+		Where("created_at >= ?", bucketStart).
+		Group("channel_id, model_name, created_at - created_at % 3600").
+		Scan(&rollups).Error
+	if err != nil || len(rollups) == 0 {
+		return err
+	}
+	return LOG_DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "model_name"}, {Name: "bucket"}},
+		DoUpdates: clause.AssignmentColumns([]string{"total", "success_count", "latency_sum", "latency_max", "first_response_sum", "first_response_count"}),
+	}).Create(&rollups).Error
+}
+
+func summarizeChannelProbeStats(stats []*ChannelProbeStat) {
+	for _, stat := range stats {
+		if stat.Total > 0 {
+			stat.Uptime = float64(stat.SuccessCount) * 100 / float64(stat.Total)
+			stat.AvgLatencyMs = stat.LatencySum / stat.Total
+		}
+		if stat.FirstResponseCount > 0 {
+			stat.AvgFirstResponseMs = stat.FirstResponseSum / stat.FirstResponseCount
+		}
+	}
+}
+
+func filterChannelProbes(tx *gorm.DB, channelId int, modelName string) *gorm.DB {
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	return tx
+}
+
+// GetRecentChannelProbeStats 按 渠道/模型 统计 since 之后的原始探测结果，用于 SLO 检查
+func GetRecentChannelProbeStats(since int64) ([]*ChannelProbeStat, error) {
+	var stats []*ChannelProbeStat
+	err := LOG_DB.Model(&ChannelProbe{}).
+		Select("channel_id, model_name, count(*) AS total, sum(CASE WHEN success THEN 1 ELSE 0 END) AS success_count, "+
+			"sum(latency_ms) AS latency_sum, sum(first_response_ms) AS first_response_sum, "+
+			"sum(CASE WHEN first_response_ms > 0 THEN 1 ELSE 0 END) AS first_response_count").
+		Where("created_at >= ?", since).
+		Group("channel_id, model_name").
+		Scan(&stats).Error
+	summarizeChannelProbeStats(stats)
+	return stats, err
+}
+
+const probeRollupStatSelect = "sum(total) AS total, sum(success_count) AS success_count, sum(latency_sum) AS latency_sum, " +
+	"sum(first_response_sum) AS first_response_sum, sum(first_response_count) AS first_response_count"
+
+// GetChannelProbeStats 按 渠道/模型 统计 since 之后的小时汇总
+func GetChannelProbeStats(since int64, channelId int, modelName string) ([]*ChannelProbeStat, error) {
+	var stats []*ChannelProbeStat
+	tx := LOG_DB.Model(&ChannelProbeRollup{}).
+		Select("channel_id, model_name, "+probeRollupStatSelect).
+		Where("bucket >= ?", since-since%probeRollupBucketSeconds)
+	err := filterChannelProbes(tx, channelId, modelName).
+		Group("channel_id, model_name").Order("channel_id, model_name").
+		Scan(&stats).Error
+	summarizeChannelProbeStats(stats)
+	return stats, err
+}
+
+// GetModelProbeStats 按模型统计 since 之后全部渠道的小时汇总，用于公开状态页
+func GetModelProbeStats(since int64) ([]*ChannelProbeStat, error) {
+	var stats []*ChannelProbeStat
+	err := LOG_DB.Model(&ChannelProbeRollup{}).
+		Select("model_name, "+probeRollupStatSelect).
+		Where("bucket >= ?", since-since%probeRollupBucketSeconds).
+		Group("model_name").Order("model_name").
+		Scan(&stats).Error
+	summarizeChannelProbeStats(stats)
+	return stats, err
+}
+
+// GetChannelProbeHistory 返回 since 之后的小时汇总序列
+func GetChannelProbeHistory(since int64, channelId int, modelName string) ([]*ChannelProbeRollup, error) {
+	var rollups []*ChannelProbeRollup
+	tx := LOG_DB.Where("bucket >= ?", since-since%probeRollupBucketSeconds)
+	err := filterChannelProbes(tx, channelId, modelName).
+		Order("bucket, channel_id, model_name").
+		Find(&rollups).Error
+	return rollups, err
+}
+
+// GetLatestChannelProbes 返回最近的原始探测记录
+func GetLatestChannelProbes(channelId int, modelName string, limit int) ([]*ChannelProbe, error) {
+	var probes []*ChannelProbe
+	err := filterChannelProbes(LOG_DB, channelId, modelName).
+		Order("id desc").Limit(limit).
+		Find(&probes).Error
+	return probes, err
+}
+
+func DeleteChannelProbesBefore(timestamp int64) (int64, error) {
+	result := LOG_DB.Where("created_at < ?", timestamp).Delete(&ChannelProbe{})
+	return result.RowsAffected, result.Error
+}
+
+func GetChannelSLOBreaches() ([]*ChannelSLOBreach, error) {
+	var breaches []*ChannelSLOBreach
+	err := LOG_DB.Order("channel_id, model_name").Find(&breaches).Error
+	return breaches, err
+}
+
+// ReplaceChannelSLOBreaches 用本轮检查结果替换全部未达标记录
+func ReplaceChannelSLOBreaches(breaches []*ChannelSLOBreach) error {
+	return LOG_DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&ChannelSLOBreach{}).Error; err != nil {
+			return err
+		}
+		if len(breaches) == 0 {
+			return nil
+		}
+		return tx.Create(&breaches).Error
+	})
+}
+
+func DeleteChannelProbeRollupsBefore(timestamp int64) (int64, error) {
+	result := LOG_DB.Where("bucket < ?", timestamp).Delete(&ChannelProbeRollup{})
+	return result.RowsAffected, result.Error
+}
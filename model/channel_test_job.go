@@ -74,6 +74,9 @@ type ChannelTestJobOptions struct {
     TargetModels      []string            `json:"target_models"`
     ParentJobID       int64               `json:"parent_job_id"`   // 父任务ID（重试任务时使用）
     IsRetryJob        bool                `json:"is_retry_job"`    // 是否为重试任务
+    Conformance       bool                `json:"conformance"`     // 基础测试成功后执行能力一致性测试
+    ConformanceChecks []string            `json:"conformance_checks"` // 为空时执行全部检查项
+    Fingerprint       bool                `json:"fingerprint"`     // 基础测试成功后执行模型指纹检测
 }
 
 // DefaultChannelTestJobOptions 返回默认配置
@@ -126,6 +129,8 @@ type ChannelTestResult struct {
     DurationMillis int    `json:"duration_millis"`
     RetryCount     int    `json:"retry_count"`
     ErrorMessage   string `json:"error_message" gorm:"type:text"`
+    Capabilities   string `json:"capabilities" gorm:"type:text"` // 能力一致性测试结果 JSON
+    Fingerprint    string `json:"fingerprint" gorm:"type:text"`  // 模型指纹检测结果 JSON
     CreatedAt      int64  `json:"created_at" gorm:"index"`
 }
 // CreateChannelTestJob 创建批量测试任务
@@ -0,0 +1,56 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"time"
+)
+
+// JobLease 定时任务租约，多节点部署时同一任务同一时刻只有一个节点执行
+type JobLease struct {
+	Name      string `json:"name" gorm:"primaryKey;type:varchar(64)"`
+	Owner     string `json:"owner" gorm:"type:varchar(64)"`
+	ExpiresAt int64  `json:"expires_at" gorm:"type:bigint;index"`
+}
+
+// AcquireJobLease 获取或续期租约，租约由其他节点持有且未过期时返回 false
+func AcquireJobLease(name string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().Unix()
+	expiresAt := now + int64(ttl/time.Second)
+	lease := &JobLease{Name: name, Owner: owner, ExpiresAt: expiresAt}
+	if err := DB.Create(lease).Error; err == nil {
+		return true, nil
+	}
+	result := DB.Model(&JobLease{}).Where("name = ? AND (owner = ? OR expires_at < ?)", name, owner, now).
+		Updates(map[string]interface{}{"owner": owner, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+	// MySQL 在值未变化时影响行数为 0，回查确认是否已持有
+	var current JobLease
+	if err := DB.Where("name = ?", name).First(&current).Error; err != nil {
+		return false, err
+	}
+	return current.Owner == owner && current.ExpiresAt >= now, nil
+}
+
+func ReleaseJobLease(name string, owner string) error {
+	return DB.Where("name = ? AND owner = ?", name, owner).Delete(&JobLease{}).Error
+}
@@ -21,6 +21,7 @@ import (
 	"os"
 	"strings"
 	"veloera/common"
+	"veloera/constant"
 
 	"github.com/gin-gonic/gin"
 
@@ -38,6 +39,7 @@ type Log struct {
 	TokenName        string `json:"token_name" gorm:"index;default:''"`
 	ModelName        string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
 	Quota            int    `json:"quota" gorm:"default:0"`
+	ChannelCost      int    `json:"channel_cost,omitempty" gorm:"default:0"` // 上游成本，仅消费日志记录
 	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
 	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
 	UseTime          int    `json:"use_time" gorm:"default:0"`
@@ -45,6 +47,7 @@ type Log struct {
 	ChannelId        int    `json:"channel" gorm:"index"`
 	ChannelName      string `json:"channel_name" gorm:"->"`
 	TokenId          int    `json:"token_id" gorm:"default:0;index"`
+	OrgId            int    `json:"org_id" gorm:"default:0;index"`
 	Group            string `json:"group" gorm:"index"`
 	Other            string `json:"other"`
 	ClientIP         string `json:"client_ip,omitempty" gorm:"column:client_ip;index"`
@@ -58,11 +61,13 @@ const (
 	LogTypeSystem
 	LogTypeCheckIn
 	LogTypeError
+	LogTypeRefund
 )
 
 func formatUserLogs(logs []*Log) {
 	for i := range logs {
 		logs[i].ChannelName = ""
+		logs[i].ChannelCost = 0
 		var otherMap map[string]interface{}
 		otherMap = common.StrToMap(logs[i].Other)
 		if otherMap != nil {
@@ -92,6 +97,11 @@ func GetLogByKey(key string, userId int) (logs []*Log, err error) {
 }
 
 func RecordLog(userId int, logType int, content string) {
+	RecordQuotaLog(userId, logType, content, 0)
+}
+
+// RecordQuotaLog 记录带额度变动的日志，额度用于对账单统计
+func RecordQuotaLog(userId int, logType int, content string, quota int) {
 	if logType == LogTypeConsume && !common.LogConsumeEnabled {
 		return
 	}
@@ -102,6 +112,28 @@ func RecordLog(userId int, logType int, content string) {
 		CreatedAt: common.GetTimestamp(),
 		Type:      logType,
 		Content:   content,
+		Quota:     quota,
+	}
+	err := LOG_DB.Create(log).Error
+	if err != nil {
+		common.SysError("failed to record log: " + err.Error())
+	}
+}
+
+// RecordRefundLog 记录异步任务退款日志，other 中记录任务 ID 与退款原因便于审计
+func RecordRefundLog(userId int, channelId int, tokenId int, modelName string, quota int, content string, other map[string]interface{}) {
+	username, _ := GetUsernameById(userId, false)
+	log := &Log{
+		UserId:    userId,
+		Username:  username,
+		CreatedAt: common.GetTimestamp(),
+		Type:      LogTypeRefund,
+		Content:   content,
+		ModelName: modelName,
+		Quota:     quota,
+		ChannelId: channelId,
+		TokenId:   tokenId,
+		Other:     common.MapToJsonStr(other),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -135,6 +167,7 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 		Quota:            0,
 		ChannelId:        channelId,
 		TokenId:          tokenId,
+		OrgId:            c.GetInt(constant.ContextKeyOrgId),
 		UseTime:          useTimeSeconds,
 		IsStream:         isStream,
 		Group:            group,
@@ -151,6 +184,12 @@ func RecordConsumeLog(c *gin.Context, userId int, channelId int, promptTokens in
 	modelName string, tokenName string, quota int, content string, tokenId int, userQuota int, useTimeSeconds int,
 	isStream bool, group string, other map[string]interface{}) {
 	common.LogInfo(c, fmt.Sprintf("record consume log: userId=%d, 用户调用前余额=%d, channelId=%d, promptTokens=%d, completionTokens=%d, modelName=%s, tokenName=%s, quota=%d, content=%s", userId, userQuota, channelId, promptTokens, completionTokens, modelName, tokenName, quota, content))
+	var channelSetting interface{}
+	if c.GetInt("channel_id") == channelId {
+		channelSetting, _ = c.Get("channel_setting")
+	}
+	channelCost := calculateLogChannelCost(channelCostFromContext(channelSetting, channelId), modelName, promptTokens, completionTokens, quota, other)
+	UpdateChannelUsedCost(channelId, channelCost)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -175,8 +214,10 @@ func RecordConsumeLog(c *gin.Context, userId int, channelId int, promptTokens in
 		TokenName:        tokenName,
 		ModelName:        modelName,
 		Quota:            quota,
+		ChannelCost:      channelCost,
 		ChannelId:        channelId,
 		TokenId:          tokenId,
+		OrgId:            c.GetInt(constant.ContextKeyOrgId),
 		UseTime:          useTimeSeconds,
 		IsStream:         isStream,
 		Group:            group,
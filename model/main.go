@@ -269,11 +269,36 @@ func migrateDB() error {
 		&TopUp{},
 		&QuotaData{},
 		&Task{},
+		&WebhookDelivery{},
+		&TaskSettlement{},
+		&PollerNode{},
+		&PollerLease{},
+		&ChannelPollState{},
+		&JobLease{},
+		&UsageExport{},
+		&UsageExportChunk{},
+		&UserStatement{},
+		&QuotaLedgerEntry{},
+		&QuotaLedgerOpening{},
+		&Organization{},
+		&OrganizationMember{},
+		&OrganizationInvitation{},
+		&SubscriptionPlan{},
+		&UserSubscription{},
+		&RedemptionCampaign{},
+		&TopUpBonus{},
+		&RedemptionCampaignClaim{},
 		&ChannelTestJob{},
 		&ChannelTestResult{},
+		&ChannelCapability{},
+		&ModelFingerprintBaseline{},
+		&ChannelFingerprint{},
 		&Setup{},
 		&Message{},
 		&UserMessage{},
+		&SpendingWindow{},
+		&TopUpRefund{},
+		&OrderLock{},
 	}
 
 	for _, model := range modelsToMigrate {
@@ -297,6 +322,12 @@ func migrateLOGDB() error {
 	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = LOG_DB.AutoMigrate(&AuditRecord{}); err != nil {
+		return err
+	}
+	if err = LOG_DB.AutoMigrate(&ChannelProbe{}, &ChannelProbeRollup{}, &ChannelSLOBreach{}); err != nil {
+		return err
+	}
 	
 	// Manual migration for client_ip column and index
 	sqlDB, err := LOG_DB.DB()
@@ -37,6 +37,7 @@ type Midjourney struct {
 	Quota       int    `json:"quota"`
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
+	CallbackUrl string `json:"callback_url" gorm:"type:text"` // 任务完成后的回调地址
 }
 
 // TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
@@ -115,6 +116,26 @@ func GetAllUnFinishTasks() []*Midjourney {
 	return tasks
 }
 
+// GetUnfinishedMidjourneyTasksByShards 获取 channel_id % shardCount 属于 shards 的未完成任务
+func GetUnfinishedMidjourneyTasksByShards(shardCount int, shards []int) []*Midjourney {
+	var tasks []*Midjourney
+	err := DB.Where("progress != ? AND channel_id % ? IN ?", "100%", shardCount, shards).Find(&tasks).Error
+	if err != nil {
+		return nil
+	}
+	return tasks
+}
+
+// GetStuckMidjourneyTasks 获取提交时间（毫秒）早于 before 仍未完成的任务
+func GetStuckMidjourneyTasks(before int64, limit int) []*Midjourney {
+	var tasks []*Midjourney
+	err := DB.Where("progress != ? AND submit_time < ?", "100%", before).Limit(limit).Order("id").Find(&tasks).Error
+	if err != nil {
+		return nil
+	}
+	return tasks
+}
+
 func GetByOnlyMJId(mjId string) *Midjourney {
 	var mj *Midjourney
 	var err error
@@ -171,6 +192,12 @@ func (midjourney *Midjourney) Update() error {
 	return err
 }
 
+// UpdateUnfinished 仅在数据库中的任务仍未完成时保存，避免轮询、回调与对账并发时重复结算
+func (midjourney *Midjourney) UpdateUnfinished() (bool, error) {
+	result := DB.Model(midjourney).Where("progress != ?", "100%").Select("*").Updates(midjourney)
+	return result.RowsAffected > 0, result.Error
+}
+
 func MjBulkUpdate(mjIds []string, params map[string]any) error {
 	return DB.Model(&Midjourney{}).
 		Where("mj_id in (?)", mjIds).
@@ -0,0 +1,74 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"time"
+	"veloera/common"
+)
+
+// OrderLock 基于数据库的订单锁，保证多节点下同一订单的回调只会被一个请求处理
+type OrderLock struct {
+	TradeNo     string `json:"trade_no" gorm:"primaryKey;type:varchar(255)"`
+	Owner       string `json:"owner" gorm:"type:varchar(64)"`
+	LockedUntil int64  `json:"locked_until" gorm:"type:bigint;index"`
+}
+
+var ErrOrderLocked = errors.New("order is being processed")
+
+// orderLockTTL 锁的最长持有时间，超时后可被其他请求抢占，防止进程崩溃导致订单永久锁定
+const orderLockTTL = 60 * time.Second
+
+// TryLockOrder 尝试获取订单锁，成功时返回持有者标识
+func TryLockOrder(tradeNo string) (string, error) {
+	owner := common.GetUUID()
+	now := time.Now()
+	lock := &OrderLock{
+		TradeNo:     tradeNo,
+		Owner:       owner,
+		LockedUntil: now.Add(orderLockTTL).Unix(),
+	}
+	if err := DB.Create(lock).Error; err == nil {
+		return owner, nil
+	}
+	result := DB.Model(&OrderLock{}).Where("trade_no = ? AND locked_until < ?", tradeNo, now.Unix()).
+		Updates(map[string]interface{}{"owner": owner, "locked_until": lock.LockedUntil})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrOrderLocked
+	}
+	return owner, nil
+}
+
+// LockOrder 获取订单锁，锁被占用时等待，最多等待 timeout
+func LockOrder(tradeNo string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		owner, err := TryLockOrder(tradeNo)
+		if !errors.Is(err, ErrOrderLocked) || time.Now().After(deadline) {
+			return owner, err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func UnlockOrder(tradeNo string, owner string) error {
+	return DB.Where("trade_no = ? AND owner = ?", tradeNo, owner).Delete(&OrderLock{}).Error
+}
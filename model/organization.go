@@ -0,0 +1,739 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"veloera/common"
+	"veloera/dto"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OrganizationRoleOwner   = "owner"   // 所有者，拥有全部权限，唯一
+	OrganizationRoleAdmin   = "admin"   // 管理成员与全部组织令牌
+	OrganizationRoleBilling = "billing" // 为组织充值、查看用量与日志
+	OrganizationRoleMember  = "member"  // 使用自己创建的组织令牌
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+var ErrOrganizationQuotaNotEnough = errors.New("组织额度不足")
+
+// Organization 组织拥有共享额度池，成员通过组织令牌消费时从组织额度中扣费
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"type:varchar(64);index"`
+	OwnerId     int            `json:"owner_id" gorm:"index"`
+	Quota       int            `json:"quota" gorm:"default:0"`
+	UsedQuota   int            `json:"used_quota" gorm:"default:0"`
+	Status      int            `json:"status" gorm:"default:1"`
+	CreatedTime int64          `json:"created_time" gorm:"type:bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// OrganizationMember 组织成员，限额字段为该成员通过组织令牌消费的周期上限，0 表示不限制
+type OrganizationMember struct {
+	Id                int    `json:"id"`
+	OrgId             int    `json:"org_id" gorm:"uniqueIndex:idx_org_member,priority:1"`
+	UserId            int    `json:"user_id" gorm:"uniqueIndex:idx_org_member,priority:2;index"`
+	Role              string `json:"role" gorm:"type:varchar(16)"`
+	DailyQuotaLimit   int    `json:"daily_quota_limit" gorm:"default:0"`
+	WeeklyQuotaLimit  int    `json:"weekly_quota_limit" gorm:"default:0"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit" gorm:"default:0"`
+	SoftLimitPercent  int    `json:"soft_limit_percent" gorm:"default:0"`
+	UsedQuota         int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime       int64  `json:"created_time" gorm:"type:bigint"`
+	Username          string `json:"username" gorm:"->;-:migration"`
+}
+
+// OrganizationInvitation 组织邀请，被邀请用户接受后才按邀请中的角色与限额成为成员
+type OrganizationInvitation struct {
+	Id                int    `json:"id"`
+	OrgId             int    `json:"org_id" gorm:"uniqueIndex:idx_org_invitation,priority:1"`
+	UserId            int    `json:"user_id" gorm:"uniqueIndex:idx_org_invitation,priority:2;index"`
+	InviterId         int    `json:"inviter_id"`
+	Role              string `json:"role" gorm:"type:varchar(16)"`
+	DailyQuotaLimit   int    `json:"daily_quota_limit" gorm:"default:0"`
+	WeeklyQuotaLimit  int    `json:"weekly_quota_limit" gorm:"default:0"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit" gorm:"default:0"`
+	SoftLimitPercent  int    `json:"soft_limit_percent" gorm:"default:0"`
+	CreatedTime       int64  `json:"created_time" gorm:"type:bigint"`
+	Username          string `json:"username" gorm:"->;-:migration"`
+	OrgName           string `json:"org_name" gorm:"->;-:migration"`
+}
+
+// UserOrganization 用户所在的组织及其角色
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleBilling, OrganizationRoleMember:
+		return true
+	}
+	return false
+}
+
+func (member *OrganizationMember) GetSpendingLimit() dto.SpendingLimit {
+	return dto.SpendingLimit{
+		Daily:            member.DailyQuotaLimit,
+		Weekly:           member.WeeklyQuotaLimit,
+		Monthly:          member.MonthlyQuotaLimit,
+		SoftLimitPercent: member.SoftLimitPercent,
+	}
+}
+
+// CanManage 是否可以管理成员与全部组织令牌
+func (member *OrganizationMember) CanManage() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleAdmin
+}
+
+// CanViewBilling 是否可以查看组织用量、日志并为组织充值
+func (member *OrganizationMember) CanViewBilling() bool {
+	return member.Role != OrganizationRoleMember
+}
+
+// Insert 创建组织并将创建者设为所有者
+func (org *Organization) Insert() error {
+	org.CreatedTime = common.GetTimestamp()
+	org.Status = OrganizationStatusEnabled
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:       org.Id,
+			UserId:      org.OwnerId,
+			Role:        OrganizationRoleOwner,
+			CreatedTime: org.CreatedTime,
+		}).Error
+	})
+}
+
+func (org *Organization) Update() error {
+	err := DB.Model(org).Select("name", "status").Updates(org).Error
+	if err == nil {
+		invalidateOrganizationCache(org.Id)
+	}
+	return err
+}
+
+// Delete 删除组织及其成员与组织令牌，组织仍有余额时不允许删除
+func (org *Organization) Delete() error {
+	var tokens []*Token
+	var members []*OrganizationMember
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var current Organization
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", org.Id).Take(&current).Error
+		if err != nil {
+			return err
+		}
+		if current.Quota > 0 {
+			return errors.New("组织仍有剩余额度，无法删除")
+		}
+		if err = tx.Where("org_id = ?", org.Id).Find(&tokens).Error; err != nil {
+			return err
+		}
+		if err = tx.Where("org_id = ?", org.Id).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		if err = tx.Where("org_id = ?", org.Id).Find(&members).Error; err != nil {
+			return err
+		}
+		if err = tx.Where("org_id = ?", org.Id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err = tx.Where("org_id = ?", org.Id).Delete(&OrganizationInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(org).Error
+	})
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			for _, token := range tokens {
+				if err := cacheDeleteToken(token.Key); err != nil {
+					common.SysError("failed to delete token cache: " + err.Error())
+				}
+			}
+			for _, member := range members {
+				invalidateOrganizationMemberCache(org.Id, member.UserId)
+			}
+			invalidateOrganizationCache(org.Id)
+		})
+	}
+	return nil
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	var org Organization
+	err := DB.Where("id = ?", id).First(&org).Error
+	return &org, err
+}
+
+func GetAllOrganizations(keyword string, startIdx int, num int) (orgs []*Organization, total int64, err error) {
+	tx := DB.Model(&Organization{})
+	if keyword != "" {
+		tx = tx.Where("name LIKE ?", "%"+keyword+"%")
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+func GetUserOrganizations(userId int) ([]*UserOrganization, error) {
+	var orgs []*UserOrganization
+	err := DB.Model(&Organization{}).
+		Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.org_id = organizations.id").
+		Where("organization_members.user_id = ?", userId).
+		Order("organizations.id").
+		Scan(&orgs).Error
+	return orgs, err
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.Where("org_id = ? AND user_id = ?", orgId, userId).First(&member).Error
+	return &member, err
+}
+
+func GetOrganizationMemberById(orgId int, id int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.Where("org_id = ? AND id = ?", orgId, id).First(&member).Error
+	return &member, err
+}
+
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Model(&OrganizationMember{}).
+		Select("organization_members.*, users.username").
+		Joins("LEFT JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.org_id = ?", orgId).
+		Order("organization_members.id").
+		Scan(&members).Error
+	return members, err
+}
+
+func (invitation *OrganizationInvitation) Insert() error {
+	invitation.CreatedTime = common.GetTimestamp()
+	return DB.Create(invitation).Error
+}
+
+func (invitation *OrganizationInvitation) Delete() error {
+	return DB.Delete(invitation).Error
+}
+
+// HasOrganizationInvitation 用户是否已有该组织的待处理邀请
+func HasOrganizationInvitation(orgId int, userId int) (bool, error) {
+	var count int64
+	err := DB.Model(&OrganizationInvitation{}).Where("org_id = ? AND user_id = ?", orgId, userId).Count(&count).Error
+	return count > 0, err
+}
+
+func GetOrganizationInvitationById(orgId int, id int) (*OrganizationInvitation, error) {
+	var invitation OrganizationInvitation
+	err := DB.Where("org_id = ? AND id = ?", orgId, id).First(&invitation).Error
+	return &invitation, err
+}
+
+// GetUserOrganizationInvitationById 获取发给指定用户的邀请
+func GetUserOrganizationInvitationById(userId int, id int) (*OrganizationInvitation, error) {
+	var invitation OrganizationInvitation
+	err := DB.Where("user_id = ? AND id = ?", userId, id).First(&invitation).Error
+	return &invitation, err
+}
+
+// GetOrganizationInvitations 查询组织发出的待处理邀请
+func GetOrganizationInvitations(orgId int) ([]*OrganizationInvitation, error) {
+	var invitations []*OrganizationInvitation
+	err := DB.Model(&OrganizationInvitation{}).
+		Select("organization_invitations.*, users.username").
+		Joins("LEFT JOIN users ON users.id = organization_invitations.user_id").
+		Where("organization_invitations.org_id = ?", orgId).
+		Order("organization_invitations.id").
+		Scan(&invitations).Error
+	return invitations, err
+}
+
+// GetUserOrganizationInvitations 查询用户收到的待处理邀请
+func GetUserOrganizationInvitations(userId int) ([]*OrganizationInvitation, error) {
+	var invitations []*OrganizationInvitation
+	err := DB.Model(&OrganizationInvitation{}).
+		Select("organization_invitations.*, organizations.name AS org_name").
+		Joins("JOIN organizations ON organizations.id = organization_invitations.org_id AND organizations.deleted_at IS NULL").
+		Where("organization_invitations.user_id = ?", userId).
+		Order("organization_invitations.id desc").
+		Scan(&invitations).Error
+	return invitations, err
+}
+
+// AcceptOrganizationInvitation 接受邀请，按邀请中的角色与限额加入组织并删除邀请
+func AcceptOrganizationInvitation(invitation *OrganizationInvitation) (*OrganizationMember, error) {
+	member := &OrganizationMember{
+		OrgId:             invitation.OrgId,
+		UserId:            invitation.UserId,
+		Role:              invitation.Role,
+		DailyQuotaLimit:   invitation.DailyQuotaLimit,
+		WeeklyQuotaLimit:  invitation.WeeklyQuotaLimit,
+		MonthlyQuotaLimit: invitation.MonthlyQuotaLimit,
+		SoftLimitPercent:  invitation.SoftLimitPercent,
+		CreatedTime:       common.GetTimestamp(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var org Organization
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", invitation.OrgId).Take(&org).Error
+		if err != nil {
+			return errors.New("组织不存在")
+		}
+		result := tx.Delete(invitation)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errors.New("邀请已失效")
+		}
+		var count int64
+		err = tx.Model(&OrganizationMember{}).Where("org_id = ? AND user_id = ?", invitation.OrgId, invitation.UserId).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("您已是该组织成员")
+		}
+		return tx.Create(member).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateOrganizationMemberCache(member.OrgId, member.UserId)
+	return member, nil
+}
+
+// Update 更新成员角色与限额，所有者变更请使用 TransferOrganizationOwnership
+func (member *OrganizationMember) Update() error {
+	err := DB.Model(member).Select("role", "daily_quota_limit", "weekly_quota_limit", "monthly_quota_limit",
+		"soft_limit_percent").Updates(member).Error
+	if err == nil {
+		invalidateOrganizationMemberCache(member.OrgId, member.UserId)
+	}
+	return err
+}
+
+// RemoveOrganizationMember 移除成员，该成员创建的组织令牌转交组织所有者并禁用，
+// 被移除的成员仍持有这些密钥，需由所有者确认后重新启用
+func RemoveOrganizationMember(org *Organization, member *OrganizationMember) error {
+	if member.Role == OrganizationRoleOwner {
+		return errors.New("无法移除组织所有者，请先转让组织")
+	}
+	var tokens []*Token
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id = ? AND user_id = ?", org.Id, member.UserId).Find(&tokens).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&Token{}).Where("org_id = ? AND user_id = ?", org.Id, member.UserId).
+			Updates(map[string]interface{}{"user_id": org.OwnerId, "status": common.TokenStatusDisabled}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("owner_type = ? AND owner_id = ?", SpendingOwnerOrgMember, member.Id).Delete(&SpendingWindow{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		token.UserId = org.OwnerId
+		token.Status = common.TokenStatusDisabled
+	}
+	refreshTokenCaches(tokens)
+	invalidateOrganizationMemberCache(org.Id, member.UserId)
+	return nil
+}
+
+// TransferOrganizationOwnership 将所有者转让给另一成员，原所有者降为管理员
+func TransferOrganizationOwnership(org *Organization, member *OrganizationMember) error {
+	previousOwnerId := org.OwnerId
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&OrganizationMember{}).Where("org_id = ? AND user_id = ?", org.Id, org.OwnerId).
+			Update("role", OrganizationRoleAdmin).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Model(member).Update("role", OrganizationRoleOwner).Error; err != nil {
+			return err
+		}
+		return tx.Model(org).Update("owner_id", member.UserId).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateOrganizationMemberCache(org.Id, previousOwnerId)
+	invalidateOrganizationMemberCache(org.Id, member.UserId)
+	invalidateOrganizationCache(org.Id)
+	return nil
+}
+
+// OwnsOrganization 用户是否为某个组织的所有者
+func OwnsOrganization(userId int) (bool, error) {
+	var count int64
+	err := DB.Model(&Organization{}).Where("owner_id = ?", userId).Count(&count).Error
+	return count > 0, err
+}
+
+// LeaveAllOrganizations 用户被删除时退出所有组织并清除收到的邀请，其组织令牌转交各组织所有者
+func LeaveAllOrganizations(userId int) error {
+	if err := DB.Where("user_id = ?", userId).Delete(&OrganizationInvitation{}).Error; err != nil {
+		return err
+	}
+	var members []*OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return err
+	}
+	for _, member := range members {
+		org, err := GetOrganizationById(member.OrgId)
+		if err != nil {
+			return err
+		}
+		if err = RemoveOrganizationMember(org, member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func refreshTokenCaches(tokens []*Token) {
+	if !common.RedisEnabled || len(tokens) == 0 {
+		return
+	}
+	gopool.Go(func() {
+		for _, token := range tokens {
+			if err := cacheSetToken(*token); err != nil {
+				common.SysError("failed to update token cache: " + err.Error())
+			}
+		}
+	})
+}
+
+// changeOrganizationQuota 在事务中修改组织额度并记账，对手方为按原因区分的系统账户
+func changeOrganizationQuota(tx *gorm.DB, orgId int, userId int, amount int, change QuotaChange) error {
+	if amount == 0 {
+		return nil
+	}
+	err := tx.Model(&Organization{}).Where("id = ?", orgId).
+		Update("quota", gorm.Expr("quota + ?", amount)).Error
+	if err != nil {
+		return err
+	}
+	change.OrgId = orgId
+	return postQuotaTransfer(tx, userId, SystemQuotaAccount(change.Reason), QuotaAccountOrganization, int64(amount), change)
+}
+
+// lockEnabledOrganization 在事务中锁定组织行并校验组织处于启用状态
+func lockEnabledOrganization(tx *gorm.DB, orgId int) (*Organization, error) {
+	var org Organization
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota", "status").Where("id = ?", orgId).Take(&org).Error
+	if err != nil {
+		return nil, err
+	}
+	if org.Status != OrganizationStatusEnabled {
+		return nil, errors.New("组织已被禁用")
+	}
+	return &org, nil
+}
+
+// FundOrganization 成员将个人余额划入组织额度池，组织被禁用时不允许划入
+func FundOrganization(orgId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("划转额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockEnabledOrganization(tx, orgId); err != nil {
+			return err
+		}
+		var user User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota").Where("id = ?", userId).Take(&user).Error
+		if err != nil {
+			return err
+		}
+		if user.Quota < quota {
+			return errors.New("余额不足")
+		}
+		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota - ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return postQuotaTransfer(tx, userId, QuotaAccountQuota, QuotaAccountOrganization, int64(quota), QuotaChange{
+			Reason:  QuotaReasonOrgFund,
+			ActorId: userId,
+			OrgId:   orgId,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := cacheDecrUserQuota(userId, int64(quota)); err != nil {
+			common.SysError("failed to decrease user quota: " + err.Error())
+		}
+		cacheIncrOrganizationQuota(orgId, int64(quota))
+	})
+	return nil
+}
+
+// WithdrawOrganization 所有者将组织额度池中的额度转回个人余额
+func WithdrawOrganization(orgId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("划转额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		org, err := lockEnabledOrganization(tx, orgId)
+		if err != nil {
+			return err
+		}
+		if org.Quota < quota {
+			return ErrOrganizationQuotaNotEnough
+		}
+		err = tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota - ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return postQuotaTransfer(tx, userId, QuotaAccountOrganization, QuotaAccountQuota, int64(quota), QuotaChange{
+			Reason:  QuotaReasonOrgWithdraw,
+			ActorId: userId,
+			OrgId:   orgId,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := cacheIncrUserQuota(userId, int64(quota)); err != nil {
+			common.SysError("failed to increase user quota: " + err.Error())
+		}
+		cacheIncrOrganizationQuota(orgId, -int64(quota))
+	})
+	return nil
+}
+
+// AdjustOrganizationQuota 管理员调整组织额度，delta 为负表示扣减
+func AdjustOrganizationQuota(orgId int, delta int, actorId int, remark string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return changeOrganizationQuota(tx, orgId, actorId, delta, QuotaChange{
+			Reason:  QuotaReasonAdminAdjust,
+			ActorId: actorId,
+			Remark:  remark,
+		})
+	})
+	if err == nil {
+		cacheIncrOrganizationQuota(orgId, int64(delta))
+	}
+	return err
+}
+
+// ConsumeOrganizationQuota 通过组织令牌消费时扣减组织额度，并累计组织与成员的已用额度
+func ConsumeOrganizationQuota(orgId int, memberId int, userId int, amount int, change QuotaChange) error {
+	if amount < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	if amount == 0 {
+		return nil
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		org, err := lockEnabledOrganization(tx, orgId)
+		if err != nil {
+			return err
+		}
+		if org.Quota < amount {
+			return fmt.Errorf("%w, organization quota: %s, need quota: %s", ErrOrganizationQuotaNotEnough,
+				common.FormatQuota(org.Quota), common.FormatQuota(amount))
+		}
+		return updateOrganizationUsage(tx, orgId, memberId, userId, -amount, change)
+	})
+	if err == nil {
+		cacheIncrOrganizationQuota(orgId, -int64(amount))
+	}
+	return err
+}
+
+// RestoreOrganizationQuota 退还通过组织令牌消费的额度
+func RestoreOrganizationQuota(orgId int, memberId int, userId int, amount int, change QuotaChange) error {
+	if amount < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	if amount == 0 {
+		return nil
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return updateOrganizationUsage(tx, orgId, memberId, userId, amount, change)
+	})
+	if err == nil {
+		cacheIncrOrganizationQuota(orgId, int64(amount))
+	}
+	return err
+}
+
+func updateOrganizationUsage(tx *gorm.DB, orgId int, memberId int, userId int, amount int, change QuotaChange) error {
+	if err := changeOrganizationQuota(tx, orgId, userId, amount, change); err != nil {
+		return err
+	}
+	err := tx.Model(&Organization{}).Where("id = ?", orgId).
+		Update("used_quota", gorm.Expr("used_quota - ?", amount)).Error
+	if err != nil {
+		return err
+	}
+	if memberId == 0 {
+		return nil
+	}
+	return tx.Model(&OrganizationMember{}).Where("id = ?", memberId).
+		Update("used_quota", gorm.Expr("used_quota - ?", amount)).Error
+}
+
+// GetOrganizationTokens 查询组织令牌，userId 不为 0 时只返回该成员名下的令牌
+func GetOrganizationTokens(orgId int, userId int, startIdx int, num int) (tokens []*Token, total int64, err error) {
+	tx := DB.Model(&Token{}).Where("org_id = ?", orgId)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&tokens).Error
+	return tokens, total, err
+}
+
+// GetManageableToken 获取用户可管理的令牌：自己名下的令牌，或自己担任所有者/管理员的组织的令牌
+func GetManageableToken(id int, userId int) (*Token, error) {
+	if id == 0 || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	var token Token
+	if err := DB.First(&token, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if token.UserId == userId {
+		return &token, nil
+	}
+	if token.OrgId != 0 {
+		member, err := GetOrganizationMember(token.OrgId, userId)
+		if err == nil && member.CanManage() {
+			return &token, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetOrganizationLogs 查询通过组织令牌产生的日志
+func GetOrganizationLogs(orgId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int) (logs []*Log, total int64, err error) {
+	tx := LOG_DB.Where("logs.org_id = ?", orgId)
+	if logType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", logType)
+	}
+	if modelName != "" {
+		tx = tx.Where("logs.model_name like ?", modelName)
+	}
+	if username != "" {
+		tx = tx.Where("logs.username = ?", username)
+	}
+	if tokenName != "" {
+		tx = tx.Where("logs.token_name = ?", tokenName)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", endTimestamp)
+	}
+	if err = tx.Model(&Log{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("logs.id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	formatUserLogs(logs)
+	for _, log := range logs {
+		log.ClientIP = ""
+	}
+	return logs, total, nil
+}
+
+// GetOrganizationLedgerBalances 在同一快照中读取 id 大于 afterId 的一批组织（含已删除）的额度及账本余额
+func GetOrganizationLedgerBalances(afterId int, limit int) ([]*QuotaLedgerBalance, error) {
+	var result []*QuotaLedgerBalance
+	err := quotaLedgerSnapshot(func(tx *gorm.DB) error {
+		var orgs []*Organization
+		err := tx.Unscoped().Select("id", "quota").Where("id > ?", afterId).Order("id").Limit(limit).Find(&orgs).Error
+		if err != nil || len(orgs) == 0 {
+			return err
+		}
+		ids := make([]int, 0, len(orgs))
+		balances := make(map[int]*QuotaLedgerBalance, len(orgs))
+		result = make([]*QuotaLedgerBalance, 0, len(orgs))
+		for _, org := range orgs {
+			ids = append(ids, org.Id)
+			balance := &QuotaLedgerBalance{
+				OrgId:   org.Id,
+				Balance: map[string]int64{QuotaAccountOrganization: int64(org.Quota)},
+				Ledger:  map[string]int64{QuotaAccountOrganization: 0},
+			}
+			balances[org.Id] = balance
+			result = append(result, balance)
+		}
+		var sums []struct {
+			OrgId  int
+			Amount int64
+		}
+		err = tx.Model(&QuotaLedgerEntry{}).
+			Select("org_id, SUM(amount) AS amount").
+			Where("org_id IN ? AND account = ?", ids, QuotaAccountOrganization).
+			Group("org_id").
+			Scan(&sums).Error
+		if err != nil {
+			return err
+		}
+		for _, sum := range sums {
+			balances[sum.OrgId].Ledger[QuotaAccountOrganization] = sum.Amount
+		}
+		return nil
+	})
+	return result, err
+}
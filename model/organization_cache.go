@@ -0,0 +1,107 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"fmt"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+func getOrganizationCacheKey(orgId int) string {
+	return fmt.Sprintf("org:%d", orgId)
+}
+
+func getOrganizationMemberCacheKey(orgId int, userId int) string {
+	return fmt.Sprintf("org_member:%d:%d", orgId, userId)
+}
+
+// CacheGetOrganization 中继请求读取组织信息，优先从缓存读取
+func CacheGetOrganization(orgId int) (*Organization, error) {
+	if !common.RedisEnabled {
+		return GetOrganizationById(orgId)
+	}
+	var org Organization
+	if err := common.RedisHGetObj(getOrganizationCacheKey(orgId), &org); err == nil {
+		return &org, nil
+	}
+	dbOrg, err := GetOrganizationById(orgId)
+	if err != nil {
+		return nil, err
+	}
+	cached := *dbOrg
+	gopool.Go(func() {
+		err := common.RedisHSetObj(getOrganizationCacheKey(orgId), &cached, time.Duration(constant.TokenCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("failed to update organization cache: " + err.Error())
+		}
+	})
+	return dbOrg, nil
+}
+
+// CacheGetOrganizationMember 中继请求读取组织成员，优先从缓存读取
+func CacheGetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	if !common.RedisEnabled {
+		return GetOrganizationMember(orgId, userId)
+	}
+	var member OrganizationMember
+	if err := common.RedisHGetObj(getOrganizationMemberCacheKey(orgId, userId), &member); err == nil {
+		return &member, nil
+	}
+	dbMember, err := GetOrganizationMember(orgId, userId)
+	if err != nil {
+		return nil, err
+	}
+	cached := *dbMember
+	gopool.Go(func() {
+		err := common.RedisHSetObj(getOrganizationMemberCacheKey(orgId, userId), &cached, time.Duration(constant.TokenCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("failed to update organization member cache: " + err.Error())
+		}
+	})
+	return dbMember, nil
+}
+
+func invalidateOrganizationCache(orgId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RedisHDelObj(getOrganizationCacheKey(orgId)); err != nil {
+		common.SysError("failed to delete organization cache: " + err.Error())
+	}
+}
+
+func invalidateOrganizationMemberCache(orgId int, userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RedisHDelObj(getOrganizationMemberCacheKey(orgId, userId)); err != nil {
+		common.SysError("failed to delete organization member cache: " + err.Error())
+	}
+}
+
+func cacheIncrOrganizationQuota(orgId int, delta int64) {
+	if !common.RedisEnabled || delta == 0 {
+		return
+	}
+	if err := common.RedisHIncrBy(getOrganizationCacheKey(orgId), "Quota", delta); err != nil {
+		common.SysError("failed to update organization quota cache: " + err.Error())
+	}
+}
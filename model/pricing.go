@@ -31,21 +31,29 @@ type Pricing struct {
 	OwnerBy         string   `json:"owner_by"`
 	CompletionRatio float64  `json:"completion_ratio"`
 	EnableGroup     []string `json:"enable_groups,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"` // 用户可用分组内该模型的所有已启用通道均通过的检查项
 }
 
 var (
 	pricingMap         []Pricing
+	groupCapabilities  GroupModelCapabilities
 	lastGetPricingTime time.Time
 	updatePricingLock  sync.Mutex
 )
 
-func GetPricing() []Pricing {
+// GetPricing 返回价格列表，能力一致性结果只统计 groups 内的通道
+func GetPricing(groups []string) []Pricing {
 	updatePricingLock.Lock()
 	defer updatePricingLock.Unlock()
 
 	if time.Since(lastGetPricingTime) > time.Minute*1 || len(pricingMap) == 0 {
 		updatePricing()
 	}
+	pricing := make([]Pricing, len(pricingMap))
+	copy(pricing, pricingMap)
+	for i := range pricing {
+		pricing[i].Capabilities = groupCapabilities.ForGroups(pricing[i].ModelName, groups)
+	}
 	//if group != "" {
 	//	userPricingMap := make([]Pricing, 0)
 	//	models := GetGroupModels(group)
@@ -57,7 +65,7 @@ func GetPricing() []Pricing {
 	//	}
 	//	return userPricingMap
 	//}
-	return pricingMap
+	return pricing
 }
 
 func updatePricing() {
@@ -75,6 +83,12 @@ func updatePricing() {
 		modelGroupsMap[ability.Model] = groups
 	}
 
+	capabilities, err := GetGroupModelCapabilities()
+	if err != nil {
+		common.SysError("get model capabilities failed: " + err.Error())
+	}
+	groupCapabilities = capabilities
+
 	pricingMap = make([]Pricing, 0)
 	for model, groups := range modelGroupsMap {
 		pricing := Pricing{
@@ -0,0 +1,389 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"veloera/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 用户侧账户，与 users 表中的余额字段一一对应
+const (
+	QuotaAccountQuota        = "quota"
+	QuotaAccountSubscription = "subscription_quota"
+	QuotaAccountAff          = "aff_quota"
+
+	// 组织额度池账户，分录的 OrgId 为所属组织
+	QuotaAccountOrganization = "org_quota"
+
+	// 系统对手方账户前缀，按变动原因区分，如 system:topup
+	quotaSystemAccountPrefix = "system:"
+)
+
+// 额度变动原因
+const (
+	QuotaReasonOpening       = "opening"        // 启用账本时的期初余额
+	QuotaReasonRegister      = "register"       // 新用户注册赠送
+	QuotaReasonInvitee       = "invitee"        // 使用邀请码注册赠送
+	QuotaReasonInviter       = "inviter"        // 邀请用户获得的邀请额度
+	QuotaReasonTopUp         = "topup"          // 在线充值
+	QuotaReasonTopUpRefund   = "topup_refund"   // 充值订单退款扣回
+	QuotaReasonRedemption    = "redemption"     // 兑换码
+	QuotaReasonCheckIn       = "checkin"        // 签到
+	QuotaReasonRebate        = "rebate"         // 邀请返佣
+	QuotaReasonAffTransfer   = "aff_transfer"   // 邀请额度划转
+	QuotaReasonConsume       = "consume"        // 请求消费
+	QuotaReasonConsumeRefund = "consume_refund" // 请求消费退还
+	QuotaReasonTaskRefund    = "task_refund"    // 异步任务失败退款
+	QuotaReasonAdminAdjust   = "admin_adjust"   // 管理员调整
+	QuotaReasonReconcile     = "reconcile"      // 对账修正，只调整账本不调整余额
+	QuotaReasonOrgFund       = "org_fund"       // 成员将个人余额划入组织
+	QuotaReasonOrgWithdraw   = "org_withdraw"   // 所有者将组织额度转回个人余额
+
+	QuotaReasonSubscriptionGrant  = "subscription_grant"  // 订阅周期发放
+	QuotaReasonSubscriptionExpire = "subscription_expire" // 订阅额度到期作废
+	QuotaReasonSubscriptionRefund = "subscription_refund" // 订阅订单退款扣回
+	QuotaReasonCampaignBonus      = "campaign_bonus"      // 兑换活动的充值加赠
+)
+
+var quotaUserAccounts = []string{QuotaAccountQuota, QuotaAccountSubscription, QuotaAccountAff}
+
+// QuotaLedgerEntry 额度账本分录，只追加不修改。每笔交易由同一 TransactionId 下金额相加为 0 的两条分录组成，
+// 一条记在用户账户上，另一条记在对手方账户（系统账户或用户的另一账户）上
+type QuotaLedgerEntry struct {
+	Id            int64  `json:"id"`
+	TransactionId string `json:"transaction_id" gorm:"type:varchar(36);index"`
+	UserId        int    `json:"user_id" gorm:"index:idx_quota_ledger_user_account,priority:1"`
+	OrgId         int    `json:"org_id" gorm:"index;default:0"`
+	Account       string `json:"account" gorm:"type:varchar(64);index:idx_quota_ledger_user_account,priority:2"`
+	Amount        int64  `json:"amount"`
+	Reason        string `json:"reason" gorm:"type:varchar(32);index"`
+	RefId         string `json:"ref_id" gorm:"type:varchar(128);index"`
+	ActorId       int    `json:"actor_id"`
+	Remark        string `json:"remark" gorm:"type:varchar(255)"`
+	CreatedAt     int64  `json:"created_at" gorm:"type:bigint;index"`
+}
+
+// QuotaChange 描述一次额度变动的原因、关联单据与操作者，ActorId 为 0 表示系统自动操作，
+// 涉及组织额度时 OrgId 为所属组织
+type QuotaChange struct {
+	Reason  string
+	RefId   string
+	ActorId int
+	OrgId   int
+	Remark  string
+}
+
+func SystemQuotaAccount(reason string) string {
+	return quotaSystemAccountPrefix + reason
+}
+
+func IsUserQuotaAccount(account string) bool {
+	return !strings.HasPrefix(account, quotaSystemAccountPrefix)
+}
+
+// postQuotaTransfer 记一笔从 from 账户转入 to 账户的交易
+func postQuotaTransfer(tx *gorm.DB, userId int, from string, to string, amount int64, change QuotaChange) error {
+	entries := quotaTransferEntries(userId, from, to, amount, change, common.GetTimestamp())
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Create(&entries).Error
+}
+
+// quotaTransferEntries 生成一笔从 from 账户转入 to 账户的交易分录，amount 为 0 时返回 nil
+func quotaTransferEntries(userId int, from string, to string, amount int64, change QuotaChange, createdAt int64) []QuotaLedgerEntry {
+	if amount == 0 {
+		return nil
+	}
+	if amount < 0 {
+		from, to, amount = to, from, -amount
+	}
+	transactionId := common.GetUUID()
+	entries := []QuotaLedgerEntry{
+		{TransactionId: transactionId, UserId: userId, Account: from, Amount: -amount},
+		{TransactionId: transactionId, UserId: userId, Account: to, Amount: amount},
+	}
+	for i := range entries {
+		entries[i].OrgId = change.OrgId
+		entries[i].Reason = change.Reason
+		entries[i].RefId = change.RefId
+		entries[i].ActorId = change.ActorId
+		entries[i].Remark = change.Remark
+		entries[i].CreatedAt = createdAt
+	}
+	return entries
+}
+
+// postUserQuotaChange 只记账不修改余额，用于余额已由调用方在同一事务中更新的场景
+func postUserQuotaChange(tx *gorm.DB, userId int, account string, amount int, change QuotaChange) error {
+	return postQuotaTransfer(tx, userId, SystemQuotaAccount(change.Reason), account, int64(amount), change)
+}
+
+// changeUserQuota 在事务中修改用户余额字段并记账，amount 为负表示扣减
+func changeUserQuota(tx *gorm.DB, userId int, account string, amount int, change QuotaChange) error {
+	if amount == 0 {
+		return nil
+	}
+	err := tx.Model(&User{}).Where("id = ?", userId).
+		Update(account, gorm.Expr(account+" + ?", amount)).Error
+	if err != nil {
+		return err
+	}
+	return postUserQuotaChange(tx, userId, account, amount, change)
+}
+
+// GetQuotaLedgerEntries 查询账本分录，onlyUserAccounts 时只返回用户侧分录
+func GetQuotaLedgerEntries(userId int, orgId int, reason string, transactionId string, onlyUserAccounts bool, startIdx int, num int) (entries []*QuotaLedgerEntry, total int64, err error) {
+	tx := DB.Model(&QuotaLedgerEntry{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if orgId != 0 {
+		tx = tx.Where("org_id = ? AND account = ?", orgId, QuotaAccountOrganization)
+	}
+	if reason != "" {
+		tx = tx.Where("reason = ?", reason)
+	}
+	if transactionId != "" {
+		tx = tx.Where("transaction_id = ?", transactionId)
+	}
+	if onlyUserAccounts {
+		tx = tx.Where("account IN ?", quotaUserAccounts)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&entries).Error
+	return entries, total, err
+}
+
+// QuotaLedgerBalance 用户或组织各账户的当前余额与按账本重新计算的余额
+type QuotaLedgerBalance struct {
+	UserId  int              `json:"user_id"`
+	OrgId   int              `json:"org_id"`
+	Balance map[string]int64 `json:"balance"`
+	Ledger  map[string]int64 `json:"ledger"`
+}
+
+// quotaLedgerSnapshot 在只读快照中执行 fn，使余额与账本分录在同一时刻读取，不受并发扣费影响。
+// SQLite 的事务本身即为一致快照
+func quotaLedgerSnapshot(fn func(tx *gorm.DB) error) error {
+	if common.UsingSQLite {
+		return DB.Transaction(fn)
+	}
+	return DB.Transaction(fn, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+// GetQuotaLedgerBalances 在同一快照中读取 id 大于 afterId 的一批用户（含已删除）的余额及账本余额
+func GetQuotaLedgerBalances(userId int, afterId int, limit int) ([]*QuotaLedgerBalance, error) {
+	var result []*QuotaLedgerBalance
+	err := quotaLedgerSnapshot(func(tx *gorm.DB) error {
+		var users []*User
+		query := tx.Unscoped().Select("id", "quota", "subscription_quota", "aff_quota")
+		if userId != 0 {
+			query = query.Where("id = ?", userId)
+		}
+		err := query.Where("id > ?", afterId).Order("id").Limit(limit).Find(&users).Error
+		if err != nil || len(users) == 0 {
+			return err
+		}
+		ids := make([]int, 0, len(users))
+		balances := make(map[int]*QuotaLedgerBalance, len(users))
+		result = make([]*QuotaLedgerBalance, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.Id)
+			balance := &QuotaLedgerBalance{
+				UserId:  user.Id,
+				Balance: userQuotaBalance(user),
+				Ledger: map[string]int64{
+					QuotaAccountQuota:        0,
+					QuotaAccountSubscription: 0,
+					QuotaAccountAff:          0,
+				},
+			}
+			balances[user.Id] = balance
+			result = append(result, balance)
+		}
+		var sums []struct {
+			UserId  int
+			Account string
+			Amount  int64
+		}
+		err = tx.Model(&QuotaLedgerEntry{}).
+			Select("user_id, account, SUM(amount) AS amount").
+			Where("user_id IN ? AND account IN ?", ids, quotaUserAccounts).
+			Group("user_id, account").
+			Scan(&sums).Error
+		if err != nil {
+			return err
+		}
+		for _, sum := range sums {
+			balances[sum.UserId].Ledger[sum.Account] = sum.Amount
+		}
+		return nil
+	})
+	return result, err
+}
+
+func userQuotaBalance(user *User) map[string]int64 {
+	return map[string]int64{
+		QuotaAccountQuota:        int64(user.Quota),
+		QuotaAccountSubscription: int64(user.SubscriptionQuota),
+		QuotaAccountAff:          int64(user.AffQuota),
+	}
+}
+
+// sumUserQuotaLedger 按账本计算用户某一账户的余额
+func sumUserQuotaLedger(tx *gorm.DB, userId int, account string) (int64, error) {
+	var amount int64
+	err := tx.Model(&QuotaLedgerEntry{}).Where("user_id = ? AND account = ?", userId, account).
+		Select("COALESCE(SUM(amount), 0)").Scan(&amount).Error
+	return amount, err
+}
+
+// GetUnbalancedQuotaTransactions 返回分录金额相加不为 0 的交易
+func GetUnbalancedQuotaTransactions(limit int) ([]string, error) {
+	var transactionIds []string
+	err := DB.Model(&QuotaLedgerEntry{}).
+		Select("transaction_id").
+		Group("transaction_id").
+		Having("SUM(amount) <> 0").
+		Limit(limit).
+		Pluck("transaction_id", &transactionIds).Error
+	return transactionIds, err
+}
+
+// ReconcileQuotaLedger 锁定用户或组织后重新比对账本与余额，差额仍为 difference 时记一笔对账修正，
+// 只写账本不修改余额。差额已变化（例如首次检查时读到了进行中的扣费）时不修正，返回 false
+func ReconcileQuotaLedger(userId int, orgId int, account string, difference int64, actorId int, remark string) (bool, error) {
+	reconciled := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var balance, ledger int64
+		var err error
+		if account == QuotaAccountOrganization {
+			err = tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Model(&Organization{}).Where("id = ?", orgId).
+				Select("quota").Take(&balance).Error
+			if err == nil {
+				err = tx.Model(&QuotaLedgerEntry{}).Where("org_id = ? AND account = ?", orgId, account).
+					Select("COALESCE(SUM(amount), 0)").Scan(&ledger).Error
+			}
+		} else {
+			var user User
+			err = tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota", "subscription_quota", "aff_quota").
+				Where("id = ?", userId).Take(&user).Error
+			if err == nil {
+				balance = userQuotaBalance(&user)[account]
+				ledger, err = sumUserQuotaLedger(tx, userId, account)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if balance-ledger != difference {
+			return nil
+		}
+		reconciled = true
+		return postQuotaTransfer(tx, userId, SystemQuotaAccount(QuotaReasonReconcile), account, difference, QuotaChange{
+			Reason:  QuotaReasonReconcile,
+			ActorId: actorId,
+			OrgId:   orgId,
+			Remark:  remark,
+		})
+	})
+	return reconciled && err == nil, err
+}
+
+// QuotaLedgerOpening 已写入期初余额的用户，保证每个用户只写入一次
+type QuotaLedgerOpening struct {
+	UserId    int   `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt int64 `json:"created_at" gorm:"type:bigint"`
+}
+
+// InitQuotaLedger 为尚未写入期初余额的用户（含已删除）逐个写入期初余额，可重复执行，中途失败后再次执行会从未完成的用户继续
+func InitQuotaLedger() error {
+	afterId := 0
+	opened := 0
+	for {
+		var ids []int
+		err := DB.Unscoped().Model(&User{}).
+			Joins("LEFT JOIN quota_ledger_openings ON quota_ledger_openings.user_id = users.id").
+			Where("users.id > ? AND quota_ledger_openings.user_id IS NULL", afterId).
+			Order("users.id").Limit(500).Pluck("users.id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			created, err := openUserQuotaLedger(id)
+			if err != nil {
+				return err
+			}
+			if created {
+				opened++
+			}
+		}
+		afterId = ids[len(ids)-1]
+	}
+	if opened > 0 {
+		common.SysLog(fmt.Sprintf("quota ledger initialized with opening balances of %d users", opened))
+	}
+	return nil
+}
+
+// openUserQuotaLedger 锁定用户行后写入期初余额。账本启用后产生的分录已反映在余额中，
+// 期初余额为当前余额减去已有分录之和，避免与实时流水重复计入
+func openUserQuotaLedger(userId int) (bool, error) {
+	opened := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota", "subscription_quota", "aff_quota").
+			Where("id = ?", userId).Take(&user).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&QuotaLedgerOpening{
+			UserId:    userId,
+			CreatedAt: common.GetTimestamp(),
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		balance := userQuotaBalance(&user)
+		for _, account := range quotaUserAccounts {
+			ledger, err := sumUserQuotaLedger(tx, userId, account)
+			if err != nil {
+				return err
+			}
+			err = postQuotaTransfer(tx, userId, SystemQuotaAccount(QuotaReasonOpening), account,
+				balance[account]-ledger, QuotaChange{Reason: QuotaReasonOpening})
+			if err != nil {
+				return err
+			}
+		}
+		opened = true
+		return nil
+	})
+	return opened, err
+}
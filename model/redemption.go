@@ -43,17 +43,28 @@ type Redemption struct {
 	IsGift       bool           `json:"is_gift" gorm:"default:false"`
 	MaxUses      int            `json:"max_uses" gorm:"default:-1"` // -1 means unlimited
 	UsedCount    int            `json:"used_count" gorm:"default:0"`
+	CampaignId   int            `json:"campaign_id" gorm:"default:0;index"` // 所属兑换活动，0 表示不属于任何活动
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
 }
 
-// RedemptionLog 记录礼品码的使用记录
+// RedemptionLog 记录兑换码的使用记录
 type RedemptionLog struct {
 	Id           int   `json:"id"`
 	RedemptionId int   `json:"redemption_id" gorm:"index"`
+	CampaignId   int   `json:"campaign_id" gorm:"default:0;index"`
 	UserId       int   `json:"user_id" gorm:"index"`
+	Quota        int   `json:"quota" gorm:"default:0"`
 	UsedTime     int64 `json:"used_time" gorm:"bigint"`
 }
 
+// RedeemResult 兑换结果，Group 与 TopUpBonusPercent 为活动附带的奖励
+type RedeemResult struct {
+	Quota             int     `json:"quota"`
+	IsGift            bool    `json:"is_gift"`
+	Group             string  `json:"group,omitempty"`
+	TopUpBonusPercent float64 `json:"top_up_bonus_percent,omitempty"`
+}
+
 func GetAllRedemptions(startIdx int, num int) (redemptions []*Redemption, total int64, err error) {
 	// 开始事务
 	tx := DB.Begin()
@@ -151,14 +162,15 @@ func GetRedemptionByKey(key string) (*Redemption, error) {
 	return &redemption, err
 }
 
-func Redeem(key string, userId int) (quota int, isGift bool, err error) {
+func Redeem(key string, userId int) (result *RedeemResult, err error) {
 	if key == "" {
-		return 0, false, errors.New("未提供兑换码")
+		return nil, errors.New("未提供兑换码")
 	}
 	if userId == 0 {
-		return 0, false, errors.New("无效的 user id")
+		return nil, errors.New("无效的 user id")
 	}
 	redemption := &Redemption{}
+	var campaign *RedemptionCampaign
 
 	keyCol := "`key`"
 	if common.UsingPostgreSQL {
@@ -170,10 +182,10 @@ func Redeem(key string, userId int) (quota int, isGift bool, err error) {
 		locked, redisErr := common.RDB.SetNX(context.Background(), lockKey, "1", 10*time.Second).Result()
 		if redisErr != nil {
 			common.SysError("Redis lock acquisition error: " + redisErr.Error())
-			return 0, false, errors.New("系统暂时繁忙，请稍后再试") // System temporarily busy, please try again later
+			return nil, errors.New("系统暂时繁忙，请稍后再试") // System temporarily busy, please try again later
 		}
 		if !locked {
-			return 0, false, errors.New("操作过于频繁，请稍后再试") // Operation too frequent, please try again later
+			return nil, errors.New("操作过于频繁，请稍后再试") // Operation too frequent, please try again later
 		}
 		defer common.RDB.Del(context.Background(), lockKey)
 	}
@@ -192,19 +204,42 @@ func Redeem(key string, userId int) (quota int, isGift bool, err error) {
 		if redemption.ValidUntil > 0 && currentTime > redemption.ValidUntil {
 			return errors.New("兑换码已过期")
 		}
+		if redemption.CampaignId != 0 {
+			campaign = &RedemptionCampaign{}
+			if err = tx.First(campaign, "id = ?", redemption.CampaignId).Error; err != nil {
+				return errors.New("兑换码所属活动不存在")
+			}
+			if err = checkRedemptionCampaign(tx, campaign, userId); err != nil {
+				return err
+			}
+		}
 
 		if !redemption.IsGift {
 			// 普通兑换码逻辑
 			if redemption.Status != common.RedemptionCodeStatusEnabled {
 				return errors.New("该兑换码已被使用")
 			}
-			err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+			err = changeUserQuota(tx, userId, QuotaAccountQuota, redemption.Quota, QuotaChange{
+				Reason:  QuotaReasonRedemption,
+				RefId:   strconv.Itoa(redemption.Id),
+				ActorId: userId,
+			})
 			if err != nil {
 				return err
 			}
 			redemption.RedeemedTime = common.GetTimestamp()
 			redemption.Status = common.RedemptionCodeStatusUsed
 			redemption.UsedUserId = userId
+			log := RedemptionLog{
+				RedemptionId: redemption.Id,
+				CampaignId:   redemption.CampaignId,
+				UserId:       userId,
+				Quota:        redemption.Quota,
+				UsedTime:     redemption.RedeemedTime,
+			}
+			if err = tx.Create(&log).Error; err != nil {
+				return err
+			}
 		} else {
 			// 礼品码逻辑
 			if redemption.MaxUses != -1 && redemption.UsedCount >= redemption.MaxUses {
@@ -220,7 +255,11 @@ func Redeem(key string, userId int) (quota int, isGift bool, err error) {
 				return errors.New("您已经使用过这个礼品码")
 			}
 
-			err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+			err = changeUserQuota(tx, userId, QuotaAccountQuota, redemption.Quota, QuotaChange{
+				Reason:  QuotaReasonRedemption,
+				RefId:   strconv.Itoa(redemption.Id),
+				ActorId: userId,
+			})
 			if err != nil {
 				return err
 			}
@@ -228,7 +267,9 @@ func Redeem(key string, userId int) (quota int, isGift bool, err error) {
 			// 记录使用日志
 			log := RedemptionLog{
 				RedemptionId: redemption.Id,
+				CampaignId:   redemption.CampaignId,
 				UserId:       userId,
+				Quota:        redemption.Quota,
 				UsedTime:     common.GetTimestamp(),
 			}
 			if err = tx.Create(&log).Error; err != nil {
@@ -241,18 +282,37 @@ func Redeem(key string, userId int) (quota int, isGift bool, err error) {
 			}
 		}
 
-		err = tx.Save(redemption).Error
-		return err
+		if err = tx.Save(redemption).Error; err != nil {
+			return err
+		}
+		if campaign != nil {
+			return applyRedemptionCampaignRewards(tx, campaign, redemption, userId)
+		}
+		return nil
 	})
 	if err != nil {
-		return 0, false, errors.New("兑换失败，" + err.Error())
+		return nil, errors.New("兑换失败，" + err.Error())
 	}
 	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过%s充值 %s，兑换码ID %d",
 		map[bool]string{true: "礼品码", false: "兑换码"}[redemption.IsGift],
 		common.LogQuota(redemption.Quota),
 		redemption.Id))
 
-	return redemption.Quota, redemption.IsGift, nil
+	result = &RedeemResult{
+		Quota:  redemption.Quota,
+		IsGift: redemption.IsGift,
+	}
+	if campaign != nil {
+		result.Group = campaign.UpgradeGroup
+		result.TopUpBonusPercent = campaign.TopUpBonusPercent
+		if campaign.UpgradeGroup != "" {
+			if err := invalidateUserCache(userId); err != nil {
+				common.SysError("failed to invalidate user cache: " + err.Error())
+			}
+			RecordLog(userId, LogTypeSystem, fmt.Sprintf("参与活动 %s，分组调整为 %s", campaign.Name, campaign.UpgradeGroup))
+		}
+	}
+	return result, nil
 }
 
 func (redemption *Redemption) Insert() error {
@@ -269,7 +329,7 @@ func (redemption *Redemption) SelectUpdate() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (redemption *Redemption) Update() error {
 	var err error
-	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time", "valid_from", "valid_until").Updates(redemption).Error
+	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time", "valid_from", "valid_until", "campaign_id").Updates(redemption).Error
 	return err
 }
 
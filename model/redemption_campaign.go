@@ -0,0 +1,293 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"veloera/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RedemptionCampaignStatusEnabled  = 1
+	RedemptionCampaignStatusDisabled = 2
+)
+
+const (
+	TopUpBonusStatusPending = "pending"
+	TopUpBonusStatusUsed    = "used"
+)
+
+// RedemptionCampaign 兑换码活动，活动下的兑换码共享领取条件与额外奖励
+type RedemptionCampaign struct {
+	Id                int     `json:"id"`
+	Name              string  `json:"name" gorm:"type:varchar(64)"`
+	Description       string  `json:"description" gorm:"type:text"`
+	Status            int     `json:"status" gorm:"default:1"`
+	NewUserDays       int     `json:"new_user_days" gorm:"default:0"`          // 仅限注册 N 天内的新用户，0 表示不限
+	AllowedGroups     string  `json:"allowed_groups" gorm:"type:varchar(255)"` // 仅限指定分组的用户，逗号分隔，为空表示不限
+	OncePerUser       bool    `json:"once_per_user"`                           // 每个用户在整个活动中只能兑换一次
+	MinTopUpQuota     int     `json:"min_top_up_quota" gorm:"default:0"`       // 要求用户累计在线充值（扣除退款）达到该额度
+	UpgradeGroup      string  `json:"upgrade_group" gorm:"type:varchar(64)"`   // 兑换后将用户调整到该分组
+	TopUpBonusPercent float64 `json:"top_up_bonus_percent" gorm:"default:0"`   // 兑换后下一次充值额外赠送的比例，如 20 表示多送 20%
+	CreatedTime       int64   `json:"created_time" gorm:"type:bigint"`
+}
+
+// TopUpBonus 兑换活动码获得的充值加赠，下一次在线充值成功时发放
+type TopUpBonus struct {
+	Id           int     `json:"id"`
+	UserId       int     `json:"user_id" gorm:"index"`
+	CampaignId   int     `json:"campaign_id" gorm:"index"`
+	RedemptionId int     `json:"redemption_id"`
+	Percent      float64 `json:"percent"`
+	Status       string  `json:"status" gorm:"type:varchar(16);index"`
+	TradeNo      string  `json:"trade_no" gorm:"type:varchar(255)"`
+	Quota        int     `json:"quota"`
+	CreatedTime  int64   `json:"created_time" gorm:"type:bigint"`
+	UsedTime     int64   `json:"used_time" gorm:"type:bigint"`
+}
+
+// RedemptionCampaignClaim 用户参与限领一次的活动的记录，(campaign_id, user_id) 唯一，
+// 在兑换事务中写入，并发兑换同一活动的多个兑换码时只有一个能成功
+type RedemptionCampaignClaim struct {
+	Id           int   `json:"id"`
+	CampaignId   int   `json:"campaign_id" gorm:"uniqueIndex:idx_campaign_claim_user,priority:1"`
+	UserId       int   `json:"user_id" gorm:"uniqueIndex:idx_campaign_claim_user,priority:2"`
+	RedemptionId int   `json:"redemption_id"`
+	CreatedTime  int64 `json:"created_time" gorm:"type:bigint"`
+}
+
+// RedemptionCampaignStats 活动统计，付费转化指兑换后完成过在线充值或订阅的用户
+type RedemptionCampaignStats struct {
+	CampaignId    int     `json:"campaign_id"`
+	Codes         int64   `json:"codes"`
+	Redemptions   int64   `json:"redemptions"`
+	Users         int64   `json:"users"`
+	GrantedQuota  int64   `json:"granted_quota"`
+	BonusQuota    int64   `json:"bonus_quota"`
+	PaidUsers     int64   `json:"paid_users"`
+	Conversion    float64 `json:"conversion"`
+	ConsumedQuota int64   `json:"consumed_quota"`
+}
+
+func (campaign *RedemptionCampaign) GetAllowedGroups() []string {
+	return splitPlanList(campaign.AllowedGroups)
+}
+
+func (campaign *RedemptionCampaign) Insert() error {
+	campaign.CreatedTime = common.GetTimestamp()
+	return DB.Create(campaign).Error
+}
+
+func (campaign *RedemptionCampaign) Update() error {
+	return DB.Model(campaign).Select("name", "description", "status", "new_user_days", "allowed_groups", "once_per_user",
+		"min_top_up_quota", "upgrade_group", "top_up_bonus_percent").Updates(campaign).Error
+}
+
+// Delete 删除活动，活动下的兑换码解除关联后保留
+func (campaign *RedemptionCampaign) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Redemption{}).Where("campaign_id = ?", campaign.Id).Update("campaign_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(campaign).Error
+	})
+}
+
+func GetRedemptionCampaignById(id int) (*RedemptionCampaign, error) {
+	var campaign RedemptionCampaign
+	err := DB.First(&campaign, "id = ?", id).Error
+	return &campaign, err
+}
+
+func GetAllRedemptionCampaigns(startIdx int, num int) (campaigns []*RedemptionCampaign, total int64, err error) {
+	if err = DB.Model(&RedemptionCampaign{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&campaigns).Error
+	return campaigns, total, err
+}
+
+// checkRedemptionCampaign 校验用户是否满足活动的领取条件
+func checkRedemptionCampaign(tx *gorm.DB, campaign *RedemptionCampaign, userId int) error {
+	if campaign.Status != RedemptionCampaignStatusEnabled {
+		return errors.New("该活动已结束")
+	}
+	if campaign.OncePerUser {
+		var count int64
+		err := tx.Model(&RedemptionLog{}).Where("campaign_id = ? AND user_id = ?", campaign.Id, userId).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("您已参与过该活动")
+		}
+	}
+	var user User
+	if err := tx.Select("id", "group", "created_time").Where("id = ?", userId).Take(&user).Error; err != nil {
+		return err
+	}
+	if campaign.NewUserDays > 0 {
+		// 记录注册时间之前创建的老用户 created_time 为 0，不视为新用户
+		if user.CreatedTime == 0 || common.GetTimestamp()-user.CreatedTime > int64(campaign.NewUserDays)*24*3600 {
+			return errors.New("该兑换码仅限新用户使用")
+		}
+	}
+	if groups := campaign.GetAllowedGroups(); len(groups) > 0 && !common.StringsContains(groups, user.Group) {
+		return errors.New("您所在的分组无法使用该兑换码")
+	}
+	if campaign.MinTopUpQuota > 0 {
+		var paid int64
+		err := tx.Model(&TopUp{}).
+			Where("user_id = ? AND status IN ?", userId, []string{TopUpStatusSuccess, TopUpStatusPartialRefunded}).
+			// 兼容未记录额度的历史订单，与 TopUp.GetQuota 一致
+			Select("COALESCE(SUM(CASE WHEN quota > 0 THEN quota ELSE amount * ? END - refunded_quota), 0)", common.QuotaPerUnit).
+			Scan(&paid).Error
+		if err != nil {
+			return err
+		}
+		if paid < int64(campaign.MinTopUpQuota) {
+			return fmt.Errorf("该兑换码要求累计充值达到 %s", common.LogQuota(campaign.MinTopUpQuota))
+		}
+	}
+	return nil
+}
+
+// applyRedemptionCampaignRewards 登记限领一次活动的参与记录，发放活动的额外奖励：调整分组并登记下一次充值的加赠
+func applyRedemptionCampaignRewards(tx *gorm.DB, campaign *RedemptionCampaign, redemption *Redemption, userId int) error {
+	if campaign.OncePerUser {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&RedemptionCampaignClaim{
+			CampaignId:   campaign.Id,
+			UserId:       userId,
+			RedemptionId: redemption.Id,
+			CreatedTime:  common.GetTimestamp(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("您已参与过该活动")
+		}
+	}
+	if campaign.UpgradeGroup != "" {
+		if err := tx.Model(&User{}).Where("id = ?", userId).Update("group", campaign.UpgradeGroup).Error; err != nil {
+			return err
+		}
+	}
+	if campaign.TopUpBonusPercent > 0 {
+		bonus := &TopUpBonus{
+			UserId:       userId,
+			CampaignId:   campaign.Id,
+			RedemptionId: redemption.Id,
+			Percent:      campaign.TopUpBonusPercent,
+			Status:       TopUpBonusStatusPending,
+			CreatedTime:  common.GetTimestamp(),
+		}
+		return tx.Create(bonus).Error
+	}
+	return nil
+}
+
+// applyTopUpBonus 在充值入账事务内发放最早一笔待使用的充值加赠并记录到订单上，退款时按比例扣回，返回加赠的额度
+func applyTopUpBonus(tx *gorm.DB, userId int, tradeNo string, quota int) (int, error) {
+	var bonus TopUpBonus
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND status = ?", userId, TopUpBonusStatusPending).Order("id asc").First(&bonus).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	bonusQuota := int(float64(quota) * bonus.Percent / 100)
+	err = tx.Model(&bonus).Updates(map[string]interface{}{
+		"status":    TopUpBonusStatusUsed,
+		"trade_no":  tradeNo,
+		"quota":     bonusQuota,
+		"used_time": common.GetTimestamp(),
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Model(&TopUp{}).Where("trade_no = ?", tradeNo).Update("bonus_quota", bonusQuota).Error; err != nil {
+		return 0, err
+	}
+	err = changeUserQuota(tx, userId, QuotaAccountQuota, bonusQuota, QuotaChange{
+		Reason: QuotaReasonCampaignBonus,
+		RefId:  tradeNo,
+		Remark: "campaign:" + strconv.Itoa(bonus.CampaignId),
+	})
+	return bonusQuota, err
+}
+
+// GetRedemptionCampaignStats 统计活动的兑换、付费转化与兑换用户此后的消耗
+func GetRedemptionCampaignStats(campaign *RedemptionCampaign) (*RedemptionCampaignStats, error) {
+	stats := &RedemptionCampaignStats{CampaignId: campaign.Id}
+	if err := DB.Model(&Redemption{}).Where("campaign_id = ?", campaign.Id).Count(&stats.Codes).Error; err != nil {
+		return nil, err
+	}
+	var redeemed struct {
+		Redemptions  int64
+		Users        int64
+		GrantedQuota int64
+	}
+	err := DB.Model(&RedemptionLog{}).Where("campaign_id = ?", campaign.Id).
+		Select("COUNT(*) AS redemptions, COUNT(DISTINCT user_id) AS users, COALESCE(SUM(quota), 0) AS granted_quota").
+		Scan(&redeemed).Error
+	if err != nil {
+		return nil, err
+	}
+	stats.Redemptions, stats.Users, stats.GrantedQuota = redeemed.Redemptions, redeemed.Users, redeemed.GrantedQuota
+	err = DB.Model(&TopUpBonus{}).Where("campaign_id = ? AND status = ?", campaign.Id, TopUpBonusStatusUsed).
+		Select("COALESCE(SUM(quota), 0)").Scan(&stats.BonusQuota).Error
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Table("redemption_logs").
+		Joins("JOIN top_ups ON top_ups.user_id = redemption_logs.user_id AND top_ups.complete_time >= redemption_logs.used_time").
+		Where("redemption_logs.campaign_id = ? AND top_ups.status IN ?", campaign.Id,
+			[]string{TopUpStatusSuccess, TopUpStatusPartialRefunded, TopUpStatusRefunded}).
+		Select("COUNT(DISTINCT redemption_logs.user_id)").Scan(&stats.PaidUsers).Error
+	if err != nil {
+		return nil, err
+	}
+	if stats.Users > 0 {
+		stats.Conversion = float64(stats.PaidUsers) / float64(stats.Users)
+	}
+	var userIds []int
+	err = DB.Model(&RedemptionLog{}).Where("campaign_id = ?", campaign.Id).Distinct().Pluck("user_id", &userIds).Error
+	if err != nil {
+		return nil, err
+	}
+	// 消耗统计自活动创建起，用户较多时分批查询日志库
+	for start := 0; start < len(userIds); start += 500 {
+		end := min(start+500, len(userIds))
+		var consumed int64
+		err = LOG_DB.Model(&Log{}).
+			Where("type = ? AND created_at >= ? AND user_id IN ?", LogTypeConsume, campaign.CreatedTime, userIds[start:end]).
+			Select("COALESCE(SUM(quota), 0)").Scan(&consumed).Error
+		if err != nil {
+			return nil, err
+		}
+		stats.ConsumedQuota += consumed
+	}
+	return stats, nil
+}
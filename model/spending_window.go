@@ -0,0 +1,198 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"time"
+	"veloera/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SpendingOwnerToken     = "token"
+	SpendingOwnerUser      = "user"
+	SpendingOwnerOrgMember = "org_member"
+)
+
+const (
+	SpendingPeriodDaily   = "daily"
+	SpendingPeriodWeekly  = "weekly"
+	SpendingPeriodMonthly = "monthly"
+)
+
+var SpendingPeriods = []string{SpendingPeriodDaily, SpendingPeriodWeekly, SpendingPeriodMonthly}
+
+// SpendingWindow 记录令牌或用户在自然日/周/月窗口内的已用额度，窗口过期后自动归零
+type SpendingWindow struct {
+	Id           int    `json:"id"`
+	OwnerType    string `json:"owner_type" gorm:"type:varchar(16);uniqueIndex:idx_spending_owner_period,priority:1"`
+	OwnerId      int    `json:"owner_id" gorm:"uniqueIndex:idx_spending_owner_period,priority:2"`
+	Period       string `json:"period" gorm:"type:varchar(16);uniqueIndex:idx_spending_owner_period,priority:3"`
+	WindowStart  int64  `json:"window_start" gorm:"type:bigint"`
+	UsedQuota    int    `json:"used_quota" gorm:"default:0"`
+	SoftNotified bool   `json:"soft_notified" gorm:"default:false"`
+}
+
+// GetSpendingWindowStart 返回 now 所在周期窗口的起始时间（服务器时区，周从周一开始）
+func GetSpendingWindowStart(period string, now time.Time) int64 {
+	y, m, d := now.Date()
+	switch period {
+	case SpendingPeriodWeekly:
+		offset := (int(now.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location()).Unix()
+	case SpendingPeriodMonthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location()).Unix()
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Unix()
+	}
+}
+
+// GetSpendingWindowResetAt 返回窗口的下一次重置时间
+func GetSpendingWindowResetAt(period string, windowStart int64) int64 {
+	start := time.Unix(windowStart, 0)
+	switch period {
+	case SpendingPeriodWeekly:
+		return start.AddDate(0, 0, 7).Unix()
+	case SpendingPeriodMonthly:
+		return start.AddDate(0, 1, 0).Unix()
+	default:
+		return start.AddDate(0, 0, 1).Unix()
+	}
+}
+
+func GetSpendingPeriodLimit(limit dto.SpendingLimit, period string) int {
+	switch period {
+	case SpendingPeriodDaily:
+		return limit.Daily
+	case SpendingPeriodWeekly:
+		return limit.Weekly
+	case SpendingPeriodMonthly:
+		return limit.Monthly
+	}
+	return 0
+}
+
+// GetSpendingWindows 返回 owner 各周期当前窗口的已用额度，已过期的窗口视为 0
+func GetSpendingWindows(ownerType string, ownerId int) (map[string]int, error) {
+	var windows []*SpendingWindow
+	err := DB.Where("owner_type = ? AND owner_id = ?", ownerType, ownerId).Find(&windows).Error
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	used := make(map[string]int, len(SpendingPeriods))
+	for _, window := range windows {
+		if window.WindowStart == GetSpendingWindowStart(window.Period, now) {
+			used[window.Period] = window.UsedQuota
+		}
+	}
+	return used, nil
+}
+
+func GetSpendingWindowUsages(ownerType string, ownerId int, limit dto.SpendingLimit) ([]dto.SpendingWindowUsage, error) {
+	used, err := GetSpendingWindows(ownerType, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	usages := make([]dto.SpendingWindowUsage, 0, len(SpendingPeriods))
+	for _, period := range SpendingPeriods {
+		windowStart := GetSpendingWindowStart(period, now)
+		usages = append(usages, dto.SpendingWindowUsage{
+			Period:      period,
+			Limit:       GetSpendingPeriodLimit(limit, period),
+			UsedQuota:   used[period],
+			WindowStart: windowStart,
+			ResetAt:     GetSpendingWindowResetAt(period, windowStart),
+		})
+	}
+	return usages, nil
+}
+
+// IncreaseSpendingWindowUsage 累加当前窗口的已用额度，delta 可以为负数（退款），返回更新后的窗口
+func IncreaseSpendingWindowUsage(ownerType string, ownerId int, period string, delta int) (*SpendingWindow, error) {
+	window, _, err := increaseSpendingWindowUsage(ownerType, ownerId, period, delta, 0)
+	return window, err
+}
+
+// ReserveSpendingWindowUsage 以单条条件更新原子地累加当前窗口的已用额度，累加后会超过 limit 时不修改并返回 false
+func ReserveSpendingWindowUsage(ownerType string, ownerId int, period string, delta int, limit int) (*SpendingWindow, bool, error) {
+	return increaseSpendingWindowUsage(ownerType, ownerId, period, delta, limit)
+}
+
+func increaseSpendingWindowUsage(ownerType string, ownerId int, period string, delta int, limit int) (*SpendingWindow, bool, error) {
+	windowStart := GetSpendingWindowStart(period, time.Now())
+	window := &SpendingWindow{}
+	err := DB.Where("owner_type = ? AND owner_id = ? AND period = ?", ownerType, ownerId, period).First(window).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		window = &SpendingWindow{
+			OwnerType:   ownerType,
+			OwnerId:     ownerId,
+			Period:      period,
+			WindowStart: windowStart,
+		}
+		if err = DB.Create(window).Error; err != nil {
+			// 并发创建时唯一索引冲突，重新读取即可
+			window = &SpendingWindow{}
+			err = DB.Where("owner_type = ? AND owner_id = ? AND period = ?", ownerType, ownerId, period).First(window).Error
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if window.WindowStart != windowStart {
+		if window.WindowStart > windowStart {
+			// 上一个窗口的退款，已经随窗口重置，无需处理
+			return window, true, nil
+		}
+		err = DB.Model(&SpendingWindow{}).Where("id = ? AND window_start = ?", window.Id, window.WindowStart).Updates(map[string]interface{}{
+			"window_start":  windowStart,
+			"used_quota":    0,
+			"soft_notified": false,
+		}).Error
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	applied := true
+	if delta != 0 {
+		tx := DB.Model(&SpendingWindow{}).Where("id = ? AND window_start = ?", window.Id, windowStart)
+		if limit > 0 {
+			tx = tx.Where("used_quota + ? <= ?", delta, limit)
+		}
+		result := tx.Update("used_quota", gorm.Expr("used_quota + ?", delta))
+		if result.Error != nil {
+			return nil, false, result.Error
+		}
+		applied = limit <= 0 || result.RowsAffected == 1
+	}
+	err = DB.First(window, window.Id).Error
+	return window, applied, err
+}
+
+// MarkSpendingWindowNotified 标记当前窗口已发送软限额提醒，返回 false 表示已被其他请求标记
+func MarkSpendingWindowNotified(id int, windowStart int64) (bool, error) {
+	result := DB.Model(&SpendingWindow{}).Where("id = ? AND window_start = ? AND soft_notified = ?", id, windowStart, false).
+		Update("soft_notified", true)
+	return result.RowsAffected == 1, result.Error
+}
+
+func DeleteSpendingWindows(ownerType string, ownerId int) error {
+	return DB.Where("owner_type = ? AND owner_id = ?", ownerType, ownerId).Delete(&SpendingWindow{}).Error
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"fmt"
+	"veloera/common"
+)
+
+// UserStatement 用户月度对账单，期末余额在生成时固定，作为下一期的期初余额
+type UserStatement struct {
+	Id             int    `json:"id"`
+	UserId         int    `json:"user_id" gorm:"uniqueIndex:idx_statement_user_period,priority:1"`
+	Period         string `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_statement_user_period,priority:2;index"`
+	StartTime      int64  `json:"start_time" gorm:"type:bigint"`
+	EndTime        int64  `json:"end_time" gorm:"type:bigint"`
+	OpeningBalance int64  `json:"opening_balance"`
+	ClosingBalance int64  `json:"closing_balance"`
+	TotalCredits   int64  `json:"total_credits"`
+	TotalDebits    int64  `json:"total_debits"`
+	Adjustment     int64  `json:"adjustment"`
+	Detail         string `json:"detail" gorm:"type:text"`
+	CreatedTime    int64  `json:"created_time" gorm:"type:bigint"`
+	EmailedTime    int64  `json:"emailed_time" gorm:"type:bigint;default:0"`
+}
+
+// StatementTopUp 对账期内完成的充值订单
+type StatementTopUp struct {
+	TradeNo  string  `json:"trade_no"`
+	Provider string  `json:"provider"`
+	Money    float64 `json:"money"`
+	Currency string  `json:"currency"`
+	Quota    int64   `json:"quota"`
+	Time     int64   `json:"time"`
+}
+
+// StatementRedemption 对账期内使用的兑换码或礼品码
+type StatementRedemption struct {
+	RedemptionId int    `json:"redemption_id"`
+	Name         string `json:"name"`
+	IsGift       bool   `json:"is_gift"`
+	Quota        int64  `json:"quota"`
+	Time         int64  `json:"time"`
+}
+
+// StatementModelUsage 对账期内按模型汇总的消费
+type StatementModelUsage struct {
+	ModelName        string `json:"model_name"`
+	RequestCount     int64  `json:"request_count"`
+	Quota            int64  `json:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+// StatementLogEntry 对账期内带额度的日志，如返佣、签到与异步任务退款
+type StatementLogEntry struct {
+	Content string `json:"content"`
+	Quota   int64  `json:"quota"`
+	Time    int64  `json:"time"`
+}
+
+func (statement *UserStatement) Insert() error {
+	statement.CreatedTime = common.GetTimestamp()
+	return DB.Create(statement).Error
+}
+
+func (statement *UserStatement) MarkEmailed() error {
+	statement.EmailedTime = common.GetTimestamp()
+	return DB.Model(statement).Update("emailed_time", statement.EmailedTime).Error
+}
+
+func GetUserStatement(userId int, period string) (*UserStatement, error) {
+	var statement UserStatement
+	err := DB.Where("user_id = ? AND period = ?", userId, period).First(&statement).Error
+	if err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func GetUserStatements(userId int, period string, startIdx int, num int) (statements []*UserStatement, total int64, err error) {
+	tx := DB.Model(&UserStatement{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if period != "" {
+		tx = tx.Where("period = ?", period)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	// 列表不返回明细，明细通过单张对账单接口获取
+	err = tx.Omit("detail").Order("period desc, id desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
+
+// GetStatementTopUps 获取完成时间在 [start, end) 内的充值订单，历史订单没有完成时间时按创建时间统计
+func GetStatementTopUps(userId int, start int64, end int64) ([]*StatementTopUp, error) {
+	var topUps []*TopUp
+	err := DB.Where("user_id = ? AND status IN ?", userId,
+		[]string{TopUpStatusSuccess, TopUpStatusRefunded, TopUpStatusPartialRefunded}).
+		Where("(complete_time > 0 AND complete_time >= ? AND complete_time < ?) OR (complete_time = 0 AND create_time >= ? AND create_time < ?)",
+			start, end, start, end).
+		Order("id").Find(&topUps).Error
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*StatementTopUp, 0, len(topUps))
+	for _, topUp := range topUps {
+		completeTime := topUp.CompleteTime
+		if completeTime == 0 {
+			completeTime = topUp.CreateTime
+		}
+		items = append(items, &StatementTopUp{
+			TradeNo:  topUp.TradeNo,
+			Provider: topUp.Provider,
+			Money:    topUp.Money,
+			Currency: topUp.Currency,
+			Quota:    int64(topUp.GetQuota()),
+			Time:     completeTime,
+		})
+	}
+	return items, nil
+}
+
+func GetStatementTopUpRefunds(userId int, start int64, end int64) ([]*TopUpRefund, error) {
+	var refunds []*TopUpRefund
+	err := DB.Where("user_id = ? AND create_time >= ? AND create_time < ?", userId, start, end).
+		Order("id").Find(&refunds).Error
+	return refunds, err
+}
+
+// GetStatementRedemptions 获取 [start, end) 内使用的兑换码（含已删除的兑换码）与礼品码
+func GetStatementRedemptions(userId int, start int64, end int64) ([]*StatementRedemption, error) {
+	var redemptions []*Redemption
+	err := DB.Unscoped().Where("used_user_id = ? AND is_gift = ? AND redeemed_time >= ? AND redeemed_time < ?",
+		userId, false, start, end).Order("redeemed_time").Find(&redemptions).Error
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*StatementRedemption, 0, len(redemptions))
+	for _, redemption := range redemptions {
+		items = append(items, &StatementRedemption{
+			RedemptionId: redemption.Id,
+			Name:         redemption.Name,
+			Quota:        int64(redemption.Quota),
+			Time:         redemption.RedeemedTime,
+		})
+	}
+	var gifts []*StatementRedemption
+	err = DB.Table("redemption_logs").
+		Select("redemption_logs.redemption_id, redemptions.name, redemptions.quota, redemption_logs.used_time AS time").
+		Joins("JOIN redemptions ON redemptions.id = redemption_logs.redemption_id AND redemptions.is_gift = ?", true).
+		Where("redemption_logs.user_id = ? AND redemption_logs.used_time >= ? AND redemption_logs.used_time < ?", userId, start, end).
+		Order("redemption_logs.used_time").Scan(&gifts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, gift := range gifts {
+		gift.IsGift = true
+		items = append(items, gift)
+	}
+	return items, nil
+}
+
+// GetStatementModelUsages 按模型汇总 [start, end) 内的消费
+func GetStatementModelUsages(userId int, start int64, end int64) ([]*StatementModelUsage, error) {
+	var usages []*StatementModelUsage
+	err := LOG_DB.Table("logs").
+		Select("model_name, count(*) AS request_count, sum(quota) AS quota, sum(prompt_tokens) AS prompt_tokens, sum(completion_tokens) AS completion_tokens").
+		Where("user_id = ? AND type = ? AND org_id = ? AND created_at >= ? AND created_at < ?", userId, LogTypeConsume, 0, start, end).
+		Group("model_name").Order("quota desc").Scan(&usages).Error
+	return usages, err
+}
+
+// GetStatementLogEntries 获取 [start, end) 内指定类型且带额度的日志
+func GetStatementLogEntries(userId int, logType int, start int64, end int64) ([]*StatementLogEntry, error) {
+	var entries []*StatementLogEntry
+	err := LOG_DB.Table("logs").Select("content, quota, created_at AS time").
+		Where("user_id = ? AND type = ? AND quota > 0 AND created_at >= ? AND created_at < ?", userId, logType, start, end).
+		Order("id").Scan(&entries).Error
+	return entries, err
+}
+
+// GetStatementRebates 从额度账本获取 [start, end) 内用户收到的邀请返佣
+func GetStatementRebates(userId int, start int64, end int64) ([]*StatementLogEntry, error) {
+	var ledgerEntries []*QuotaLedgerEntry
+	err := DB.Where("user_id = ? AND account = ? AND reason = ? AND amount > 0 AND created_at >= ? AND created_at < ?",
+		userId, QuotaAccountQuota, QuotaReasonRebate, start, end).Order("id").Find(&ledgerEntries).Error
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*StatementLogEntry, 0, len(ledgerEntries))
+	for _, ledgerEntry := range ledgerEntries {
+		entries = append(entries, &StatementLogEntry{
+			Content: fmt.Sprintf("获得%s返佣", ledgerEntry.Remark),
+			Quota:   ledgerEntry.Amount,
+			Time:    ledgerEntry.CreatedAt,
+		})
+	}
+	return entries, nil
+}
+
+// GetStatementUsers 按 id 游标分批获取需要生成对账单的用户
+func GetStatementUsers(afterId int, limit int) ([]*User, error) {
+	var users []*User
+	err := DB.Select("id", "username", "email", "quota", "subscription_quota").
+		Where("id > ?", afterId).Order("id").Limit(limit).Find(&users).Error
+	return users, err
+}
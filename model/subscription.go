@@ -0,0 +1,433 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"veloera/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SubscriptionPlanStatusEnabled  = 1
+	SubscriptionPlanStatusDisabled = 2
+)
+
+const (
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusExpired   = "expired"
+	SubscriptionStatusCancelled = "cancelled"
+)
+
+// SubscriptionPlan 订阅套餐，每个周期开始时向订阅用户发放订阅额度
+type SubscriptionPlan struct {
+	Id          int     `json:"id"`
+	Name        string  `json:"name" gorm:"type:varchar(64)"`
+	Description string  `json:"description" gorm:"type:text"`
+	Price       float64 `json:"price"`                              // 每个周期的价格，与充值价格同一计价单位，下单时按渠道汇率换算
+	Quota       int     `json:"quota"`                              // 每个周期发放的订阅额度
+	PeriodDays  int     `json:"period_days" gorm:"default:30"`      // 周期天数
+	MaxPeriods  int     `json:"max_periods" gorm:"default:12"`      // 单次最多购买的周期数
+	Rollover    bool    `json:"rollover"`                           // 周期结束时未用完的订阅额度是否结转到下一周期
+	RolloverCap int     `json:"rollover_cap"`                       // 最多结转的额度，0 表示不限
+	Groups      string  `json:"groups" gorm:"type:text"`            // 套餐可使用的分组，逗号分隔
+	Models      string  `json:"models" gorm:"type:text"`            // 可使用订阅额度的模型，逗号分隔，为空表示不限；其他模型只从余额扣费
+	TpmLimit    int     `json:"tpm_limit" gorm:"default:0"`         // 用户未单独设置时生效
+	Concurrency int     `json:"concurrency_limit" gorm:"default:0"` // 用户未单独设置时生效
+	Status      int     `json:"status" gorm:"default:1"`
+	CreatedTime int64   `json:"created_time" gorm:"type:bigint"`
+}
+
+// UserSubscription 用户订阅，同一用户同时只有一个生效的订阅，续费时延长到期时间
+type UserSubscription struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	PlanId        int    `json:"plan_id" gorm:"index"`
+	Status        string `json:"status" gorm:"type:varchar(16);index"`
+	StartTime     int64  `json:"start_time" gorm:"type:bigint"`
+	PeriodStart   int64  `json:"period_start" gorm:"type:bigint"`
+	NextGrantTime int64  `json:"next_grant_time" gorm:"type:bigint;index"` // 下一周期开始时间，到达时结算本周期并发放额度
+	ExpireTime    int64  `json:"expire_time" gorm:"type:bigint"`
+	TradeNo       string `json:"trade_no" gorm:"type:varchar(255)"` // 最近一次付款的订单号
+	Quota         int    `json:"quota" gorm:"default:0"`            // 本订阅发放且尚未作废的额度，订阅额度不区分来源，按优先消耗订阅发放的额度计算
+	QuotaTracked  bool   `json:"-" gorm:"default:false"`            // 旧版本创建的订阅未记录 Quota，作废时按订阅额度账户的全部余额处理
+	UpdatedTime   int64  `json:"updated_time" gorm:"type:bigint"`
+	PlanName      string `json:"plan_name" gorm:"->;-:migration"`
+}
+
+var ErrSubscriptionPlanMismatch = errors.New("已订阅其他套餐，请在当前套餐到期后再更换")
+
+func (plan *SubscriptionPlan) PeriodSeconds() int64 {
+	return int64(plan.PeriodDays) * 24 * 3600
+}
+
+func (plan *SubscriptionPlan) GetGroups() []string {
+	return splitPlanList(plan.Groups)
+}
+
+// AllowModel 该模型是否可以使用套餐的订阅额度
+func (plan *SubscriptionPlan) AllowModel(modelName string) bool {
+	models := splitPlanList(plan.Models)
+	if len(models) == 0 {
+		return true
+	}
+	for _, m := range models {
+		if m == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPlanList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	if err := DB.Create(plan).Error; err != nil {
+		return err
+	}
+	refreshSubscriptionPlanCache()
+	return nil
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	err := DB.Model(plan).Select("name", "description", "price", "quota", "period_days", "max_periods",
+		"rollover", "rollover_cap", "groups", "models", "tpm_limit", "concurrency", "status").Updates(plan).Error
+	if err != nil {
+		return err
+	}
+	refreshSubscriptionPlanCache()
+	return nil
+}
+
+// Delete 删除套餐，仍有生效订阅时拒绝删除，可改为禁用
+func (plan *SubscriptionPlan) Delete() error {
+	var count int64
+	err := DB.Model(&UserSubscription{}).Where("plan_id = ? AND status = ?", plan.Id, SubscriptionStatusActive).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有生效的订阅，请先禁用")
+	}
+	if err = DB.Delete(plan).Error; err != nil {
+		return err
+	}
+	refreshSubscriptionPlanCache()
+	return nil
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	var plan SubscriptionPlan
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func GetSubscriptionPlans(onlyEnabled bool) ([]*SubscriptionPlan, error) {
+	var plans []*SubscriptionPlan
+	tx := DB.Order("price asc, id asc")
+	if onlyEnabled {
+		tx = tx.Where("status = ?", SubscriptionPlanStatusEnabled)
+	}
+	err := tx.Find(&plans).Error
+	return plans, err
+}
+
+var subscriptionPlans map[int]*SubscriptionPlan
+var subscriptionPlanLock sync.RWMutex
+
+func InitSubscriptionPlanCache() {
+	var plans []*SubscriptionPlan
+	if err := DB.Find(&plans).Error; err != nil {
+		common.SysError("failed to load subscription plans: " + err.Error())
+		return
+	}
+	newPlans := make(map[int]*SubscriptionPlan, len(plans))
+	for _, plan := range plans {
+		newPlans[plan.Id] = plan
+	}
+	subscriptionPlanLock.Lock()
+	subscriptionPlans = newPlans
+	subscriptionPlanLock.Unlock()
+}
+
+func SyncSubscriptionPlanCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitSubscriptionPlanCache()
+	}
+}
+
+func refreshSubscriptionPlanCache() {
+	if common.MemoryCacheEnabled {
+		InitSubscriptionPlanCache()
+	}
+}
+
+// CacheGetSubscriptionPlan 获取套餐定义，未启用内存缓存时直接查询数据库
+func CacheGetSubscriptionPlan(id int) (*SubscriptionPlan, bool) {
+	if id == 0 {
+		return nil, false
+	}
+	if !common.MemoryCacheEnabled {
+		plan, err := GetSubscriptionPlanById(id)
+		return plan, err == nil
+	}
+	subscriptionPlanLock.RLock()
+	defer subscriptionPlanLock.RUnlock()
+	plan, ok := subscriptionPlans[id]
+	return plan, ok
+}
+
+// GetPlanGroups 返回用户当前套餐解锁的分组
+func GetPlanGroups(planId int) []string {
+	plan, ok := CacheGetSubscriptionPlan(planId)
+	if !ok {
+		return nil
+	}
+	return plan.GetGroups()
+}
+
+// GetActiveSubscription 获取用户当前生效的订阅
+func GetActiveSubscription(userId int) (*UserSubscription, error) {
+	var sub UserSubscription
+	err := DB.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).First(&sub).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func GetUserSubscriptions(userId int, startIdx int, num int) (subs []*UserSubscription, total int64, err error) {
+	tx := DB.Model(&UserSubscription{})
+	if userId != 0 {
+		tx = tx.Where("user_subscriptions.user_id = ?", userId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Select("user_subscriptions.*, subscription_plans.name AS plan_name").
+		Joins("LEFT JOIN subscription_plans ON subscription_plans.id = user_subscriptions.plan_id").
+		Order("user_subscriptions.id desc").Limit(num).Offset(startIdx).Find(&subs).Error
+	return subs, total, err
+}
+
+// ActivateSubscription 订单支付成功后开通或续费订阅。
+// 新开通时立即发放第一个周期的额度；续费同一套餐时延长到期时间，后续周期由定时任务发放。
+// 若支付期间用户已开通其他套餐，先结束旧订阅再开通新套餐，保证已付款的订单一定生效
+func ActivateSubscription(userId int, planId int, periods int, tradeNo string) error {
+	plan, err := GetSubscriptionPlanById(planId)
+	if err != nil {
+		return err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return activateSubscription(tx, userId, plan, periods, tradeNo)
+	})
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(userId)
+}
+
+// activateSubscription 在调用方的事务中开通或续费订阅
+func activateSubscription(tx *gorm.DB, userId int, plan *SubscriptionPlan, periods int, tradeNo string) error {
+	if periods <= 0 {
+		periods = 1
+	}
+	now := common.GetTimestamp()
+	var sub UserSubscription
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).First(&sub).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && sub.PlanId == plan.Id {
+		return tx.Model(&sub).Updates(map[string]interface{}{
+			"expire_time":  sub.ExpireTime + int64(periods)*plan.PeriodSeconds(),
+			"trade_no":     tradeNo,
+			"updated_time": now,
+		}).Error
+	}
+	if err == nil {
+		if err = endSubscription(tx, &sub, SubscriptionStatusCancelled, now); err != nil {
+			return err
+		}
+	}
+	sub = UserSubscription{
+		UserId:        userId,
+		PlanId:        plan.Id,
+		Status:        SubscriptionStatusActive,
+		StartTime:     now,
+		PeriodStart:   now,
+		NextGrantTime: now + plan.PeriodSeconds(),
+		ExpireTime:    now + int64(periods)*plan.PeriodSeconds(),
+		TradeNo:       tradeNo,
+		Quota:         plan.Quota,
+		QuotaTracked:  true,
+		UpdatedTime:   now,
+	}
+	if err = tx.Create(&sub).Error; err != nil {
+		return err
+	}
+	if err = tx.Model(&User{}).Where("id = ?", userId).Update("plan_id", plan.Id).Error; err != nil {
+		return err
+	}
+	return changeUserQuota(tx, userId, QuotaAccountSubscription, plan.Quota, QuotaChange{
+		Reason: QuotaReasonSubscriptionGrant,
+		RefId:  tradeNo,
+		Remark: plan.Name,
+	})
+}
+
+// endSubscription 结束订阅并作废本订阅发放的剩余额度
+func endSubscription(tx *gorm.DB, sub *UserSubscription, status string, now int64) error {
+	if _, err := expireSubscriptionQuota(tx, sub, 0); err != nil {
+		return err
+	}
+	err := tx.Model(sub).Updates(map[string]interface{}{
+		"status":        status,
+		"quota":         0,
+		"quota_tracked": true,
+		"updated_time":  now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&User{}).Where("id = ?", sub.UserId).Update("plan_id", 0).Error
+}
+
+// expireSubscriptionQuota 作废本订阅剩余额度中超出 keep 的部分，返回保留的额度。
+// 本订阅的剩余额度为其发放的额度与订阅额度账户余额中的较小值，其他来源的订阅额度不受影响
+func expireSubscriptionQuota(tx *gorm.DB, sub *UserSubscription, keep int) (int, error) {
+	var remain int
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&User{}).Where("id = ?", sub.UserId).
+		Select("subscription_quota").Take(&remain).Error
+	if err != nil {
+		return 0, err
+	}
+	outstanding := remain
+	if sub.QuotaTracked {
+		outstanding = min(sub.Quota, remain)
+	}
+	outstanding = max(outstanding, 0)
+	kept := min(outstanding, keep)
+	if outstanding <= kept {
+		return kept, nil
+	}
+	return kept, changeUserQuota(tx, sub.UserId, QuotaAccountSubscription, -(outstanding - kept), QuotaChange{
+		Reason: QuotaReasonSubscriptionExpire,
+		RefId:  fmt.Sprintf("subscription:%d", sub.Id),
+	})
+}
+
+// CancelSubscription 取消订阅（如订单全额退款），剩余订阅额度作废，tradeNo 非空时只取消由该订单付款的订阅
+func CancelSubscription(userId int, tradeNo string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var sub UserSubscription
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive)
+		if tradeNo != "" {
+			query = query.Where("trade_no = ?", tradeNo)
+		}
+		if err := query.First(&sub).Error; err != nil {
+			return err
+		}
+		return endSubscription(tx, &sub, SubscriptionStatusCancelled, common.GetTimestamp())
+	})
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(userId)
+}
+
+// GetDueSubscriptionIds 按 id 顺序返回 afterId 之后已到达下一周期开始时间的生效订阅
+func GetDueSubscriptionIds(now int64, afterId int, limit int) ([]int, error) {
+	var ids []int
+	err := DB.Model(&UserSubscription{}).Where("status = ? AND next_grant_time <= ? AND id > ?", SubscriptionStatusActive, now, afterId).
+		Order("id asc").Limit(limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// AdvanceSubscription 结算订阅的当前周期：到期则结束订阅，否则按结转规则处理剩余额度并发放新周期额度。
+// 一次只推进一个周期，调用方循环调用直到返回 false，以便补发停机期间错过的周期
+func AdvanceSubscription(id int, now int64) (advanced bool, err error) {
+	var userId int
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var sub UserSubscription
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, "id = ?", id).Error
+		if err != nil {
+			return err
+		}
+		if sub.Status != SubscriptionStatusActive || sub.NextGrantTime > now {
+			return nil
+		}
+		userId = sub.UserId
+		advanced = true
+		if sub.NextGrantTime >= sub.ExpireTime {
+			return endSubscription(tx, &sub, SubscriptionStatusExpired, now)
+		}
+		var plan SubscriptionPlan
+		if err = tx.First(&plan, "id = ?", sub.PlanId).Error; err != nil {
+			return err
+		}
+		keep := 0
+		if plan.Rollover {
+			keep = math.MaxInt
+			if plan.RolloverCap > 0 {
+				keep = plan.RolloverCap
+			}
+		}
+		kept, err := expireSubscriptionQuota(tx, &sub, keep)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&sub).Updates(map[string]interface{}{
+			"period_start":    sub.NextGrantTime,
+			"next_grant_time": sub.NextGrantTime + plan.PeriodSeconds(),
+			"quota":           kept + plan.Quota,
+			"quota_tracked":   true,
+			"updated_time":    now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return changeUserQuota(tx, sub.UserId, QuotaAccountSubscription, plan.Quota, QuotaChange{
+			Reason: QuotaReasonSubscriptionGrant,
+			RefId:  fmt.Sprintf("subscription:%d", sub.Id),
+			Remark: plan.Name,
+		})
+	})
+	if err != nil || !advanced {
+		return advanced, err
+	}
+	return advanced, invalidateUserCache(userId)
+}
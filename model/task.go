@@ -37,22 +37,28 @@ const (
 )
 
 type Task struct {
-	ID         int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
-	CreatedAt  int64                 `json:"created_at" gorm:"index"`
-	UpdatedAt  int64                 `json:"updated_at"`
-	TaskID     string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
-	Platform   constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
-	UserId     int                   `json:"user_id" gorm:"index"`
-	ChannelId  int                   `json:"channel_id" gorm:"index"`
-	Quota      int                   `json:"quota"`
-	Action     string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
-	Status     TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
-	FailReason string                `json:"fail_reason"`
-	SubmitTime int64                 `json:"submit_time" gorm:"index"`
-	StartTime  int64                 `json:"start_time" gorm:"index"`
-	FinishTime int64                 `json:"finish_time" gorm:"index"`
-	Progress   string                `json:"progress" gorm:"type:varchar(20);index"`
-	Properties Properties            `json:"properties" gorm:"type:json"`
+	ID          int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
+	CreatedAt   int64                 `json:"created_at" gorm:"index"`
+	UpdatedAt   int64                 `json:"updated_at"`
+	TaskID      string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
+	Platform    constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
+	UserId      int                   `json:"user_id" gorm:"index"`
+	ChannelId   int                   `json:"channel_id" gorm:"index"`
+	Quota       int                   `json:"quota"`
+	Action      string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
+	Status      TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
+	FailReason  string                `json:"fail_reason"`
+	SubmitTime  int64                 `json:"submit_time" gorm:"index"`
+	StartTime   int64                 `json:"start_time" gorm:"index"`
+	FinishTime  int64                 `json:"finish_time" gorm:"index"`
+	Progress    string                `json:"progress" gorm:"type:varchar(20);index"`
+	Properties  Properties            `json:"properties" gorm:"type:json"`
+	ResultURL   string                `json:"result_url" gorm:"type:text"`   // 上游返回的结果地址，完成后缓存
+	CallbackUrl string                `json:"callback_url" gorm:"type:text"` // 任务完成后的回调地址
+
+	// 按任务轮询的平台使用，记录退避状态
+	PollCount    int   `json:"-"`
+	NextPollTime int64 `json:"-"`
 
 	Data json.RawMessage `json:"data" gorm:"type:json"`
 }
@@ -68,7 +74,10 @@ func (t *Task) GetData(v any) error {
 }
 
 type Properties struct {
-	Input string `json:"input"`
+	Input   string `json:"input"`
+	Model   string `json:"model,omitempty"`
+	Seconds int    `json:"seconds,omitempty"`
+	Size    string `json:"size,omitempty"`
 }
 
 func (m *Properties) Scan(val interface{}) error {
@@ -197,6 +206,36 @@ func GetAllUnFinishSyncTasks(limit int) []*Task {
 	return tasks
 }
 
+// GetUnfinishedTasksByShards 获取 channel_id % shardCount 属于 shards 的未完成任务
+func GetUnfinishedTasksByShards(shardCount int, shards []int, limit int) []*Task {
+	var tasks []*Task
+	err := DB.Where("progress != ? AND channel_id % ? IN ?", "100%", shardCount, shards).Limit(limit).Order("id").Find(&tasks).Error
+	if err != nil {
+		return nil
+	}
+	return tasks
+}
+
+// GetStuckTasks 获取创建时间早于 before 仍未完成的任务
+func GetStuckTasks(before int64, limit int) []*Task {
+	var tasks []*Task
+	err := DB.Where("progress != ? AND created_at < ?", "100%", before).Limit(limit).Order("id").Find(&tasks).Error
+	if err != nil {
+		return nil
+	}
+	return tasks
+}
+
+func GetTaskByID(id int64) (*Task, bool, error) {
+	var task *Task
+	err := DB.Where("id = ?", id).First(&task).Error
+	exist, err := RecordExist(err)
+	if err != nil {
+		return nil, false, err
+	}
+	return task, exist, err
+}
+
 func GetByOnlyTaskId(taskId string) (*Task, bool, error) {
 	if taskId == "" {
 		return nil, false, nil
@@ -256,6 +295,12 @@ func (Task *Task) Update() error {
 	return err
 }
 
+// UpdateUnfinished 仅在数据库中的任务仍未完成时保存，避免轮询与取消并发时重复结算
+func (Task *Task) UpdateUnfinished() (bool, error) {
+	result := DB.Model(Task).Where("progress != ?", "100%").Select("*").Updates(Task)
+	return result.RowsAffected > 0, result.Error
+}
+
 func TaskBulkUpdate(TaskIds []string, params map[string]any) error {
 	if len(TaskIds) == 0 {
 		return nil
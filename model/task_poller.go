@@ -0,0 +1,124 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// PollerNode 参与异步任务轮询的节点，按心跳判断是否存活
+type PollerNode struct {
+	NodeId        string `json:"node_id" gorm:"primaryKey;type:varchar(64)"`
+	Hostname      string `json:"hostname" gorm:"type:varchar(191)"`
+	StartedTime   int64  `json:"started_time" gorm:"type:bigint"`
+	HeartbeatTime int64  `json:"heartbeat_time" gorm:"type:bigint;index"`
+}
+
+// PollerLease 轮询分片租约，同一分片同一时刻只有一个持有者
+type PollerLease struct {
+	Name      string `json:"name" gorm:"primaryKey;type:varchar(64)"`
+	Owner     string `json:"owner" gorm:"type:varchar(64)"`
+	ExpiresAt int64  `json:"expires_at" gorm:"type:bigint;index"`
+}
+
+// ChannelPollState 渠道轮询状态，重启后据此恢复失败退避
+type ChannelPollState struct {
+	Id              int    `json:"id"`
+	Poller          string `json:"poller" gorm:"type:varchar(32);uniqueIndex:idx_poll_state_channel,priority:1"`
+	ChannelId       int    `json:"channel_id" gorm:"uniqueIndex:idx_poll_state_channel,priority:2"`
+	NodeId          string `json:"node_id" gorm:"type:varchar(64)"`
+	LastPollTime    int64  `json:"last_poll_time" gorm:"type:bigint"`
+	LastSuccessTime int64  `json:"last_success_time" gorm:"type:bigint"`
+	FailureCount    int    `json:"failure_count"`
+	LastError       string `json:"last_error"`
+}
+
+func HeartbeatPollerNode(node *PollerNode) error {
+	node.HeartbeatTime = time.Now().Unix()
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hostname", "heartbeat_time"}),
+	}).Create(node).Error
+}
+
+// GetAlivePollerNodes 获取 since 之后有心跳的节点，按节点 ID 排序保证各节点视图一致
+func GetAlivePollerNodes(since int64) ([]*PollerNode, error) {
+	var nodes []*PollerNode
+	err := DB.Where("heartbeat_time >= ?", since).Order("node_id").Find(&nodes).Error
+	return nodes, err
+}
+
+func GetAllPollerNodes() ([]*PollerNode, error) {
+	var nodes []*PollerNode
+	err := DB.Order("node_id").Find(&nodes).Error
+	return nodes, err
+}
+
+// AcquirePollerLease 获取或续期租约，租约由其他节点持有且未过期时返回 false
+func AcquirePollerLease(name string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().Unix()
+	expiresAt := now + int64(ttl/time.Second)
+	lease := &PollerLease{Name: name, Owner: owner, ExpiresAt: expiresAt}
+	if err := DB.Create(lease).Error; err == nil {
+		return true, nil
+	}
+	result := DB.Model(&PollerLease{}).Where("name = ? AND (owner = ? OR expires_at < ?)", name, owner, now).
+		Updates(map[string]interface{}{"owner": owner, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+	// MySQL 在值未变化时影响行数为 0，回查确认是否已持有
+	var current PollerLease
+	if err := DB.Where("name = ?", name).First(&current).Error; err != nil {
+		return false, err
+	}
+	return current.Owner == owner && current.ExpiresAt >= now, nil
+}
+
+func ReleasePollerLease(name string, owner string) error {
+	return DB.Where("name = ? AND owner = ?", name, owner).Delete(&PollerLease{}).Error
+}
+
+func GetPollerLeases() ([]*PollerLease, error) {
+	var leases []*PollerLease
+	err := DB.Order("name").Find(&leases).Error
+	return leases, err
+}
+
+func GetChannelPollStates(poller string) ([]*ChannelPollState, error) {
+	var states []*ChannelPollState
+	tx := DB.Model(&ChannelPollState{})
+	if poller != "" {
+		tx = tx.Where("poller = ?", poller)
+	}
+	err := tx.Order("channel_id").Find(&states).Error
+	return states, err
+}
+
+func SaveChannelPollState(state *ChannelPollState) error {
+	return DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "poller"}, {Name: "channel_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"node_id", "last_poll_time", "last_success_time", "failure_count", "last_error",
+		}),
+	}).Create(state).Error
+}
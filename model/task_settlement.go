@@ -0,0 +1,201 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"veloera/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TaskSettlementSourceTask       = "task"
+	TaskSettlementSourceMidjourney = "midjourney"
+)
+
+const (
+	TaskSettlementStatusReserved  = "reserved"
+	TaskSettlementStatusCommitted = "committed"
+	TaskSettlementStatusRefunded  = "refunded"
+)
+
+// TaskSettlement 异步任务结算记录，提交时预留额度，任务结束后提交或退还，
+// (source, record_id) 唯一，状态只能从 reserved 流转一次，保证重启后重复结算不会重复退款
+type TaskSettlement struct {
+	Id                int    `json:"id"`
+	Source            string `json:"source" gorm:"type:varchar(20);uniqueIndex:idx_settlement_source_record,priority:1"`
+	RecordId          int64  `json:"record_id" gorm:"uniqueIndex:idx_settlement_source_record,priority:2"`
+	TaskId            string `json:"task_id" gorm:"type:varchar(191);index"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	TokenId           int    `json:"token_id"`
+	OrgId             int    `json:"org_id" gorm:"default:0"` // 通过组织令牌提交时从组织额度扣费，退款退回组织
+	OrgMemberId       int    `json:"org_member_id" gorm:"default:0"`
+	ChannelId         int    `json:"channel_id"`
+	ModelName         string `json:"model_name" gorm:"type:varchar(191)"`
+	Quota             int    `json:"quota"`
+	SubscriptionQuota int    `json:"subscription_quota" gorm:"default:0"` // Quota 中从订阅额度扣除的部分，退款时退回订阅额度
+	Status            string `json:"status" gorm:"type:varchar(20);index"`
+	Reason            string `json:"reason"`
+	CreatedTime       int64  `json:"created_time" gorm:"type:bigint;index"`
+	SettledTime       int64  `json:"settled_time" gorm:"type:bigint"`
+}
+
+// ReserveTaskSettlement 写入预留记录，已存在时返回已有记录
+func ReserveTaskSettlement(settlement *TaskSettlement) (*TaskSettlement, error) {
+	if settlement.Source == "" || settlement.RecordId == 0 {
+		return nil, errors.New("结算来源或记录 ID 为空")
+	}
+	settlement.Status = TaskSettlementStatusReserved
+	settlement.CreatedTime = common.GetTimestamp()
+	err := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(settlement).Error
+	if err != nil {
+		return nil, err
+	}
+	return GetTaskSettlement(settlement.Source, settlement.RecordId)
+}
+
+func GetTaskSettlement(source string, recordId int64) (*TaskSettlement, error) {
+	var settlement TaskSettlement
+	err := DB.Where("source = ? AND record_id = ?", source, recordId).First(&settlement).Error
+	if err != nil {
+		return nil, err
+	}
+	return &settlement, nil
+}
+
+// CommitTaskSettlement 将预留额度确认为已消费，返回是否由本次调用完成状态流转
+func CommitTaskSettlement(id int) (bool, error) {
+	result := DB.Model(&TaskSettlement{}).
+		Where("id = ? AND status = ?", id, TaskSettlementStatusReserved).
+		Updates(map[string]interface{}{
+			"status":       TaskSettlementStatusCommitted,
+			"settled_time": common.GetTimestamp(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// RefundTaskSettlement 在同一事务内完成状态流转与用户、令牌额度退还，返回是否由本次调用完成退款
+func RefundTaskSettlement(settlement *TaskSettlement, reason string) (bool, error) {
+	refunded := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TaskSettlement{}).
+			Where("id = ? AND status = ?", settlement.Id, TaskSettlementStatusReserved).
+			Updates(map[string]interface{}{
+				"status":       TaskSettlementStatusRefunded,
+				"reason":       reason,
+				"settled_time": common.GetTimestamp(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		refunded = true
+		if settlement.Quota <= 0 {
+			return nil
+		}
+		change := QuotaChange{
+			Reason: QuotaReasonTaskRefund,
+			RefId:  fmt.Sprintf("%s:%d", settlement.Source, settlement.RecordId),
+			Remark: reason,
+		}
+		var err error
+		if settlement.OrgId != 0 {
+			err = updateOrganizationUsage(tx, settlement.OrgId, settlement.OrgMemberId, settlement.UserId, settlement.Quota, change)
+		} else {
+			// 按扣费时的账户拆分退回
+			err = changeUserQuota(tx, settlement.UserId, QuotaAccountSubscription, settlement.subscriptionPart(), change)
+			if err == nil {
+				err = changeUserQuota(tx, settlement.UserId, QuotaAccountQuota, settlement.Quota-settlement.subscriptionPart(), change)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if settlement.TokenId > 0 {
+			err = tx.Model(&Token{}).Where("id = ?", settlement.TokenId).Updates(map[string]interface{}{
+				"remain_quota": gorm.Expr("remain_quota + ?", settlement.Quota),
+				"used_quota":   gorm.Expr("used_quota - ?", settlement.Quota),
+			}).Error
+		}
+		return err
+	})
+	if err != nil || !refunded || settlement.Quota <= 0 {
+		return refunded, err
+	}
+	settlement.Status = TaskSettlementStatusRefunded
+	settlement.Reason = reason
+	gopool.Go(func() {
+		if settlement.OrgId == 0 {
+			if cacheErr := cacheIncrUserSubscriptionQuota(settlement.UserId, int64(settlement.subscriptionPart())); cacheErr != nil {
+				common.SysError("failed to increase user subscription quota: " + cacheErr.Error())
+			}
+			if cacheErr := cacheIncrUserQuota(settlement.UserId, int64(settlement.Quota-settlement.subscriptionPart())); cacheErr != nil {
+				common.SysError("failed to increase user quota: " + cacheErr.Error())
+			}
+		} else {
+			cacheIncrOrganizationQuota(settlement.OrgId, int64(settlement.Quota))
+		}
+		if settlement.TokenId > 0 && common.RedisEnabled {
+			token, tokenErr := GetTokenById(settlement.TokenId)
+			if tokenErr != nil {
+				return
+			}
+			if cacheErr := cacheIncrTokenQuota(token.Key, int64(settlement.Quota)); cacheErr != nil {
+				common.SysError("failed to increase token quota: " + cacheErr.Error())
+			}
+		}
+	})
+	return true, nil
+}
+
+// subscriptionPart 从订阅额度扣除的部分，不超过预留额度
+func (settlement *TaskSettlement) subscriptionPart() int {
+	return max(0, min(settlement.SubscriptionQuota, settlement.Quota))
+}
+
+func GetTaskSettlements(userId int, source string, status string, startIdx int, num int) (settlements []*TaskSettlement, total int64, err error) {
+	tx := DB.Model(&TaskSettlement{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if source != "" {
+		tx = tx.Where("source = ?", source)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&settlements).Error
+	return settlements, total, err
+}
+
+// GetReservedTaskSettlements 获取仍处于预留状态且创建时间早于 before 的结算记录
+func GetReservedTaskSettlements(before int64, limit int) ([]*TaskSettlement, error) {
+	var settlements []*TaskSettlement
+	err := DB.Where("status = ? AND created_time < ?", TaskSettlementStatusReserved, before).
+		Order("id").Limit(limit).Find(&settlements).Error
+	return settlements, err
+}
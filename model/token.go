@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strings"
 	"veloera/common"
+	"veloera/dto"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
@@ -29,6 +30,7 @@ import (
 type Token struct {
 	Id                 int            `json:"id"`
 	UserId             int            `json:"user_id" gorm:"index"`
+	OrgId              int            `json:"org_id" gorm:"index;default:0"` // 组织令牌从组织额度扣费
 	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
 	Status             int            `json:"status" gorm:"default:1"`
 	Name               string         `json:"name" gorm:"index" `
@@ -46,9 +48,32 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	DailyQuotaLimit    int            `json:"daily_quota_limit" gorm:"default:0"`
+	WeeklyQuotaLimit   int            `json:"weekly_quota_limit" gorm:"default:0"`
+	MonthlyQuotaLimit  int            `json:"monthly_quota_limit" gorm:"default:0"`
+	SoftLimitPercent   int            `json:"soft_limit_percent" gorm:"default:0"`
+	TpmLimit           int            `json:"tpm_limit" gorm:"default:0"`
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`
+	WebhookUrl         string         `json:"webhook_url" gorm:"type:varchar(512);default:''"` // 异步任务完成回调的默认地址
+	PiiMask            int            `json:"pii_mask" gorm:"default:0"`                       // 敏感信息脱敏，见 TokenPiiMask* 常量
+	AuditCapture       int            `json:"audit_capture" gorm:"default:0"`                  // 请求与响应审计归档，见 TokenAuditCapture* 常量
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
+// 令牌级敏感信息脱敏开关
+const (
+	TokenPiiMaskDefault  = 0 // 跟随分组设置
+	TokenPiiMaskEnabled  = 1
+	TokenPiiMaskDisabled = 2
+)
+
+// 令牌级审计归档开关
+const (
+	TokenAuditCaptureDefault  = 0 // 跟随分组设置
+	TokenAuditCaptureEnabled  = 1
+	TokenAuditCaptureDisabled = 2
+)
+
 func (token *Token) Clean() {
 	token.Key = ""
 }
@@ -205,7 +230,9 @@ func (token *Token) Update() (err error) {
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
 		"rate_limit_enabled", "rate_limit_period", "rate_limit_count", "rate_limit_success",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group",
+		"daily_quota_limit", "weekly_quota_limit", "monthly_quota_limit", "soft_limit_percent",
+		"tpm_limit", "concurrency_limit", "webhook_url", "pii_mask", "audit_capture").Updates(token).Error
 	return err
 }
 
@@ -239,6 +266,15 @@ func (token *Token) Delete() (err error) {
 	return err
 }
 
+func (token *Token) GetSpendingLimit() dto.SpendingLimit {
+	return dto.SpendingLimit{
+		Daily:            token.DailyQuotaLimit,
+		Weekly:           token.WeeklyQuotaLimit,
+		Monthly:          token.MonthlyQuotaLimit,
+		SoftLimitPercent: token.SoftLimitPercent,
+	}
+}
+
 func (token *Token) IsModelLimitsEnabled() bool {
 	return token.ModelLimitsEnabled
 }
@@ -274,8 +310,7 @@ func DeleteTokenById(id int, userId int) (err error) {
 	if id == 0 || userId == 0 {
 		return errors.New("id 或 userId 为空！")
 	}
-	token := Token{Id: id, UserId: userId}
-	err = DB.Where(token).First(&token).Error
+	token, err := GetManageableToken(id, userId)
 	if err != nil {
 		return err
 	}
@@ -16,16 +16,58 @@
 // along with this program. If not, see <https://www.gnu.org/licenses/>.
 package model
 
+import (
+	"errors"
+	"fmt"
+	"veloera/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TopUpStatusPending         = "pending"
+	TopUpStatusSuccess         = "success"
+	TopUpStatusRefunded        = "refunded"
+	TopUpStatusPartialRefunded = "partial_refunded"
+)
+
 type TopUp struct {
+	Id                int     `json:"id"`
+	UserId            int     `json:"user_id" gorm:"index"`
+	Amount            int64   `json:"amount"`
+	Money             float64 `json:"money"`
+	TradeNo           string  `json:"trade_no" gorm:"unique;type:varchar(255);index"`
+	CreateTime        int64   `json:"create_time"`
+	Status            string  `json:"status"`
+	Provider          string  `json:"provider" gorm:"type:varchar(32);default:'epay'"`
+	Currency          string  `json:"currency" gorm:"type:varchar(16);default:''"`
+	Quota             int     `json:"quota" gorm:"default:0"`
+	ProviderOrderId   string  `json:"provider_order_id" gorm:"type:varchar(255);index"`
+	ProviderPaymentId string  `json:"provider_payment_id" gorm:"type:varchar(255);index"`
+	CompleteTime      int64   `json:"complete_time" gorm:"type:bigint;default:0"`
+	RefundedMoney     float64 `json:"refunded_money" gorm:"default:0"`
+	RefundedQuota     int     `json:"refunded_quota" gorm:"default:0"`
+	PlanId            int     `json:"plan_id" gorm:"default:0;index"` // 订阅订单对应的套餐，0 表示普通充值
+	Periods           int     `json:"periods" gorm:"default:0"`       // 订阅订单购买的周期数
+	BonusQuota        int     `json:"bonus_quota" gorm:"default:0"`   // 兑换活动的充值加赠，退款时按比例扣回
+}
+
+// TopUpRefund 充值退款记录，RefundId 为支付渠道侧的退款编号，用于保证退款回调幂等
+type TopUpRefund struct {
 	Id         int     `json:"id"`
+	TopUpId    int     `json:"top_up_id" gorm:"index"`
 	UserId     int     `json:"user_id" gorm:"index"`
-	Amount     int64   `json:"amount"`
+	TradeNo    string  `json:"trade_no" gorm:"type:varchar(255);index"`
+	RefundId   string  `json:"refund_id" gorm:"type:varchar(255);uniqueIndex"`
 	Money      float64 `json:"money"`
-	TradeNo    string  `json:"trade_no" gorm:"unique;type:varchar(255);index"`
-	CreateTime int64   `json:"create_time"`
-	Status     string  `json:"status"`
+	Quota      int     `json:"quota"`
+	Reason     string  `json:"reason" gorm:"type:varchar(255)"`
+	CreateTime int64   `json:"create_time" gorm:"type:bigint"`
 }
 
+var ErrTopUpRefundDuplicated = errors.New("refund already applied")
+
 func (topUp *TopUp) Insert() error {
 	var err error
 	err = DB.Create(topUp).Error
@@ -38,6 +80,14 @@ func (topUp *TopUp) Update() error {
 	return err
 }
 
+// GetQuota 返回订单对应的额度，兼容未记录额度的历史订单
+func (topUp *TopUp) GetQuota() int {
+	if topUp.Quota > 0 {
+		return topUp.Quota
+	}
+	return int(float64(topUp.Amount) * common.QuotaPerUnit)
+}
+
 func GetTopUpById(id int) *TopUp {
 	var topUp *TopUp
 	var err error
@@ -57,3 +107,177 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	}
 	return topUp
 }
+
+func GetTopUpByProviderPaymentId(provider string, paymentId string) *TopUp {
+	var topUp *TopUp
+	err := DB.Where("provider = ? AND provider_payment_id = ?", provider, paymentId).First(&topUp).Error
+	if err != nil {
+		return nil
+	}
+	return topUp
+}
+
+// UpdateTopUpProviderOrderId 拉起支付后记录渠道侧的订单号
+func UpdateTopUpProviderOrderId(tradeNo string, providerOrderId string) error {
+	if providerOrderId == "" {
+		return nil
+	}
+	return DB.Model(&TopUp{}).Where("trade_no = ?", tradeNo).Update("provider_order_id", providerOrderId).Error
+}
+
+// FulfillTopUp 在同一事务中将待支付订单标记为成功并入账：订阅订单开通套餐，普通订单增加余额并发放充值加赠。
+// 返回 false 表示订单已被处理过
+func FulfillTopUp(topUp *TopUp, providerPaymentId string) (bool, error) {
+	var plan *SubscriptionPlan
+	if topUp.PlanId != 0 {
+		var err error
+		if plan, err = GetSubscriptionPlanById(topUp.PlanId); err != nil {
+			return false, err
+		}
+	}
+	quota := topUp.GetQuota()
+	fulfilled := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"status":        TopUpStatusSuccess,
+			"complete_time": common.GetTimestamp(),
+		}
+		if providerPaymentId != "" {
+			updates["provider_payment_id"] = providerPaymentId
+		}
+		result := tx.Model(&TopUp{}).Where("trade_no = ? AND status = ?", topUp.TradeNo, TopUpStatusPending).Updates(updates)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return result.Error
+		}
+		fulfilled = true
+		if plan != nil {
+			return activateSubscription(tx, topUp.UserId, plan, topUp.Periods, topUp.TradeNo)
+		}
+		err := changeUserQuota(tx, topUp.UserId, QuotaAccountQuota, quota, QuotaChange{
+			Reason: QuotaReasonTopUp,
+			RefId:  topUp.TradeNo,
+		})
+		if err != nil {
+			return err
+		}
+		// 加赠与入账在同一事务中完成，回调重试不会重复发放
+		topUp.BonusQuota, err = applyTopUpBonus(tx, topUp.UserId, topUp.TradeNo, quota)
+		return err
+	})
+	if err != nil || !fulfilled {
+		return false, err
+	}
+	if plan != nil {
+		return true, invalidateUserCache(topUp.UserId)
+	}
+	if err = cacheIncrUserQuota(topUp.UserId, int64(quota+topUp.BonusQuota)); err != nil {
+		common.SysError("failed to increase user quota: " + err.Error())
+	}
+	return true, nil
+}
+
+// TopUpRefundExists 判断渠道退款编号是否已经处理过
+func TopUpRefundExists(refundId string) (bool, error) {
+	var count int64
+	err := DB.Model(&TopUpRefund{}).Where("refund_id = ?", refundId).Count(&count).Error
+	return count > 0, err
+}
+
+// RecordTopUpRefund 在同一事务中写入退款记录、累加订单的退款金额与额度并扣回额度：
+// 订阅订单扣回订阅额度（不超过剩余额度），普通订单扣回余额与 bonusQuota 对应的充值加赠。
+// 返回实际扣回的额度，重复的 refundId 返回 ErrTopUpRefundDuplicated
+func RecordTopUpRefund(topUp *TopUp, refundId string, money float64, quota int, bonusQuota int, reason string) (int, error) {
+	deducted := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&TopUpRefund{}).Where("refund_id = ?", refundId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTopUpRefundDuplicated
+		}
+		refund := &TopUpRefund{
+			TopUpId:    topUp.Id,
+			UserId:     topUp.UserId,
+			TradeNo:    topUp.TradeNo,
+			RefundId:   refundId,
+			Money:      money,
+			Quota:      quota,
+			Reason:     reason,
+			CreateTime: common.GetTimestamp(),
+		}
+		if err := tx.Create(refund).Error; err != nil {
+			return err
+		}
+		status := TopUpStatusPartialRefunded
+		if topUp.RefundedMoney+money >= topUp.Money-0.0001 {
+			status = TopUpStatusRefunded
+		}
+		result := tx.Model(&TopUp{}).Where("id = ? AND refunded_quota = ?", topUp.Id, topUp.RefundedQuota).Updates(map[string]interface{}{
+			"refunded_money": gorm.Expr("refunded_money + ?", money),
+			"refunded_quota": gorm.Expr("refunded_quota + ?", quota),
+			"status":         status,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return fmt.Errorf("top up %s was modified concurrently", topUp.TradeNo)
+		}
+		if topUp.PlanId != 0 {
+			var remain int
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&User{}).Where("id = ?", topUp.UserId).
+				Select("subscription_quota").Take(&remain).Error
+			if err != nil {
+				return err
+			}
+			deducted = max(0, min(quota, remain))
+			err = changeUserQuota(tx, topUp.UserId, QuotaAccountSubscription, -deducted, QuotaChange{
+				Reason: QuotaReasonSubscriptionRefund,
+				RefId:  topUp.TradeNo,
+				Remark: reason,
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			deducted = quota
+			err := changeUserQuota(tx, topUp.UserId, QuotaAccountQuota, -quota, QuotaChange{
+				Reason: QuotaReasonTopUpRefund,
+				RefId:  topUp.TradeNo,
+				Remark: reason,
+			})
+			if err == nil {
+				err = changeUserQuota(tx, topUp.UserId, QuotaAccountQuota, -bonusQuota, QuotaChange{
+					Reason: QuotaReasonCampaignBonus,
+					RefId:  topUp.TradeNo,
+					Remark: reason,
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		topUp.RefundedMoney += money
+		topUp.RefundedQuota += quota
+		topUp.Status = status
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if topUp.PlanId != 0 {
+		if err = cacheDecrUserSubscriptionQuota(topUp.UserId, int64(deducted)); err != nil {
+			common.SysError("failed to decrease user subscription quota: " + err.Error())
+		}
+	} else if err = cacheDecrUserQuota(topUp.UserId, int64(deducted+bonusQuota)); err != nil {
+		common.SysError("failed to decrease user quota: " + err.Error())
+	}
+	return deducted, nil
+}
+
+func GetTopUpRefunds(tradeNo string) ([]*TopUpRefund, error) {
+	var refunds []*TopUpRefund
+	err := DB.Where("trade_no = ?", tradeNo).Order("id desc").Find(&refunds).Error
+	return refunds, err
+}
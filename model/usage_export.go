@@ -0,0 +1,218 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"veloera/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	UsageExportScopeSelf = "self"
+	UsageExportScopeAll  = "all"
+)
+
+const (
+	UsageExportStatusPending   = "pending"
+	UsageExportStatusRunning   = "running"
+	UsageExportStatusCompleted = "completed"
+	UsageExportStatusFailed    = "failed"
+)
+
+// UsageExport 用量导出任务，文件在后台生成后分块保存在数据库中，任意节点都可下载
+type UsageExport struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Scope        string `json:"scope" gorm:"type:varchar(16)"`
+	Format       string `json:"format" gorm:"type:varchar(16)"`
+	Rollup       string `json:"rollup" gorm:"type:varchar(16)"`
+	Filters      string `json:"filters" gorm:"type:text"`
+	Status       string `json:"status" gorm:"type:varchar(16);index"`
+	RowCount     int64  `json:"row_count"`
+	FileSize     int64  `json:"file_size"`
+	FileName     string `json:"file_name" gorm:"type:varchar(191)"`
+	Error        string `json:"error"`
+	CreatedTime  int64  `json:"created_time" gorm:"type:bigint;index"`
+	UpdatedTime  int64  `json:"updated_time" gorm:"type:bigint"`
+	FinishedTime int64  `json:"finished_time" gorm:"type:bigint"`
+}
+
+// UsageExportChunk 导出文件的一个分块，按 Seq 顺序拼接即为完整文件
+type UsageExportChunk struct {
+	Id       int    `json:"id"`
+	ExportId int    `json:"export_id" gorm:"uniqueIndex:idx_usage_export_chunk,priority:1"`
+	Seq      int    `json:"seq" gorm:"uniqueIndex:idx_usage_export_chunk,priority:2"`
+	Data     []byte `json:"-"`
+}
+
+// UsageExportFilter 导出筛选条件，与日志列表的查询参数一致
+type UsageExportFilter struct {
+	LogType        int    `json:"type"`
+	StartTimestamp int64  `json:"start_timestamp"`
+	EndTimestamp   int64  `json:"end_timestamp"`
+	UserId         int    `json:"user_id"`
+	Username       string `json:"username"`
+	TokenName      string `json:"token_name"`
+	ModelName      string `json:"model_name"`
+	Channel        int    `json:"channel"`
+	Group          string `json:"group"`
+}
+
+func (export *UsageExport) Insert() error {
+	export.Status = UsageExportStatusPending
+	export.CreatedTime = common.GetTimestamp()
+	export.UpdatedTime = export.CreatedTime
+	return DB.Create(export).Error
+}
+
+func (export *UsageExport) Update() error {
+	export.UpdatedTime = common.GetTimestamp()
+	return DB.Save(export).Error
+}
+
+func GetUsageExportById(id int) (*UsageExport, error) {
+	var export UsageExport
+	err := DB.First(&export, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func GetUsageExports(userId int, scope string, startIdx int, num int) (exports []*UsageExport, total int64, err error) {
+	tx := DB.Model(&UsageExport{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if scope != "" {
+		tx = tx.Where("scope = ?", scope)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&exports).Error
+	return exports, total, err
+}
+
+// CountActiveUsageExports 统计用户排队中与生成中的导出任务数
+func CountActiveUsageExports(userId int) (int64, error) {
+	var count int64
+	err := DB.Model(&UsageExport{}).Where("user_id = ? AND status IN ?", userId,
+		[]string{UsageExportStatusPending, UsageExportStatusRunning}).Count(&count).Error
+	return count, err
+}
+
+// GetStaleUsageExports 获取 before 之后再无进度更新的未完成导出任务
+func GetStaleUsageExports(before int64) ([]*UsageExport, error) {
+	var exports []*UsageExport
+	err := DB.Where("status IN ? AND updated_time < ?",
+		[]string{UsageExportStatusPending, UsageExportStatusRunning}, before).Find(&exports).Error
+	return exports, err
+}
+
+func DeleteUsageExport(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&UsageExportChunk{}, "export_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&UsageExport{}, "id = ?", id).Error
+	})
+}
+
+func InsertUsageExportChunk(exportId int, seq int, data []byte) error {
+	return DB.Create(&UsageExportChunk{ExportId: exportId, Seq: seq, Data: data}).Error
+}
+
+// IterateUsageExportChunks 按顺序逐块读取导出文件，避免一次性加载整个文件
+func IterateUsageExportChunks(exportId int, fn func(data []byte) error) error {
+	seq := -1
+	for {
+		var chunk UsageExportChunk
+		err := DB.Where("export_id = ? AND seq > ?", exportId, seq).Order("seq").Limit(1).Find(&chunk).Error
+		if err != nil {
+			return err
+		}
+		if chunk.Id == 0 {
+			return nil
+		}
+		if err := fn(chunk.Data); err != nil {
+			return err
+		}
+		seq = chunk.Seq
+	}
+}
+
+func DeleteUsageExportChunks(exportId int) error {
+	return DB.Delete(&UsageExportChunk{}, "export_id = ?", exportId).Error
+}
+
+func usageExportQuery(filter *UsageExportFilter) *gorm.DB {
+	tx := LOG_DB.Model(&Log{})
+	if filter.LogType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", filter.LogType)
+	}
+	if filter.UserId != 0 {
+		tx = tx.Where("logs.user_id = ?", filter.UserId)
+	}
+	if filter.Username != "" {
+		tx = tx.Where("logs.username = ?", filter.Username)
+	}
+	if filter.TokenName != "" {
+		tx = tx.Where("logs.token_name = ?", filter.TokenName)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("logs.model_name like ?", filter.ModelName)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.Channel != 0 {
+		tx = tx.Where("logs.channel_id = ?", filter.Channel)
+	}
+	if filter.Group != "" {
+		tx = tx.Where("logs."+groupCol+" = ?", filter.Group)
+	}
+	return tx
+}
+
+// IterateExportLogs 按 id 游标分批读取符合条件的日志，避免大偏移分页与一次性加载全部数据
+func IterateExportLogs(filter *UsageExportFilter, batchSize int, fn func(logs []*Log) error) error {
+	lastId := 0
+	for {
+		var logs []*Log
+		err := usageExportQuery(filter).Where("logs.id > ?", lastId).
+			Order("logs.id").Limit(batchSize).Find(&logs).Error
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		if err := fn(logs); err != nil {
+			return err
+		}
+		if len(logs) < batchSize {
+			return nil
+		}
+		lastId = logs[len(logs)-1].Id
+	}
+}
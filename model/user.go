@@ -61,6 +61,15 @@ type User struct {
 	IDCFlareId        string         `json:"idc_flare_id" gorm:"column:idc_flare_id;index"`
 	Setting           string         `json:"setting" gorm:"type:text;column:setting"`
 	LastCheckInTime   *time.Time     `json:"last_check_in_time" gorm:"column:last_check_in_time"` // 上次签到时间
+	DailyQuotaLimit   int            `json:"daily_quota_limit" gorm:"type:int;default:0"`
+	WeeklyQuotaLimit  int            `json:"weekly_quota_limit" gorm:"type:int;default:0"`
+	MonthlyQuotaLimit int            `json:"monthly_quota_limit" gorm:"type:int;default:0"`
+	SoftLimitPercent  int            `json:"soft_limit_percent" gorm:"type:int;default:0"`
+	TpmLimit          int            `json:"tpm_limit" gorm:"type:int;default:0"`
+	RpmLimit          int            `json:"rpm_limit" gorm:"type:int;default:0"`
+	ConcurrencyLimit  int            `json:"concurrency_limit" gorm:"type:int;default:0"`
+	PlanId            int            `json:"plan_id" gorm:"type:int;default:0;index"` // 当前生效的订阅套餐
+	CreatedTime       int64          `json:"created_time" gorm:"type:bigint;default:0"`    // 注册时间，早于该字段加入时注册的用户为 0
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -73,6 +82,14 @@ func (user *User) ToBaseUser() *UserBase {
 		Username:          user.Username,
 		Setting:           user.Setting,
 		Email:             user.Email,
+		DailyQuotaLimit:   user.DailyQuotaLimit,
+		WeeklyQuotaLimit:  user.WeeklyQuotaLimit,
+		MonthlyQuotaLimit: user.MonthlyQuotaLimit,
+		SoftLimitPercent:  user.SoftLimitPercent,
+		TpmLimit:          user.TpmLimit,
+		RpmLimit:          user.RpmLimit,
+		ConcurrencyLimit:  user.ConcurrencyLimit,
+		PlanId:            user.PlanId,
 	}
 	return cache
 }
@@ -294,10 +311,25 @@ func HardDeleteUserById(id int) error {
 	if id == 0 {
 		return errors.New("id 为空！")
 	}
+	if err := leaveOrganizationsBeforeDelete(id); err != nil {
+		return err
+	}
 	err := DB.Unscoped().Delete(&User{}, "id = ?", id).Error
 	return err
 }
 
+// leaveOrganizationsBeforeDelete 删除用户前退出其所在组织，组织所有者需先转让组织
+func leaveOrganizationsBeforeDelete(userId int) error {
+	owns, err := OwnsOrganization(userId)
+	if err != nil {
+		return err
+	}
+	if owns {
+		return errors.New("该用户是组织所有者，请先转让或删除组织")
+	}
+	return LeaveAllOrganizations(userId)
+}
+
 func inviteUser(inviterId int) (err error) {
 	// 始终更新邀请统计，不管奖励是否为0
 	updateFields := map[string]interface{}{
@@ -310,18 +342,20 @@ func inviteUser(inviterId int) (err error) {
 		updateFields["aff_history"] = gorm.Expr("aff_history + ?", common.QuotaForInviter)
 	}
 
-	result := DB.Model(&User{}).Where("id = ?", inviterId).Updates(updateFields)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", inviterId).Updates(updateFields)
 
-	if result.Error != nil {
-		return result.Error
-	}
+		if result.Error != nil {
+			return result.Error
+		}
 
-	// 检查是否找到了用户
-	if result.RowsAffected == 0 {
-		return errors.New("邀请者用户不存在")
-	}
+		// 检查是否找到了用户
+		if result.RowsAffected == 0 {
+			return errors.New("邀请者用户不存在")
+		}
 
-	return nil
+		return postUserQuotaChange(tx, inviterId, QuotaAccountAff, common.QuotaForInviter, QuotaChange{Reason: QuotaReasonInviter})
+	})
 }
 
 // ProcessRebate 处理返佣逻辑
@@ -349,14 +383,18 @@ func ProcessRebate(userId int, amount int, rebateType string) error {
 	}
 
 	// 给邀请者增加返佣额度
-	err = IncreaseUserQuota(user.InviterId, rebateAmount, false)
+	err = IncreaseUserQuota(user.InviterId, rebateAmount, false, QuotaChange{
+		Reason: QuotaReasonRebate,
+		RefId:  strconv.Itoa(userId),
+		Remark: rebateType,
+	})
 	if err != nil {
 		return err
 	}
 
 	// 记录返佣日志
-	RecordLog(user.InviterId, LogTypeSystem, fmt.Sprintf("获得%s返佣 %s，返佣比例：%.1f%%",
-		rebateType, common.LogQuota(rebateAmount), common.RebatePercentage))
+	RecordQuotaLog(user.InviterId, LogTypeSystem, fmt.Sprintf("获得%s返佣 %s，返佣比例：%.1f%%",
+		rebateType, common.LogQuota(rebateAmount), common.RebatePercentage), rebateAmount)
 	RecordLog(userId, LogTypeSystem, fmt.Sprintf("为邀请者产生%s返佣 %s",
 		rebateType, common.LogQuota(rebateAmount)))
 
@@ -395,6 +433,13 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	err = postQuotaTransfer(tx, user.Id, QuotaAccountAff, QuotaAccountQuota, int64(quota), QuotaChange{
+		Reason:  QuotaReasonAffTransfer,
+		ActorId: user.Id,
+	})
+	if err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -410,6 +455,7 @@ func (user *User) Insert(inviterId int) error {
 	}
 	user.Quota = common.QuotaForNewUser
 	user.AffCode = common.GetRandomString(4)
+	user.CreatedTime = common.GetTimestamp()
 
 	// 开始数据库事务
 	tx := DB.Begin()
@@ -424,6 +470,10 @@ func (user *User) Insert(inviterId int) error {
 		return result.Error
 	}
 
+	if err = postUserQuotaChange(tx, user.Id, QuotaAccountQuota, user.Quota, QuotaChange{Reason: QuotaReasonRegister}); err != nil {
+		return err
+	}
+
 	// 记录新用户注册日志
 	if common.QuotaForNewUser > 0 {
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
@@ -433,7 +483,10 @@ func (user *User) Insert(inviterId int) error {
 	if inviterId != 0 && common.AffEnabled {
 		// 给被邀请者增加额度（如果配置了奖励）
 		if common.QuotaForInvitee > 0 {
-			err = tx.Model(&User{}).Where("id = ?", user.Id).Update("quota", gorm.Expr("quota + ?", common.QuotaForInvitee)).Error
+			err = changeUserQuota(tx, user.Id, QuotaAccountQuota, common.QuotaForInvitee, QuotaChange{
+				Reason: QuotaReasonInvitee,
+				RefId:  strconv.Itoa(inviterId),
+			})
 			if err != nil {
 				return err
 			}
@@ -462,6 +515,13 @@ func (user *User) Insert(inviterId int) error {
 		if result.RowsAffected == 0 {
 			return errors.New("邀请者用户不存在")
 		}
+		err = postUserQuotaChange(tx, inviterId, QuotaAccountAff, common.QuotaForInviter, QuotaChange{
+			Reason: QuotaReasonInviter,
+			RefId:  strconv.Itoa(user.Id),
+		})
+		if err != nil {
+			return err
+		}
 
 		// 记录日志（不管奖励是否为0都记录邀请事件）
 		if common.QuotaForInviter > 0 {
@@ -485,7 +545,10 @@ func (user *User) Update(updatePassword bool) error {
 	}
 	newUser := *user
 	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(newUser).Error; err != nil {
+	// 余额字段只能通过账本变动，避免用读取时的旧值覆盖期间发生的额度变化
+	// 套餐由订阅开通与到期维护
+	omits := append([]string{"plan_id"}, quotaUserAccounts...)
+	if err = DB.Model(user).Omit(omits...).Updates(newUser).Error; err != nil {
 		return err
 	}
 
@@ -493,7 +556,8 @@ func (user *User) Update(updatePassword bool) error {
 	return updateUserCache(*user)
 }
 
-func (user *User) Edit(updatePassword bool) error {
+// Edit 管理员编辑用户信息，额度变化按差额记入账本，actorId 为操作的管理员
+func (user *User) Edit(updatePassword bool, actorId int) error {
 	var err error
 	if updatePassword {
 		user.Password, err = common.Password2Hash(user.Password)
@@ -508,15 +572,37 @@ func (user *User) Edit(updatePassword bool) error {
 		"display_name": newUser.DisplayName,
 		"group":        newUser.Group,
 		"quota":        newUser.Quota,
+
+		"daily_quota_limit":   newUser.DailyQuotaLimit,
+		"weekly_quota_limit":  newUser.WeeklyQuotaLimit,
+		"monthly_quota_limit": newUser.MonthlyQuotaLimit,
+		"soft_limit_percent":  newUser.SoftLimitPercent,
+		"tpm_limit":           newUser.TpmLimit,
+		"rpm_limit":           newUser.RpmLimit,
+		"concurrency_limit":   newUser.ConcurrencyLimit,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
 	}
 
-	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var origin User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota").Where("id = ?", user.Id).Take(&origin).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Model(&User{}).Where("id = ?", user.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+		return postUserQuotaChange(tx, user.Id, QuotaAccountQuota, newUser.Quota-origin.Quota, QuotaChange{
+			Reason:  QuotaReasonAdminAdjust,
+			ActorId: actorId,
+		})
+	})
+	if err != nil {
 		return err
 	}
+	DB.First(&user, user.Id)
 
 	// Update cache
 	return updateUserCache(*user)
@@ -526,6 +612,9 @@ func (user *User) Delete() error {
 	if user.Id == 0 {
 		return errors.New("id 为空！")
 	}
+	if err := leaveOrganizationsBeforeDelete(user.Id); err != nil {
+		return err
+	}
 	if err := DB.Delete(user).Error; err != nil {
 		return err
 	}
@@ -538,6 +627,9 @@ func (user *User) HardDelete() error {
 	if user.Id == 0 {
 		return errors.New("id 为空！")
 	}
+	if err := leaveOrganizationsBeforeDelete(user.Id); err != nil {
+		return err
+	}
 	err := DB.Unscoped().Delete(user).Error
 	return err
 }
@@ -820,7 +912,7 @@ func GetUserShowIPInLogs(id int, fromDB bool) (bool, error) {
 	return false, nil
 }
 
-func IncreaseUserQuota(id int, quota int, db bool) (err error) {
+func IncreaseUserQuota(id int, quota int, db bool, change QuotaChange) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -831,21 +923,19 @@ func IncreaseUserQuota(id int, quota int, db bool) (err error) {
 		}
 	})
 	if !db && common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addUserQuotaRecord(id, QuotaAccountQuota, quota, change)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return increaseUserQuota(id, quota, change)
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
+func increaseUserQuota(id int, quota int, change QuotaChange) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return changeUserQuota(tx, id, QuotaAccountQuota, quota, change)
+	})
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+func DecreaseUserQuota(id int, quota int, change QuotaChange) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -856,38 +946,35 @@ func DecreaseUserQuota(id int, quota int) (err error) {
 		}
 	})
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
+		addUserQuotaRecord(id, QuotaAccountQuota, -quota, change)
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
+	return increaseUserQuota(id, -quota, change)
 }
 
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
-}
-
-func DeltaUpdateUserQuota(id int, delta int) (err error) {
+func DeltaUpdateUserQuota(id int, delta int, change QuotaChange) (err error) {
 	if delta == 0 {
 		return nil
 	}
 	if delta > 0 {
-		return IncreaseUserQuota(id, delta, false)
+		return IncreaseUserQuota(id, delta, false, change)
 	} else {
-		return DecreaseUserQuota(id, -delta)
+		return DecreaseUserQuota(id, -delta, change)
 	}
 }
 
-func ConsumeUserQuota(id int, amount int) (subscriptionUsed int, quotaUsed int, err error) {
+// ConsumeUserQuota 优先扣减订阅额度，不足部分扣减余额，两部分分别记账
+// allowSubscription 为 false 时（套餐不支持当前模型）只扣减余额
+func ConsumeUserQuota(id int, amount int, allowSubscription bool, change QuotaChange) (subscriptionUsed int, quotaUsed int, err error) {
 	if amount < 0 {
 		return 0, 0, errors.New("quota 不能为负数！")
 	}
 	if amount == 0 {
 		return 0, 0, nil
 	}
+	if common.BatchUpdateEnabled {
+		return consumeUserQuotaBatched(id, amount, allowSubscription, change)
+	}
 	tx := DB.Begin()
 	if tx.Error != nil {
 		return 0, 0, tx.Error
@@ -908,6 +995,9 @@ func ConsumeUserQuota(id int, amount int) (subscriptionUsed int, quotaUsed int,
 	if err != nil {
 		return 0, 0, err
 	}
+	if !allowSubscription {
+		snapshot.SubscriptionQuota = 0
+	}
 	total := snapshot.SubscriptionQuota + snapshot.Quota
 	if total < amount {
 		err = fmt.Errorf("user quota is not enough, user quota: %s, need quota: %s", common.FormatQuota(total), common.FormatQuota(amount))
@@ -918,24 +1008,58 @@ func ConsumeUserQuota(id int, amount int) (subscriptionUsed int, quotaUsed int,
 		subscriptionUsed = snapshot.SubscriptionQuota
 	}
 	quotaUsed = amount - subscriptionUsed
+	err = changeUserQuota(tx, id, QuotaAccountSubscription, -subscriptionUsed, change)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = changeUserQuota(tx, id, QuotaAccountQuota, -quotaUsed, change)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return 0, 0, err
+	}
 	if subscriptionUsed > 0 {
-		err = tx.Model(&User{}).Where("id = ?", id).
-			Update("subscription_quota", gorm.Expr("subscription_quota - ?", subscriptionUsed)).Error
-		if err != nil {
-			return 0, 0, err
-		}
+		gopool.Go(func() {
+			if cacheErr := cacheDecrUserSubscriptionQuota(id, int64(subscriptionUsed)); cacheErr != nil {
+				common.SysError("failed to decrease user subscription quota: " + cacheErr.Error())
+			}
+		})
 	}
 	if quotaUsed > 0 {
-		err = tx.Model(&User{}).Where("id = ?", id).
-			Update("quota", gorm.Expr("quota - ?", quotaUsed)).Error
-		if err != nil {
-			return 0, 0, err
-		}
+		gopool.Go(func() {
+			if cacheErr := cacheDecrUserQuota(id, int64(quotaUsed)); cacheErr != nil {
+				common.SysError("failed to decrease user quota: " + cacheErr.Error())
+			}
+		})
 	}
-	err = tx.Commit().Error
+	return subscriptionUsed, quotaUsed, nil
+}
+
+// consumeUserQuotaBatched 批量更新模式下按当前余额估算订阅额度与余额的扣减量，暂存后与账本一起定时落库
+func consumeUserQuotaBatched(id int, amount int, allowSubscription bool, change QuotaChange) (subscriptionUsed int, quotaUsed int, err error) {
+	balance, err := GetUserQuotaBalance(id, false)
 	if err != nil {
 		return 0, 0, err
 	}
+	if !common.RedisEnabled {
+		// 数据库中的余额尚未包含暂存的变动，Redis 缓存则已在变动时同步更新
+		pending := getPendingUserQuota(id)
+		balance.SubscriptionQuota += pending[QuotaAccountSubscription]
+		balance.Quota += pending[QuotaAccountQuota]
+	}
+	if !allowSubscription {
+		balance.SubscriptionQuota = 0
+	}
+	total := balance.Total()
+	if total < amount {
+		return 0, 0, fmt.Errorf("user quota is not enough, user quota: %s, need quota: %s", common.FormatQuota(total), common.FormatQuota(amount))
+	}
+	subscriptionUsed = min(amount, max(balance.SubscriptionQuota, 0))
+	quotaUsed = amount - subscriptionUsed
+	addUserQuotaRecord(id, QuotaAccountSubscription, -subscriptionUsed, change)
+	addUserQuotaRecord(id, QuotaAccountQuota, -quotaUsed, change)
 	if subscriptionUsed > 0 {
 		gopool.Go(func() {
 			if cacheErr := cacheDecrUserSubscriptionQuota(id, int64(subscriptionUsed)); cacheErr != nil {
@@ -953,40 +1077,39 @@ func ConsumeUserQuota(id int, amount int) (subscriptionUsed int, quotaUsed int,
 	return subscriptionUsed, quotaUsed, nil
 }
 
-func RestoreUserQuota(id int, subscriptionAmount int, quotaAmount int) (err error) {
+func RestoreUserQuota(id int, subscriptionAmount int, quotaAmount int, change QuotaChange) (err error) {
 	if subscriptionAmount < 0 || quotaAmount < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if subscriptionAmount == 0 && quotaAmount == 0 {
 		return nil
 	}
-	tx := DB.Begin()
-	if tx.Error != nil {
-		return tx.Error
-	}
-	defer func() {
+	if common.BatchUpdateEnabled {
+		addUserQuotaRecord(id, QuotaAccountSubscription, subscriptionAmount, change)
+		addUserQuotaRecord(id, QuotaAccountQuota, quotaAmount, change)
+	} else {
+		tx := DB.Begin()
+		if tx.Error != nil {
+			return tx.Error
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			}
+		}()
+		err = changeUserQuota(tx, id, QuotaAccountSubscription, subscriptionAmount, change)
 		if err != nil {
-			tx.Rollback()
+			return err
 		}
-	}()
-	if subscriptionAmount > 0 {
-		err = tx.Model(&User{}).Where("id = ?", id).
-			Update("subscription_quota", gorm.Expr("subscription_quota + ?", subscriptionAmount)).Error
+		err = changeUserQuota(tx, id, QuotaAccountQuota, quotaAmount, change)
 		if err != nil {
 			return err
 		}
-	}
-	if quotaAmount > 0 {
-		err = tx.Model(&User{}).Where("id = ?", id).
-			Update("quota", gorm.Expr("quota + ?", quotaAmount)).Error
+		err = tx.Commit().Error
 		if err != nil {
 			return err
 		}
 	}
-	err = tx.Commit().Error
-	if err != nil {
-		return err
-	}
 	if subscriptionAmount > 0 {
 		gopool.Go(func() {
 			if cacheErr := cacheIncrUserSubscriptionQuota(id, int64(subscriptionAmount)); cacheErr != nil {
@@ -1059,6 +1182,11 @@ func updateUserRequestCount(id int, count int) {
 	}
 }
 
+func GetUserIdByUsername(username string) (id int, err error) {
+	err = DB.Model(&User{}).Where("username = ?", username).Select("id").Take(&id).Error
+	return id, err
+}
+
 // GetUsernameById gets username from Redis first, falls back to DB if needed
 func GetUsernameById(id int, fromDB bool) (username string, err error) {
 	defer func() {
@@ -1203,15 +1331,22 @@ func applyAndSaveCheckIn(tx *gorm.DB, user *User, reward int) error {
 	// Update user data
 	now := time.Now()
 	user.LastCheckInTime = &now
-	user.Quota += reward
-
-	// Save changes
-	if err := tx.Save(user).Error; err != nil {
+	if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("last_check_in_time", now).Error; err != nil {
+		return err
+	}
+	err := changeUserQuota(tx, user.Id, QuotaAccountQuota, reward, QuotaChange{
+		Reason:  QuotaReasonCheckIn,
+		ActorId: user.Id,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&User{}).Where("id = ?", user.Id).Select("quota").Find(&user.Quota).Error; err != nil {
 		return err
 	}
 
 	// Record this activity in log
-	RecordLog(user.Id, LogTypeCheckIn, fmt.Sprintf("签到奖励 %s", common.LogQuota(reward)))
+	RecordQuotaLog(user.Id, LogTypeCheckIn, fmt.Sprintf("签到奖励 %s", common.LogQuota(reward)), reward)
 
 	return nil
 }
@@ -23,6 +23,7 @@ import (
 	"time"
 	"veloera/common"
 	"veloera/constant"
+	"veloera/dto"
 
 	"github.com/bytedance/gopkg/util/gopool"
 )
@@ -37,6 +38,14 @@ type UserBase struct {
 	Status            int    `json:"status"`
 	Username          string `json:"username"`
 	Setting           string `json:"setting"`
+	DailyQuotaLimit   int    `json:"daily_quota_limit"`
+	WeeklyQuotaLimit  int    `json:"weekly_quota_limit"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit"`
+	SoftLimitPercent  int    `json:"soft_limit_percent"`
+	TpmLimit          int    `json:"tpm_limit"`
+	RpmLimit          int    `json:"rpm_limit"`
+	ConcurrencyLimit  int    `json:"concurrency_limit"`
+	PlanId            int    `json:"plan_id"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -47,6 +56,30 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	c.Set(constant.ContextKeyUserEmail, user.Email)
 	c.Set("username", user.Username)
 	c.Set(constant.ContextKeyUserSetting, user.GetSetting())
+	c.Set(constant.ContextKeyUserSpendingLimit, user.GetSpendingLimit())
+	tpmLimit, concurrencyLimit := user.TpmLimit, user.ConcurrencyLimit
+	c.Set(constant.ContextKeyUserPlanId, user.PlanId)
+	// 用户未单独设置限流时使用套餐的限流
+	if plan, ok := CacheGetSubscriptionPlan(user.PlanId); ok {
+		if tpmLimit == 0 {
+			tpmLimit = plan.TpmLimit
+		}
+		if concurrencyLimit == 0 {
+			concurrencyLimit = plan.Concurrency
+		}
+	}
+	c.Set(constant.ContextKeyUserTPMLimit, tpmLimit)
+	c.Set(constant.ContextKeyUserRPMLimit, user.RpmLimit)
+	c.Set(constant.ContextKeyUserConcurrencyLimit, concurrencyLimit)
+}
+
+func (user *UserBase) GetSpendingLimit() dto.SpendingLimit {
+	return dto.SpendingLimit{
+		Daily:            user.DailyQuotaLimit,
+		Weekly:           user.WeeklyQuotaLimit,
+		Monthly:          user.MonthlyQuotaLimit,
+		SoftLimitPercent: user.SoftLimitPercent,
+	}
 }
 
 func (user *UserBase) GetSetting() map[string]interface{} {
@@ -129,6 +162,13 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 		Username:          user.Username,
 		Setting:           user.Setting,
 		Email:             user.Email,
+		DailyQuotaLimit:   user.DailyQuotaLimit,
+		WeeklyQuotaLimit:  user.WeeklyQuotaLimit,
+		MonthlyQuotaLimit: user.MonthlyQuotaLimit,
+		SoftLimitPercent:  user.SoftLimitPercent,
+		TpmLimit:          user.TpmLimit,
+		ConcurrencyLimit:  user.ConcurrencyLimit,
+		PlanId:            user.PlanId,
 	}
 
 	return userCache, nil
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"sync"
 	"time"
 	"veloera/common"
@@ -31,12 +32,23 @@ const (
 	BatchUpdateTypeUsedQuota
 	BatchUpdateTypeChannelUsedQuota
 	BatchUpdateTypeRequestCount
+	BatchUpdateTypeChannelUsedCost
 	BatchUpdateTypeCount // if you add a new type, you need to add a new map and a new lock
 )
 
 var batchUpdateStores []map[int]int
 var batchUpdateLocks []sync.Mutex
 
+// batchQuotaChanges 批量更新模式下暂存的用户额度变动明细，与余额在同一事务中落库
+var batchQuotaChanges = make(map[int][]pendingQuotaChange)
+
+type pendingQuotaChange struct {
+	account   string
+	amount    int
+	change    QuotaChange
+	createdAt int64
+}
+
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int))
@@ -63,18 +75,93 @@ func addNewRecord(type_ int, id int, value int) {
 	}
 }
 
+// addUserQuotaRecord 暂存用户账户（余额或订阅额度）的一笔变动
+func addUserQuotaRecord(id int, account string, value int, change QuotaChange) {
+	if value == 0 {
+		return
+	}
+	batchUpdateLocks[BatchUpdateTypeUserQuota].Lock()
+	defer batchUpdateLocks[BatchUpdateTypeUserQuota].Unlock()
+	batchUpdateStores[BatchUpdateTypeUserQuota][id] += value
+	batchQuotaChanges[id] = append(batchQuotaChanges[id], pendingQuotaChange{
+		account:   account,
+		amount:    value,
+		change:    change,
+		createdAt: common.GetTimestamp(),
+	})
+}
+
+// getPendingUserQuota 返回用户尚未落库的各账户变动合计
+func getPendingUserQuota(id int) map[string]int {
+	batchUpdateLocks[BatchUpdateTypeUserQuota].Lock()
+	defer batchUpdateLocks[BatchUpdateTypeUserQuota].Unlock()
+	pending := make(map[string]int)
+	for _, change := range batchQuotaChanges[id] {
+		pending[change.account] += change.amount
+	}
+	return pending
+}
+
+// flushUserQuota 将暂存的额度变动按账户合并更新余额，并在同一事务中批量写入账本。
+// 批量扣减订阅额度时按暂存前的余额估算，落库时订阅额度不足的部分改由余额承担
+func flushUserQuota(id int, changes []pendingQuotaChange) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		deltas := make(map[string]int)
+		entries := make([]QuotaLedgerEntry, 0, len(changes)*2)
+		for _, pending := range changes {
+			deltas[pending.account] += pending.amount
+			entries = append(entries, quotaTransferEntries(id, SystemQuotaAccount(pending.change.Reason), pending.account,
+				int64(pending.amount), pending.change, pending.createdAt)...)
+		}
+		if deltas[QuotaAccountSubscription] < 0 {
+			var subscriptionQuota int
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&User{}).Where("id = ?", id).
+				Select("subscription_quota").Take(&subscriptionQuota).Error
+			if err != nil {
+				return err
+			}
+			if deficit := -(subscriptionQuota + deltas[QuotaAccountSubscription]); deficit > 0 {
+				deltas[QuotaAccountSubscription] += deficit
+				deltas[QuotaAccountQuota] -= deficit
+				entries = append(entries, quotaTransferEntries(id, QuotaAccountQuota, QuotaAccountSubscription, int64(deficit),
+					QuotaChange{Reason: QuotaReasonConsume, Remark: "订阅额度不足，差额由余额扣减"}, common.GetTimestamp())...)
+			}
+		}
+		updates := make(map[string]interface{}, len(deltas))
+		for account, delta := range deltas {
+			if delta != 0 {
+				updates[account] = gorm.Expr(account+" + ?", delta)
+			}
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&User{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&entries, 500).Error
+	})
+}
+
 func batchUpdate() {
 	common.SysLog("batch update started")
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		var quotaChanges map[int][]pendingQuotaChange
+		if i == BatchUpdateTypeUserQuota {
+			quotaChanges = batchQuotaChanges
+			batchQuotaChanges = make(map[int][]pendingQuotaChange)
+		}
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := flushUserQuota(key, quotaChanges[key])
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
@@ -89,6 +176,8 @@ func batchUpdate() {
 				updateUserRequestCount(key, value)
 			case BatchUpdateTypeChannelUsedQuota:
 				updateChannelUsedQuota(key, value)
+			case BatchUpdateTypeChannelUsedCost:
+				updateChannelUsedCost(key, value)
 			}
 		}
 	}
@@ -0,0 +1,88 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+const (
+	WebhookDeliveryStatusPending = "pending"
+	WebhookDeliveryStatusSuccess = "success"
+	WebhookDeliveryStatusFailed  = "failed"
+)
+
+// WebhookDelivery 异步任务完成回调的投递记录
+type WebhookDelivery struct {
+	Id              int    `json:"id"`
+	UserId          int    `json:"user_id" gorm:"index"`
+	Source          string `json:"source" gorm:"type:varchar(20);index"` // task 或 mj
+	TaskId          string `json:"task_id" gorm:"type:varchar(100);index"`
+	Event           string `json:"event" gorm:"type:varchar(40)"`
+	Url             string `json:"url" gorm:"type:text"`
+	Payload         string `json:"payload" gorm:"type:text"`
+	Status          string `json:"status" gorm:"type:varchar(20);index"`
+	Attempts        int    `json:"attempts"`
+	ResponseCode    int    `json:"response_code"`
+	LastError       string `json:"last_error" gorm:"type:text"`
+	NextAttemptTime int64  `json:"next_attempt_time" gorm:"type:bigint;index"`
+	CreatedTime     int64  `json:"created_time" gorm:"type:bigint;index"`
+	DeliveredTime   int64  `json:"delivered_time" gorm:"type:bigint"`
+}
+
+func (delivery *WebhookDelivery) Insert() error {
+	return DB.Create(delivery).Error
+}
+
+func (delivery *WebhookDelivery) Update() error {
+	return DB.Save(delivery).Error
+}
+
+// GetDueWebhookDeliveries 获取到达重试时间的待投递记录
+func GetDueWebhookDeliveries(now int64, limit int) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	err := DB.Where("status = ? AND next_attempt_time <= ?", WebhookDeliveryStatusPending, now).
+		Order("next_attempt_time").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func GetWebhookDeliveryById(id int) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := DB.First(&delivery, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// GetWebhookDeliveries userId 为 0 时查询全部用户
+func GetWebhookDeliveries(userId int, taskId string, status string, startIdx int, num int) ([]*WebhookDelivery, int64, error) {
+	var deliveries []*WebhookDelivery
+	var total int64
+	query := DB.Model(&WebhookDelivery{})
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if taskId != "" {
+		query = query.Where("task_id = ?", taskId)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&deliveries).Error
+	return deliveries, total, err
+}
@@ -60,3 +60,11 @@ type TaskAdaptor interface {
 	// FetchTask
 	FetchTask(baseUrl, key string, body map[string]any) (*http.Response, error)
 }
+
+// TaskPollAdaptor 逐个轮询任务状态的适配器，用于视频等上游仅支持按任务 ID 查询的平台
+type TaskPollAdaptor interface {
+	TaskAdaptor
+
+	ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error)
+	CancelTask(baseUrl, key, taskID, action string) error
+}
@@ -121,7 +121,9 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	} else {
 		client = service.GetHttpClient()
 	}
+	attempt := common.GetUpstreamTrace(c).Begin(req, info.ChannelId)
 	resp, err := client.Do(req)
+	attempt.Finish(resp, err)
 	if err != nil {
 		return nil, err
 	}
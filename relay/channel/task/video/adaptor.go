@@ -0,0 +1,246 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/relay/channel"
+	relaycommon "veloera/relay/common"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrCancelNotSupported = errors.New("该渠道不支持取消任务")
+
+// vendor 不同上游的视频接口格式
+type vendor interface {
+	defaultSeconds() int
+	submitPath(action string) string
+	setAuth(req *http.Request, key string) error
+	buildBody(request *dto.VideoRequest, info *relaycommon.TaskRelayInfo) (body io.Reader, contentType string, err error)
+	parseSubmit(respBody []byte) (taskID string, err error)
+	fetchPath(taskID, action string) string
+	parseTask(respBody []byte) (*relaycommon.TaskInfo, error)
+	// cancelRequest 返回取消任务的请求方法与路径，上游不支持取消时返回 false
+	cancelRequest(taskID, action string) (method string, path string, ok bool)
+}
+
+// contentVendor 结果文件需要携带密钥下载的上游
+type contentVendor interface {
+	contentPath(taskID string) string
+}
+
+type TaskAdaptor struct {
+	ChannelType int
+}
+
+func (a *TaskAdaptor) vendor() vendor {
+	switch a.ChannelType {
+	case common.ChannelTypeKling:
+		return klingVendor{}
+	case common.ChannelTypeRunway:
+		return runwayVendor{}
+	}
+	return openaiVendor{}
+}
+
+func (a *TaskAdaptor) Init(info *relaycommon.TaskRelayInfo) {
+	a.ChannelType = info.ChannelType
+}
+
+func (a *TaskAdaptor) ValidateRequestAndSetAction(c *gin.Context, info *relaycommon.TaskRelayInfo) (taskErr *dto.TaskError) {
+	var videoRequest dto.VideoRequest
+	err := common.UnmarshalBodyReusable(c, &videoRequest)
+	if err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if videoRequest.Prompt == "" && videoRequest.InputReference == "" {
+		taskErr = service.TaskErrorWrapperLocal(fmt.Errorf("prompt_empty"), "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if videoRequest.Seconds <= 0 {
+		videoRequest.Seconds = dto.VideoSeconds(a.vendor().defaultSeconds())
+	}
+
+	info.Action = constant.VideoActionTextToVideo
+	if videoRequest.InputReference != "" {
+		info.Action = constant.VideoActionImageToVideo
+	}
+	info.VideoSeconds = int(videoRequest.Seconds)
+	info.VideoResolution = videoRequest.Size
+	c.Set("task_request", &videoRequest)
+	return nil
+}
+
+func (a *TaskAdaptor) BuildRequestURL(info *relaycommon.TaskRelayInfo) (string, error) {
+	return info.BaseUrl + a.vendor().submitPath(info.Action), nil
+}
+
+func (a *TaskAdaptor) BuildRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.TaskRelayInfo) error {
+	req.Header.Set("Content-Type", c.GetString("task_content_type"))
+	req.Header.Set("Accept", "application/json")
+	return a.vendor().setAuth(req, info.ApiKey)
+}
+
+func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.TaskRelayInfo) (io.Reader, error) {
+	value, ok := c.Get("task_request")
+	if !ok {
+		return nil, errors.New("video request not found")
+	}
+	videoRequest := *value.(*dto.VideoRequest)
+	videoRequest.Model = info.UpstreamModelName
+	body, contentType, err := a.vendor().buildBody(&videoRequest, info)
+	if err != nil {
+		return nil, err
+	}
+	c.Set("task_content_type", contentType)
+	return body, nil
+}
+
+func (a *TaskAdaptor) DoRequest(c *gin.Context, info *relaycommon.TaskRelayInfo, requestBody io.Reader) (*http.Response, error) {
+	return channel.DoTaskApiRequest(a, c, info, requestBody)
+}
+
+func (a *TaskAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.TaskRelayInfo) (taskID string, taskData []byte, taskErr *dto.TaskError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		taskErr = service.TaskErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+		return
+	}
+	taskID, err = a.vendor().parseSubmit(responseBody)
+	if err != nil {
+		taskErr = service.TaskErrorWrapper(err, "submit_task_failed", http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.VideoResponse{
+		Id:        taskID,
+		Object:    "video",
+		Model:     info.OriginModelName,
+		Status:    "queued",
+		CreatedAt: time.Now().Unix(),
+		Seconds:   strconv.Itoa(info.VideoSeconds),
+		Size:      info.VideoResolution,
+	})
+	return taskID, responseBody, nil
+}
+
+func (a *TaskAdaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *TaskAdaptor) GetChannelName() string {
+	return ChannelName
+}
+
+func (a *TaskAdaptor) doRequest(ctx context.Context, method, requestUrl, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if err = a.vendor().setAuth(req, key); err != nil {
+		return nil, err
+	}
+	return service.GetHttpClient().Do(req)
+}
+
+// FetchTask body 需包含 task_id 与 action
+func (a *TaskAdaptor) FetchTask(baseUrl, key string, body map[string]any) (*http.Response, error) {
+	taskID, _ := body["task_id"].(string)
+	action, _ := body["action"].(string)
+	if taskID == "" {
+		return nil, errors.New("task_id is required")
+	}
+	// 设置超时时间
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	resp, err := a.doRequest(ctx, http.MethodGet, baseUrl+a.vendor().fetchPath(taskID, action), key, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 在超时取消前读完响应
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+	return resp, nil
+}
+
+func (a *TaskAdaptor) ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error) {
+	return a.vendor().parseTask(respBody)
+}
+
+func (a *TaskAdaptor) CancelTask(baseUrl, key, taskID, action string) error {
+	method, path, ok := a.vendor().cancelRequest(taskID, action)
+	if !ok {
+		return ErrCancelNotSupported
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	resp, err := a.doRequest(ctx, method, baseUrl+path, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cancel task failed with status %d: %s", resp.StatusCode, string(responseBody))
+	}
+	return nil
+}
+
+// FetchContent 下载需要鉴权的结果文件，结果为公开地址的上游返回 false
+func (a *TaskAdaptor) FetchContent(ctx context.Context, baseUrl, key, taskID, variant string) (*http.Response, bool, error) {
+	v, ok := a.vendor().(contentVendor)
+	if !ok {
+		return nil, false, nil
+	}
+	requestUrl := baseUrl + v.contentPath(taskID)
+	if variant != "" {
+		requestUrl += "?variant=" + url.QueryEscape(variant)
+	}
+	resp, err := a.doRequest(ctx, http.MethodGet, requestUrl, key, nil)
+	return resp, true, err
+}
+
+func marshalBody(body any) (io.Reader, string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(data), "application/json", nil
+}
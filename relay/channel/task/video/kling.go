@@ -0,0 +1,171 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package video
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// klingVendor 可灵开放平台，密钥格式为 AccessKey|SecretKey，单个值时直接作为 Bearer 令牌（兼容代理）
+type klingVendor struct{}
+
+type klingResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		TaskId        string `json:"task_id"`
+		TaskStatus    string `json:"task_status"`
+		TaskStatusMsg string `json:"task_status_msg"`
+		TaskResult    struct {
+			Videos []struct {
+				Id       string `json:"id"`
+				Url      string `json:"url"`
+				Duration string `json:"duration"`
+			} `json:"videos"`
+		} `json:"task_result"`
+	} `json:"data"`
+}
+
+func (v klingVendor) defaultSeconds() int {
+	return 5
+}
+
+func klingPath(action string) string {
+	if action == constant.VideoActionImageToVideo {
+		return "/v1/videos/image2video"
+	}
+	return "/v1/videos/text2video"
+}
+
+func (v klingVendor) submitPath(action string) string {
+	return klingPath(action)
+}
+
+func (v klingVendor) setAuth(req *http.Request, key string) error {
+	accessKey, secretKey, found := strings.Cut(key, "|")
+	if !found {
+		req.Header.Set("Authorization", "Bearer "+key)
+		return nil
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": accessKey,
+		"exp": now.Add(30 * time.Minute).Unix(),
+		"nbf": now.Add(-5 * time.Second).Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(secretKey))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	return nil
+}
+
+func (v klingVendor) buildBody(request *dto.VideoRequest, info *relaycommon.TaskRelayInfo) (io.Reader, string, error) {
+	body := map[string]any{
+		"model_name": request.Model,
+		"prompt":     request.Prompt,
+		"duration":   strconv.Itoa(int(request.Seconds)),
+	}
+	if request.NegativePrompt != "" {
+		body["negative_prompt"] = request.NegativePrompt
+	}
+	if request.AspectRatio != "" {
+		body["aspect_ratio"] = request.AspectRatio
+	}
+	if request.Mode != "" {
+		body["mode"] = request.Mode
+	}
+	if request.InputReference != "" {
+		// 可灵只接受图片 URL 或不带前缀的 base64
+		image := request.InputReference
+		if strings.HasPrefix(image, "data:") {
+			if _, data, found := strings.Cut(image, ","); found {
+				image = data
+			}
+		}
+		body["image"] = image
+	}
+	return marshalBody(body)
+}
+
+func (v klingVendor) parseSubmit(respBody []byte) (string, error) {
+	var response klingResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", err
+	}
+	if response.Code != 0 {
+		return "", errors.New(response.Message)
+	}
+	if response.Data.TaskId == "" {
+		return "", errors.New("empty task id")
+	}
+	return response.Data.TaskId, nil
+}
+
+func (v klingVendor) fetchPath(taskID, action string) string {
+	return klingPath(action) + "/" + taskID
+}
+
+func (v klingVendor) parseTask(respBody []byte) (*relaycommon.TaskInfo, error) {
+	var response klingResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, err
+	}
+	if response.Code != 0 {
+		return nil, errors.New(response.Message)
+	}
+	info := &relaycommon.TaskInfo{TaskID: response.Data.TaskId}
+	// 可灵不返回进度，按状态估算
+	switch response.Data.TaskStatus {
+	case "submitted":
+		info.Status = model.TaskStatusQueued
+		info.Progress = 10
+	case "processing":
+		info.Status = model.TaskStatusInProgress
+		info.Progress = 50
+	case "succeed":
+		info.Status = model.TaskStatusSuccess
+		info.Progress = 100
+		if len(response.Data.TaskResult.Videos) > 0 {
+			info.Url = response.Data.TaskResult.Videos[0].Url
+		}
+	case "failed":
+		info.Status = model.TaskStatusFailure
+		info.Progress = 100
+		info.Reason = response.Data.TaskStatusMsg
+	default:
+		info.Status = model.TaskStatusUnknown
+	}
+	return info, nil
+}
+
+func (v klingVendor) cancelRequest(taskID, action string) (string, string, bool) {
+	return "", "", false
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package video
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"veloera/dto"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+	"veloera/service"
+)
+
+// openaiVendor OpenAI /v1/videos 格式
+type openaiVendor struct{}
+
+type openaiVideo struct {
+	Id       string          `json:"id"`
+	Status   string          `json:"status"`
+	Progress int             `json:"progress"`
+	Error    *dto.VideoError `json:"error"`
+}
+
+func (v openaiVendor) defaultSeconds() int {
+	return 4
+}
+
+func (v openaiVendor) submitPath(action string) string {
+	return "/v1/videos"
+}
+
+func (v openaiVendor) setAuth(req *http.Request, key string) error {
+	req.Header.Set("Authorization", "Bearer "+key)
+	return nil
+}
+
+func (v openaiVendor) buildBody(request *dto.VideoRequest, info *relaycommon.TaskRelayInfo) (io.Reader, string, error) {
+	fields := map[string]string{
+		"model":   request.Model,
+		"prompt":  request.Prompt,
+		"seconds": strconv.Itoa(int(request.Seconds)),
+	}
+	if request.Size != "" {
+		fields["size"] = request.Size
+	}
+	if request.InputReference == "" {
+		return marshalBody(fields)
+	}
+
+	// 参考图需以文件形式上传
+	var mimeType, data string
+	var err error
+	if strings.HasPrefix(request.InputReference, "data:") {
+		mimeType, data, err = service.DecodeBase64FileData(request.InputReference)
+	} else {
+		mimeType, data, err = service.GetImageFromUrl(request.InputReference)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	image, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, "", err
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		if err = writer.WriteField(key, value); err != nil {
+			return nil, "", err
+		}
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="input_reference"; filename="input_reference"`)
+	header.Set("Content-Type", mimeType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = part.Write(image); err != nil {
+		return nil, "", err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, writer.FormDataContentType(), nil
+}
+
+func (v openaiVendor) parseSubmit(respBody []byte) (string, error) {
+	var video openaiVideo
+	if err := json.Unmarshal(respBody, &video); err != nil {
+		return "", err
+	}
+	if video.Id == "" {
+		if video.Error != nil {
+			return "", errors.New(video.Error.Message)
+		}
+		return "", errors.New("empty task id")
+	}
+	return video.Id, nil
+}
+
+func (v openaiVendor) fetchPath(taskID, action string) string {
+	return "/v1/videos/" + taskID
+}
+
+func (v openaiVendor) parseTask(respBody []byte) (*relaycommon.TaskInfo, error) {
+	var video openaiVideo
+	if err := json.Unmarshal(respBody, &video); err != nil {
+		return nil, err
+	}
+	if video.Id == "" && video.Error != nil {
+		return nil, errors.New(video.Error.Message)
+	}
+	info := &relaycommon.TaskInfo{TaskID: video.Id, Progress: video.Progress}
+	switch video.Status {
+	case "queued":
+		info.Status = model.TaskStatusQueued
+	case "in_progress":
+		info.Status = model.TaskStatusInProgress
+	case "completed":
+		info.Status = model.TaskStatusSuccess
+		info.Progress = 100
+	case "failed":
+		info.Status = model.TaskStatusFailure
+		info.Progress = 100
+		if video.Error != nil {
+			info.Reason = video.Error.Message
+		}
+	default:
+		info.Status = model.TaskStatusUnknown
+	}
+	return info, nil
+}
+
+// OpenAI 没有取消接口，删除任务即停止生成
+func (v openaiVendor) cancelRequest(taskID, action string) (string, string, bool) {
+	return http.MethodDelete, "/v1/videos/" + taskID, true
+}
+
+func (v openaiVendor) contentPath(taskID string) string {
+	return "/v1/videos/" + taskID + "/content"
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package video
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+)
+
+const runwayApiVersion = "2024-11-06"
+
+// runwayVendor Runway 风格的任务接口
+type runwayVendor struct{}
+
+type runwayTask struct {
+	Id       string   `json:"id"`
+	Status   string   `json:"status"`
+	Progress float64  `json:"progress"`
+	Failure  string   `json:"failure"`
+	Output   []string `json:"output"`
+	Error    string   `json:"error"`
+}
+
+func (v runwayVendor) defaultSeconds() int {
+	return 5
+}
+
+func (v runwayVendor) submitPath(action string) string {
+	if action == constant.VideoActionImageToVideo {
+		return "/v1/image_to_video"
+	}
+	return "/v1/text_to_video"
+}
+
+func (v runwayVendor) setAuth(req *http.Request, key string) error {
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("X-Runway-Version", runwayApiVersion)
+	return nil
+}
+
+func (v runwayVendor) buildBody(request *dto.VideoRequest, info *relaycommon.TaskRelayInfo) (io.Reader, string, error) {
+	body := map[string]any{
+		"model":      request.Model,
+		"promptText": request.Prompt,
+		"duration":   int(request.Seconds),
+	}
+	// Runway 使用 1280:720 形式的比例
+	if request.Size != "" {
+		body["ratio"] = strings.Replace(request.Size, "x", ":", 1)
+	} else if request.AspectRatio != "" {
+		body["ratio"] = request.AspectRatio
+	}
+	if request.InputReference != "" {
+		body["promptImage"] = request.InputReference
+	}
+	return marshalBody(body)
+}
+
+func (v runwayVendor) parseSubmit(respBody []byte) (string, error) {
+	var task runwayTask
+	if err := json.Unmarshal(respBody, &task); err != nil {
+		return "", err
+	}
+	if task.Id == "" {
+		if task.Error != "" {
+			return "", errors.New(task.Error)
+		}
+		return "", errors.New("empty task id")
+	}
+	return task.Id, nil
+}
+
+func (v runwayVendor) fetchPath(taskID, action string) string {
+	return "/v1/tasks/" + taskID
+}
+
+func (v runwayVendor) parseTask(respBody []byte) (*relaycommon.TaskInfo, error) {
+	var task runwayTask
+	if err := json.Unmarshal(respBody, &task); err != nil {
+		return nil, err
+	}
+	if task.Id == "" && task.Error != "" {
+		return nil, errors.New(task.Error)
+	}
+	info := &relaycommon.TaskInfo{TaskID: task.Id}
+	switch task.Status {
+	case "PENDING", "THROTTLED":
+		info.Status = model.TaskStatusQueued
+	case "RUNNING":
+		info.Status = model.TaskStatusInProgress
+		info.Progress = int(task.Progress * 100)
+	case "SUCCEEDED":
+		info.Status = model.TaskStatusSuccess
+		info.Progress = 100
+		if len(task.Output) > 0 {
+			info.Url = task.Output[0]
+		}
+	case "FAILED":
+		info.Status = model.TaskStatusFailure
+		info.Progress = 100
+		info.Reason = task.Failure
+	case "CANCELLED":
+		info.Status = model.TaskStatusFailure
+		info.Progress = 100
+		info.Reason = "cancelled"
+	default:
+		info.Status = model.TaskStatusUnknown
+	}
+	return info, nil
+}
+
+func (v runwayVendor) cancelRequest(taskID, action string) (string, string, bool) {
+	return http.MethodDelete, "/v1/tasks/" + taskID, true
+}
@@ -73,6 +73,21 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 
 	relayInfo := relaycommon.GenRelayInfoClaude(c)
 
+	// 审计归档需在脱敏还原之前包装响应，以记录客户端实际收到的内容
+	auditCapture := service.StartAuditCapture(c)
+	defer func() {
+		var openaiErr *dto.OpenAIErrorWithStatusCode
+		if claudeError != nil {
+			openaiErr = service.ClaudeErrorToOpenAIError(claudeError)
+		}
+		service.FinishAuditCapture(c, auditCapture, relayInfo, openaiErr)
+	}()
+
+	// 发往上游前对请求体中的文本脱敏，占位符在响应中还原
+	if _, err := service.MaskRequestBodyPii(c); err != nil {
+		return service.ClaudeErrorWrapperLocal(err, "mask_request_pii_failed", http.StatusInternalServerError)
+	}
+
 	// get & validate textRequest 获取并验证文本请求
 	textRequest, err := getAndValidateClaudeRequest(c)
 	if err != nil {
@@ -160,6 +175,7 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 	if err != nil {
 		return service.ClaudeErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
+	auditCapture.SetRequest(jsonData)
 	requestBody = bytes.NewBuffer(jsonData)
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
@@ -60,7 +60,16 @@ type RerankerInfo struct {
 	ReturnDocuments bool
 }
 
+// TPMReservation 准入时按预估 prompt tokens 预占的 TPM，结算时按实际用量修正
+type TPMReservation struct {
+	Keys    []string
+	Minute  int64
+	Tokens  int
+	Settled bool
+}
+
 type RelayInfo struct {
+	RequestId         string
 	ChannelType       int
 	ChannelId         int
 	TokenId           int
@@ -102,8 +111,15 @@ type RelayInfo struct {
 	UserSetting               map[string]interface{}
 	UserEmail                 string
 	UserQuota                 int
+	TokenSpendingLimit        dto.SpendingLimit
+	UserSpendingLimit         dto.SpendingLimit
+	OrgId                     int // 组织令牌所属组织，非 0 时从组织额度扣费
+	OrgMemberId               int
+	OrgMemberSpendingLimit    dto.SpendingLimit
+	TPMReservation            *TPMReservation
 	ConsumedSubscriptionQuota int
 	ConsumedQuota             int
+	SubscriptionExcluded      bool // 套餐不支持当前模型时只从余额扣费
 	RelayFormat               string
 	SendResponseCount         int
 	ChannelCreateTime         int64
@@ -202,6 +218,7 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	}
 
 	info := &RelayInfo{
+		RequestId:         c.GetString(common.RequestIdKey),
 		UserQuota:         c.GetInt(constant.ContextKeyUserQuota),
 		UserSetting:       c.GetStringMap(constant.ContextKeyUserSetting),
 		UserEmail:         c.GetString(constant.ContextKeyUserEmail),
@@ -237,6 +254,19 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		},
 	}
 
+	if limit, ok := c.Get(constant.ContextKeyTokenSpendingLimit); ok {
+		info.TokenSpendingLimit, _ = limit.(dto.SpendingLimit)
+	}
+	if limit, ok := c.Get(constant.ContextKeyUserSpendingLimit); ok {
+		info.UserSpendingLimit, _ = limit.(dto.SpendingLimit)
+	}
+	info.OrgId = c.GetInt(constant.ContextKeyOrgId)
+	info.SubscriptionExcluded = c.GetBool(constant.ContextKeySubscriptionExcluded)
+	info.OrgMemberId = c.GetInt(constant.ContextKeyOrgMemberId)
+	if limit, ok := c.Get(constant.ContextKeyOrgMemberSpendingLimit); ok {
+		info.OrgMemberSpendingLimit, _ = limit.(dto.SpendingLimit)
+	}
+
 	if format, exists := c.Get("relay_format"); exists {
 		if relayFormat, ok := format.(string); ok && relayFormat != "" {
 			info.RelayFormat = relayFormat
@@ -314,9 +344,22 @@ type TaskRelayInfo struct {
 	Action       string
 	OriginTaskID string
 
+	// 视频任务按时长与分辨率计费
+	VideoSeconds    int
+	VideoResolution string
+
 	ConsumeQuota bool
 }
 
+// TaskInfo 上游任务查询结果的统一表示
+type TaskInfo struct {
+	TaskID   string
+	Status   string // 取值同 model.TaskStatus
+	Progress int    // 0-100
+	Reason   string
+	Url      string
+}
+
 func GenTaskRelayInfo(c *gin.Context) *TaskRelayInfo {
 	info := &TaskRelayInfo{
 		RelayInfo: GenRelayInfo(c),
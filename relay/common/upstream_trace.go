@@ -0,0 +1,220 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"veloera/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamTraceBodyLimit 单个请求或响应最多记录的字节数
+const upstreamTraceBodyLimit = 256 << 10
+
+// UpstreamTrace 记录发往上游的原始请求、响应与耗时，供 Playground 调试渠道配置，重试时每次请求各记一条
+type UpstreamTrace struct {
+	mu       sync.Mutex
+	Attempts []*UpstreamAttempt `json:"attempts"`
+}
+
+// UpstreamAttempt 单次上游请求，耗时单位为毫秒
+type UpstreamAttempt struct {
+	ChannelId         int               `json:"channel_id"`
+	Method            string            `json:"method"`
+	URL               string            `json:"url"`
+	RequestHeaders    map[string]string `json:"request_headers"`
+	RequestBody       string            `json:"request_body"`
+	RequestTruncated  bool              `json:"request_truncated,omitempty"`
+	StatusCode        int               `json:"status_code"`
+	ResponseHeaders   map[string]string `json:"response_headers"`
+	ResponseBody      string            `json:"response_body"`
+	ResponseTruncated bool              `json:"response_truncated,omitempty"`
+	Error             string            `json:"error,omitempty"`
+	StartedAt         int64             `json:"started_at"`
+	HeaderMs          int64             `json:"header_ms"` // 收到响应头的耗时
+	TotalMs           int64             `json:"total_ms"`  // 读完响应体的耗时
+
+	start    time.Time
+	response bytes.Buffer
+}
+
+// EnableUpstreamTrace 为本次请求开启上游请求记录
+func EnableUpstreamTrace(c *gin.Context) *UpstreamTrace {
+	trace := &UpstreamTrace{}
+	c.Set(constant.ContextKeyUpstreamTrace, trace)
+	return trace
+}
+
+// GetUpstreamTrace 未开启记录时返回 nil
+func GetUpstreamTrace(c *gin.Context) *UpstreamTrace {
+	if trace, ok := c.Get(constant.ContextKeyUpstreamTrace); ok {
+		return trace.(*UpstreamTrace)
+	}
+	return nil
+}
+
+// Begin 记录即将发出的请求，会读出并还原请求体；trace 为 nil 时返回 nil
+func (t *UpstreamTrace) Begin(req *http.Request, channelId int) *UpstreamAttempt {
+	if t == nil {
+		return nil
+	}
+	attempt := &UpstreamAttempt{
+		ChannelId:      channelId,
+		Method:         req.Method,
+		URL:            maskTraceURL(req.URL),
+		RequestHeaders: maskTraceHeaders(req.Header),
+		start:          time.Now(),
+	}
+	attempt.StartedAt = attempt.start.UnixMilli()
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		if len(body) > upstreamTraceBodyLimit {
+			body = body[:upstreamTraceBodyLimit]
+			attempt.RequestTruncated = true
+		}
+		attempt.RequestBody = string(body)
+	}
+	t.mu.Lock()
+	t.Attempts = append(t.Attempts, attempt)
+	t.mu.Unlock()
+	return attempt
+}
+
+// Finish 记录响应头，并在响应体被读取时同步记录内容，读完或关闭时计算总耗时
+func (a *UpstreamAttempt) Finish(resp *http.Response, err error) {
+	if a == nil {
+		return
+	}
+	a.HeaderMs = time.Since(a.start).Milliseconds()
+	if err != nil {
+		a.Error = err.Error()
+		a.TotalMs = a.HeaderMs
+		return
+	}
+	if resp == nil {
+		return
+	}
+	a.StatusCode = resp.StatusCode
+	a.ResponseHeaders = maskTraceHeaders(resp.Header)
+	if resp.Body != nil {
+		resp.Body = &traceBody{ReadCloser: resp.Body, attempt: a}
+	}
+}
+
+// Snapshot 返回可序列化的记录副本
+func (t *UpstreamTrace) Snapshot() []*UpstreamAttempt {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, attempt := range t.Attempts {
+		attempt.ResponseBody = attempt.response.String()
+	}
+	return append([]*UpstreamAttempt(nil), t.Attempts...)
+}
+
+type traceBody struct {
+	io.ReadCloser
+	attempt *UpstreamAttempt
+	done    bool
+}
+
+func (b *traceBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		chunk := p[:n]
+		if remain := upstreamTraceBodyLimit - b.attempt.response.Len(); remain < n {
+			chunk = chunk[:max(remain, 0)]
+			b.attempt.ResponseTruncated = true
+		}
+		b.attempt.response.Write(chunk)
+	}
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *traceBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *traceBody) finish() {
+	if !b.done {
+		b.done = true
+		b.attempt.TotalMs = time.Since(b.attempt.start).Milliseconds()
+	}
+}
+
+// isSecretTraceKey 判断请求头或查询参数是否可能包含密钥
+func isSecretTraceKey(name string) bool {
+	name = strings.ToLower(name)
+	return name == "authorization" || name == "proxy-authorization" || name == "cookie" || name == "set-cookie" ||
+		strings.Contains(name, "key") || strings.Contains(name, "token") || strings.Contains(name, "secret") ||
+		strings.Contains(name, "signature")
+}
+
+func maskTraceValue(value string) string {
+	if len(value) <= 12 {
+		return "***"
+	}
+	return value[:8] + "***"
+}
+
+func maskTraceHeaders(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if isSecretTraceKey(name) {
+			value = maskTraceValue(value)
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func maskTraceURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	masked := *u
+	masked.User = nil
+	query := masked.Query()
+	for name, values := range query {
+		if isSecretTraceKey(name) {
+			for i := range values {
+				values[i] = maskTraceValue(values[i])
+			}
+		}
+	}
+	masked.RawQuery = query.Encode()
+	return masked.String()
+}
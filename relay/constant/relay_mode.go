@@ -53,6 +53,11 @@ const (
 	RelayModeSunoFetchByID
 	RelayModeSunoSubmit
 
+	RelayModeVideoSubmit
+	RelayModeVideoFetchByID
+	RelayModeVideoContent
+	RelayModeVideoCancel
+
 	RelayModeRerank
 
 	RelayModeResponses
@@ -69,7 +74,11 @@ const (
 
 func Path2RelayMode(path string) int {
 	relayMode := RelayModeUnknown
-	if strings.HasPrefix(path, "/v1/chat/completions") || strings.HasPrefix(path, "/pg/chat/completions") {
+	// Playground 的 /pg/xxx 与 /v1/xxx 对应
+	if strings.HasPrefix(path, "/pg/") {
+		path = "/v1" + strings.TrimPrefix(path, "/pg")
+	}
+	if strings.HasPrefix(path, "/v1/chat/completions") {
 		relayMode = RelayModeChatCompletions
 	} else if strings.HasPrefix(path, "/v1/completions") {
 		relayMode = RelayModeCompletions
@@ -153,3 +162,17 @@ func Path2RelaySuno(method, path string) int {
 	}
 	return relayMode
 }
+
+func Path2RelayVideo(method, path string) int {
+	relayMode := RelayModeUnknown
+	if method == http.MethodPost && strings.HasSuffix(path, "/cancel") {
+		relayMode = RelayModeVideoCancel
+	} else if method == http.MethodGet && strings.HasSuffix(path, "/content") {
+		relayMode = RelayModeVideoContent
+	} else if method == http.MethodGet {
+		relayMode = RelayModeVideoFetchByID
+	} else if method == http.MethodPost {
+		relayMode = RelayModeVideoSubmit
+	}
+	return relayMode
+}
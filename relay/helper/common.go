@@ -42,6 +42,8 @@ func ClaudeData(c *gin.Context, resp dto.ClaudeResponse) error {
 	jsonData, err := json.Marshal(resp)
 	if err != nil {
 		common.SysError("error marshalling stream response: " + err.Error())
+	} else if filter := getStreamOutputFilter(c); filter != nil {
+		renderStreamEvents(c, filter.filterClaude(resp.Type, string(jsonData)))
 	} else {
 		c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", resp.Type)})
 		c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonData)})
@@ -55,16 +57,24 @@ func ClaudeData(c *gin.Context, resp dto.ClaudeResponse) error {
 }
 
 func ClaudeChunkData(c *gin.Context, resp dto.ClaudeResponse, data string) {
-	c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", resp.Type)})
-	c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("data: %s\n", data)})
+	if filter := getStreamOutputFilter(c); filter != nil {
+		renderStreamEvents(c, filter.filterClaude(resp.Type, data))
+	} else {
+		c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", resp.Type)})
+		c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("data: %s\n", data)})
+	}
 	if flusher, ok := c.Writer.(http.Flusher); ok {
 		flusher.Flush()
 	}
 }
 
 func ResponseChunkData(c *gin.Context, resp dto.ResponsesStreamResponse, data string) {
-	c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", resp.Type)})
-	c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("data: %s\n", data)})
+	if filter := getStreamOutputFilter(c); filter != nil {
+		renderStreamEvents(c, filter.filterResponses(resp.Type, data))
+	} else {
+		c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", resp.Type)})
+		c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("data: %s\n", data)})
+	}
 	if flusher, ok := c.Writer.(http.Flusher); ok {
 		flusher.Flush()
 	}
@@ -73,7 +83,13 @@ func ResponseChunkData(c *gin.Context, resp dto.ResponsesStreamResponse, data st
 func StringData(c *gin.Context, str string) error {
 	//str = strings.TrimPrefix(str, "data: ")
 	//str = strings.TrimSuffix(str, "\r")
-	c.Render(-1, common.CustomEvent{Data: "data: " + str})
+	if filter := getStreamOutputFilter(c); filter != nil {
+		for _, data := range filter.filterData(str) {
+			c.Render(-1, common.CustomEvent{Data: "data: " + data})
+		}
+	} else {
+		c.Render(-1, common.CustomEvent{Data: "data: " + str})
+	}
 	if flusher, ok := c.Writer.(http.Flusher); ok {
 		flusher.Flush()
 	} else {
@@ -82,6 +98,13 @@ func StringData(c *gin.Context, str string) error {
 	return nil
 }
 
+func renderStreamEvents(c *gin.Context, events []streamEvent) {
+	for _, event := range events {
+		c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", event.eventType)})
+		c.Render(-1, common.CustomEvent{Data: "data: " + event.data})
+	}
+}
+
 func PingData(c *gin.Context) error {
 	c.Writer.Write([]byte(": PING\n\n"))
 	if flusher, ok := c.Writer.(http.Flusher); ok {
@@ -104,7 +127,9 @@ func WaitData(c *gin.Context) error {
 
 func StartWaitingHeartbeat(c *gin.Context, interval time.Duration) func() {
 	done := make(chan struct{})
+	exited := make(chan struct{})
 	go func() {
+		defer close(exited)
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 		for {
@@ -116,7 +141,11 @@ func StartWaitingHeartbeat(c *gin.Context, interval time.Duration) func() {
 			}
 		}
 	}()
-	return func() { close(done) }
+	// 等待心跳协程退出后再返回，避免与后续响应写入并发
+	return func() {
+		close(done)
+		<-exited
+	}
 }
 
 func ObjectData(c *gin.Context, object interface{}) error {
@@ -0,0 +1,809 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"veloera/common"
+	"veloera/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamTextProcessor 逐段处理流式输出的文本，key 区分不同候选与字段。
+// Feed 返回可以立即发送的文本，Flush 返回保留的剩余文本，返回 true 表示需要终止输出
+type StreamTextProcessor interface {
+	Feed(key string, text string) (string, bool)
+	Flush(key string) (string, bool)
+}
+
+// streamOutputFilter 按 OpenAI、Claude、Gemini 格式解析流式分片，将其中的文本依次交给各处理器，
+// 并在结束事件前补发处理器保留的文本；处理器要求终止时写出对应格式的结束事件
+type streamOutputFilter struct {
+	processors []StreamTextProcessor
+	keys       []string
+	seen       map[string]bool
+	terminated bool
+	chunk      map[string]any // 最近一个 OpenAI 分片，用于生成补发与终止分片
+	// Responses 格式各文本键对应的增量事件模板，以及已发送的文本，用于补发增量和改写 done 事件中的完整文本
+	responseDeltas map[string]map[string]any
+	responseTexts  map[string]*strings.Builder
+}
+
+// streamEvent 带事件名的 SSE 事件，用于 Claude 与 Responses 格式
+type streamEvent struct {
+	eventType string
+	data      string
+}
+
+// AddStreamTextProcessor 为当前请求的流式输出追加文本处理器，
+// 之后经 StringData、ObjectData、ClaudeData、ClaudeChunkData、ResponseChunkData 写出的分片都会经过处理
+func AddStreamTextProcessor(c *gin.Context, processor StreamTextProcessor) {
+	filter := getStreamOutputFilter(c)
+	if filter == nil {
+		filter = &streamOutputFilter{
+			seen:           make(map[string]bool),
+			responseDeltas: make(map[string]map[string]any),
+			responseTexts:  make(map[string]*strings.Builder),
+		}
+		c.Set(constant.ContextKeyStreamOutputFilter, filter)
+	}
+	filter.processors = append(filter.processors, processor)
+}
+
+func getStreamOutputFilter(c *gin.Context) *streamOutputFilter {
+	if filter, ok := c.Get(constant.ContextKeyStreamOutputFilter); ok {
+		return filter.(*streamOutputFilter)
+	}
+	return nil
+}
+
+// StreamOutputTerminated 流式输出是否已被处理器终止
+func StreamOutputTerminated(c *gin.Context) bool {
+	filter := getStreamOutputFilter(c)
+	return filter != nil && filter.terminated
+}
+
+func (f *streamOutputFilter) feed(key string, text string) (string, bool) {
+	if !f.seen[key] {
+		f.seen[key] = true
+		f.keys = append(f.keys, key)
+	}
+	for _, processor := range f.processors {
+		var block bool
+		if text, block = processor.Feed(key, text); block {
+			f.terminated = true
+			return "", true
+		}
+	}
+	return text, false
+}
+
+// flush 依次取出各处理器保留的文本，前面处理器补发的文本仍需经过后续处理器
+func (f *streamOutputFilter) flush(key string) (string, bool) {
+	text := ""
+	for _, processor := range f.processors {
+		released, block := processor.Feed(key, text)
+		if !block {
+			var tail string
+			tail, block = processor.Flush(key)
+			text = released + tail
+		}
+		if block {
+			f.terminated = true
+			return "", true
+		}
+	}
+	return text, false
+}
+
+// StreamOutputChecker 审核一段待发送的输出文本，返回遮盖后的文本以及是否需要终止输出
+type StreamOutputChecker func(text string) (string, bool)
+
+// moderationWindow 每段文本在发送前保留最后 window 个字符，与后续分片拼接后再审核，
+// 使跨分片的命中也能被遮盖。remoteCheck 不为空时，每累计 remoteInterval 个待发送字符
+// 及流结束时再对累计的文本审核一次，只能终止输出
+type moderationWindow struct {
+	check          StreamOutputChecker
+	window         int
+	pending        map[string][]rune
+	remoteCheck    StreamOutputChecker
+	remoteInterval int
+	unchecked      map[string][]rune
+}
+
+// EnableStreamOutputModeration 为当前请求的流式输出启用审核，check 逐窗口调用，remoteCheck 按 remoteInterval 节流调用
+func EnableStreamOutputModeration(c *gin.Context, window int, check StreamOutputChecker, remoteCheck StreamOutputChecker, remoteInterval int) {
+	if window <= 0 {
+		window = 64
+	}
+	if remoteInterval <= 0 {
+		remoteInterval = 1000
+	}
+	AddStreamTextProcessor(c, &moderationWindow{
+		check:          check,
+		window:         window,
+		pending:        make(map[string][]rune),
+		remoteCheck:    remoteCheck,
+		remoteInterval: remoteInterval,
+		unchecked:      make(map[string][]rune),
+	})
+}
+
+// checkRemote 累计即将发送的文本，达到间隔或流结束时调用 remoteCheck，返回是否需要终止输出
+func (m *moderationWindow) checkRemote(key string, text string, final bool) bool {
+	if m.remoteCheck == nil {
+		return false
+	}
+	unchecked := append(m.unchecked[key], []rune(text)...)
+	if !final && len(unchecked) < m.remoteInterval {
+		m.unchecked[key] = unchecked
+		return false
+	}
+	delete(m.unchecked, key)
+	if len(unchecked) == 0 {
+		return false
+	}
+	_, block := m.remoteCheck(string(unchecked))
+	return block
+}
+
+func (m *moderationWindow) Feed(key string, text string) (string, bool) {
+	if text == "" {
+		return "", false
+	}
+	pending := append(m.pending[key], []rune(text)...)
+	if len(pending) < 2*m.window {
+		m.pending[key] = pending
+		return "", false
+	}
+	masked, block := m.check(string(pending))
+	if block {
+		return "", true
+	}
+	runes := []rune(masked)
+	cut := max(len(runes)-m.window, 0)
+	m.pending[key] = runes[cut:]
+	released := string(runes[:cut])
+	if m.checkRemote(key, released, false) {
+		return "", true
+	}
+	return released, false
+}
+
+func (m *moderationWindow) Flush(key string) (string, bool) {
+	pending := m.pending[key]
+	if len(pending) == 0 {
+		return "", m.checkRemote(key, "", true)
+	}
+	delete(m.pending, key)
+	masked, block := m.check(string(pending))
+	if block || m.checkRemote(key, masked, true) {
+		return "", true
+	}
+	return masked, false
+}
+
+// pendingIndexes 返回指定前缀下出现过的序号，保持首次出现的顺序
+func (f *streamOutputFilter) pendingIndexes(prefix string) []string {
+	var indexes []string
+	seen := make(map[string]bool)
+	for _, key := range f.keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		index := strings.SplitN(strings.TrimPrefix(key, prefix), ":", 2)[0]
+		if !seen[index] {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+func decodeStreamChunk(data string) (map[string]any, bool) {
+	var chunk map[string]any
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&chunk); err != nil {
+		return nil, false
+	}
+	return chunk, true
+}
+
+func encodeStreamChunk(chunk map[string]any) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(chunk); err != nil {
+		common.SysError("error marshalling moderated stream chunk: " + err.Error())
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func streamChunkIndex(item map[string]any, position int) string {
+	if index, ok := item["index"]; ok {
+		return fmt.Sprint(index)
+	}
+	return fmt.Sprint(position)
+}
+
+// filterData 审核 OpenAI 与 Gemini 格式的分片，返回实际需要写出的分片
+func (f *streamOutputFilter) filterData(data string) []string {
+	if f.terminated {
+		if data == "[DONE]" {
+			return []string{data}
+		}
+		if chunk, ok := decodeStreamChunk(data); ok && !hasStreamContent(chunk) {
+			// 终止后仍放行 usage 等不含内容的分片
+			return []string{data}
+		}
+		return nil
+	}
+	chunk, ok := decodeStreamChunk(data)
+	if !ok {
+		if data == "[DONE]" {
+			return append(f.flushData(), data)
+		}
+		return []string{data}
+	}
+	if choices, ok := chunk["choices"].([]any); ok && len(choices) > 0 {
+		return f.filterOpenAIChunk(chunk, choices)
+	}
+	if candidates, ok := chunk["candidates"].([]any); ok && len(candidates) > 0 {
+		return f.filterGeminiChunk(chunk, candidates)
+	}
+	return append(f.flushData(), data)
+}
+
+func hasStreamContent(chunk map[string]any) bool {
+	if choices, ok := chunk["choices"].([]any); ok && len(choices) > 0 {
+		return true
+	}
+	if candidates, ok := chunk["candidates"].([]any); ok && len(candidates) > 0 {
+		return true
+	}
+	return false
+}
+
+func hasStreamText(v any) bool {
+	s, ok := v.(string)
+	return ok && s != ""
+}
+
+func (f *streamOutputFilter) filterOpenAIChunk(chunk map[string]any, choices []any) []string {
+	f.chunk = chunk
+	for i, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := streamChunkIndex(choice, i)
+		flushPoint := choice["finish_reason"] != nil
+		target := choice
+		fields := []string{"text"} // completions 格式
+		if delta, ok := choice["delta"].(map[string]any); ok {
+			target = delta
+			fields = []string{"reasoning_content", "content"}
+			if _, ok := delta["tool_calls"]; ok {
+				flushPoint = true
+			}
+		}
+		for _, field := range fields {
+			key := "c" + index + ":" + field
+			text, isString := target[field].(string)
+			released, block := f.feed(key, text)
+			// 开始输出正文时补发保留的思考内容
+			if !block && (flushPoint || field == "reasoning_content" && hasStreamText(target["content"])) {
+				var tail string
+				tail, block = f.flush(key)
+				released += tail
+			}
+			if block {
+				return []string{f.terminateOpenAI(index)}
+			}
+			if isString || released != "" {
+				target[field] = released
+			}
+		}
+	}
+	return []string{encodeStreamChunk(chunk)}
+}
+
+func (f *streamOutputFilter) openAIChunk(choices []any) map[string]any {
+	chunk := map[string]any{
+		"object":  "chat.completion.chunk",
+		"choices": choices,
+	}
+	for _, key := range []string{"id", "object", "created", "model", "system_fingerprint"} {
+		if v, ok := f.chunk[key]; ok {
+			chunk[key] = v
+		}
+	}
+	return chunk
+}
+
+func (f *streamOutputFilter) terminateOpenAI(index string) string {
+	f.terminated = true
+	choice := map[string]any{
+		"index":         json.Number(index),
+		"finish_reason": constant.FinishReasonContentFilter,
+	}
+	if f.chunk["object"] == "text_completion" {
+		choice["text"] = ""
+	} else {
+		choice["delta"] = map[string]any{"content": ""}
+	}
+	return encodeStreamChunk(f.openAIChunk([]any{choice}))
+}
+
+func geminiPartKey(index string, thought bool) string {
+	if thought {
+		return "g" + index + ":thought"
+	}
+	return "g" + index + ":text"
+}
+
+// flushGeminiParts 以文本分片的形式补发指定候选保留的内容
+func (f *streamOutputFilter) flushGeminiParts(index string) ([]any, bool) {
+	var parts []any
+	for _, thought := range []bool{true, false} {
+		text, block := f.flush(geminiPartKey(index, thought))
+		if block {
+			return nil, true
+		}
+		if text == "" {
+			continue
+		}
+		part := map[string]any{"text": text}
+		if thought {
+			part["thought"] = true
+		}
+		parts = append(parts, part)
+	}
+	return parts, false
+}
+
+func (f *streamOutputFilter) filterGeminiChunk(chunk map[string]any, candidates []any) []string {
+	for i, item := range candidates {
+		candidate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := streamChunkIndex(candidate, i)
+		content, _ := candidate["content"].(map[string]any)
+		var parts []any
+		if content != nil {
+			parts, _ = content["parts"].([]any)
+		}
+		newParts := make([]any, 0, len(parts))
+		for _, p := range parts {
+			part, ok := p.(map[string]any)
+			text, isText := part["text"].(string)
+			if !ok || !isText {
+				// 函数调用等非文本内容之前先补发保留的文本
+				flushed, block := f.flushGeminiParts(index)
+				if block {
+					return []string{f.terminateGemini(chunk, index)}
+				}
+				newParts = append(append(newParts, flushed...), p)
+				continue
+			}
+			released, block := f.feed(geminiPartKey(index, part["thought"] == true), text)
+			if block {
+				return []string{f.terminateGemini(chunk, index)}
+			}
+			if released != "" {
+				part["text"] = released
+				newParts = append(newParts, part)
+			}
+		}
+		if finishReason, _ := candidate["finishReason"].(string); finishReason != "" {
+			flushed, block := f.flushGeminiParts(index)
+			if block {
+				return []string{f.terminateGemini(chunk, index)}
+			}
+			newParts = append(newParts, flushed...)
+		}
+		if len(newParts) == 0 && len(parts) > 0 {
+			newParts = append(newParts, map[string]any{"text": ""})
+		}
+		if content == nil && len(newParts) > 0 {
+			content = map[string]any{"role": "model"}
+			candidate["content"] = content
+		}
+		if content != nil {
+			content["parts"] = newParts
+		}
+	}
+	return []string{encodeStreamChunk(chunk)}
+}
+
+func (f *streamOutputFilter) terminateGemini(chunk map[string]any, index string) string {
+	f.terminated = true
+	response := map[string]any{
+		"candidates": []any{map[string]any{
+			"index":        json.Number(index),
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": ""}}},
+			"finishReason": "SAFETY",
+		}},
+	}
+	for _, key := range []string{"usageMetadata", "modelVersion", "responseId"} {
+		if v, ok := chunk[key]; ok {
+			response[key] = v
+		}
+	}
+	return encodeStreamChunk(response)
+}
+
+// flushData 在流结束前补发 OpenAI 与 Gemini 格式中仍保留的文本
+func (f *streamOutputFilter) flushData() []string {
+	var out []string
+	for _, index := range f.pendingIndexes("c") {
+		choice := map[string]any{"index": json.Number(index)}
+		delta := map[string]any{}
+		for _, field := range []string{"reasoning_content", "content", "text"} {
+			text, block := f.flush("c" + index + ":" + field)
+			if block {
+				return append(out, f.terminateOpenAI(index))
+			}
+			if text == "" {
+				continue
+			}
+			if field == "text" {
+				choice["text"] = text
+			} else {
+				delta[field] = text
+			}
+		}
+		if len(delta) == 0 && choice["text"] == nil {
+			continue
+		}
+		if len(delta) > 0 {
+			choice["delta"] = delta
+		}
+		out = append(out, encodeStreamChunk(f.openAIChunk([]any{choice})))
+	}
+	for _, index := range f.pendingIndexes("g") {
+		parts, block := f.flushGeminiParts(index)
+		if block {
+			return append(out, f.terminateGemini(map[string]any{}, index))
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		out = append(out, encodeStreamChunk(map[string]any{
+			"candidates": []any{map[string]any{
+				"index":   json.Number(index),
+				"content": map[string]any{"role": "model", "parts": parts},
+			}},
+		}))
+	}
+	return out
+}
+
+func claudeDeltaField(deltaType string) string {
+	switch deltaType {
+	case "text_delta":
+		return "text"
+	case "thinking_delta":
+		return "thinking"
+	}
+	return ""
+}
+
+// flushClaudeBlock 以 content_block_delta 事件补发内容块保留的文本
+func (f *streamOutputFilter) flushClaudeBlock(index string) ([]streamEvent, bool) {
+	var events []streamEvent
+	for _, deltaType := range []string{"thinking_delta", "text_delta"} {
+		text, block := f.flush("a" + index + ":" + deltaType)
+		if block {
+			return nil, true
+		}
+		if text == "" {
+			continue
+		}
+		events = append(events, streamEvent{
+			eventType: "content_block_delta",
+			data: encodeStreamChunk(map[string]any{
+				"type":  "content_block_delta",
+				"index": json.Number(index),
+				"delta": map[string]any{"type": deltaType, claudeDeltaField(deltaType): text},
+			}),
+		})
+	}
+	return events, false
+}
+
+// filterClaude 审核 Claude 格式的事件，返回实际需要写出的事件
+func (f *streamOutputFilter) filterClaude(eventType string, data string) []streamEvent {
+	if f.terminated {
+		return nil
+	}
+	event, ok := decodeStreamChunk(data)
+	if !ok {
+		return []streamEvent{{eventType: eventType, data: data}}
+	}
+	index := ""
+	if v, ok := event["index"]; ok {
+		index = fmt.Sprint(v)
+	}
+	switch eventType {
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]any)
+		deltaType, _ := delta["type"].(string)
+		field := claudeDeltaField(deltaType)
+		if field == "" {
+			break
+		}
+		text, _ := delta[field].(string)
+		released, block := f.feed("a"+index+":"+deltaType, text)
+		if block {
+			return f.terminateClaude(index)
+		}
+		if released == "" {
+			return nil
+		}
+		delta[field] = released
+		return []streamEvent{{eventType: eventType, data: encodeStreamChunk(event)}}
+	case "content_block_stop":
+		events, block := f.flushClaudeBlock(index)
+		if block {
+			return f.terminateClaude(index)
+		}
+		return append(events, streamEvent{eventType: eventType, data: data})
+	case "message_delta", "message_stop":
+		var events []streamEvent
+		for _, pendingIndex := range f.pendingIndexes("a") {
+			flushed, block := f.flushClaudeBlock(pendingIndex)
+			if block {
+				return f.terminateClaude(pendingIndex)
+			}
+			events = append(events, flushed...)
+		}
+		return append(events, streamEvent{eventType: eventType, data: data})
+	}
+	return []streamEvent{{eventType: eventType, data: data}}
+}
+
+// terminateClaude 结束当前内容块并以 refusal 结束消息
+func (f *streamOutputFilter) terminateClaude(index string) []streamEvent {
+	f.terminated = true
+	var events []streamEvent
+	if index != "" {
+		events = append(events, streamEvent{
+			eventType: "content_block_stop",
+			data:      encodeStreamChunk(map[string]any{"type": "content_block_stop", "index": json.Number(index)}),
+		})
+	}
+	return append(events,
+		streamEvent{
+			eventType: "message_delta",
+			data: encodeStreamChunk(map[string]any{
+				"type":  "message_delta",
+				"delta": map[string]any{"stop_reason": "refusal", "stop_sequence": nil},
+				"usage": map[string]any{"output_tokens": 0},
+			}),
+		},
+		streamEvent{
+			eventType: "message_stop",
+			data:      encodeStreamChunk(map[string]any{"type": "message_stop"}),
+		},
+	)
+}
+
+// responseTextKinds Responses 格式中需要处理的文本增量事件，值为对应的结束事件与序号字段
+var responseTextKinds = map[string]struct {
+	doneType   string
+	indexField string
+}{
+	"response.output_text.delta":            {"response.output_text.done", "content_index"},
+	"response.reasoning_summary_text.delta": {"response.reasoning_summary_text.done", "summary_index"},
+}
+
+func responseTextKey(itemId any, index any, deltaType string) string {
+	return fmt.Sprintf("r%v:%v:%s", itemId, index, deltaType)
+}
+
+// sentResponseText 返回 key 已实际发送的文本
+func (f *streamOutputFilter) sentResponseText(key string) (string, bool) {
+	builder, ok := f.responseTexts[key]
+	if !ok {
+		return "", false
+	}
+	return builder.String(), true
+}
+
+func (f *streamOutputFilter) recordResponseText(key string, text string) {
+	builder, ok := f.responseTexts[key]
+	if !ok {
+		builder = &strings.Builder{}
+		f.responseTexts[key] = builder
+	}
+	builder.WriteString(text)
+}
+
+// flushResponseKey 以增量事件补发 key 保留的文本
+func (f *streamOutputFilter) flushResponseKey(key string) ([]streamEvent, bool) {
+	text, block := f.flush(key)
+	if block {
+		return nil, true
+	}
+	template, ok := f.responseDeltas[key]
+	if text == "" || !ok {
+		return nil, false
+	}
+	f.recordResponseText(key, text)
+	event := make(map[string]any, len(template))
+	for k, v := range template {
+		event[k] = v
+	}
+	event["delta"] = text
+	eventType, _ := event["type"].(string)
+	return []streamEvent{{eventType: eventType, data: encodeStreamChunk(event)}}, false
+}
+
+// flushResponseItem 补发输出项 itemId 下所有保留的文本，itemId 为空时补发全部
+func (f *streamOutputFilter) flushResponseItem(itemId any) ([]streamEvent, bool) {
+	prefix := "r"
+	if itemId != nil {
+		prefix = fmt.Sprintf("r%v:", itemId)
+	}
+	var events []streamEvent
+	for _, key := range f.keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		flushed, block := f.flushResponseKey(key)
+		if block {
+			return nil, true
+		}
+		events = append(events, flushed...)
+	}
+	return events, false
+}
+
+// rewriteResponseParts 将内容片段中的完整文本替换为实际发送的文本
+func (f *streamOutputFilter) rewriteResponseParts(itemId any, parts []any, deltaType string) {
+	for i, p := range parts {
+		part, ok := p.(map[string]any)
+		if !ok {
+			continue
+		}
+		if text, ok := f.sentResponseText(responseTextKey(itemId, i, deltaType)); ok {
+			part["text"] = text
+		}
+	}
+}
+
+// rewriteResponseItem 改写输出项中的消息文本与思考摘要
+func (f *streamOutputFilter) rewriteResponseItem(item map[string]any) {
+	if item == nil {
+		return
+	}
+	if content, ok := item["content"].([]any); ok {
+		f.rewriteResponseParts(item["id"], content, "response.output_text.delta")
+	}
+	if summary, ok := item["summary"].([]any); ok {
+		f.rewriteResponseParts(item["id"], summary, "response.reasoning_summary_text.delta")
+	}
+}
+
+// filterResponses 审核 Responses 格式的事件，返回实际需要写出的事件
+func (f *streamOutputFilter) filterResponses(eventType string, data string) []streamEvent {
+	if f.terminated {
+		return nil
+	}
+	event, ok := decodeStreamChunk(data)
+	if !ok {
+		return []streamEvent{{eventType: eventType, data: data}}
+	}
+	if kind, ok := responseTextKinds[eventType]; ok {
+		key := responseTextKey(event["item_id"], event[kind.indexField], eventType)
+		if _, ok := f.responseDeltas[key]; !ok {
+			template := make(map[string]any)
+			for _, field := range []string{"type", "item_id", "output_index", kind.indexField} {
+				if v, ok := event[field]; ok {
+					template[field] = v
+				}
+			}
+			f.responseDeltas[key] = template
+		}
+		text, _ := event["delta"].(string)
+		released, block := f.feed(key, text)
+		if block {
+			return f.terminateResponses(event)
+		}
+		if released == "" {
+			return nil
+		}
+		f.recordResponseText(key, released)
+		event["delta"] = released
+		return []streamEvent{{eventType: eventType, data: encodeStreamChunk(event)}}
+	}
+	var events []streamEvent
+	var block bool
+	switch eventType {
+	case "response.output_text.done", "response.reasoning_summary_text.done":
+		deltaType := strings.TrimSuffix(eventType, ".done") + ".delta"
+		key := responseTextKey(event["item_id"], event[responseTextKinds[deltaType].indexField], deltaType)
+		if events, block = f.flushResponseKey(key); block {
+			return f.terminateResponses(event)
+		}
+		if text, ok := f.sentResponseText(key); ok {
+			event["text"] = text
+		}
+	case "response.content_part.done", "response.reasoning_summary_part.done":
+		if events, block = f.flushResponseItem(event["item_id"]); block {
+			return f.terminateResponses(event)
+		}
+		deltaType, indexField := "response.output_text.delta", "content_index"
+		if eventType == "response.reasoning_summary_part.done" {
+			deltaType, indexField = "response.reasoning_summary_text.delta", "summary_index"
+		}
+		if part, ok := event["part"].(map[string]any); ok {
+			if text, ok := f.sentResponseText(responseTextKey(event["item_id"], event[indexField], deltaType)); ok {
+				part["text"] = text
+			}
+		}
+	case "response.output_item.done":
+		item, _ := event["item"].(map[string]any)
+		if item != nil {
+			if events, block = f.flushResponseItem(item["id"]); block {
+				return f.terminateResponses(event)
+			}
+		}
+		f.rewriteResponseItem(item)
+	case "response.completed", "response.incomplete", "response.failed":
+		if events, block = f.flushResponseItem(nil); block {
+			return f.terminateResponses(event)
+		}
+		if response, ok := event["response"].(map[string]any); ok {
+			output, _ := response["output"].([]any)
+			for _, o := range output {
+				item, _ := o.(map[string]any)
+				f.rewriteResponseItem(item)
+			}
+		}
+	default:
+		return []streamEvent{{eventType: eventType, data: data}}
+	}
+	return append(events, streamEvent{eventType: eventType, data: encodeStreamChunk(event)})
+}
+
+// terminateResponses 以 content_filter 原因的 response.incomplete 事件结束响应
+func (f *streamOutputFilter) terminateResponses(event map[string]any) []streamEvent {
+	f.terminated = true
+	response := map[string]any{
+		"status":             "incomplete",
+		"incomplete_details": map[string]any{"reason": constant.FinishReasonContentFilter},
+	}
+	if original, ok := event["response"].(map[string]any); ok {
+		for _, key := range []string{"id", "object", "created_at", "model", "usage"} {
+			if v, ok := original[key]; ok {
+				response[key] = v
+			}
+		}
+	}
+	return []streamEvent{{
+		eventType: "response.incomplete",
+		data:      encodeStreamChunk(map[string]any{"type": "response.incomplete", "response": response}),
+	}}
+}
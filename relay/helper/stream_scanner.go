@@ -137,7 +137,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				writeMutex.Lock() // Lock before writing
 				success := dataHandler(data)
 				writeMutex.Unlock() // Unlock after writing
-				if !success {
+				if !success || StreamOutputTerminated(c) {
 					break
 				}
 			}
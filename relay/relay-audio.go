@@ -73,6 +73,10 @@ func getAndValidAudioRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.
 }
 
 func AudioHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	// 音频请求为 multipart 或二进制响应，暂不支持审计归档
+	if err := service.CheckAuditCaptureSupported(c); err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "audit_capture_unsupported", http.StatusForbidden)
+	}
 	relayInfo := relaycommon.GenRelayInfo(c)
 	audioRequest, err := getAndValidAudioRequest(c, relayInfo)
 
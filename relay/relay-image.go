@@ -26,7 +26,6 @@ import (
 	"strings"
 	"veloera/common"
 	"veloera/dto"
-	"veloera/model"
 	relaycommon "veloera/relay/common"
 	"veloera/relay/helper"
 	"veloera/service"
@@ -88,9 +87,14 @@ func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.
 	return imageRequest, nil
 }
 
-func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+func ImageHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	relayInfo := relaycommon.GenRelayInfo(c)
 
+	auditCapture := service.StartAuditCapture(c)
+	defer func() {
+		service.FinishAuditCapture(c, auditCapture, relayInfo, openaiErr)
+	}()
+
 	imageRequest, err := getAndValidImageRequest(c, relayInfo)
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("getAndValidImageRequest failed: %s", err.Error()))
@@ -114,11 +118,10 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		priceData.ModelPrice = 0.0025 * priceData.ModelRatio
 	}
 
-	userBalance, err := model.GetUserQuotaBalance(relayInfo.UserId, false)
+	totalQuota, err := service.GetRelayQuota(relayInfo)
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
-	totalQuota := userBalance.Total()
 
 	sizeRatio := 1.0
 	// Size
@@ -164,6 +167,7 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
+	auditCapture.SetRequest(jsonData)
 	requestBody = bytes.NewBuffer(jsonData)
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
@@ -184,7 +188,7 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		}
 	}
 
-	_, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	_, openaiErr = adaptor.DoResponse(c, httpResp, relayInfo)
 	if openaiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
@@ -99,6 +99,7 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 			Result:      "",
 		}
 	}
+	oldStatus := midjourneyTask.Status
 	midjourneyTask.Progress = midjRequest.Progress
 	midjourneyTask.PromptEn = midjRequest.PromptEn
 	midjourneyTask.State = midjRequest.State
@@ -115,6 +116,10 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 			Description: "update_midjourney_task_failed",
 		}
 	}
+	service.SettleMidjourney(c, midjourneyTask, "")
+	if midjourneyTask.Status != oldStatus {
+		service.NotifyMidjourneyFinished(midjourneyTask)
+	}
 
 	return nil
 }
@@ -159,6 +164,9 @@ func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjo
 }
 
 func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
+	if err := service.CheckAuditCaptureSupported(c); err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, err.Error())
+	}
 	startTime := time.Now().UnixNano() / int64(time.Millisecond)
 	tokenId := c.GetInt("token_id")
 	userId := c.GetInt("id")
@@ -186,7 +194,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	}
 	groupRatio := setting.GetGroupRatio(group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(userId, false)
+	userQuota, err := service.GetRelayQuota(relayInfo)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -201,6 +209,10 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 			Description: "quota_not_enough",
 		}
 	}
+	callbackUrl, err := service.GetTaskCallbackUrl(c)
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "invalid_callback_url")
+	}
 	requestURL := getMjRequestPath(c.Request.URL.String())
 	baseURL := c.GetString("base_url")
 	fullRequestURL := fmt.Sprintf("%s%s", baseURL, requestURL)
@@ -208,30 +220,6 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	if err != nil {
 		return &mjResp.Response
 	}
-	defer func() {
-		if mjResp.StatusCode == 200 && mjResp.Response.Code == 1 {
-			err := service.PostConsumeQuota(relayInfo, quota, 0, true)
-			if err != nil {
-				common.SysError("error consuming token remain quota: " + err.Error())
-			}
-			//err = model.CacheUpdateUserQuota(userId)
-			if err != nil {
-				common.SysError("error update user quota cache: " + err.Error())
-			}
-			if quota != 0 {
-				tokenName := c.GetString("token_name")
-				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, constant.MjActionSwapFace)
-				other := make(map[string]interface{})
-				other["model_price"] = modelPrice
-				other["group_ratio"] = groupRatio
-				model.RecordConsumeLog(c, userId, channelId, 0, 0, modelName, tokenName,
-					quota, logContent, tokenId, userQuota, 0, false, group, other)
-				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
-				channelId := c.GetInt("channel_id")
-				model.UpdateChannelUsedQuota(channelId, quota)
-			}
-		}
-	}()
 	midjResponse := &mjResp.Response
 	midjourneyTask := &model.Midjourney{
 		UserId:      userId,
@@ -251,11 +239,33 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 		FailReason:  "",
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       quota,
+		CallbackUrl: callbackUrl,
 	}
 	err = midjourneyTask.Insert()
 	if err != nil {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "insert_midjourney_task_failed")
 	}
+	reservedQuota := 0
+	if mjResp.StatusCode == 200 && midjResponse.Code == 1 {
+		// 先扣费再记录预留额度，预留记录需要本次从订阅额度扣除的部分
+		err := service.PostConsumeQuota(relayInfo, quota, 0, true)
+		if err != nil {
+			common.SysError("error consuming token remain quota: " + err.Error())
+		}
+		if quota != 0 {
+			tokenName := c.GetString("token_name")
+			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, constant.MjActionSwapFace)
+			other := make(map[string]interface{})
+			other["model_price"] = modelPrice
+			other["group_ratio"] = groupRatio
+			model.RecordConsumeLog(c, userId, channelId, 0, 0, modelName, tokenName,
+				quota, logContent, tokenId, userQuota, 0, false, group, other)
+			model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
+			model.UpdateChannelUsedQuota(c.GetInt("channel_id"), quota)
+		}
+		reservedQuota = quota
+	}
+	service.ReserveMidjourneyQuota(c, midjourneyTask, relayInfo, reservedQuota)
 	c.Writer.WriteHeader(mjResp.StatusCode)
 	respBody, err := json.Marshal(midjResponse)
 	if err != nil {
@@ -370,6 +380,9 @@ func RelayMidjourneyTask(c *gin.Context, relayMode int) *dto.MidjourneyResponse
 }
 
 func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyResponse {
+	if err := service.CheckAuditCaptureSupported(c); err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, err.Error())
+	}
 
 	tokenId := c.GetInt("token_id")
 	//channelType := c.GetInt("channel")
@@ -470,6 +483,11 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 		consumeQuota = false
 	}
 
+	callbackUrl, err := service.GetTaskCallbackUrl(c)
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "invalid_callback_url")
+	}
+
 	//baseURL := common.ChannelBaseURLs[channelType]
 	requestURL := getMjRequestPath(c.Request.URL.String())
 
@@ -492,7 +510,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	}
 	groupRatio := setting.GetGroupRatio(group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(userId, false)
+	userQuota, err := service.GetRelayQuota(relayInfo)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -514,27 +532,6 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	}
 	midjResponse := &midjResponseWithStatus.Response
 
-	defer func() {
-		if consumeQuota && midjResponseWithStatus.StatusCode == 200 {
-			err := service.PostConsumeQuota(relayInfo, quota, 0, true)
-			if err != nil {
-				common.SysError("error consuming token remain quota: " + err.Error())
-			}
-			if quota != 0 {
-				tokenName := c.GetString("token_name")
-				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", modelPrice, groupRatio, midjRequest.Action, midjResponse.Result)
-				other := make(map[string]interface{})
-				other["model_price"] = modelPrice
-				other["group_ratio"] = groupRatio
-				model.RecordConsumeLog(c, userId, channelId, 0, 0, modelName, tokenName,
-					quota, logContent, tokenId, userQuota, 0, false, group, other)
-				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
-				channelId := c.GetInt("channel_id")
-				model.UpdateChannelUsedQuota(channelId, quota)
-			}
-		}
-	}()
-
 	// 文档：https://github.com/novicezk/midjourney-proxy/blob/main/docs/api.md
 	//1-提交成功
 	// 21-任务已存在（处理中或者有结果了） {"code":21,"description":"任务已存在","result":"0741798445574458","properties":{"status":"SUCCESS","imageUrl":"https://xxxx"}}
@@ -560,6 +557,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 		FailReason:  "",
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       quota,
+		CallbackUrl: callbackUrl,
 	}
 	if midjResponse.Code == 3 {
 		//无实例账号自动禁用渠道（No available account instance）
@@ -611,6 +609,30 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 			Description: "insert_midjourney_task_failed",
 		}
 	}
+	reservedQuota := 0
+	if consumeQuota && midjResponseWithStatus.StatusCode == 200 {
+		// 先扣费再记录预留额度，预留记录需要本次从订阅额度扣除的部分
+		err := service.PostConsumeQuota(relayInfo, quota, 0, true)
+		if err != nil {
+			common.SysError("error consuming token remain quota: " + err.Error())
+		}
+		if quota != 0 {
+			tokenName := c.GetString("token_name")
+			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", modelPrice, groupRatio, midjRequest.Action, midjResponse.Result)
+			other := make(map[string]interface{})
+			other["model_price"] = modelPrice
+			other["group_ratio"] = groupRatio
+			model.RecordConsumeLog(c, userId, channelId, 0, 0, modelName, tokenName,
+				quota, logContent, tokenId, userQuota, 0, false, group, other)
+			model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
+			model.UpdateChannelUsedQuota(c.GetInt("channel_id"), quota)
+		}
+		reservedQuota = quota
+	}
+	service.ReserveMidjourneyQuota(c, midjourneyTask, relayInfo, reservedQuota)
+	service.SettleMidjourney(c, midjourneyTask, "")
+	// 任务已存在且已有结果时直接回调
+	service.NotifyMidjourneyFinished(midjourneyTask)
 
 	if midjResponse.Code == 22 { //22-排队中，说明任务已存在
 		//修改返回值
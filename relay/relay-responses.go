@@ -65,6 +65,12 @@ func getInputTokens(req *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo
 func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	relayInfo := relaycommon.GenRelayInfo(c)
 
+	// 审计归档需在脱敏还原之前包装响应，以记录客户端实际收到的内容
+	auditCapture := service.StartAuditCapture(c)
+	defer func() {
+		service.FinishAuditCapture(c, auditCapture, relayInfo, openaiErr)
+	}()
+
 	// Validate request and check sensitive content
 	req, openaiErr := validateAndPrepareRequest(c, relayInfo)
 	if openaiErr != nil {
@@ -91,7 +97,7 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	}()
 
 	// Prepare and send request
-	httpResp, openaiErr := prepareAndSendRequest(c, relayInfo, req)
+	httpResp, openaiErr := prepareAndSendRequest(c, relayInfo, req, auditCapture)
 	if openaiErr != nil {
 		return openaiErr
 	}
@@ -109,6 +115,10 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 }
 
 func validateAndPrepareRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo) (*dto.OpenAIResponsesRequest, *dto.OpenAIErrorWithStatusCode) {
+	// 发往上游前对请求体中的文本脱敏，占位符在响应中还原
+	if _, err := service.MaskRequestBodyPii(c); err != nil {
+		return nil, service.OpenAIErrorWrapperLocal(err, "mask_request_pii_failed", http.StatusInternalServerError)
+	}
 	req, err := getAndValidateResponsesRequest(c, relayInfo)
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("getAndValidateResponsesRequest error: %s", err.Error()))
@@ -164,14 +174,14 @@ func handleQuotaAndPricing(c *gin.Context, relayInfo *relaycommon.RelayInfo, req
 	return priceData, preConsumedQuota, userQuota, nil
 }
 
-func prepareAndSendRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo, req *dto.OpenAIResponsesRequest) (*http.Response, *dto.OpenAIErrorWithStatusCode) {
+func prepareAndSendRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo, req *dto.OpenAIResponsesRequest, auditCapture *service.AuditCapture) (*http.Response, *dto.OpenAIErrorWithStatusCode) {
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return nil, service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
 
 	adaptor.Init(relayInfo)
-	requestBody, openaiErr := prepareRequestBody(c, relayInfo, req, adaptor)
+	requestBody, openaiErr := prepareRequestBody(c, relayInfo, req, adaptor, auditCapture)
 	if openaiErr != nil {
 		return nil, openaiErr
 	}
@@ -196,12 +206,13 @@ func prepareAndSendRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo, req
 	return httpResp, nil
 }
 
-func prepareRequestBody(c *gin.Context, relayInfo *relaycommon.RelayInfo, req *dto.OpenAIResponsesRequest, adaptor interface{}) (io.Reader, *dto.OpenAIErrorWithStatusCode) {
+func prepareRequestBody(c *gin.Context, relayInfo *relaycommon.RelayInfo, req *dto.OpenAIResponsesRequest, adaptor interface{}, auditCapture *service.AuditCapture) (io.Reader, *dto.OpenAIErrorWithStatusCode) {
 	if shouldUsePassThrough(adaptor, relayInfo) {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return nil, service.OpenAIErrorWrapperLocal(err, "get_request_body_error", http.StatusInternalServerError)
 		}
+		auditCapture.SetRequest(body)
 		return bytes.NewBuffer(body), nil
 	}
 
@@ -229,6 +240,7 @@ func prepareRequestBody(c *gin.Context, relayInfo *relaycommon.RelayInfo, req *d
 		println("requestBody: ", string(jsonData))
 	}
 
+	auditCapture.SetRequest(jsonData)
 	return bytes.NewBuffer(jsonData), nil
 }
 
@@ -157,6 +157,15 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		}
 	}
 
+	// 审计归档需在脱敏还原之前包装响应，以记录客户端实际收到的内容
+	auditCapture := service.StartAuditCapture(c)
+	defer func() {
+		service.FinishAuditCapture(c, auditCapture, relayInfo, openaiErr)
+	}()
+
+	// 发往上游前对消息内容脱敏，占位符在响应中还原
+	piiMasked := service.MaskRequestPii(c, textRequest)
+
 	err = helper.ModelMappedHelper(c, relayInfo)
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
@@ -239,11 +248,13 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	adaptor.Init(relayInfo)
 	var requestBody io.Reader
 
-	if shouldUsePassThrough(adaptor, relayInfo) {
+	// 脱敏后的请求不能透传原始请求体
+	if shouldUsePassThrough(adaptor, relayInfo) && !piiMasked {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
 		}
+		auditCapture.SetRequest(body)
 		requestBody = bytes.NewBuffer(body)
 	} else {
 		convertedRequest, err := adaptor.ConvertOpenAIRequest(c, relayInfo, textRequest)
@@ -274,6 +285,7 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		if common.DebugEnabled {
 			println("requestBody: ", string(jsonData))
 		}
+		auditCapture.SetRequest(jsonData)
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
@@ -381,11 +393,10 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
-	userBalance, err := model.GetUserQuotaBalance(relayInfo.UserId, false)
+	totalQuota, err := service.GetRelayQuota(relayInfo)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
-	totalQuota := userBalance.Total()
 	if totalQuota <= 0 {
 		return 0, 0, service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
@@ -393,7 +404,12 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		return 0, 0, service.OpenAIErrorWrapperLocal(fmt.Errorf("chat pre-consumed quota failed, user quota: %s, need quota: %s", common.FormatQuota(totalQuota), common.FormatQuota(preConsumedQuota)), "insufficient_user_quota", http.StatusForbidden)
 	}
 	relayInfo.UserQuota = totalQuota
-	if totalQuota > 100*preConsumedQuota {
+	if tpmErr := service.ReserveTPM(c, relayInfo, relayInfo.PromptTokens); tpmErr != nil {
+		return 0, 0, tpmErr
+	}
+	spendingLimited := relayInfo.TokenSpendingLimit.Enabled() || relayInfo.UserSpendingLimit.Enabled() ||
+		relayInfo.OrgMemberSpendingLimit.Enabled()
+	if totalQuota > 100*preConsumedQuota && !spendingLimited {
 		// 用户额度充足，判断令牌额度是否充足
 		if !relayInfo.TokenUnlimited {
 			// 非无限令牌，判断令牌额度是否充足
@@ -414,22 +430,35 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 	if preConsumedQuota > 0 {
 		err := service.PreConsumeTokenQuota(relayInfo, preConsumedQuota)
 		if err != nil {
+			service.SettleTPM(c, relayInfo, 0)
+			if errors.Is(err, service.ErrSpendingLimitExceeded) {
+				return 0, 0, service.OpenAIErrorWrapperLocal(err, "spending_limit_exceeded", http.StatusTooManyRequests)
+			}
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
-		subscriptionUsed, quotaUsed, consumeErr := model.ConsumeUserQuota(relayInfo.UserId, preConsumedQuota)
+		subscriptionUsed, quotaUsed, consumeErr := service.ConsumeRelayQuota(relayInfo, preConsumedQuota)
 		if consumeErr != nil {
 			rollbackErr := model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, preConsumedQuota)
 			if rollbackErr != nil {
 				common.LogError(c, fmt.Sprintf("failed to rollback token pre-consume for user %d token %d: %s", relayInfo.UserId, relayInfo.TokenId, rollbackErr.Error()))
 			}
+			service.RecordSpendingUsage(relayInfo, -preConsumedQuota)
+			service.SettleTPM(c, relayInfo, 0)
 			return 0, 0, service.OpenAIErrorWrapperLocal(consumeErr, "decrease_user_quota_failed", http.StatusInternalServerError)
 		}
 		relayInfo.TrackConsumedQuota(subscriptionUsed, quotaUsed)
+	} else if spendingLimited {
+		err := service.CheckSpendingLimits(relayInfo, 0)
+		if err != nil {
+			service.SettleTPM(c, relayInfo, 0)
+			return 0, 0, service.OpenAIErrorWrapperLocal(err, "spending_limit_exceeded", http.StatusTooManyRequests)
+		}
 	}
 	return preConsumedQuota, totalQuota, nil
 }
 
 func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, userQuota int, preConsumedQuota int) {
+	service.SettleTPM(c, relayInfo, 0)
 	if preConsumedQuota != 0 {
 		gopool.Go(func() {
 			relayInfoCopy := *relayInfo
@@ -701,6 +730,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
 		}
 	}
+	service.SettleTPM(ctx, relayInfo, totalTokens)
 
 	logModel := modelName
 	if strings.HasPrefix(logModel, "gpt-4-gizmo") {
@@ -38,6 +38,7 @@ import (
 	"veloera/relay/channel/perplexity"
 	"veloera/relay/channel/siliconflow"
 	"veloera/relay/channel/task/suno"
+	"veloera/relay/channel/task/video"
 	"veloera/relay/channel/tencent"
 	"veloera/relay/channel/vertex"
 	"veloera/relay/channel/volcengine"
@@ -113,6 +114,8 @@ func GetTaskAdaptor(platform commonconstant.TaskPlatform) channel.TaskAdaptor {
 	//	return &aiproxy.Adaptor{}
 	case commonconstant.TaskPlatformSuno:
 		return &suno.TaskAdaptor{}
+	case commonconstant.TaskPlatformVideo:
+		return &video.TaskAdaptor{}
 	}
 	return nil
 }
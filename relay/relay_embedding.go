@@ -52,6 +52,17 @@ func validateEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, embed
 func EmbeddingHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	relayInfo := relaycommon.GenRelayInfo(c)
 
+	// 审计归档需在脱敏还原之前包装响应，以记录客户端实际收到的内容
+	auditCapture := service.StartAuditCapture(c)
+	defer func() {
+		service.FinishAuditCapture(c, auditCapture, relayInfo, openaiErr)
+	}()
+
+	// 发往上游前对输入文本脱敏
+	if _, err := service.MaskRequestBodyPii(c); err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "mask_request_pii_failed", http.StatusInternalServerError)
+	}
+
 	var embeddingRequest *dto.EmbeddingRequest
 	err := common.UnmarshalBodyReusable(c, &embeddingRequest)
 	if err != nil {
@@ -113,6 +124,7 @@ func EmbeddingHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
+	auditCapture.SetRequest(jsonData)
 	requestBody := bytes.NewBuffer(jsonData)
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
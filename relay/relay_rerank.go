@@ -51,6 +51,11 @@ func RerankHelper(c *gin.Context, relayMode int) (openaiErr *dto.OpenAIErrorWith
 
 	relayInfo := relaycommon.GenRelayInfoRerank(c, rerankRequest)
 
+	auditCapture := service.StartAuditCapture(c)
+	defer func() {
+		service.FinishAuditCapture(c, auditCapture, relayInfo, openaiErr)
+	}()
+
 	if rerankRequest.Query == "" {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("query is empty"), "invalid_query", http.StatusBadRequest)
 	}
@@ -97,6 +102,7 @@ func RerankHelper(c *gin.Context, relayMode int) (openaiErr *dto.OpenAIErrorWith
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
+	auditCapture.SetRequest(jsonData)
 	requestBody := bytes.NewBuffer(jsonData)
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
@@ -30,6 +30,7 @@ import (
 	"veloera/model"
 	relaycommon "veloera/relay/common"
 	relayconstant "veloera/relay/constant"
+	"veloera/relay/helper"
 	"veloera/service"
 	"veloera/setting"
 	"veloera/setting/operation_setting"
@@ -39,6 +40,9 @@ import (
 Task 任务通过平台、Action 区分任务
 */
 func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
+	if err := service.CheckAuditCaptureSupported(c); err != nil {
+		return service.TaskErrorWrapperLocal(err, "audit_capture_unsupported", http.StatusForbidden)
+	}
 	platform := constant.TaskPlatform(c.GetString("platform"))
 	relayInfo := relaycommon.GenTaskRelayInfo(c)
 
@@ -53,8 +57,26 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		return
 	}
 
+	callbackUrl, err := service.GetTaskCallbackUrl(c)
+	if err != nil {
+		return service.TaskErrorWrapperLocal(err, "invalid_callback_url", http.StatusBadRequest)
+	}
+
 	modelName := service.CoverTaskActionToModelName(platform, relayInfo.Action)
+	if platform == constant.TaskPlatformVideo {
+		// 视频任务按请求中的模型计费
+		err := helper.ModelMappedHelper(c, relayInfo.RelayInfo)
+		if err != nil {
+			return service.TaskErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
+		}
+		modelName = relayInfo.OriginModelName
+	}
 	modelPrice, success := operation_setting.GetModelPriceWithFallback(modelName, true)
+	if platform == constant.TaskPlatformVideo {
+		if videoPrice, ok := operation_setting.GetVideoPrice(modelName, relayInfo.VideoSeconds, relayInfo.VideoResolution); ok {
+			modelPrice, success = videoPrice, true
+		}
+	}
 	if !success {
 		defaultPrice, ok := operation_setting.GetDefaultModelRatioMap()[modelName]
 		if !ok {
@@ -67,7 +89,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	// 预扣
 	groupRatio := setting.GetGroupRatio(relayInfo.Group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := service.GetRelayQuota(relayInfo.RelayInfo)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
@@ -125,49 +147,58 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		return
 	}
 
-	defer func() {
-		// release quota
-		if relayInfo.ConsumeQuota && taskErr == nil {
-
-			err := service.PostConsumeQuota(relayInfo.RelayInfo, quota, 0, true)
-			if err != nil {
-				common.SysError("error consuming token remain quota: " + err.Error())
-			}
-			if quota != 0 {
-				tokenName := c.GetString("token_name")
-				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, relayInfo.Action)
-				other := make(map[string]interface{})
-				other["model_price"] = modelPrice
-				other["group_ratio"] = groupRatio
-				model.RecordConsumeLog(c, relayInfo.UserId, relayInfo.ChannelId, 0, 0,
-					modelName, tokenName, quota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
-				model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-				model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
-			}
-		}
-	}()
-
 	taskID, taskData, taskErr := adaptor.DoResponse(c, resp, relayInfo)
 	if taskErr != nil {
 		return
 	}
-	relayInfo.ConsumeQuota = true
 	// insert task
-	task := model.InitTask(constant.TaskPlatformSuno, relayInfo)
+	task := model.InitTask(platform, relayInfo)
 	task.TaskID = taskID
+	task.Action = relayInfo.Action
 	task.Quota = quota
+	task.CallbackUrl = callbackUrl
 	task.Data = taskData
+	if platform == constant.TaskPlatformVideo {
+		task.Properties = model.Properties{
+			Model:   relayInfo.OriginModelName,
+			Seconds: relayInfo.VideoSeconds,
+			Size:    relayInfo.VideoResolution,
+		}
+	}
 	err = task.Insert()
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "insert_task_failed", http.StatusInternalServerError)
 		return
 	}
+	// 先扣费再记录预留额度，预留记录需要本次从订阅额度扣除的部分
+	err = service.PostConsumeQuota(relayInfo.RelayInfo, quota, 0, true)
+	if err != nil {
+		common.SysError("error consuming token remain quota: " + err.Error())
+	}
+	if quota != 0 {
+		tokenName := c.GetString("token_name")
+		logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, relayInfo.Action)
+		other := make(map[string]interface{})
+		other["model_price"] = modelPrice
+		other["group_ratio"] = groupRatio
+		if platform == constant.TaskPlatformVideo {
+			logContent += fmt.Sprintf("，时长 %d 秒，分辨率 %s", relayInfo.VideoSeconds, relayInfo.VideoResolution)
+			other["video_seconds"] = relayInfo.VideoSeconds
+			other["video_resolution"] = relayInfo.VideoResolution
+		}
+		model.RecordConsumeLog(c, relayInfo.UserId, relayInfo.ChannelId, 0, 0,
+			modelName, tokenName, quota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
+		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
+		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+	}
+	service.ReserveTaskQuota(c, task, relayInfo.RelayInfo)
 	return nil
 }
 
 var fetchRespBuilders = map[int]func(c *gin.Context) (respBody []byte, taskResp *dto.TaskError){
-	relayconstant.RelayModeSunoFetchByID: sunoFetchByIDRespBodyBuilder,
-	relayconstant.RelayModeSunoFetch:     sunoFetchRespBodyBuilder,
+	relayconstant.RelayModeSunoFetchByID:  sunoFetchByIDRespBodyBuilder,
+	relayconstant.RelayModeSunoFetch:      sunoFetchRespBodyBuilder,
+	relayconstant.RelayModeVideoFetchByID: videoFetchByIDRespBodyBuilder,
 }
 
 func RelayTaskFetch(c *gin.Context, relayMode int) (taskResp *dto.TaskError) {
@@ -0,0 +1,181 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/relay/channel/task/video"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VideoCancelledReason 用户取消的视频任务的失败原因
+const VideoCancelledReason = "cancelled"
+
+func TaskModel2VideoDto(task *model.Task) *dto.VideoResponse {
+	progress, _ := strconv.Atoi(strings.TrimSuffix(task.Progress, "%"))
+	response := &dto.VideoResponse{
+		Id:          task.TaskID,
+		Object:      "video",
+		Model:       task.Properties.Model,
+		Progress:    progress,
+		CreatedAt:   task.SubmitTime,
+		CompletedAt: task.FinishTime,
+		Size:        task.Properties.Size,
+		Url:         task.ResultURL,
+	}
+	if task.Properties.Seconds > 0 {
+		response.Seconds = strconv.Itoa(task.Properties.Seconds)
+	}
+	switch task.Status {
+	case model.TaskStatusSuccess:
+		response.Status = "completed"
+	case model.TaskStatusFailure:
+		response.Status = "failed"
+		response.Error = &dto.VideoError{Code: "video_generation_failed", Message: task.FailReason}
+		if task.FailReason == VideoCancelledReason {
+			response.Status = "cancelled"
+			response.Error.Code = VideoCancelledReason
+		}
+	case model.TaskStatusInProgress:
+		response.Status = "in_progress"
+	default:
+		response.Status = "queued"
+	}
+	return response
+}
+
+func getUserVideoTask(c *gin.Context) (*model.Task, *dto.TaskError) {
+	task, exist, err := model.GetByTaskId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return nil, service.TaskErrorWrapper(err, "get_task_failed", http.StatusInternalServerError)
+	}
+	if !exist || task.Platform != constant.TaskPlatformVideo {
+		return nil, service.TaskErrorWrapperLocal(errors.New("task_not_exist"), "task_not_exist", http.StatusNotFound)
+	}
+	return task, nil
+}
+
+func getVideoTaskChannel(task *model.Task) (*model.Channel, *video.TaskAdaptor, *dto.TaskError) {
+	channel, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil {
+		return nil, nil, service.TaskErrorWrapperLocal(err, "channel_not_found", http.StatusBadRequest)
+	}
+	return channel, &video.TaskAdaptor{ChannelType: channel.Type}, nil
+}
+
+func videoFetchByIDRespBodyBuilder(c *gin.Context) (respBody []byte, taskResp *dto.TaskError) {
+	task, taskErr := getUserVideoTask(c)
+	if taskErr != nil {
+		return nil, taskErr
+	}
+	respBody, err := json.Marshal(TaskModel2VideoDto(task))
+	if err != nil {
+		taskResp = service.TaskErrorWrapper(err, "marshal_response_failed", http.StatusInternalServerError)
+	}
+	return
+}
+
+// RelayVideoContent 返回视频文件，已缓存公开地址时直接跳转，否则携带渠道密钥从上游下载
+func RelayVideoContent(c *gin.Context) *dto.TaskError {
+	task, taskErr := getUserVideoTask(c)
+	if taskErr != nil {
+		return taskErr
+	}
+	if task.Status != model.TaskStatusSuccess {
+		return service.TaskErrorWrapperLocal(errors.New("task_not_completed"), "task_not_completed", http.StatusBadRequest)
+	}
+	if task.ResultURL != "" {
+		c.Redirect(http.StatusFound, task.ResultURL)
+		return nil
+	}
+	channel, adaptor, taskErr := getVideoTaskChannel(task)
+	if taskErr != nil {
+		return taskErr
+	}
+	resp, ok, err := adaptor.FetchContent(c.Request.Context(), channel.GetBaseURL(), channel.Key, task.TaskID, c.Query("variant"))
+	if !ok {
+		return service.TaskErrorWrapperLocal(errors.New("content_not_found"), "content_not_found", http.StatusNotFound)
+	}
+	if err != nil {
+		return service.TaskErrorWrapper(err, "fetch_content_failed", http.StatusInternalServerError)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return service.TaskErrorWrapper(fmt.Errorf("%s", responseBody), "fetch_content_failed", resp.StatusCode)
+	}
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+		if value := resp.Header.Get(key); value != "" {
+			c.Writer.Header().Set(key, value)
+		}
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Writer, resp.Body)
+	if err != nil {
+		return service.TaskErrorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// RelayVideoCancel 取消未完成的视频任务并退还额度
+func RelayVideoCancel(c *gin.Context) *dto.TaskError {
+	task, taskErr := getUserVideoTask(c)
+	if taskErr != nil {
+		return taskErr
+	}
+	if task.Progress == "100%" {
+		return service.TaskErrorWrapperLocal(errors.New("task_already_finished"), "task_already_finished", http.StatusBadRequest)
+	}
+	channel, adaptor, taskErr := getVideoTaskChannel(task)
+	if taskErr != nil {
+		return taskErr
+	}
+	err := adaptor.CancelTask(channel.GetBaseURL(), channel.Key, task.TaskID, task.Action)
+	if errors.Is(err, video.ErrCancelNotSupported) {
+		return service.TaskErrorWrapperLocal(err, "cancel_not_supported", http.StatusBadRequest)
+	}
+	if err != nil {
+		return service.TaskErrorWrapper(err, "cancel_task_failed", http.StatusInternalServerError)
+	}
+
+	task.Status = model.TaskStatusFailure
+	task.FailReason = VideoCancelledReason
+	task.Progress = "100%"
+	task.FinishTime = time.Now().Unix()
+	updated, err := task.UpdateUnfinished()
+	if err != nil {
+		return service.TaskErrorWrapper(err, "update_task_failed", http.StatusInternalServerError)
+	}
+	if updated {
+		service.SettleTask(c, task, "已取消")
+		service.NotifyTaskFinished(task)
+	}
+	c.JSON(http.StatusOK, TaskModel2VideoDto(task))
+	return nil
+}
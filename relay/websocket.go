@@ -32,6 +32,10 @@ import (
 )
 
 func WssHelper(c *gin.Context, ws *websocket.Conn) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	// 实时会话为双向流，暂不支持审计归档
+	if err := service.CheckAuditCaptureSupported(c); err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "audit_capture_unsupported", http.StatusForbidden)
+	}
 	relayInfo := relaycommon.GenRelayInfoWs(c, ws)
 
 	// get & validate textRequest 获取并验证文本请求
@@ -34,6 +34,8 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
 		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.GET("/status/queue", middleware.AdminAuth(), controller.GetRequestQueueStatus)
+		apiRouter.GET("/status/models", controller.GetModelStatus)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/about", controller.GetAbout)
 		//apiRouter.GET("/midjourney", controller.GetMidjourney)
@@ -60,6 +62,9 @@ func SetApiRouter(router *gin.Engine) {
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
 			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.GET("/payment/:provider/notify", controller.PaymentNotify)
+			userRoute.POST("/payment/:provider/notify", controller.PaymentNotify)
+			userRoute.GET("/payment/paypal/return", controller.PayPalReturn)
 			userRoute.GET("/groups", controller.GetUserGroups)
 
 			selfRoute := userRoute.Group("/")
@@ -67,6 +72,7 @@ func SetApiRouter(router *gin.Engine) {
 			{
 				selfRoute.GET("/self/groups", controller.GetUserGroups)
 				selfRoute.GET("/self", controller.GetSelf)
+				selfRoute.GET("/self/spending", controller.GetSelfSpending)
 				selfRoute.GET("/models", controller.GetUserModels)
 				selfRoute.PUT("/self", controller.UpdateSelf)
 				selfRoute.DELETE("/self", controller.DeleteSelf)
@@ -74,11 +80,15 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
 				selfRoute.POST("/pay", controller.RequestEpay)
+				selfRoute.GET("/payment/providers", controller.GetPaymentProviders)
+				selfRoute.POST("/payment", controller.RequestPayment)
 				selfRoute.POST("/amount", controller.RequestAmount)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
 				selfRoute.GET("/check_in_status", controller.CheckInStatus)
 				selfRoute.POST("/check_in", controller.CheckIn)
+				selfRoute.GET("/self/statement", controller.GetSelfStatements)
+				selfRoute.GET("/self/statement/:period", controller.GetSelfStatement)
 			}
 
 			adminRoute := userRoute.Group("/")
@@ -86,9 +96,13 @@ func SetApiRouter(router *gin.Engine) {
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/search", controller.SearchUsers)
+				adminRoute.GET("/statement", controller.GetAllStatements)
+				adminRoute.GET("/statement/:user_id/:period", controller.GetUserStatement)
+				adminRoute.POST("/statement/send", controller.SendStatements)
 				adminRoute.GET("/:id", controller.GetUser)
 				adminRoute.POST("/", controller.CreateUser)
 				adminRoute.POST("/manage", controller.ManageUser)
+				adminRoute.POST("/topup/refund", controller.RefundTopUp)
 				adminRoute.PUT("/", controller.UpdateUser)
 				adminRoute.DELETE("/:id", controller.DeleteUser)
 			}
@@ -126,6 +140,18 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/test/jobs/:id/delete_failed", controller.DeleteFailedModelsByJob)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+			channelRoute.GET("/balance_providers", controller.GetChannelBalanceProviders)
+			channelRoute.GET("/probe/stats", controller.GetChannelProbeStats)
+			channelRoute.GET("/probe/history", controller.GetChannelProbeHistory)
+			channelRoute.GET("/probe/latest", controller.GetLatestChannelProbes)
+			channelRoute.GET("/probe/breaches", controller.GetChannelSLOBreaches)
+			channelRoute.POST("/probe/run", controller.RunChannelProbe)
+			channelRoute.GET("/capabilities", controller.GetChannelCapabilities)
+			channelRoute.GET("/fingerprints", controller.GetChannelFingerprints)
+			channelRoute.POST("/fingerprint/run", controller.RunChannelFingerprint)
+			channelRoute.GET("/fingerprint/baselines", controller.GetFingerprintBaselines)
+			channelRoute.POST("/fingerprint/baseline", controller.CaptureFingerprintBaseline)
+			channelRoute.DELETE("/fingerprint/baseline", controller.DeleteFingerprintBaseline)
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
@@ -156,6 +182,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
+			tokenRoute.GET("/:id/spending", controller.GetTokenSpending)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
@@ -169,19 +196,80 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.DELETE("/delete-by-name", controller.DeleteRedemptionsByName)
 			redemptionRoute.PUT("/batch-disable", controller.BatchDisableRedemptions)
 			redemptionRoute.DELETE("/delete-disabled", controller.DeleteDisabledRedemptions)
+			redemptionRoute.GET("/campaign", controller.GetAllRedemptionCampaigns)
+			redemptionRoute.POST("/campaign", controller.AddRedemptionCampaign)
+			redemptionRoute.PUT("/campaign", controller.UpdateRedemptionCampaign)
+			redemptionRoute.DELETE("/campaign/:id", controller.DeleteRedemptionCampaign)
+			redemptionRoute.GET("/campaign/:id/stats", controller.GetRedemptionCampaignStats)
 			redemptionRoute.GET("/:id", controller.GetRedemption)
 			redemptionRoute.POST("/", controller.AddRedemption)
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+			subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+			subscriptionRoute.POST("/purchase", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.PurchaseSubscription)
+			subscriptionRoute.GET("/", middleware.AdminAuth(), controller.GetAllSubscriptions)
+			subscriptionRoute.POST("/cancel", middleware.AdminAuth(), controller.CancelSubscription)
+			subscriptionRoute.GET("/plan", middleware.AdminAuth(), controller.GetAllSubscriptionPlans)
+			subscriptionRoute.POST("/plan", middleware.AdminAuth(), controller.AddSubscriptionPlan)
+			subscriptionRoute.PUT("/plan", middleware.AdminAuth(), controller.UpdateSubscriptionPlan)
+			subscriptionRoute.DELETE("/plan/:id", middleware.AdminAuth(), controller.DeleteSubscriptionPlan)
+		}
+		organizationRoute := apiRouter.Group("/organization")
+		organizationRoute.GET("/all", middleware.AdminAuth(), controller.GetAllOrganizations)
+		organizationRoute.PUT("/:id/manage", middleware.AdminAuth(), controller.ManageOrganization)
+		organizationRoute.Use(middleware.UserAuth())
+		{
+			organizationRoute.GET("/", controller.GetSelfOrganizations)
+			organizationRoute.POST("/", controller.CreateOrganization)
+			organizationRoute.GET("/invitation", controller.GetSelfOrganizationInvitations)
+			organizationRoute.POST("/invitation/:invitation_id/accept", controller.AcceptOrganizationInvitation)
+			organizationRoute.DELETE("/invitation/:invitation_id", controller.DeclineOrganizationInvitation)
+			organizationRoute.GET("/:id", controller.GetOrganization)
+			organizationRoute.PUT("/:id", controller.UpdateOrganization)
+			organizationRoute.DELETE("/:id", controller.DeleteOrganization)
+			organizationRoute.GET("/:id/members", controller.GetOrganizationMembers)
+			organizationRoute.POST("/:id/members", controller.AddOrganizationMember)
+			organizationRoute.PUT("/:id/members/:member_id", controller.UpdateOrganizationMember)
+			organizationRoute.DELETE("/:id/members/:member_id", controller.RemoveOrganizationMember)
+			organizationRoute.GET("/:id/invitations", controller.GetOrganizationInvitations)
+			organizationRoute.DELETE("/:id/invitations/:invitation_id", controller.CancelOrganizationInvitation)
+			organizationRoute.POST("/:id/fund", controller.FundOrganization)
+			organizationRoute.POST("/:id/withdraw", controller.WithdrawOrganization)
+			organizationRoute.GET("/:id/logs", controller.GetOrganizationLogs)
+			organizationRoute.GET("/:id/tokens", controller.GetOrganizationTokens)
+		}
+		quotaLedgerRoute := apiRouter.Group("/quota_ledger")
+		quotaLedgerRoute.GET("/", middleware.AdminAuth(), controller.GetQuotaLedgerEntries)
+		quotaLedgerRoute.GET("/check", middleware.AdminAuth(), controller.CheckQuotaLedger)
+		quotaLedgerRoute.POST("/reconcile", middleware.RootAuth(), controller.ReconcileQuotaLedger)
+		quotaLedgerRoute.GET("/self", middleware.UserAuth(), controller.GetSelfQuotaLedgerEntries)
+		auditRoute := apiRouter.Group("/audit")
+		auditRoute.Use(middleware.RootAuth())
+		{
+			auditRoute.GET("/", controller.GetAuditRecords)
+			auditRoute.GET("/:id", controller.GetAuditRecord)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/margin", middleware.AdminAuth(), controller.GetLogsMarginStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		logRoute.POST("/export", middleware.AdminAuth(), controller.CreateUsageExport)
+		logRoute.GET("/export", middleware.AdminAuth(), controller.GetUsageExports)
+		logRoute.GET("/export/:id/download", middleware.AdminAuth(), controller.DownloadUsageExport)
+		logRoute.DELETE("/export/:id", middleware.AdminAuth(), controller.DeleteUsageExport)
+		logRoute.POST("/self/export", middleware.UserAuth(), controller.CreateUserUsageExport)
+		logRoute.GET("/self/export", middleware.UserAuth(), controller.GetUserUsageExports)
+		logRoute.GET("/self/export/:id/download", middleware.UserAuth(), controller.DownloadUserUsageExport)
+		logRoute.DELETE("/self/export/:id", middleware.UserAuth(), controller.DeleteUserUsageExport)
 
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
@@ -216,6 +304,12 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
 			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
+			taskRoute.GET("/webhook/self", middleware.UserAuth(), controller.GetUserWebhookDeliveries)
+			taskRoute.GET("/webhook", middleware.AdminAuth(), controller.GetAllWebhookDeliveries)
+			taskRoute.POST("/webhook/:id/retry", middleware.UserAuth(), controller.RetryWebhookDelivery)
+			taskRoute.GET("/settlement", middleware.AdminAuth(), controller.GetAllTaskSettlements)
+			taskRoute.POST("/reconcile", middleware.AdminAuth(), controller.ReconcileTasks)
+			taskRoute.GET("/poller", middleware.AdminAuth(), controller.GetTaskPollerStatus)
 		}
 
 		// User message routes
@@ -42,7 +42,7 @@ func SetRelayRouter(router *gin.Engine) {
 
 		// HTTP 路由
 		httpRouter := v1Router.Group("")
-		httpRouter.Use(middleware.Distribute())
+		httpRouter.Use(middleware.Distribute(), middleware.Moderation())
 		httpRouter.POST("/messages", controller.RelayClaude)
 		httpRouter.POST("/completions", controller.Relay)
 		httpRouter.POST("/chat/completions", controller.Relay)
@@ -69,6 +69,10 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.DELETE("/models/:model", controller.RelayNotImplemented)
 		httpRouter.POST("/moderations", controller.Relay)
 		httpRouter.POST("/rerank", controller.Relay)
+		httpRouter.POST("/videos", controller.RelayTask)
+		httpRouter.GET("/videos/:id", controller.RelayTask)
+		httpRouter.GET("/videos/:id/content", controller.RelayTask)
+		httpRouter.POST("/videos/:id/cancel", controller.RelayTask)
 
 		// Token count route (no channel distribution needed)
 		v1Router.POST("/messages/count_tokens", controller.RelayTokenCount)
@@ -88,7 +92,7 @@ func SetRelayRouter(router *gin.Engine) {
 	}
 
 	geminiActionRouter := router.Group("/v1beta/models")
-	geminiActionRouter.Use(middleware.TokenAuth(), middleware.TokenRateLimit(), middleware.ModelRequestRateLimit(), middleware.Distribute())
+	geminiActionRouter.Use(middleware.TokenAuth(), middleware.TokenRateLimit(), middleware.ModelRequestRateLimit(), middleware.UserRequestRateLimit(), middleware.Distribute(), middleware.Moderation())
 	{
 		geminiActionRouter.POST("/:model", controller.RelayGemini)
 	}
@@ -103,6 +107,7 @@ func SetRelayRouter(router *gin.Engine) {
 	relayV1Router.Use(middleware.TokenAuth())
 	relayV1Router.Use(middleware.TokenRateLimit())
 	relayV1Router.Use(middleware.ModelRequestRateLimit())
+	relayV1Router.Use(middleware.UserRequestRateLimit())
 	setupV1Router(relayV1Router)
 
 	// 设置 /hf/v1 路由组
@@ -110,12 +115,24 @@ func SetRelayRouter(router *gin.Engine) {
 	relayHfV1Router.Use(middleware.TokenAuth())
 	relayHfV1Router.Use(middleware.TokenRateLimit())
 	relayHfV1Router.Use(middleware.ModelRequestRateLimit())
+	relayHfV1Router.Use(middleware.UserRequestRateLimit())
 	setupV1Router(relayHfV1Router)
 
 	playgroundRouter := router.Group("/pg")
 	playgroundRouter.Use(middleware.UserAuth())
 	{
 		playgroundRouter.POST("/chat/completions", controller.Playground)
+		playgroundRouter.POST("/completions", controller.Playground)
+		playgroundRouter.POST("/embeddings", controller.Playground)
+		playgroundRouter.POST("/images/generations", controller.Playground)
+		playgroundRouter.POST("/audio/speech", controller.Playground)
+		playgroundRouter.POST("/audio/transcriptions", controller.Playground)
+		playgroundRouter.POST("/audio/translations", controller.Playground)
+		playgroundRouter.POST("/rerank", controller.Playground)
+		playgroundRouter.POST("/moderations", controller.Playground)
+		playgroundRouter.POST("/responses", controller.Playground)
+		playgroundRouter.POST("/messages", controller.Playground)
+		playgroundRouter.GET("/trace/:id", controller.GetPlaygroundTrace)
 	}
 
 	relayMjRouter := router.Group("/mj")
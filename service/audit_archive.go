@@ -0,0 +1,613 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+	"veloera/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const auditSealVersion = 1
+
+const auditPurgeBatchSize = 500
+
+// auditKey 归档加密密钥，由 AUDIT_ENCRYPTION_KEY 派生。未设置时不进行归档，
+// 避免使用重启后会变化的随机密钥导致归档无法解密
+func auditKey() [32]byte {
+	return sha256.Sum256([]byte("veloera-audit:" + common.AuditEncryptionKey))
+}
+
+// legacyAuditKey 旧版本在未设置 AUDIT_ENCRYPTION_KEY 时由 CRYPTO_SECRET 派生的密钥，仅用于解密已有归档
+func legacyAuditKey() [32]byte {
+	return sha256.Sum256([]byte("veloera-audit:" + common.CryptoSecret))
+}
+
+// sealAuditBody 压缩后使用 AES-GCM 加密，格式为 版本号 | nonce | 密文
+func sealAuditBody(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	key := auditKey()
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, 1+gcm.NonceSize(), 1+gcm.NonceSize()+compressed.Len()+gcm.Overhead())
+	sealed[0] = auditSealVersion
+	if _, err = rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(sealed, sealed[1:], compressed.Bytes(), nil), nil
+}
+
+func openAuditBody(sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	if sealed[0] != auditSealVersion {
+		return nil, fmt.Errorf("unsupported audit body version %d", sealed[0])
+	}
+	compressed, err := openAuditSealed(sealed, auditKey())
+	if err != nil {
+		compressed, err = openAuditSealed(sealed, legacyAuditKey())
+	}
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func openAuditSealed(sealed []byte, key [32]byte) ([]byte, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 1+gcm.NonceSize() {
+		return nil, errors.New("audit body is corrupted")
+	}
+	nonce := sealed[1 : 1+gcm.NonceSize()]
+	compressed, err := gcm.Open(nil, nonce, sealed[1+gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt audit body, the encryption key may have changed")
+	}
+	return compressed, nil
+}
+
+type auditToolCall struct {
+	Id        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// auditStreamReassembler 将 OpenAI、Claude、Gemini 格式的流式响应重组为完整结果
+type auditStreamReassembler struct {
+	limit        int
+	line         bytes.Buffer
+	events       int
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolKeys     []string
+	toolCalls    map[string]*auditToolCall
+	finishReason string
+	usage        any
+	truncated    bool
+}
+
+func (r *auditStreamReassembler) write(p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.line.Write(p)
+			return
+		}
+		r.line.Write(p[:i])
+		r.handleLine(strings.TrimSuffix(r.line.String(), "\r"))
+		r.line.Reset()
+		p = p[i+1:]
+	}
+}
+
+func (r *auditStreamReassembler) appendText(builder *strings.Builder, v any) {
+	text, ok := v.(string)
+	if !ok || text == "" {
+		return
+	}
+	if builder.Len()+len(text) > r.limit {
+		r.truncated = true
+		return
+	}
+	builder.WriteString(text)
+}
+
+func (r *auditStreamReassembler) toolCall(key string) *auditToolCall {
+	call, ok := r.toolCalls[key]
+	if !ok {
+		call = &auditToolCall{}
+		r.toolCalls[key] = call
+		r.toolKeys = append(r.toolKeys, key)
+	}
+	return call
+}
+
+func (r *auditStreamReassembler) handleLine(line string) {
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(line[len("data:"):])
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	var event map[string]any
+	if err := common.DecodeJsonStr(data, &event); err != nil {
+		return
+	}
+	r.events++
+	if usage, ok := event["usage"]; ok && usage != nil {
+		r.usage = usage
+	}
+	if usage, ok := event["usageMetadata"]; ok {
+		r.usage = usage
+	}
+	// OpenAI
+	if choices, ok := event["choices"].([]any); ok {
+		for _, item := range choices {
+			choice, _ := item.(map[string]any)
+			if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+				r.finishReason = reason
+			}
+			r.appendText(&r.content, choice["text"])
+			delta, _ := choice["delta"].(map[string]any)
+			r.appendText(&r.content, delta["content"])
+			r.appendText(&r.reasoning, delta["reasoning_content"])
+			toolCalls, _ := delta["tool_calls"].([]any)
+			for _, t := range toolCalls {
+				tool, _ := t.(map[string]any)
+				call := r.toolCall(fmt.Sprint("c", tool["index"]))
+				if id, ok := tool["id"].(string); ok && id != "" {
+					call.Id = id
+				}
+				function, _ := tool["function"].(map[string]any)
+				if name, ok := function["name"].(string); ok && name != "" {
+					call.Name = name
+				}
+				if arguments, ok := function["arguments"].(string); ok {
+					call.Arguments += arguments
+				}
+			}
+		}
+		return
+	}
+	// Gemini
+	if candidates, ok := event["candidates"].([]any); ok {
+		for _, item := range candidates {
+			candidate, _ := item.(map[string]any)
+			if reason, ok := candidate["finishReason"].(string); ok && reason != "" {
+				r.finishReason = reason
+			}
+			content, _ := candidate["content"].(map[string]any)
+			parts, _ := content["parts"].([]any)
+			for _, p := range parts {
+				part, _ := p.(map[string]any)
+				if functionCall, ok := part["functionCall"].(map[string]any); ok {
+					call := r.toolCall(fmt.Sprint("g", len(r.toolKeys)))
+					call.Name, _ = functionCall["name"].(string)
+					arguments, _ := json.Marshal(functionCall["args"])
+					call.Arguments = string(arguments)
+				} else if part["thought"] == true {
+					r.appendText(&r.reasoning, part["text"])
+				} else {
+					r.appendText(&r.content, part["text"])
+				}
+			}
+		}
+		return
+	}
+	// Claude
+	index := fmt.Sprint("a", event["index"])
+	switch event["type"] {
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]any)
+		if block["type"] == "tool_use" {
+			call := r.toolCall(index)
+			call.Id, _ = block["id"].(string)
+			call.Name, _ = block["name"].(string)
+		}
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]any)
+		switch delta["type"] {
+		case "text_delta":
+			r.appendText(&r.content, delta["text"])
+		case "thinking_delta":
+			r.appendText(&r.reasoning, delta["thinking"])
+		case "input_json_delta":
+			if partial, ok := delta["partial_json"].(string); ok {
+				r.toolCall(index).Arguments += partial
+			}
+		}
+	case "message_delta":
+		delta, _ := event["delta"].(map[string]any)
+		if reason, ok := delta["stop_reason"].(string); ok && reason != "" {
+			r.finishReason = reason
+		}
+	}
+}
+
+func (r *auditStreamReassembler) result() []byte {
+	if r.line.Len() > 0 {
+		r.handleLine(strings.TrimSuffix(r.line.String(), "\r"))
+		r.line.Reset()
+	}
+	result := map[string]any{
+		"stream":        true,
+		"events":        r.events,
+		"content":       r.content.String(),
+		"finish_reason": r.finishReason,
+	}
+	if r.reasoning.Len() > 0 {
+		result["reasoning_content"] = r.reasoning.String()
+	}
+	if len(r.toolKeys) > 0 {
+		toolCalls := make([]*auditToolCall, 0, len(r.toolKeys))
+		for _, key := range r.toolKeys {
+			toolCalls = append(toolCalls, r.toolCalls[key])
+		}
+		result["tool_calls"] = toolCalls
+	}
+	if r.usage != nil {
+		result["usage"] = r.usage
+	}
+	data, _ := json.Marshal(result)
+	return data
+}
+
+// AuditCapture 单次上游请求的归档内容
+type AuditCapture struct {
+	limit        int
+	request      []byte
+	requestSize  int
+	response     bytes.Buffer
+	responseSize int
+	stream       *auditStreamReassembler
+	truncated    bool
+}
+
+// SetRequest 记录转换后发往上游的请求体
+func (a *AuditCapture) SetRequest(body []byte) {
+	if a == nil {
+		return
+	}
+	a.requestSize = len(body)
+	if len(body) > a.limit {
+		body = body[:a.limit]
+		a.truncated = true
+	}
+	a.request = append([]byte(nil), body...)
+}
+
+func (a *AuditCapture) writeResponse(header http.Header, p []byte) {
+	a.responseSize += len(p)
+	if a.stream == nil && a.response.Len() == 0 && strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		a.stream = &auditStreamReassembler{limit: a.limit, toolCalls: make(map[string]*auditToolCall)}
+	}
+	if a.stream != nil {
+		a.stream.write(p)
+		return
+	}
+	if remain := a.limit - a.response.Len(); remain < len(p) {
+		p = p[:max(remain, 0)]
+		a.truncated = true
+	}
+	a.response.Write(p)
+}
+
+func (a *AuditCapture) responseBody() []byte {
+	if a.stream != nil {
+		body := a.stream.result()
+		a.truncated = a.truncated || a.stream.truncated
+		return body
+	}
+	return a.response.Bytes()
+}
+
+// auditWriter 在写出响应的同时记录响应内容
+type auditWriter struct {
+	gin.ResponseWriter
+	capture *AuditCapture
+}
+
+func (w *auditWriter) Write(p []byte) (int, error) {
+	w.capture.writeResponse(w.Header(), p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.capture.writeResponse(w.Header(), []byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// ShouldCaptureAudit 令牌单独设置优先，否则按分组设置。未设置 AUDIT_ENCRYPTION_KEY 时不归档
+func ShouldCaptureAudit(c *gin.Context) bool {
+	if !operation_setting.GetAuditArchiveSetting().Enabled || common.AuditEncryptionKey == "" {
+		return false
+	}
+	switch c.GetInt("token_audit_capture") {
+	case model.TokenAuditCaptureEnabled:
+		return true
+	case model.TokenAuditCaptureDisabled:
+		return false
+	}
+	return operation_setting.AuditArchiveEnabledForGroup(c.GetString("group"))
+}
+
+// CheckAuditCaptureSupported 音频、Realtime、Midjourney 与异步任务接口不支持归档，
+// 需要归档的请求访问这些接口时拒绝，避免绕过审计
+func CheckAuditCaptureSupported(c *gin.Context) error {
+	if ShouldCaptureAudit(c) {
+		return errors.New("当前令牌或分组已开启审计归档，该接口不支持归档")
+	}
+	return nil
+}
+
+// StartAuditCapture 开始记录本次上游请求，未启用归档时返回 nil。
+// 需在其他响应包装之前调用，以记录客户端实际收到的内容；重试时重新开始记录
+func StartAuditCapture(c *gin.Context) *AuditCapture {
+	if !ShouldCaptureAudit(c) {
+		return nil
+	}
+	limit := operation_setting.GetAuditArchiveSetting().MaxBodyBytes
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	capture := &AuditCapture{limit: limit}
+	if existing, ok := c.Get(constant.ContextKeyAuditWriter); ok {
+		existing.(*auditWriter).capture = capture
+	} else {
+		writer := &auditWriter{ResponseWriter: c.Writer, capture: capture}
+		c.Writer = writer
+		c.Set(constant.ContextKeyAuditWriter, writer)
+	}
+	return capture
+}
+
+// FinishAuditCapture 在后台保存归档，请求未发往上游时不保存
+func FinishAuditCapture(c *gin.Context, capture *AuditCapture, info *relaycommon.RelayInfo, openaiErr *dto.OpenAIErrorWithStatusCode) {
+	if capture == nil || capture.request == nil {
+		return
+	}
+	record := &model.AuditRecord{
+		RequestId:    c.GetString(common.RequestIdKey),
+		UserId:       info.UserId,
+		Username:     c.GetString("username"),
+		TokenId:      info.TokenId,
+		TokenName:    c.GetString("token_name"),
+		Group:        c.GetString("group"),
+		ModelName:    info.OriginModelName,
+		ChannelId:    info.ChannelId,
+		IsStream:     info.IsStream,
+		StatusCode:   c.Writer.Status(),
+		Storage:      operation_setting.GetAuditArchiveSetting().Storage,
+		RequestSize:  capture.requestSize,
+		ResponseSize: capture.responseSize,
+		CreatedAt:    common.GetTimestamp(),
+	}
+	if openaiErr != nil {
+		record.StatusCode = openaiErr.StatusCode
+		record.Error = openaiErr.Error.Message
+	}
+	request := capture.request
+	response := append([]byte(nil), capture.responseBody()...)
+	record.Truncated = capture.truncated
+	gopool.Go(func() {
+		if err := saveAuditRecord(record, request, response); err != nil {
+			common.SysError("failed to save audit record: " + err.Error())
+		}
+	})
+}
+
+func saveAuditRecord(record *model.AuditRecord, request []byte, response []byte) error {
+	sealedRequest, err := sealAuditBody(request)
+	if err != nil {
+		return err
+	}
+	sealedResponse, err := sealAuditBody(response)
+	if err != nil {
+		return err
+	}
+	if record.Storage != operation_setting.AuditStorageFile {
+		record.Storage = operation_setting.AuditStorageDB
+		record.RequestData = sealedRequest
+		record.ResponseData = sealedResponse
+		return record.Insert()
+	}
+	name := record.RequestId
+	if name == "" {
+		name = common.GetUUID()
+	}
+	record.FilePath = filepath.Join(time.Unix(record.CreatedAt, 0).Format("2006/01/02"),
+		fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	dir := filepath.Join(common.AuditDir, filepath.Dir(record.FilePath))
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	base := filepath.Join(common.AuditDir, record.FilePath)
+	if err = os.WriteFile(base+".req", sealedRequest, 0600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(base+".resp", sealedResponse, 0600); err != nil {
+		_ = os.Remove(base + ".req")
+		return err
+	}
+	if err = record.Insert(); err != nil {
+		removeAuditFiles(record)
+		return err
+	}
+	return nil
+}
+
+func removeAuditFiles(record *model.AuditRecord) {
+	if record.Storage != operation_setting.AuditStorageFile || record.FilePath == "" {
+		return
+	}
+	base := filepath.Join(common.AuditDir, record.FilePath)
+	for _, suffix := range []string{".req", ".resp"} {
+		if err := os.Remove(base + suffix); err != nil && !os.IsNotExist(err) {
+			common.SysError("failed to remove audit file: " + err.Error())
+		}
+	}
+}
+
+// ReadAuditRecordBodies 解密归档的请求体与响应体
+func ReadAuditRecordBodies(record *model.AuditRecord) (request []byte, response []byte, err error) {
+	sealedRequest, sealedResponse := record.RequestData, record.ResponseData
+	if record.Storage == operation_setting.AuditStorageFile {
+		base := filepath.Join(common.AuditDir, record.FilePath)
+		if sealedRequest, err = os.ReadFile(base + ".req"); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil, errors.New("归档文件不在本节点的 AUDIT_DIR 中，多节点部署请将 AUDIT_DIR 配置为共享目录")
+			}
+			return nil, nil, err
+		}
+		if sealedResponse, err = os.ReadFile(base + ".resp"); err != nil {
+			return nil, nil, err
+		}
+	}
+	if request, err = openAuditBody(sealedRequest); err != nil {
+		return nil, nil, err
+	}
+	if response, err = openAuditBody(sealedResponse); err != nil {
+		return nil, nil, err
+	}
+	return request, response, nil
+}
+
+// RunAuditRetentionJob 每小时删除超过保留天数的归档，保留天数为 0 时永久保存。
+// 所有节点都需运行：主节点清理数据库记录，每个节点清理本地 AUDIT_DIR 中的过期文件
+func RunAuditRetentionJob() {
+	for {
+		if common.IsMasterNode {
+			purgeExpiredAuditRecords()
+		}
+		purgeExpiredAuditFiles()
+		time.Sleep(time.Hour)
+	}
+}
+
+// purgeExpiredAuditFiles 按 年/月/日 目录删除本节点早于保留天数的归档文件，
+// 文件存储未使用共享目录时其他节点写入的文件只能由其自身清理
+func purgeExpiredAuditFiles() {
+	days := operation_setting.GetAuditArchiveSetting().RetentionDays
+	if days <= 0 {
+		return
+	}
+	// 目录日期早于截止日期的整天文件均已过期
+	cutoff := time.Now().AddDate(0, 0, -days).Format("2006/01/02")
+	dayDirs, err := filepath.Glob(filepath.Join(common.AuditDir, "[0-9][0-9][0-9][0-9]", "[0-9][0-9]", "[0-9][0-9]"))
+	if err != nil {
+		return
+	}
+	removed := 0
+	for _, dayDir := range dayDirs {
+		rel, err := filepath.Rel(common.AuditDir, dayDir)
+		if err != nil || filepath.ToSlash(rel) >= cutoff {
+			continue
+		}
+		if err = os.RemoveAll(dayDir); err != nil {
+			common.SysError("failed to remove audit directory: " + err.Error())
+			continue
+		}
+		removed++
+		// 移除随之变空的月、年目录
+		monthDir := filepath.Dir(dayDir)
+		if os.Remove(monthDir) == nil {
+			_ = os.Remove(filepath.Dir(monthDir))
+		}
+	}
+	if removed > 0 {
+		common.SysLog(fmt.Sprintf("purged %d expired audit file directories", removed))
+	}
+}
+
+func purgeExpiredAuditRecords() {
+	days := operation_setting.GetAuditArchiveSetting().RetentionDays
+	if days <= 0 {
+		return
+	}
+	before := time.Now().AddDate(0, 0, -days).Unix()
+	for {
+		records, err := model.GetExpiredAuditRecords(before, auditPurgeBatchSize)
+		if err != nil {
+			common.SysError("failed to query expired audit records: " + err.Error())
+			return
+		}
+		if len(records) == 0 {
+			return
+		}
+		ids := make([]int, 0, len(records))
+		for _, record := range records {
+			removeAuditFiles(record)
+			ids = append(ids, record.Id)
+		}
+		if err = model.DeleteAuditRecords(ids); err != nil {
+			common.SysError("failed to delete expired audit records: " + err.Error())
+			return
+		}
+		common.SysLog(fmt.Sprintf("purged %d expired audit records", len(ids)))
+		if len(records) < auditPurgeBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"fmt"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/service"
+)
+
+const (
+	AlertActionNotify        = service.ChannelAlertActionNotify
+	AlertActionDisable       = service.ChannelAlertActionDisable
+	AlertActionLowerPriority = service.ChannelAlertActionLowerPriority
+)
+
+// 渠道 other_info 中记录余额告警时间的字段，告警只在余额跌破阈值时触发一次，余额恢复后清除
+const otherInfoBalanceAlertTime = "balance_alert_time"
+
+const balanceDisableReason = "余额低于告警阈值"
+
+func formatAlertNotifyType(channelId int) string {
+	return fmt.Sprintf("%s_%d_balance", dto.NotifyTypeChannelUpdate, channelId)
+}
+
+func balanceAlert(channel *model.Channel, config *Config) *service.ChannelAlert {
+	return &service.ChannelAlert{
+		Source:        "balance",
+		TimeKey:       otherInfoBalanceAlertTime,
+		Action:        config.Action,
+		Priority:      config.AlertPriority,
+		DisableReason: balanceDisableReason,
+		NotifyType:    formatAlertNotifyType(channel.Id),
+	}
+}
+
+// IsDisabledByBalanceAlert 渠道是否在余额告警期间被自动禁用，定时刷新余额时需包含此类渠道以便余额恢复后重新启用
+func IsDisabledByBalanceAlert(channel *model.Channel) bool {
+	if channel.Status != common.ChannelStatusAutoDisabled {
+		return false
+	}
+	_, alerting := channel.GetOtherInfo()[otherInfoBalanceAlertTime]
+	return alerting
+}
+
+// checkBalanceAlert 余额跌破阈值时按设置通知、禁用渠道或降低优先级，余额恢复后撤销降级并重新启用
+func checkBalanceAlert(channel *model.Channel, config *Config, balance float64) {
+	if config.Threshold <= 0 {
+		return
+	}
+	info := channel.GetOtherInfo()
+	_, alerting := info[otherInfoBalanceAlertTime]
+	if balance < config.Threshold {
+		if !alerting {
+			service.TriggerChannelAlert(channel, info, balanceAlert(channel, config),
+				fmt.Sprintf("（余额 %.4f，阈值 %.4f）", balance, config.Threshold),
+				fmt.Sprintf("通道「%s」（#%d）余额不足", channel.Name, channel.Id),
+				fmt.Sprintf("通道「%s」（#%d）余额 %.4f 低于告警阈值 %.4f", channel.Name, channel.Id, balance, config.Threshold))
+		}
+		return
+	}
+	if alerting {
+		service.RecoverChannelAlert(channel, info, balanceAlert(channel, config),
+			fmt.Sprintf("通道「%s」（#%d）余额已恢复", channel.Name, channel.Id),
+			fmt.Sprintf("通道「%s」（#%d）余额 %.4f 已恢复至告警阈值 %.4f 以上", channel.Name, channel.Id, balance, config.Threshold))
+	}
+}
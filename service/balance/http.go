@@ -0,0 +1,73 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/model"
+	"veloera/service"
+)
+
+func bearerHeader(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// channelBaseURL 返回渠道地址，未填写时使用渠道类型的默认地址
+func channelBaseURL(channel *model.Channel) string {
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = common.ChannelBaseURLs[channel.Type]
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// getJSON 发起 GET 请求并将响应解析到 v，渠道设置了代理时通过代理请求
+func getJSON(ctx context.Context, channel *model.Channel, url string, headers map[string]string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, value := range headers {
+		req.Header.Set(k, value)
+	}
+	client := service.GetHttpClient()
+	if proxy, ok := channel.GetSetting()[constant.ChanelSettingProxy].(string); ok && proxy != "" {
+		if client, err = service.NewProxyHttpClient(proxy); err != nil {
+			return err
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"veloera/model"
+)
+
+// jsonPathProvider 按渠道设置发起 GET 请求，并按 JSONPath 从响应中取出余额
+type jsonPathProvider struct{}
+
+func init() {
+	register(&jsonPathProvider{})
+}
+
+func (p *jsonPathProvider) Name() string {
+	return "jsonpath"
+}
+
+func (p *jsonPathProvider) ChannelTypes() []int {
+	return nil
+}
+
+func (p *jsonPathProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	if config.URL == "" || config.Path == "" {
+		return 0, errors.New("url and path are required by jsonpath balance provider")
+	}
+	replacer := strings.NewReplacer("{key}", channel.Key, "{base_url}", channelBaseURL(channel))
+	headers := make(map[string]string, len(config.Headers))
+	for k, v := range config.Headers {
+		headers[k] = replacer.Replace(v)
+	}
+	var response interface{}
+	if err := getJSON(ctx, channel, replacer.Replace(config.URL), headers, &response); err != nil {
+		return 0, err
+	}
+	balance, err := lookupNumber(response, config.Path)
+	if err != nil {
+		return 0, err
+	}
+	if config.UsedPath != "" {
+		used, err := lookupNumber(response, config.UsedPath)
+		if err != nil {
+			return 0, err
+		}
+		balance -= used
+	}
+	return balance, nil
+}
+
+// lookupNumber 按 JSONPath 取值并转换为数字，支持 $.a.b[0].c 与 $['a']['b'] 形式，数字字符串也会被解析
+func lookupNumber(data interface{}, path string) (float64, error) {
+	tokens, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+	current := data
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return 0, fmt.Errorf("path %s: key %s not found", path, token)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil {
+				return 0, fmt.Errorf("path %s: %s is not an array index", path, token)
+			}
+			if index < 0 {
+				index += len(node)
+			}
+			if index < 0 || index >= len(node) {
+				return 0, fmt.Errorf("path %s: index %s out of range", path, token)
+			}
+			current = node[index]
+		default:
+			return 0, fmt.Errorf("path %s: cannot read %s from a non-container value", path, token)
+		}
+	}
+	switch value := current.(type) {
+	case float64:
+		return value, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case json.Number:
+		return value.Float64()
+	default:
+		return 0, fmt.Errorf("path %s: value is not a number", path)
+	}
+}
+
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	tokens := make([]string, 0)
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid json path: %s", path)
+			}
+			token := strings.TrimSpace(path[i+1 : i+end])
+			token = strings.Trim(token, `'"`)
+			if token == "" {
+				return nil, fmt.Errorf("invalid json path: %s", path)
+			}
+			tokens = append(tokens, token)
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			tokens = append(tokens, path[i:i+end])
+			i += end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid json path: %s", path)
+	}
+	return tokens, nil
+}
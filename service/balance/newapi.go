@@ -0,0 +1,87 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"veloera/model"
+)
+
+// defaultQuotaPerUnit new-api / one-api 默认的每美元额度
+const defaultQuotaPerUnit = 500 * 1000.0
+
+type newAPIUserResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    struct {
+		Quota float64 `json:"quota"`
+	} `json:"data"`
+}
+
+// newAPIProvider 查询 new-api / one-api 及本项目部署的上游：
+// 配置了系统访问令牌时查询 /api/user/self 的剩余额度，否则使用渠道密钥查询兼容的 dashboard 接口
+type newAPIProvider struct {
+	name string
+	// userHeader 上游校验用户 ID 的请求头，为空时不发送
+	userHeader string
+}
+
+func init() {
+	register(&newAPIProvider{name: "new-api", userHeader: "New-Api-User"})
+	register(&newAPIProvider{name: "one-api"})
+	register(&newAPIProvider{name: "veloera", userHeader: "Veloera-User"})
+}
+
+func (p *newAPIProvider) Name() string {
+	return p.name
+}
+
+func (p *newAPIProvider) ChannelTypes() []int {
+	return nil
+}
+
+func (p *newAPIProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	baseURL := channelBaseURL(channel)
+	if baseURL == "" {
+		return 0, errors.New("channel base url is empty")
+	}
+	if config.AccessToken == "" {
+		return fetchDashboardBalance(ctx, channel, baseURL, channel.Key)
+	}
+	headers := bearerHeader(config.AccessToken)
+	if p.userHeader != "" {
+		if config.UserId == 0 {
+			return 0, fmt.Errorf("user_id is required by %s", p.name)
+		}
+		headers[p.userHeader] = strconv.Itoa(config.UserId)
+	}
+	response := newAPIUserResponse{}
+	if err := getJSON(ctx, channel, fmt.Sprintf("%s/api/user/self", baseURL), headers, &response); err != nil {
+		return 0, err
+	}
+	if !response.Success {
+		return 0, errors.New(response.Message)
+	}
+	quotaPerUnit := config.QuotaPerUnit
+	if quotaPerUnit <= 0 {
+		quotaPerUnit = defaultQuotaPerUnit
+	}
+	return response.Data.Quota / quotaPerUnit, nil
+}
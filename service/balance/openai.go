@@ -0,0 +1,156 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/model"
+)
+
+type openAISubscriptionResponse struct {
+	HasPaymentMethod bool    `json:"has_payment_method"`
+	HardLimitUSD     float64 `json:"hard_limit_usd"`
+}
+
+type openAIUsageResponse struct {
+	TotalUsage float64 `json:"total_usage"` // unit: 0.01 dollar
+}
+
+type creditGrantsResponse struct {
+	TotalGranted   float64 `json:"total_granted"`
+	TotalUsed      float64 `json:"total_used"`
+	TotalAvailable float64 `json:"total_available"`
+	TotalRemaining float64 `json:"total_remaining"`
+}
+
+type openAISBUsageResponse struct {
+	Msg  string `json:"msg"`
+	Data *struct {
+		Credit string `json:"credit"`
+	} `json:"data"`
+}
+
+// openAIDashboardProvider 通过 /v1/dashboard/billing 接口查询，额度上限减去已用额度即为余额
+type openAIDashboardProvider struct{}
+
+func init() {
+	register(&openAIDashboardProvider{})
+	register(&creditGrantsProvider{name: "closeai"})
+	register(&creditGrantsProvider{
+		name:         "api2gpt",
+		url:          "https://api.api2gpt.com/dashboard/billing/credit_grants",
+		channelTypes: []int{common.ChannelTypeAPI2GPT},
+		remaining:    true,
+	})
+	register(&creditGrantsProvider{
+		name:         "aigc2d",
+		url:          "https://api.aigc2d.com/dashboard/billing/credit_grants",
+		channelTypes: []int{common.ChannelTypeAIGC2D},
+	})
+	register(&openAISBProvider{})
+}
+
+func (p *openAIDashboardProvider) Name() string {
+	return "openai"
+}
+
+func (p *openAIDashboardProvider) ChannelTypes() []int {
+	return []int{common.ChannelTypeOpenAI, common.ChannelTypeCustom}
+}
+
+func (p *openAIDashboardProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	return fetchDashboardBalance(ctx, channel, channelBaseURL(channel), channel.Key)
+}
+
+func fetchDashboardBalance(ctx context.Context, channel *model.Channel, baseURL string, key string) (float64, error) {
+	subscription := openAISubscriptionResponse{}
+	err := getJSON(ctx, channel, fmt.Sprintf("%s/v1/dashboard/billing/subscription", baseURL), bearerHeader(key), &subscription)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	startDate := fmt.Sprintf("%s-01", now.Format("2006-01"))
+	endDate := now.Format("2006-01-02")
+	if !subscription.HasPaymentMethod {
+		startDate = now.AddDate(0, 0, -100).Format("2006-01-02")
+	}
+	usage := openAIUsageResponse{}
+	url := fmt.Sprintf("%s/v1/dashboard/billing/usage?start_date=%s&end_date=%s", baseURL, startDate, endDate)
+	if err = getJSON(ctx, channel, url, bearerHeader(key), &usage); err != nil {
+		return 0, err
+	}
+	return subscription.HardLimitUSD - usage.TotalUsage/100, nil
+}
+
+// creditGrantsProvider 通过 /dashboard/billing/credit_grants 接口查询，url 为空时使用渠道地址
+type creditGrantsProvider struct {
+	name         string
+	url          string
+	channelTypes []int
+	// remaining 余额字段为 total_remaining 而非 total_available
+	remaining bool
+}
+
+func (p *creditGrantsProvider) Name() string {
+	return p.name
+}
+
+func (p *creditGrantsProvider) ChannelTypes() []int {
+	return p.channelTypes
+}
+
+func (p *creditGrantsProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	url := p.url
+	if url == "" {
+		url = fmt.Sprintf("%s/dashboard/billing/credit_grants", channelBaseURL(channel))
+	}
+	response := creditGrantsResponse{}
+	if err := getJSON(ctx, channel, url, bearerHeader(channel.Key), &response); err != nil {
+		return 0, err
+	}
+	if p.remaining {
+		return response.TotalRemaining, nil
+	}
+	return response.TotalAvailable, nil
+}
+
+type openAISBProvider struct{}
+
+func (p *openAISBProvider) Name() string {
+	return "openai-sb"
+}
+
+func (p *openAISBProvider) ChannelTypes() []int {
+	return nil
+}
+
+func (p *openAISBProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	url := fmt.Sprintf("https://api.openai-sb.com/sb-api/user/status?api_key=%s", channel.Key)
+	response := openAISBUsageResponse{}
+	if err := getJSON(ctx, channel, url, bearerHeader(channel.Key), &response); err != nil {
+		return 0, err
+	}
+	if response.Data == nil {
+		return 0, errors.New(response.Msg)
+	}
+	return strconv.ParseFloat(response.Data.Credit, 64)
+}
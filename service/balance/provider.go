@@ -0,0 +1,149 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/model"
+)
+
+// Config 渠道设置中 balance 字段的内容，用于指定余额查询方式与余额告警
+type Config struct {
+	// Provider 余额查询方式，为空时按渠道类型选择
+	Provider string `json:"provider,omitempty"`
+
+	// URL、Headers、Path 等用于 jsonpath 查询方式，{key} 与 {base_url} 会被替换为渠道密钥与地址
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	// UsedPath 不为空时余额为 Path 与 UsedPath 取值之差
+	UsedPath string `json:"used_path,omitempty"`
+	// Scale 查询结果的换算系数，为 0 时不换算
+	Scale float64 `json:"scale,omitempty"`
+
+	// AccessToken 与 UserId 为 new-api / one-api 上游的系统访问令牌与用户 ID，为空时使用渠道密钥查询
+	AccessToken  string  `json:"access_token,omitempty"`
+	UserId       int     `json:"user_id,omitempty"`
+	QuotaPerUnit float64 `json:"quota_per_unit,omitempty"`
+
+	// Threshold 余额低于该值时告警，为 0 时不告警
+	Threshold float64 `json:"threshold,omitempty"`
+	// Action 告警时的处理方式：notify、disable 或 lower_priority
+	Action string `json:"action,omitempty"`
+	// AlertPriority 告警时渠道降至的优先级，为空或不低于原优先级时在原优先级基础上减 1
+	AlertPriority *int64 `json:"alert_priority,omitempty"`
+}
+
+// GetConfig 解析渠道的余额设置，未设置时返回空配置
+func GetConfig(channel *model.Channel) (*Config, error) {
+	config := &Config{}
+	value, ok := channel.GetSetting()[constant.ChannelSettingBalance]
+	if !ok || value == nil {
+		return config, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid balance setting: %w", err)
+	}
+	return config, nil
+}
+
+// Provider 上游余额查询方式
+type Provider interface {
+	Name() string
+	// ChannelTypes 默认使用该查询方式的渠道类型
+	ChannelTypes() []int
+	// Fetch 查询上游余额，不写入数据库
+	Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error)
+}
+
+var (
+	providers     = make(map[string]Provider)
+	typeProviders = make(map[int]Provider)
+)
+
+func register(provider Provider) {
+	providers[provider.Name()] = provider
+	for _, channelType := range provider.ChannelTypes() {
+		typeProviders[channelType] = provider
+	}
+}
+
+func GetProvider(name string) (Provider, bool) {
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+// GetProviderNames 返回全部已注册的查询方式名称，用于前端渠道设置的下拉选项
+func GetProviderNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetChannelProvider 优先使用渠道设置中指定的查询方式，否则按渠道类型选择
+func GetChannelProvider(channel *model.Channel, config *Config) (Provider, error) {
+	if config.Provider != "" {
+		provider, ok := providers[config.Provider]
+		if !ok {
+			return nil, fmt.Errorf("unknown balance provider: %s", config.Provider)
+		}
+		return provider, nil
+	}
+	provider, ok := typeProviders[channel.Type]
+	if !ok {
+		return nil, errors.New("尚未实现")
+	}
+	return provider, nil
+}
+
+// UpdateChannelBalance 查询并保存渠道余额，随后检查余额告警
+func UpdateChannelBalance(ctx context.Context, channel *model.Channel) (float64, error) {
+	config, err := GetConfig(channel)
+	if err != nil {
+		return 0, err
+	}
+	provider, err := GetChannelProvider(channel, config)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := provider.Fetch(ctx, channel, config)
+	if err != nil {
+		return 0, err
+	}
+	if config.Scale != 0 {
+		balance *= config.Scale
+	}
+	channel.UpdateBalance(balance)
+	checkBalanceAlert(channel, config, balance)
+	if common.DebugEnabled {
+		common.SysLog(fmt.Sprintf("channel #%d balance updated by %s: %f", channel.Id, provider.Name(), balance))
+	}
+	return balance, nil
+}
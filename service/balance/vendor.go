@@ -0,0 +1,188 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"veloera/common"
+	"veloera/model"
+)
+
+type aiProxyUserOverviewResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	ErrorCode int    `json:"error_code"`
+	Data      struct {
+		TotalPoints float64 `json:"totalPoints"`
+	} `json:"data"`
+}
+
+type siliconFlowUserInfoResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		TotalBalance string `json:"totalBalance"`
+	} `json:"data"`
+}
+
+type deepSeekBalanceResponse struct {
+	BalanceInfos []struct {
+		Currency     string `json:"currency"`
+		TotalBalance string `json:"total_balance"`
+	} `json:"balance_infos"`
+}
+
+type openRouterCreditsResponse struct {
+	Data struct {
+		TotalCredits float64 `json:"total_credits"`
+		TotalUsage   float64 `json:"total_usage"`
+	} `json:"data"`
+}
+
+type moonshotBalanceResponse struct {
+	Code   int    `json:"code"`
+	Error  string `json:"error"`
+	Status bool   `json:"status"`
+	Data   struct {
+		AvailableBalance float64 `json:"available_balance"`
+	} `json:"data"`
+}
+
+func init() {
+	register(&aiProxyProvider{})
+	register(&siliconFlowProvider{})
+	register(&deepSeekProvider{})
+	register(&openRouterProvider{})
+	register(&moonshotProvider{})
+}
+
+type aiProxyProvider struct{}
+
+func (p *aiProxyProvider) Name() string {
+	return "aiproxy"
+}
+
+func (p *aiProxyProvider) ChannelTypes() []int {
+	return []int{common.ChannelTypeAIProxy}
+}
+
+func (p *aiProxyProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	response := aiProxyUserOverviewResponse{}
+	err := getJSON(ctx, channel, "https://aiproxy.io/api/report/getUserOverview", map[string]string{"Api-Key": channel.Key}, &response)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Success {
+		return 0, fmt.Errorf("code: %d, message: %s", response.ErrorCode, response.Message)
+	}
+	return response.Data.TotalPoints, nil
+}
+
+type siliconFlowProvider struct{}
+
+func (p *siliconFlowProvider) Name() string {
+	return "siliconflow"
+}
+
+func (p *siliconFlowProvider) ChannelTypes() []int {
+	return []int{common.ChannelTypeSiliconFlow}
+}
+
+func (p *siliconFlowProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	response := siliconFlowUserInfoResponse{}
+	err := getJSON(ctx, channel, "https://api.siliconflow.cn/v1/user/info", bearerHeader(channel.Key), &response)
+	if err != nil {
+		return 0, err
+	}
+	if response.Code != 20000 {
+		return 0, fmt.Errorf("code: %d, message: %s", response.Code, response.Message)
+	}
+	return strconv.ParseFloat(response.Data.TotalBalance, 64)
+}
+
+// deepSeekProvider 返回人民币余额
+type deepSeekProvider struct{}
+
+func (p *deepSeekProvider) Name() string {
+	return "deepseek"
+}
+
+func (p *deepSeekProvider) ChannelTypes() []int {
+	return []int{common.ChannelTypeDeepSeek}
+}
+
+func (p *deepSeekProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	response := deepSeekBalanceResponse{}
+	err := getJSON(ctx, channel, "https://api.deepseek.com/user/balance", bearerHeader(channel.Key), &response)
+	if err != nil {
+		return 0, err
+	}
+	for _, balanceInfo := range response.BalanceInfos {
+		if balanceInfo.Currency == "CNY" {
+			return strconv.ParseFloat(balanceInfo.TotalBalance, 64)
+		}
+	}
+	return 0, errors.New("currency CNY not found")
+}
+
+// openRouterProvider 余额为已购买额度减去已用额度，单位为美元
+type openRouterProvider struct{}
+
+func (p *openRouterProvider) Name() string {
+	return "openrouter"
+}
+
+func (p *openRouterProvider) ChannelTypes() []int {
+	return []int{common.ChannelTypeOpenRouter}
+}
+
+func (p *openRouterProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	response := openRouterCreditsResponse{}
+	// 默认地址为 https://openrouter.ai/api，也兼容填写为站点根地址的情况
+	url := fmt.Sprintf("%s/api/v1/credits", strings.TrimSuffix(channelBaseURL(channel), "/api"))
+	if err := getJSON(ctx, channel, url, bearerHeader(channel.Key), &response); err != nil {
+		return 0, err
+	}
+	return response.Data.TotalCredits - response.Data.TotalUsage, nil
+}
+
+// moonshotProvider 返回可用余额（含代金券），单位为人民币
+type moonshotProvider struct{}
+
+func (p *moonshotProvider) Name() string {
+	return "moonshot"
+}
+
+func (p *moonshotProvider) ChannelTypes() []int {
+	return []int{common.ChannelTypeMoonshot}
+}
+
+func (p *moonshotProvider) Fetch(ctx context.Context, channel *model.Channel, config *Config) (float64, error) {
+	response := moonshotBalanceResponse{}
+	url := fmt.Sprintf("%s/v1/users/me/balance", channelBaseURL(channel))
+	if err := getJSON(ctx, channel, url, bearerHeader(channel.Key), &response); err != nil {
+		return 0, err
+	}
+	if !response.Status {
+		return 0, fmt.Errorf("code: %d, message: %s", response.Code, response.Error)
+	}
+	return response.Data.AvailableBalance, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"fmt"
+	"strings"
+	"veloera/common"
+	"veloera/model"
+)
+
+// 渠道 other_info 中记录告警处理的字段，余额、模型指纹等各来源共用：
+// origin_priority 为首次降级前的优先级，priority_demotions 为 来源 -> 该来源要求的优先级，
+// 渠道优先级取其中的最小值，全部来源恢复后还原；alert_disables 为 来源 -> 禁用原因，
+// alert_disable_reason 为告警禁用渠道时实际写入的禁用原因，全部来源恢复且渠道仍为该原因禁用时重新启用
+const (
+	otherInfoOriginPriority     = "origin_priority"
+	otherInfoPriorityDemotions  = "priority_demotions"
+	otherInfoAlertDisables      = "alert_disables"
+	otherInfoAlertDisableReason = "alert_disable_reason"
+)
+
+const (
+	ChannelAlertActionNotify        = "notify"
+	ChannelAlertActionLowerPriority = "lower_priority"
+	ChannelAlertActionDisable       = "disable"
+)
+
+// ChannelAlert 渠道告警的来源及处理方式
+type ChannelAlert struct {
+	Source        string // 告警来源，如 balance、fingerprint
+	TimeKey       string // other_info 中记录告警时间的字段，存在即表示告警中
+	Action        string
+	Priority      *int64 // lower_priority 时降至的优先级，为空或不低于原优先级时为原优先级减 1
+	DisableReason string // 禁用原因前缀，恢复时只重新启用由告警禁用的渠道
+	NotifyType    string
+}
+
+func alertInfoMap(info map[string]interface{}, key string) map[string]interface{} {
+	if m, ok := info[key].(map[string]interface{}); ok {
+		return m
+	}
+	return make(map[string]interface{})
+}
+
+// alertInfoInt64 读取 other_info 中的整数，经 JSON 解码后为 float64
+func alertInfoInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
+
+// minDemotedPriority 返回各来源要求的优先级中的最小值
+func minDemotedPriority(demotions map[string]interface{}) int64 {
+	first := true
+	var lowest int64
+	for _, v := range demotions {
+		priority := alertInfoInt64(v)
+		if first || priority < lowest {
+			lowest = priority
+			first = false
+		}
+	}
+	return lowest
+}
+
+// TriggerChannelAlert 记录告警并按 Action 降低优先级或禁用渠道。detail 为禁用原因的补充说明，
+// subject、content 为通知内容，content 后会附加处理结果；禁用渠道时由 DisableChannel 通知
+func TriggerChannelAlert(channel *model.Channel, info map[string]interface{}, alert *ChannelAlert, detail string, subject string, content string) {
+	info[alert.TimeKey] = common.GetTimestamp()
+	var priority *int64
+	action := "仅通知"
+	switch alert.Action {
+	case ChannelAlertActionLowerPriority:
+		origin := channel.GetPriority()
+		if v, ok := info[otherInfoOriginPriority]; ok {
+			origin = alertInfoInt64(v)
+		} else {
+			info[otherInfoOriginPriority] = origin
+		}
+		lowered := origin - 1
+		if alert.Priority != nil && *alert.Priority < origin {
+			lowered = *alert.Priority
+		}
+		demotions := alertInfoMap(info, otherInfoPriorityDemotions)
+		demotions[alert.Source] = lowered
+		info[otherInfoPriorityDemotions] = demotions
+		effective := minDemotedPriority(demotions)
+		priority = &effective
+		action = fmt.Sprintf("优先级已由 %d 降至 %d", channel.GetPriority(), effective)
+	case ChannelAlertActionDisable:
+		disables := alertInfoMap(info, otherInfoAlertDisables)
+		disables[alert.Source] = alert.DisableReason + detail
+		info[otherInfoAlertDisables] = disables
+		// 渠道已被禁用时保留原有的禁用原因
+		if _, ok := info[otherInfoAlertDisableReason]; !ok && channel.Status == common.ChannelStatusEnabled {
+			info[otherInfoAlertDisableReason] = alert.DisableReason + detail
+		}
+	}
+	channel.SetOtherInfo(info)
+	if err := model.UpdateChannelOtherInfoById(channel.Id, channel.OtherInfo, priority); err != nil {
+		common.SysError(fmt.Sprintf("failed to save %s alert of channel #%d: %s", alert.Source, channel.Id, err.Error()))
+		return
+	}
+	if alert.Action == ChannelAlertActionDisable {
+		DisableChannel(channel.Id, channel.Name, alert.DisableReason+detail)
+		return
+	}
+	NotifyRootUser(alert.NotifyType, subject, content+"，"+action)
+}
+
+// RecoverChannelAlert 清除告警及该来源的降级，其他来源仍在降级时保持其中最低的优先级，全部恢复后还原原优先级；
+// 所有禁用渠道的来源都已恢复且渠道仍因告警处于自动禁用状态时重新启用，否则发送恢复通知
+func RecoverChannelAlert(channel *model.Channel, info map[string]interface{}, alert *ChannelAlert, subject string, content string) {
+	delete(info, alert.TimeKey)
+	var priority *int64
+	demotions := alertInfoMap(info, otherInfoPriorityDemotions)
+	if _, ok := demotions[alert.Source]; ok {
+		delete(demotions, alert.Source)
+		if len(demotions) > 0 {
+			effective := minDemotedPriority(demotions)
+			priority = &effective
+			info[otherInfoPriorityDemotions] = demotions
+		} else {
+			restored := alertInfoInt64(info[otherInfoOriginPriority])
+			priority = &restored
+			delete(info, otherInfoPriorityDemotions)
+			delete(info, otherInfoOriginPriority)
+		}
+	} else if origin, ok := info[alert.Source+"_origin_priority"]; ok {
+		// 兼容旧版按来源分别记录的原优先级
+		restored := alertInfoInt64(origin)
+		priority = &restored
+		delete(info, alert.Source+"_origin_priority")
+	}
+	disables := alertInfoMap(info, otherInfoAlertDisables)
+	_, disabledBySource := disables[alert.Source]
+	delete(disables, alert.Source)
+	statusReason, _ := info["status_reason"].(string)
+	enable := false
+	if len(disables) > 0 {
+		info[otherInfoAlertDisables] = disables
+	} else {
+		if reason, ok := info[otherInfoAlertDisableReason].(string); ok {
+			enable = statusReason == reason
+		} else if !disabledBySource {
+			// 兼容旧版只按禁用原因前缀识别的告警禁用
+			enable = alert.DisableReason != "" && strings.HasPrefix(statusReason, alert.DisableReason)
+		}
+		delete(info, otherInfoAlertDisables)
+		delete(info, otherInfoAlertDisableReason)
+	}
+	channel.SetOtherInfo(info)
+	if err := model.UpdateChannelOtherInfoById(channel.Id, channel.OtherInfo, priority); err != nil {
+		common.SysError(fmt.Sprintf("failed to clear %s alert of channel #%d: %s", alert.Source, channel.Id, err.Error()))
+		return
+	}
+	if enable && channel.Status == common.ChannelStatusAutoDisabled {
+		EnableChannel(channel.Id, channel.Name)
+		return
+	}
+	if priority != nil && len(demotions) > 0 {
+		content += fmt.Sprintf("，其他告警仍在降级，优先级调整为 %d", *priority)
+	} else if priority != nil {
+		content += fmt.Sprintf("，优先级已恢复为 %d", *priority)
+	}
+	NotifyRootUser(alert.NotifyType, subject, content)
+}
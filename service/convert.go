@@ -197,6 +197,7 @@ func OpenAIErrorToClaudeError(openAIError *dto.OpenAIErrorWithStatusCode) *dto.C
 	return &dto.ClaudeErrorWithStatusCode{
 		Error:      claudeError,
 		StatusCode: openAIError.StatusCode,
+		LocalError: openAIError.LocalError,
 	}
 }
 
@@ -208,6 +209,7 @@ func ClaudeErrorToOpenAIError(claudeError *dto.ClaudeErrorWithStatusCode) *dto.O
 	return &dto.OpenAIErrorWithStatusCode{
 		Error:      openAIError,
 		StatusCode: claudeError.StatusCode,
+		LocalError: claudeError.LocalError,
 	}
 }
 
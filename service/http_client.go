@@ -18,17 +18,25 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/net/proxy"
 	"net"
 	"net/http"
 	"net/url"
+	"syscall"
 	"time"
 	"veloera/common"
 )
 
 var httpClient *http.Client
 var impatientHTTPClient *http.Client
+var externalHTTPClient *http.Client
+
+var errInternalAddress = errors.New("不允许访问内网、回环或链路本地地址")
+
+// cgnatNet 运营商级 NAT 地址段，net.IP.IsPrivate 不包含
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
 
 func init() {
 	if common.RelayTimeout == 0 {
@@ -42,6 +50,59 @@ func init() {
 	impatientHTTPClient = &http.Client{
 		Timeout: 5 * time.Second,
 	}
+
+	// 访问用户提供的地址时在建立连接前检查解析后的 IP，防止通过 DNS 解析或重定向访问内网
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || IsInternalIP(ip) {
+				return errInternalAddress
+			}
+			return nil
+		},
+	}
+	externalHTTPClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// GetExternalHttpClient 用于请求用户提供的地址（如任务回调），拒绝连接内网、回环与链路本地地址，不走代理
+func GetExternalHttpClient() *http.Client {
+	return externalHTTPClient
+}
+
+// IsInternalIP 是否为回环、私有、链路本地、未指定或组播地址
+func IsInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatNet.Contains(ip)
+}
+
+// CheckExternalHost 解析主机名并确认所有地址都不是内网地址
+func CheckExternalHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if IsInternalIP(ip) {
+			return errInternalAddress
+		}
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("无法解析主机 %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if IsInternalIP(ip) {
+			return errInternalAddress
+		}
+	}
+	return nil
 }
 
 func GetHttpClient() *http.Client {
@@ -0,0 +1,31 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"veloera/common"
+	"veloera/model"
+)
+
+// jobNodeId 当前进程的节点标识，用作定时任务租约的持有者
+var jobNodeId = common.GetUUID()
+
+func releaseJobLease(name string) {
+	if err := model.ReleaseJobLease(name, jobNodeId); err != nil {
+		common.SysError("release job lease " + name + " error: " + err.Error())
+	}
+}
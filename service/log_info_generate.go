@@ -18,6 +18,7 @@ package service
 
 import (
 	"veloera/common"
+	"veloera/constant"
 	"veloera/dto"
 	relaycommon "veloera/relay/common"
 
@@ -41,6 +42,12 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if hits, ok := ctx.Get(constant.ContextKeyModerationHits); ok {
+		other["moderation"] = hits
+	}
+	if masker, ok := ctx.Get(constant.ContextKeyPiiMasker); ok {
+		other["pii_masked"] = masker.(*PiiMasker).Counts()
+	}
 
 	// 添加输入输出内容
 	if relayInfo.Other != nil && common.LogChatContentEnabled {
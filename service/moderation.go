@@ -0,0 +1,555 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/model"
+	"veloera/relay/helper"
+	"veloera/setting"
+	"veloera/setting/operation_setting"
+
+	goahocorasick "github.com/anknown/ahocorasick"
+	"github.com/gin-gonic/gin"
+)
+
+const moderationRedactPlaceholder = "**###**"
+
+const defaultOpenAIModerationModel = "omni-moderation-latest"
+
+// ModerationHit 审核命中记录
+type ModerationHit struct {
+	Stage    string `json:"stage"`
+	Category string `json:"category"`
+	Action   string `json:"action"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// ModerationInput 待审核的内容，图片只有分类服务类阶段会审核
+type ModerationInput struct {
+	Texts  []string
+	Images []string
+}
+
+// ModerationResult 审核结果，Texts 为按 redact 动作遮盖后的文本，与输入一一对应
+type ModerationResult struct {
+	Action     string
+	RouteGroup string
+	Hits       []ModerationHit
+	Texts      []string
+	Redacted   bool
+}
+
+func (result *ModerationResult) Blocked() bool {
+	return result.Action == operation_setting.ModerationActionBlock
+}
+
+// moderationMatch 阶段内的一次命中，TextIndex 为 -1 表示命中图片或无法定位到具体文本，
+// Spans 为命中的 rune 区间，为空时遮盖整段文本
+type moderationMatch struct {
+	Category  string
+	Detail    string
+	TextIndex int
+	Spans     [][2]int
+}
+
+var moderationActionRank = map[string]int{
+	operation_setting.ModerationActionLog:    1,
+	operation_setting.ModerationActionRedact: 2,
+	operation_setting.ModerationActionRoute:  3,
+	operation_setting.ModerationActionBlock:  4,
+}
+
+// Moderate 按配置的阶段依次审核内容。block 命中后立即返回，redact 命中后后续阶段审核遮盖后的文本
+func Moderate(ctx context.Context, group string, direction string, input ModerationInput) (*ModerationResult, error) {
+	return moderate(ctx, group, direction, input, nil)
+}
+
+// isLocalModerationStage 关键词、正则阶段在本地执行，不需要请求外部服务
+func isLocalModerationStage(stage *operation_setting.ModerationStage) bool {
+	return stage.Type == operation_setting.ModerationStageKeyword || stage.Type == operation_setting.ModerationStageRegex
+}
+
+// moderate 只运行 include 返回 true 的阶段，include 为 nil 时运行全部阶段
+func moderate(ctx context.Context, group string, direction string, input ModerationInput, include func(stage *operation_setting.ModerationStage) bool) (*ModerationResult, error) {
+	moderationSetting := operation_setting.GetModerationSetting()
+	result := &ModerationResult{
+		Texts: append([]string(nil), input.Texts...),
+	}
+	override := moderationSetting.GroupOverrides[group]
+	for _, stage := range moderationSetting.Stages {
+		if !moderationStageApplies(&stage, &override, direction) || (include != nil && !include(&stage)) {
+			continue
+		}
+		matches, err := runModerationStage(ctx, &stage, group, direction, result.Texts, input.Images)
+		if err != nil {
+			common.SysError(fmt.Sprintf("moderation stage %s error: %s", stage.Name, err.Error()))
+			if stage.FailOpen {
+				continue
+			}
+			result.Action = operation_setting.ModerationActionBlock
+			result.Hits = append(result.Hits, ModerationHit{Stage: stage.Name, Category: "error", Action: result.Action})
+			return result, nil
+		}
+		for _, match := range matches {
+			action := moderationAction(&stage, &override, match.Category, direction)
+			if action == operation_setting.ModerationActionRedact && match.TextIndex < 0 {
+				// 图片无法遮盖
+				action = operation_setting.ModerationActionBlock
+			}
+			result.Hits = append(result.Hits, ModerationHit{
+				Stage:    stage.Name,
+				Category: match.Category,
+				Action:   action,
+				Detail:   match.Detail,
+			})
+			if moderationActionRank[action] > moderationActionRank[result.Action] {
+				result.Action = action
+				if action == operation_setting.ModerationActionRoute {
+					result.RouteGroup = stage.RouteGroup
+					if override.RouteGroup != "" {
+						result.RouteGroup = override.RouteGroup
+					}
+				}
+			}
+			if action == operation_setting.ModerationActionRedact {
+				result.Texts[match.TextIndex] = redactModerationSpans(result.Texts[match.TextIndex], match.Spans)
+				result.Redacted = true
+			}
+		}
+		if result.Blocked() {
+			return result, nil
+		}
+	}
+	if result.Action == operation_setting.ModerationActionRoute && result.RouteGroup == "" {
+		result.Action = operation_setting.ModerationActionBlock
+	}
+	return result, nil
+}
+
+func moderationStageApplies(stage *operation_setting.ModerationStage, override *operation_setting.ModerationGroupOverride, direction string) bool {
+	if !stage.Enabled {
+		return false
+	}
+	if direction == operation_setting.ModerationDirectionInput && !stage.Input {
+		return false
+	}
+	if direction == operation_setting.ModerationDirectionOutput && !stage.Output {
+		return false
+	}
+	return !common.StringsContains(override.DisabledStages, stage.Name)
+}
+
+// moderationAction 依次取分组覆盖、阶段配置中该分类的动作，再取默认动作 "*"，都未配置时拒绝
+func moderationAction(stage *operation_setting.ModerationStage, override *operation_setting.ModerationGroupOverride, category string, direction string) string {
+	action := ""
+	for _, actions := range []map[string]string{override.Actions[stage.Name], stage.Actions} {
+		if a, ok := actions[category]; ok {
+			action = a
+			break
+		}
+		if a, ok := actions["*"]; ok {
+			action = a
+			break
+		}
+	}
+	if _, ok := moderationActionRank[action]; !ok {
+		action = operation_setting.ModerationActionBlock
+	}
+	if action == operation_setting.ModerationActionRoute && direction == operation_setting.ModerationDirectionOutput {
+		action = operation_setting.ModerationActionBlock
+	}
+	return action
+}
+
+func runModerationStage(ctx context.Context, stage *operation_setting.ModerationStage, group string, direction string, texts []string, images []string) ([]moderationMatch, error) {
+	switch stage.Type {
+	case operation_setting.ModerationStageKeyword:
+		words := stage.Words
+		if len(words) == 0 {
+			words = setting.SensitiveWords
+		}
+		return keywordModeration(words, moderationCategory(stage), texts), nil
+	case operation_setting.ModerationStageRegex:
+		return regexModeration(stage.Words, moderationCategory(stage), texts), nil
+	case operation_setting.ModerationStageOpenAI:
+		return openAIModeration(ctx, stage, group, texts, images)
+	case operation_setting.ModerationStageHTTP:
+		return httpModeration(ctx, stage, group, direction, texts, images)
+	}
+	return nil, fmt.Errorf("unknown moderation stage type: %s", stage.Type)
+}
+
+func moderationCategory(stage *operation_setting.ModerationStage) string {
+	if stage.Category != "" {
+		return stage.Category
+	}
+	return stage.Type
+}
+
+var acMachines sync.Map
+
+func getAcMachine(words []string) *goahocorasick.Machine {
+	key := strings.Join(words, "\x00")
+	if m, ok := acMachines.Load(key); ok {
+		return m.(*goahocorasick.Machine)
+	}
+	m := InitAc(words)
+	if m != nil {
+		acMachines.Store(key, m)
+	}
+	return m
+}
+
+func keywordModeration(words []string, category string, texts []string) []moderationMatch {
+	if len(words) == 0 {
+		return nil
+	}
+	m := getAcMachine(words)
+	if m == nil {
+		return nil
+	}
+	var matches []moderationMatch
+	for i, text := range texts {
+		runes := []rune(text)
+		lower := make([]rune, len(runes))
+		for j, r := range runes {
+			lower[j] = unicode.ToLower(r)
+		}
+		hits := m.MultiPatternSearch(lower, false)
+		if len(hits) == 0 {
+			continue
+		}
+		match := moderationMatch{Category: category, TextIndex: i}
+		found := make([]string, 0, len(hits))
+		for _, hit := range hits {
+			match.Spans = append(match.Spans, [2]int{hit.Pos, hit.Pos + len(hit.Word)})
+			found = append(found, string(hit.Word))
+		}
+		match.Detail = strings.Join(found, ",")
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+var moderationRegexps sync.Map
+
+func getModerationRegexp(pattern string) *regexp.Regexp {
+	if re, ok := moderationRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		common.SysError("invalid moderation regex " + pattern + ": " + err.Error())
+		re = nil
+	}
+	moderationRegexps.Store(pattern, re)
+	return re
+}
+
+func regexModeration(patterns []string, category string, texts []string) []moderationMatch {
+	var matches []moderationMatch
+	for i, text := range texts {
+		match := moderationMatch{Category: category, TextIndex: i}
+		found := make([]string, 0)
+		for _, pattern := range patterns {
+			re := getModerationRegexp(pattern)
+			if re == nil {
+				continue
+			}
+			locs := re.FindAllStringIndex(text, -1)
+			if len(locs) == 0 {
+				continue
+			}
+			found = append(found, pattern)
+			for _, loc := range locs {
+				start := len([]rune(text[:loc[0]]))
+				match.Spans = append(match.Spans, [2]int{start, start + len([]rune(text[loc[0]:loc[1]]))})
+			}
+		}
+		if len(found) > 0 {
+			match.Detail = strings.Join(found, ",")
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// redactModerationSpans 遮盖命中的区间，未定位到区间时遮盖整段文本
+func redactModerationSpans(text string, spans [][2]int) string {
+	if len(spans) == 0 {
+		return moderationRedactPlaceholder
+	}
+	runes := []rune(text)
+	masked := make([]bool, len(runes))
+	for _, span := range spans {
+		for i := max(span[0], 0); i < min(span[1], len(runes)); i++ {
+			masked[i] = true
+		}
+	}
+	var builder strings.Builder
+	builder.Grow(len(text))
+	for i := 0; i < len(runes); i++ {
+		if !masked[i] {
+			builder.WriteRune(runes[i])
+			continue
+		}
+		builder.WriteString(moderationRedactPlaceholder)
+		for i+1 < len(runes) && masked[i+1] {
+			i++
+		}
+	}
+	return builder.String()
+}
+
+type openAIModerationResponse struct {
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// openAIModeration 通过本站渠道调用 /v1/moderations，文本逐条审核，图片合并为一次多模态请求
+func openAIModeration(ctx context.Context, stage *operation_setting.ModerationStage, group string, texts []string, images []string) ([]moderationMatch, error) {
+	modelName := stage.Model
+	if modelName == "" {
+		modelName = defaultOpenAIModerationModel
+	}
+	if stage.Group != "" {
+		group = stage.Group
+	}
+	channel, err := model.CacheGetRandomSatisfiedChannel(group, modelName, 0)
+	if err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		return nil, fmt.Errorf("no available channel for %s in group %s", modelName, group)
+	}
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = common.ChannelBaseURLs[channel.Type]
+	}
+	key := strings.TrimSpace(strings.Split(channel.Key, ",")[0])
+	client := GetHttpClient()
+	if proxy, ok := channel.GetSetting()["proxy"].(string); ok && proxy != "" {
+		if client, err = NewProxyHttpClient(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []moderationMatch
+	call := func(input any, textIndex func(int) int) error {
+		body, _ := json.Marshal(map[string]any{"model": modelName, "input": input})
+		var resp openAIModerationResponse
+		if err := postModerationRequest(ctx, client, stage, strings.TrimSuffix(baseURL, "/")+"/v1/moderations",
+			map[string]string{"Authorization": "Bearer " + key}, body, &resp); err != nil {
+			return err
+		}
+		if resp.Error != nil {
+			return errors.New(resp.Error.Message)
+		}
+		for i, r := range resp.Results {
+			for category, flagged := range r.Categories {
+				score := r.CategoryScores[category]
+				if (stage.Threshold > 0 && score >= stage.Threshold) || (stage.Threshold <= 0 && flagged) {
+					matches = append(matches, moderationMatch{
+						Category:  category,
+						Detail:    fmt.Sprintf("%.4f", score),
+						TextIndex: textIndex(i),
+					})
+				}
+			}
+		}
+		return nil
+	}
+	nonEmpty := make([]int, 0, len(texts))
+	input := make([]string, 0, len(texts))
+	for i, text := range texts {
+		if strings.TrimSpace(text) != "" {
+			nonEmpty = append(nonEmpty, i)
+			input = append(input, text)
+		}
+	}
+	if len(input) > 0 {
+		err = call(input, func(i int) int {
+			if i < len(nonEmpty) {
+				return nonEmpty[i]
+			}
+			return -1
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(images) > 0 {
+		parts := make([]map[string]any, 0, len(images))
+		for _, image := range images {
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": image}})
+		}
+		if err = call(parts, func(int) int { return -1 }); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+type httpModerationRequest struct {
+	Direction string   `json:"direction"`
+	Group     string   `json:"group"`
+	Texts     []string `json:"texts"`
+	Images    []string `json:"images"`
+}
+
+// httpModerationResponse 自定义分类服务的响应，index 为 -1 表示针对图片或整体的结果
+type httpModerationResponse struct {
+	Results []struct {
+		Index      int                `json:"index"`
+		Categories map[string]float64 `json:"categories"`
+	} `json:"results"`
+}
+
+// httpModeration 调用自定义 HTTP 分类服务，分类得分达到阈值（默认 0.5）视为命中
+func httpModeration(ctx context.Context, stage *operation_setting.ModerationStage, group string, direction string, texts []string, images []string) ([]moderationMatch, error) {
+	body, _ := json.Marshal(httpModerationRequest{
+		Direction: direction,
+		Group:     group,
+		Texts:     texts,
+		Images:    images,
+	})
+	var resp httpModerationResponse
+	if err := postModerationRequest(ctx, GetHttpClient(), stage, stage.URL, stage.Headers, body, &resp); err != nil {
+		return nil, err
+	}
+	threshold := stage.Threshold
+	if threshold <= 0 {
+		threshold = 0.5
+	}
+	var matches []moderationMatch
+	for _, r := range resp.Results {
+		textIndex := r.Index
+		if textIndex >= len(texts) {
+			textIndex = -1
+		}
+		for category, score := range r.Categories {
+			if score >= threshold {
+				matches = append(matches, moderationMatch{
+					Category:  category,
+					Detail:    fmt.Sprintf("%.4f", score),
+					TextIndex: textIndex,
+				})
+			}
+		}
+	}
+	return matches, nil
+}
+
+func postModerationRequest(ctx context.Context, client *http.Client, stage *operation_setting.ModerationStage, url string, headers map[string]string, body []byte, v any) error {
+	timeout := time.Duration(stage.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("moderation service returned status %d: %s", resp.StatusCode, string(data))
+	}
+	return json.Unmarshal(data, v)
+}
+
+// AppendModerationHits 将命中记录追加到请求上下文，随消费日志一并记录
+func AppendModerationHits(c *gin.Context, hits []ModerationHit) {
+	if existing, ok := c.Get(constant.ContextKeyModerationHits); ok {
+		hits = append(existing.([]ModerationHit), hits...)
+	}
+	c.Set(constant.ContextKeyModerationHits, hits)
+}
+
+// NewStreamOutputCheckers 创建流式输出的审核函数：local 只运行本地阶段，逐窗口调用；
+// remote 只运行分类服务等远程阶段，按累计字符节流调用，没有适用的远程阶段时为 nil。
+// 同一命中在滑动窗口中重复出现时只记录一次
+func NewStreamOutputCheckers(c *gin.Context, group string) (local helper.StreamOutputChecker, remote helper.StreamOutputChecker) {
+	seen := make(map[ModerationHit]bool)
+	newChecker := func(include func(stage *operation_setting.ModerationStage) bool) helper.StreamOutputChecker {
+		return func(text string) (string, bool) {
+			result, err := moderate(c.Request.Context(), group, operation_setting.ModerationDirectionOutput, ModerationInput{
+				Texts: []string{text},
+			}, include)
+			if err != nil {
+				common.LogError(c, "output moderation error: "+err.Error())
+				return text, false
+			}
+			var hits []ModerationHit
+			for _, hit := range result.Hits {
+				if !seen[hit] {
+					seen[hit] = true
+					hits = append(hits, hit)
+				}
+			}
+			if len(hits) > 0 {
+				AppendModerationHits(c, hits)
+				common.LogWarn(c, fmt.Sprintf("output moderation hits for user %d: action=%s, hits=%v", c.GetInt("id"), result.Action, hits))
+			}
+			return result.Texts[0], result.Blocked()
+		}
+	}
+	local = newChecker(isLocalModerationStage)
+	moderationSetting := operation_setting.GetModerationSetting()
+	override := moderationSetting.GroupOverrides[group]
+	for _, stage := range moderationSetting.Stages {
+		if !isLocalModerationStage(&stage) && moderationStageApplies(&stage, &override, operation_setting.ModerationDirectionOutput) {
+			remote = newChecker(func(stage *operation_setting.ModerationStage) bool {
+				return !isLocalModerationStage(stage)
+			})
+			break
+		}
+	}
+	return local, remote
+}
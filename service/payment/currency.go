@@ -0,0 +1,62 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"strings"
+	"veloera/setting/operation_setting"
+
+	"github.com/shopspring/decimal"
+)
+
+// 无小数位的货币，金额的最小单位即为 1 元
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true, "MGA": true,
+	"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+func currencyDecimals(currency string) int32 {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 0
+	}
+	return 2
+}
+
+// ConvertMoney 将按 Price 计价的站内金额换算为渠道结算货币，并按货币精度四舍五入
+func ConvertMoney(money float64, setting operation_setting.PaymentProviderSetting) float64 {
+	rate := setting.ExchangeRate
+	if rate <= 0 {
+		rate = 1
+	}
+	return decimal.NewFromFloat(money).Mul(decimal.NewFromFloat(rate)).
+		Round(currencyDecimals(setting.Currency)).InexactFloat64()
+}
+
+// ToMinorUnits 将金额转换为最小货币单位（如美分）
+func ToMinorUnits(money float64, currency string) int64 {
+	decimals := currencyDecimals(currency)
+	return decimal.NewFromFloat(money).Shift(decimals).Round(0).IntPart()
+}
+
+func FromMinorUnits(amount int64, currency string) float64 {
+	return decimal.NewFromInt(amount).Shift(-currencyDecimals(currency)).InexactFloat64()
+}
+
+// FormatMoney 按货币精度格式化金额
+func FormatMoney(money float64, currency string) string {
+	return decimal.NewFromFloat(money).StringFixed(currencyDecimals(currency))
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"veloera/common"
+	"veloera/model"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mockProvider 测试用支付渠道：直接跳转回站点，回调请求体与通用 webhook 渠道相同但不校验签名。
+// 任何人都能伪造回调完成订单，因此只有设置环境变量 PAYMENT_MOCK_ENABLED=true 时才会注册
+type mockProvider struct{}
+
+func init() {
+	if os.Getenv("PAYMENT_MOCK_ENABLED") == "true" {
+		register(&mockProvider{})
+	}
+}
+
+func (p *mockProvider) Name() string {
+	return "mock"
+}
+
+func (p *mockProvider) Setting() operation_setting.PaymentProviderSetting {
+	return operation_setting.GetPaymentSetting().Mock
+}
+
+func (p *mockProvider) Configured() bool {
+	return true
+}
+
+func (p *mockProvider) CreateCheckout(ctx context.Context, order *Order) (*Checkout, error) {
+	returnURL, err := url.Parse(order.ReturnURL)
+	if err != nil {
+		return nil, err
+	}
+	query := returnURL.Query()
+	query.Set("trade_no", order.TradeNo)
+	returnURL.RawQuery = query.Encode()
+	return &Checkout{URL: returnURL.String(), ProviderOrderId: "mock-" + order.TradeNo}, nil
+}
+
+func (p *mockProvider) ParseNotification(c *gin.Context) (*Notification, error) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	var notification webhookNotification
+	if err = common.DecodeJson(payload, &notification); err != nil {
+		return nil, err
+	}
+	return notification.toNotification()
+}
+
+func (p *mockProvider) Refund(ctx context.Context, topUp *model.TopUp, money float64, reason string) (*RefundResult, error) {
+	return &RefundResult{RefundId: "mock-" + common.GetUUID()}, nil
+}
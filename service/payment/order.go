@@ -0,0 +1,217 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"veloera/common"
+	"veloera/model"
+)
+
+const orderLockTimeout = 10 * time.Second
+
+// FulfillOrder 支付成功后为用户增加额度，同一订单重复回调只会入账一次
+// paidMoney 为渠道回调中的实付金额，为 0 时不校验
+func FulfillOrder(tradeNo string, providerPaymentId string, paidMoney float64) error {
+	owner, err := model.LockOrder(tradeNo, orderLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := model.UnlockOrder(tradeNo, owner); err != nil {
+			common.SysError("failed to unlock order " + tradeNo + ": " + err.Error())
+		}
+	}()
+
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return fmt.Errorf("order %s not found", tradeNo)
+	}
+	if topUp.Status != model.TopUpStatusPending {
+		return nil
+	}
+	if paidMoney > 0 && paidMoney+0.01 < topUp.Money {
+		return fmt.Errorf("order %s paid money %.2f is less than %.2f", tradeNo, paidMoney, topUp.Money)
+	}
+	// 标记订单与入账在同一事务中完成，失败时订单仍为待支付，渠道重试回调即可重新入账
+	fulfilled, err := model.FulfillTopUp(topUp, providerPaymentId)
+	if err != nil || !fulfilled {
+		return err
+	}
+	if topUp.PlanId != 0 {
+		recordSubscriptionLog(topUp)
+		return nil
+	}
+	quota := topUp.GetQuota()
+	if topUp.Currency == "" {
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(quota), topUp.Money))
+	} else {
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用 %s 在线充值成功，充值金额: %v，支付金额：%s %s",
+			topUp.Provider, common.LogQuota(quota), FormatMoney(topUp.Money, topUp.Currency), topUp.Currency))
+	}
+	if topUp.BonusQuota > 0 {
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("兑换活动充值加赠 %s，订单 %s", common.LogQuota(topUp.BonusQuota), tradeNo))
+	}
+	err = model.ProcessRebate(topUp.UserId, quota, "充值")
+	if err != nil {
+		common.SysError(fmt.Sprintf("处理充值返佣失败: %v", err))
+	}
+	return nil
+}
+
+// RefundOrder 管理员发起退款：先锁定并校验订单，再调用渠道退款接口，provider 为 nil 时仅记录线下退款
+func RefundOrder(ctx context.Context, provider Provider, tradeNo string, money float64, reason string, manualRefundId string) error {
+	if money <= 0 {
+		return errors.New("退款金额必须大于 0")
+	}
+	owner, err := model.LockOrder(tradeNo, orderLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := model.UnlockOrder(tradeNo, owner); err != nil {
+			common.SysError("failed to unlock order " + tradeNo + ": " + err.Error())
+		}
+	}()
+
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return errors.New("订单不存在")
+	}
+	if err = validateRefund(topUp, money); err != nil {
+		return err
+	}
+	refundId := manualRefundId
+	if provider != nil {
+		result, err := provider.Refund(ctx, topUp, money, reason)
+		if err != nil {
+			return fmt.Errorf("渠道退款失败: %w", err)
+		}
+		refundId = result.RefundId
+	}
+	return applyRefund(topUp, refundId, money, reason)
+}
+
+// ApplyRefund 记录退款并按退款比例扣回额度，同一 refundId 只会处理一次
+func ApplyRefund(topUp *model.TopUp, refundId string, money float64, reason string) error {
+	if money <= 0 {
+		return errors.New("退款金额必须大于 0")
+	}
+	owner, err := model.LockOrder(topUp.TradeNo, orderLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := model.UnlockOrder(topUp.TradeNo, owner); err != nil {
+			common.SysError("failed to unlock order " + topUp.TradeNo + ": " + err.Error())
+		}
+	}()
+
+	topUp = model.GetTopUpByTradeNo(topUp.TradeNo)
+	if topUp == nil {
+		return errors.New("订单不存在")
+	}
+	// 渠道重复推送已处理的退款回调时订单可能已全额退款，需在校验状态前返回成功
+	applied, err := model.TopUpRefundExists(topUp.Provider + ":" + refundId)
+	if err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if err = validateRefund(topUp, money); err != nil {
+		return err
+	}
+	return applyRefund(topUp, refundId, money, reason)
+}
+
+// validateRefund 校验订单状态与可退金额，调用方需持有订单锁
+func validateRefund(topUp *model.TopUp, money float64) error {
+	if topUp.Status != model.TopUpStatusSuccess && topUp.Status != model.TopUpStatusPartialRefunded {
+		return fmt.Errorf("订单状态为 %s，无法退款", topUp.Status)
+	}
+	if money > topUp.Money-topUp.RefundedMoney+0.0001 {
+		return fmt.Errorf("退款金额超过可退金额 %.2f", topUp.Money-topUp.RefundedMoney)
+	}
+	return nil
+}
+
+// applyRefund 写入退款记录并扣回额度，调用方需持有订单锁并已完成校验
+func applyRefund(topUp *model.TopUp, refundId string, money float64, reason string) error {
+	totalQuota := topUp.GetQuota()
+	quota := totalQuota
+	if topUp.Money > 0 {
+		quota = int(float64(totalQuota) * money / topUp.Money)
+	}
+	quota = min(quota, totalQuota-topUp.RefundedQuota)
+	// 充值加赠按已退额度占比扣回，全部退款时恰好扣回全部加赠
+	bonusQuota := 0
+	if topUp.PlanId == 0 && topUp.BonusQuota > 0 && totalQuota > 0 {
+		bonusQuota = int(int64(topUp.BonusQuota)*int64(topUp.RefundedQuota+quota)/int64(totalQuota)) -
+			int(int64(topUp.BonusQuota)*int64(topUp.RefundedQuota)/int64(totalQuota))
+	}
+
+	deducted, err := model.RecordTopUpRefund(topUp, topUp.Provider+":"+refundId, money, quota, bonusQuota, reason)
+	if errors.Is(err, model.ErrTopUpRefundDuplicated) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if topUp.PlanId != 0 {
+		refundSubscription(topUp, deducted, money, reason)
+		return nil
+	}
+	if bonusQuota > 0 {
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("充值订单 %s 退款，扣回兑换活动充值加赠 %v",
+			topUp.TradeNo, common.LogQuota(bonusQuota)))
+	}
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("充值订单 %s 退款 %s %s，扣除额度 %v，原因：%s",
+		topUp.TradeNo, FormatMoney(money, topUp.Currency), topUp.Currency, common.LogQuota(quota), reason))
+	return nil
+}
+
+// HandleNotification 处理经过验签的回调事件
+func HandleNotification(provider Provider, notification *Notification) error {
+	switch notification.Type {
+	case NotificationPaid:
+		topUp := model.GetTopUpByTradeNo(notification.TradeNo)
+		if topUp == nil || topUp.Provider != provider.Name() {
+			return fmt.Errorf("order %s not found", notification.TradeNo)
+		}
+		return FulfillOrder(notification.TradeNo, notification.ProviderPaymentId, notification.Money)
+	case NotificationRefunded:
+		var topUp *model.TopUp
+		if notification.TradeNo != "" {
+			topUp = model.GetTopUpByTradeNo(notification.TradeNo)
+		} else if notification.ProviderPaymentId != "" {
+			topUp = model.GetTopUpByProviderPaymentId(provider.Name(), notification.ProviderPaymentId)
+		}
+		if topUp == nil || topUp.Provider != provider.Name() {
+			return fmt.Errorf("refund order not found: %s", notification.RefundId)
+		}
+		reason := notification.Reason
+		if reason == "" {
+			reason = provider.Name() + " 退款回调"
+		}
+		return ApplyRefund(topUp, notification.RefundId, notification.Money, reason)
+	}
+	return nil
+}
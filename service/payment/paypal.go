@@ -0,0 +1,340 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	paypalAPIBase        = "https://api-m.paypal.com"
+	paypalSandboxAPIBase = "https://api-m.sandbox.paypal.com"
+)
+
+type paypalProvider struct {
+	mutex       sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+type paypalMoney struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type paypalLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+type paypalOrder struct {
+	Id            string       `json:"id"`
+	Status        string       `json:"status"`
+	Links         []paypalLink `json:"links"`
+	PurchaseUnits []struct {
+		ReferenceId string `json:"reference_id"`
+		CustomId    string `json:"custom_id"`
+		Payments    struct {
+			Captures []paypalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+type paypalCapture struct {
+	Id       string      `json:"id"`
+	Status   string      `json:"status"`
+	CustomId string      `json:"custom_id"`
+	Amount   paypalMoney `json:"amount"`
+}
+
+type paypalRefund struct {
+	Id          string       `json:"id"`
+	Status      string       `json:"status"`
+	CustomId    string       `json:"custom_id"`
+	Amount      paypalMoney  `json:"amount"`
+	NoteToPayer string       `json:"note_to_payer"`
+	Links       []paypalLink `json:"links"`
+}
+
+type paypalWebhookEvent struct {
+	Id        string          `json:"id"`
+	EventType string          `json:"event_type"`
+	Resource  json.RawMessage `json:"resource"`
+}
+
+func init() {
+	register(&paypalProvider{})
+}
+
+func (p *paypalProvider) Name() string {
+	return "paypal"
+}
+
+func (p *paypalProvider) Setting() operation_setting.PaymentProviderSetting {
+	return operation_setting.GetPaymentSetting().PayPal
+}
+
+func (p *paypalProvider) Configured() bool {
+	setting := operation_setting.GetPaymentSetting()
+	return setting.PayPalClientId != "" && setting.PayPalClientSecret != "" && setting.PayPalWebhookId != ""
+}
+
+func (p *paypalProvider) baseURL() string {
+	if operation_setting.GetPaymentSetting().PayPalSandbox {
+		return paypalSandboxAPIBase
+	}
+	return paypalAPIBase
+}
+
+func (p *paypalProvider) getAccessToken(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.accessToken != "" && time.Now().Before(p.expiresAt) {
+		return p.accessToken, nil
+	}
+	setting := operation_setting.GetPaymentSetting()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL()+"/v1/oauth2/token", strings.NewReader("grant_type=client_credentials"))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(setting.PayPalClientId, setting.PayPalClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("paypal oauth failed with status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	p.accessToken = token.AccessToken
+	// 提前一分钟刷新
+	p.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn-60) * time.Second)
+	return p.accessToken, nil
+}
+
+func (p *paypalProvider) request(ctx context.Context, method string, path string, body any, v any) error {
+	token, err := p.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL()+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("paypal request %s failed with status %d: %s", path, resp.StatusCode, string(respBody))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, v)
+}
+
+func (p *paypalProvider) CreateCheckout(ctx context.Context, order *Order) (*Checkout, error) {
+	currency := strings.ToUpper(order.Currency)
+	body := map[string]any{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]any{{
+			"reference_id": order.TradeNo,
+			"custom_id":    order.TradeNo,
+			"description":  order.Title,
+			"amount": paypalMoney{
+				CurrencyCode: currency,
+				Value:        FormatMoney(order.Money, currency),
+			},
+		}},
+		"application_context": map[string]any{
+			"return_url":  order.ReturnURL,
+			"cancel_url":  order.CancelURL,
+			"user_action": "PAY_NOW",
+		},
+	}
+	var created paypalOrder
+	if err := p.request(ctx, http.MethodPost, "/v2/checkout/orders", body, &created); err != nil {
+		return nil, err
+	}
+	for _, link := range created.Links {
+		if link.Rel == "approve" || link.Rel == "payer-action" {
+			return &Checkout{URL: link.Href, ProviderOrderId: created.Id}, nil
+		}
+	}
+	return nil, errors.New("paypal order has no approve link")
+}
+
+// CaptureOrder 用户在 PayPal 确认付款后扣款，返回扣款信息
+func (p *paypalProvider) CaptureOrder(ctx context.Context, orderId string) (*Notification, error) {
+	var captured paypalOrder
+	if err := p.request(ctx, http.MethodPost, "/v2/checkout/orders/"+orderId+"/capture", map[string]any{}, &captured); err != nil {
+		return nil, err
+	}
+	for _, unit := range captured.PurchaseUnits {
+		for _, capture := range unit.Payments.Captures {
+			if capture.Status != "COMPLETED" {
+				continue
+			}
+			money, _ := strconv.ParseFloat(capture.Amount.Value, 64)
+			return &Notification{
+				Type:              NotificationPaid,
+				TradeNo:           unit.ReferenceId,
+				ProviderPaymentId: capture.Id,
+				Money:             money,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("paypal order %s is not completed: %s", orderId, captured.Status)
+}
+
+func (p *paypalProvider) verifyWebhook(ctx context.Context, c *gin.Context, payload []byte) error {
+	body := map[string]any{
+		"auth_algo":         c.GetHeader("PAYPAL-AUTH-ALGO"),
+		"cert_url":          c.GetHeader("PAYPAL-CERT-URL"),
+		"transmission_id":   c.GetHeader("PAYPAL-TRANSMISSION-ID"),
+		"transmission_sig":  c.GetHeader("PAYPAL-TRANSMISSION-SIG"),
+		"transmission_time": c.GetHeader("PAYPAL-TRANSMISSION-TIME"),
+		"webhook_id":        operation_setting.GetPaymentSetting().PayPalWebhookId,
+		"webhook_event":     json.RawMessage(payload),
+	}
+	var result struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/v1/notifications/verify-webhook-signature", body, &result); err != nil {
+		return err
+	}
+	if result.VerificationStatus != "SUCCESS" {
+		return errors.New("paypal webhook verification failed")
+	}
+	return nil
+}
+
+func (p *paypalProvider) ParseNotification(c *gin.Context) (*Notification, error) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.verifyWebhook(c.Request.Context(), c, payload); err != nil {
+		return nil, err
+	}
+	var event paypalWebhookEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	switch event.EventType {
+	case "PAYMENT.CAPTURE.COMPLETED":
+		var capture paypalCapture
+		if err = json.Unmarshal(event.Resource, &capture); err != nil {
+			return nil, err
+		}
+		money, _ := strconv.ParseFloat(capture.Amount.Value, 64)
+		return &Notification{
+			Type:              NotificationPaid,
+			TradeNo:           capture.CustomId,
+			ProviderPaymentId: capture.Id,
+			Money:             money,
+		}, nil
+	case "PAYMENT.CAPTURE.REFUNDED":
+		var refund paypalRefund
+		if err = json.Unmarshal(event.Resource, &refund); err != nil {
+			return nil, err
+		}
+		money, _ := strconv.ParseFloat(refund.Amount.Value, 64)
+		notification := &Notification{
+			Type:     NotificationRefunded,
+			TradeNo:  refund.CustomId,
+			Money:    money,
+			RefundId: refund.Id,
+			Reason:   refund.NoteToPayer,
+		}
+		// 未携带 custom_id 时通过 up 链接中的扣款编号定位订单
+		for _, link := range refund.Links {
+			if link.Rel == "up" {
+				notification.ProviderPaymentId = link.Href[strings.LastIndex(link.Href, "/")+1:]
+			}
+		}
+		return notification, nil
+	}
+	return &Notification{Type: NotificationIgnored}, nil
+}
+
+func (p *paypalProvider) Refund(ctx context.Context, topUp *model.TopUp, money float64, reason string) (*RefundResult, error) {
+	if topUp.ProviderPaymentId == "" {
+		return nil, errors.New("订单缺少 PayPal 扣款编号，无法退款")
+	}
+	body := map[string]any{
+		"amount": paypalMoney{
+			CurrencyCode: strings.ToUpper(topUp.Currency),
+			Value:        FormatMoney(money, topUp.Currency),
+		},
+		"custom_id":     topUp.TradeNo,
+		"note_to_payer": reason,
+	}
+	var refund paypalRefund
+	if err := p.request(ctx, http.MethodPost, "/v2/payments/captures/"+topUp.ProviderPaymentId+"/refund", body, &refund); err != nil {
+		return nil, err
+	}
+	return &RefundResult{RefundId: refund.Id}, nil
+}
+
+// CapturePayPalOrder 处理用户从 PayPal 返回后的扣款
+func CapturePayPalOrder(ctx context.Context, orderId string) (*Notification, error) {
+	provider, ok := GetProvider("paypal")
+	if !ok {
+		return nil, errors.New("paypal is not configured")
+	}
+	return provider.(*paypalProvider).CaptureOrder(ctx, orderId)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"context"
+	"sort"
+	"veloera/model"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Order 发起支付所需的订单信息，Money 已按渠道汇率换算为渠道结算货币
+type Order struct {
+	TradeNo   string
+	UserId    int
+	Quota     int
+	Money     float64
+	Currency  string
+	Title     string
+	ReturnURL string
+	CancelURL string
+	NotifyURL string
+}
+
+// Checkout 渠道返回的支付跳转信息
+type Checkout struct {
+	URL             string            `json:"url"`
+	Params          map[string]string `json:"params,omitempty"`
+	ProviderOrderId string            `json:"-"`
+}
+
+const (
+	NotificationPaid     = "paid"
+	NotificationRefunded = "refunded"
+	NotificationIgnored  = "ignored"
+)
+
+// Notification 经过验签的渠道回调
+type Notification struct {
+	Type              string
+	TradeNo           string
+	ProviderPaymentId string
+	Money             float64
+	RefundId          string
+	Reason            string
+}
+
+type RefundResult struct {
+	RefundId string
+}
+
+// Provider 支付渠道
+type Provider interface {
+	Name() string
+	Setting() operation_setting.PaymentProviderSetting
+	// Configured 渠道必需的密钥等配置是否已填写
+	Configured() bool
+	CreateCheckout(ctx context.Context, order *Order) (*Checkout, error)
+	// ParseNotification 校验回调签名并解析事件
+	ParseNotification(c *gin.Context) (*Notification, error)
+	Refund(ctx context.Context, topUp *model.TopUp, money float64, reason string) (*RefundResult, error)
+}
+
+// ProviderInfo 展示给用户的渠道信息
+type ProviderInfo struct {
+	Name         string  `json:"name"`
+	Currency     string  `json:"currency"`
+	ExchangeRate float64 `json:"exchange_rate"`
+}
+
+var providers = make(map[string]Provider)
+
+func register(provider Provider) {
+	providers[provider.Name()] = provider
+}
+
+// GetProvider 返回已启用且已配置的渠道
+func GetProvider(name string) (Provider, bool) {
+	provider, ok := providers[name]
+	if !ok || !provider.Setting().Enabled || !provider.Configured() {
+		return nil, false
+	}
+	return provider, true
+}
+
+func GetEnabledProviders() []ProviderInfo {
+	infos := make([]ProviderInfo, 0, len(providers))
+	for name := range providers {
+		provider, ok := GetProvider(name)
+		if !ok {
+			continue
+		}
+		setting := provider.Setting()
+		infos = append(infos, ProviderInfo{
+			Name:         name,
+			Currency:     setting.Currency,
+			ExchangeRate: setting.ExchangeRate,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
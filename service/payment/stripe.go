@@ -0,0 +1,236 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const stripeAPIBase = "https://api.stripe.com/v1"
+
+// stripeSignatureTolerance 回调时间戳允许的最大偏差
+const stripeSignatureTolerance = 5 * time.Minute
+
+type stripeProvider struct{}
+
+type stripeEvent struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeCheckoutSession struct {
+	Id                string `json:"id"`
+	Url               string `json:"url"`
+	ClientReferenceId string `json:"client_reference_id"`
+	PaymentStatus     string `json:"payment_status"`
+	PaymentIntent     string `json:"payment_intent"`
+	AmountTotal       int64  `json:"amount_total"`
+	Currency          string `json:"currency"`
+}
+
+type stripeRefund struct {
+	Id            string `json:"id"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	PaymentIntent string `json:"payment_intent"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason"`
+}
+
+type stripeError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func init() {
+	register(&stripeProvider{})
+}
+
+func (p *stripeProvider) Name() string {
+	return "stripe"
+}
+
+func (p *stripeProvider) Setting() operation_setting.PaymentProviderSetting {
+	return operation_setting.GetPaymentSetting().Stripe
+}
+
+func (p *stripeProvider) Configured() bool {
+	setting := operation_setting.GetPaymentSetting()
+	return setting.StripeSecretKey != "" && setting.StripeWebhookSecret != ""
+}
+
+func (p *stripeProvider) request(ctx context.Context, path string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stripeAPIBase+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+operation_setting.GetPaymentSetting().StripeSecretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var stripeErr stripeError
+		_ = json.Unmarshal(body, &stripeErr)
+		return fmt.Errorf("stripe request failed with status %d: %s", resp.StatusCode, stripeErr.Error.Message)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (p *stripeProvider) CreateCheckout(ctx context.Context, order *Order) (*Checkout, error) {
+	currency := strings.ToLower(order.Currency)
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("success_url", order.ReturnURL)
+	form.Set("cancel_url", order.CancelURL)
+	form.Set("client_reference_id", order.TradeNo)
+	form.Set("metadata[trade_no]", order.TradeNo)
+	form.Set("payment_intent_data[metadata][trade_no]", order.TradeNo)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", currency)
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(ToMinorUnits(order.Money, currency), 10))
+	form.Set("line_items[0][price_data][product_data][name]", order.Title)
+	var session stripeCheckoutSession
+	if err := p.request(ctx, "/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &Checkout{URL: session.Url, ProviderOrderId: session.Id}, nil
+}
+
+// verifySignature 校验 Stripe-Signature 头：t=时间戳,v1=HMAC-SHA256(secret, "t.payload")
+func (p *stripeProvider) verifySignature(header string, payload []byte) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errors.New("invalid stripe signature header")
+	}
+	if time.Since(time.Unix(ts, 0)).Abs() > stripeSignatureTolerance {
+		return errors.New("stripe signature timestamp out of tolerance")
+	}
+	mac := hmac.New(sha256.New, []byte(operation_setting.GetPaymentSetting().StripeWebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("stripe signature mismatch")
+}
+
+func (p *stripeProvider) ParseNotification(c *gin.Context) (*Notification, error) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.verifySignature(c.GetHeader("Stripe-Signature"), payload); err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		var session stripeCheckoutSession
+		if err = json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, err
+		}
+		if session.PaymentStatus != "paid" {
+			return &Notification{Type: NotificationIgnored}, nil
+		}
+		return &Notification{
+			Type:              NotificationPaid,
+			TradeNo:           session.ClientReferenceId,
+			ProviderPaymentId: session.PaymentIntent,
+			Money:             FromMinorUnits(session.AmountTotal, session.Currency),
+		}, nil
+	case "refund.created", "refund.updated":
+		var refund stripeRefund
+		if err = json.Unmarshal(event.Data.Object, &refund); err != nil {
+			return nil, err
+		}
+		if refund.Status != "succeeded" {
+			return &Notification{Type: NotificationIgnored}, nil
+		}
+		return &Notification{
+			Type:              NotificationRefunded,
+			ProviderPaymentId: refund.PaymentIntent,
+			Money:             FromMinorUnits(refund.Amount, refund.Currency),
+			RefundId:          refund.Id,
+			Reason:            refund.Reason,
+		}, nil
+	}
+	return &Notification{Type: NotificationIgnored}, nil
+}
+
+func (p *stripeProvider) Refund(ctx context.Context, topUp *model.TopUp, money float64, reason string) (*RefundResult, error) {
+	if topUp.ProviderPaymentId == "" {
+		return nil, errors.New("订单缺少 Stripe PaymentIntent，无法退款")
+	}
+	form := url.Values{}
+	form.Set("payment_intent", topUp.ProviderPaymentId)
+	form.Set("amount", strconv.FormatInt(ToMinorUnits(money, topUp.Currency), 10))
+	form.Set("metadata[trade_no]", topUp.TradeNo)
+	form.Set("metadata[reason]", reason)
+	var refund stripeRefund
+	if err := p.request(ctx, "/refunds", form, &refund); err != nil {
+		return nil, err
+	}
+	return &RefundResult{RefundId: refund.Id}, nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"fmt"
+	"veloera/common"
+	"veloera/model"
+)
+
+// recordSubscriptionLog 订阅订单入账后记录开通日志
+func recordSubscriptionLog(topUp *model.TopUp) {
+	planName := fmt.Sprintf("#%d", topUp.PlanId)
+	if plan, err := model.GetSubscriptionPlanById(topUp.PlanId); err == nil {
+		planName = plan.Name
+	}
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用 %s 订阅套餐 %s 成功，周期数：%d，支付金额：%s %s",
+		topUp.Provider, planName, topUp.Periods, FormatMoney(topUp.Money, topUp.Currency), topUp.Currency))
+}
+
+// refundSubscription 订阅订单退款记录后的收尾：全额退款时取消订阅并记录扣回的订阅额度
+func refundSubscription(topUp *model.TopUp, deducted int, money float64, reason string) {
+	if topUp.Status == model.TopUpStatusRefunded {
+		if err := model.CancelSubscription(topUp.UserId, topUp.TradeNo); err != nil {
+			common.SysError(fmt.Sprintf("取消订阅失败，订单 %s: %s", topUp.TradeNo, err.Error()))
+		}
+	}
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("订阅订单 %s 退款 %s %s，扣回订阅额度 %v，原因：%s",
+		topUp.TradeNo, FormatMoney(money, topUp.Currency), topUp.Currency, common.LogQuota(deducted), reason))
+}
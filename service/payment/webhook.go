@@ -0,0 +1,187 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+// webhookSignatureTolerance 回调时间戳允许的最大偏差
+const webhookSignatureTolerance = 5 * time.Minute
+
+// webhookNotification 通用回调的请求体，签名为请求体的 HMAC-SHA256
+type webhookNotification struct {
+	Event     string      `json:"event"` // paid 或 refunded
+	TradeNo   string      `json:"trade_no"`
+	PaymentId string      `json:"payment_id"`
+	Amount    json.Number `json:"amount"`
+	RefundId  string      `json:"refund_id"`
+	Reason    string      `json:"reason"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+func (n *webhookNotification) toNotification() (*Notification, error) {
+	money, _ := n.Amount.Float64()
+	switch n.Event {
+	case NotificationPaid:
+		return &Notification{
+			Type:              NotificationPaid,
+			TradeNo:           n.TradeNo,
+			ProviderPaymentId: n.PaymentId,
+			Money:             money,
+		}, nil
+	case NotificationRefunded:
+		if n.RefundId == "" {
+			return nil, errors.New("refund_id is required")
+		}
+		return &Notification{
+			Type:              NotificationRefunded,
+			TradeNo:           n.TradeNo,
+			ProviderPaymentId: n.PaymentId,
+			Money:             money,
+			RefundId:          n.RefundId,
+			Reason:            n.Reason,
+		}, nil
+	}
+	return &Notification{Type: NotificationIgnored}, nil
+}
+
+type webhookProvider struct{}
+
+func init() {
+	register(&webhookProvider{})
+}
+
+func (p *webhookProvider) Name() string {
+	return "webhook"
+}
+
+func (p *webhookProvider) Setting() operation_setting.PaymentProviderSetting {
+	return operation_setting.GetPaymentSetting().Webhook
+}
+
+func (p *webhookProvider) Configured() bool {
+	setting := operation_setting.GetPaymentSetting()
+	return setting.WebhookCheckoutURL != "" && setting.WebhookSecret != ""
+}
+
+func (p *webhookProvider) CreateCheckout(ctx context.Context, order *Order) (*Checkout, error) {
+	setting := operation_setting.GetPaymentSetting()
+	checkoutURL, err := url.Parse(setting.WebhookCheckoutURL)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("trade_no", order.TradeNo)
+	params.Set("amount", FormatMoney(order.Money, order.Currency))
+	params.Set("currency", order.Currency)
+	params.Set("title", order.Title)
+	params.Set("notify_url", order.NotifyURL)
+	params.Set("return_url", order.ReturnURL)
+	params.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	// 对按键名排序后的查询串签名
+	params.Set("signature", service.GenerateWebhookSignature(setting.WebhookSecret, []byte(params.Encode())))
+	query := checkoutURL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	checkoutURL.RawQuery = query.Encode()
+	return &Checkout{URL: checkoutURL.String()}, nil
+}
+
+func (p *webhookProvider) ParseNotification(c *gin.Context) (*Notification, error) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	expected := service.GenerateWebhookSignature(operation_setting.GetPaymentSetting().WebhookSecret, payload)
+	if !hmac.Equal([]byte(c.GetHeader(webhookSignatureHeader)), []byte(expected)) {
+		return nil, errors.New("webhook signature mismatch")
+	}
+	var notification webhookNotification
+	if err = common.DecodeJson(payload, &notification); err != nil {
+		return nil, err
+	}
+	// 时间戳参与签名，缺失时无法防止旧回调被重放
+	if notification.Timestamp <= 0 {
+		return nil, errors.New("webhook timestamp is required")
+	}
+	if time.Since(time.Unix(notification.Timestamp, 0)).Abs() > webhookSignatureTolerance {
+		return nil, errors.New("webhook timestamp out of tolerance")
+	}
+	return notification.toNotification()
+}
+
+func (p *webhookProvider) Refund(ctx context.Context, topUp *model.TopUp, money float64, reason string) (*RefundResult, error) {
+	setting := operation_setting.GetPaymentSetting()
+	if setting.WebhookRefundURL == "" {
+		return nil, errors.New("未配置通用支付退款地址")
+	}
+	payload, err := json.Marshal(map[string]any{
+		"trade_no":   topUp.TradeNo,
+		"payment_id": topUp.ProviderPaymentId,
+		"amount":     FormatMoney(money, topUp.Currency),
+		"currency":   topUp.Currency,
+		"reason":     reason,
+		"timestamp":  time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, setting.WebhookRefundURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, service.GenerateWebhookSignature(setting.WebhookSecret, payload))
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("webhook refund failed with status %d", resp.StatusCode)
+	}
+	var result struct {
+		RefundId string `json:"refund_id"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.RefundId == "" {
+		return nil, errors.New("webhook refund response has no refund_id")
+	}
+	return &RefundResult{RefundId: result.RefundId}, nil
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/relay/helper"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type piiDetector struct {
+	name     string
+	re       *regexp.Regexp
+	validate func(string) bool
+}
+
+// builtinPiiDetectors 按顺序匹配，身份证号与卡号先于电话号码，避免长数字被拆成电话号码
+var builtinPiiDetectors = []piiDetector{
+	{
+		name: operation_setting.PiiTypeNationalId,
+		re:   regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b|\b\d{3}-\d{2}-\d{4}\b`),
+	},
+	{
+		name:     operation_setting.PiiTypeCard,
+		re:       regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		validate: luhnValid,
+	},
+	{
+		name: operation_setting.PiiTypeEmail,
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		name: operation_setting.PiiTypePhone,
+		re:   regexp.MustCompile(`(?:\+\d{1,3}[ -]?)?\b(?:1[3-9]\d{9}|\d{3}[ .-]\d{3}[ .-]\d{4})\b`),
+	},
+}
+
+var piiPlaceholderPattern = regexp.MustCompile(`\[PII_[A-Z0-9_]+_\d+\]`)
+
+var piiNameReplacer = regexp.MustCompile(`[^A-Z0-9]+`)
+
+var piiCustomRegexps sync.Map
+
+func luhnValid(s string) bool {
+	sum := 0
+	digits := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// PiiMasker 单个请求内的脱敏映射。映射只保存在本次请求的内存中，不写入日志、缓存或数据库
+type PiiMasker struct {
+	originals    map[string]string // 占位符 -> 原文
+	placeholders map[string]string // 原文 -> 占位符
+	counters     map[string]int
+	detectors    []piiDetector
+}
+
+func NewPiiMasker() *PiiMasker {
+	piiSetting := operation_setting.GetPiiMaskSetting()
+	masker := &PiiMasker{
+		originals:    make(map[string]string),
+		placeholders: make(map[string]string),
+		counters:     make(map[string]int),
+	}
+	for _, detector := range builtinPiiDetectors {
+		if common.StringsContains(piiSetting.Types, detector.name) {
+			masker.detectors = append(masker.detectors, detector)
+		}
+	}
+	for name, pattern := range piiSetting.CustomPatterns {
+		re, ok := piiCustomRegexps.Load(pattern)
+		if !ok {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				common.SysError("invalid pii pattern " + name + ": " + err.Error())
+			}
+			re, _ = piiCustomRegexps.LoadOrStore(pattern, compiled)
+		}
+		if compiled := re.(*regexp.Regexp); compiled != nil {
+			masker.detectors = append(masker.detectors, piiDetector{name: name, re: compiled})
+		}
+	}
+	return masker
+}
+
+func (m *PiiMasker) placeholder(name string, original string) string {
+	if placeholder, ok := m.placeholders[original]; ok {
+		return placeholder
+	}
+	label := strings.Trim(piiNameReplacer.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+	if label == "" {
+		label = "CUSTOM"
+	}
+	m.counters[label]++
+	placeholder := fmt.Sprintf("[PII_%s_%d]", label, m.counters[label])
+	m.placeholders[original] = placeholder
+	m.originals[placeholder] = original
+	return placeholder
+}
+
+// Mask 将文本中的敏感信息替换为占位符，已有的占位符不会被再次匹配
+func (m *PiiMasker) Mask(text string) string {
+	for _, detector := range m.detectors {
+		var builder strings.Builder
+		last := 0
+		replace := func(segment string) {
+			builder.WriteString(detector.re.ReplaceAllStringFunc(segment, func(s string) string {
+				if detector.validate != nil && !detector.validate(s) {
+					return s
+				}
+				return m.placeholder(detector.name, s)
+			}))
+		}
+		for _, loc := range piiPlaceholderPattern.FindAllStringIndex(text, -1) {
+			replace(text[last:loc[0]])
+			builder.WriteString(text[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		replace(text[last:])
+		text = builder.String()
+	}
+	return text
+}
+
+func (m *PiiMasker) Masked() bool {
+	return len(m.originals) > 0
+}
+
+// Counts 各类型的脱敏数量，用于日志记录
+func (m *PiiMasker) Counts() map[string]int {
+	counts := make(map[string]int, len(m.counters))
+	for label, n := range m.counters {
+		counts[strings.ToLower(label)] = n
+	}
+	return counts
+}
+
+// Restore 将文本中的占位符还原为原文
+func (m *PiiMasker) Restore(text string) string {
+	if !m.Masked() || !strings.Contains(text, "[PII_") {
+		return text
+	}
+	return piiPlaceholderPattern.ReplaceAllStringFunc(text, func(s string) string {
+		if original, ok := m.originals[s]; ok {
+			return original
+		}
+		return s
+	})
+}
+
+// RestoreJSON 还原 JSON 字符串中的占位符，原文按 JSON 字符串转义
+func (m *PiiMasker) RestoreJSON(data []byte) []byte {
+	if !m.Masked() || !strings.Contains(string(data), "[PII_") {
+		return data
+	}
+	return piiPlaceholderPattern.ReplaceAllFunc(data, func(s []byte) []byte {
+		original, ok := m.originals[string(s)]
+		if !ok {
+			return s
+		}
+		escaped, _ := json.Marshal(original)
+		return escaped[1 : len(escaped)-1]
+	})
+}
+
+func (m *PiiMasker) maskMessage(message *dto.Message) {
+	if message.IsStringContent() {
+		content := message.StringContent()
+		if masked := m.Mask(content); masked != content {
+			message.SetStringContent(masked)
+		}
+		return
+	}
+	contents := message.ParseContent()
+	changed := false
+	for i := range contents {
+		if contents[i].Type != dto.ContentTypeText {
+			continue
+		}
+		if masked := m.Mask(contents[i].Text); masked != contents[i].Text {
+			contents[i].Text = masked
+			changed = true
+		}
+	}
+	if changed {
+		message.SetMediaContent(contents)
+	}
+}
+
+// piiStreamRestorer 还原流式输出中的占位符，分片末尾可能是未完整的占位符时保留到下一分片
+type piiStreamRestorer struct {
+	masker  *PiiMasker
+	pending map[string]string
+}
+
+func (r *piiStreamRestorer) Feed(key string, text string) (string, bool) {
+	buf := r.pending[key] + text
+	hold := len(buf)
+	if i := strings.LastIndex(buf, "["); i >= 0 && isPiiPlaceholderPrefix(buf[i:]) {
+		hold = i
+	}
+	r.pending[key] = buf[hold:]
+	return r.masker.Restore(buf[:hold]), false
+}
+
+func (r *piiStreamRestorer) Flush(key string) (string, bool) {
+	buf := r.pending[key]
+	delete(r.pending, key)
+	return r.masker.Restore(buf), false
+}
+
+func isPiiPlaceholderPrefix(s string) bool {
+	if len(s) <= len("[PII_") {
+		return strings.HasPrefix("[PII_", s)
+	}
+	if !strings.HasPrefix(s, "[PII_") || len(s) > 64 {
+		return false
+	}
+	for _, r := range s[len("[PII_"):] {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// piiRestoreWriter 还原非流式响应体中的占位符
+type piiRestoreWriter struct {
+	gin.ResponseWriter
+	masker *PiiMasker
+}
+
+func (w *piiRestoreWriter) Write(data []byte) (int, error) {
+	restored := w.masker.RestoreJSON(data)
+	if len(restored) != len(data) && !w.Written() {
+		w.Header().Del("Content-Length")
+	}
+	if _, err := w.ResponseWriter.Write(restored); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *piiRestoreWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// ShouldMaskPii 令牌单独设置优先，否则按分组设置
+func ShouldMaskPii(c *gin.Context) bool {
+	if !operation_setting.GetPiiMaskSetting().Enabled {
+		return false
+	}
+	switch c.GetInt("token_pii_mask") {
+	case model.TokenPiiMaskEnabled:
+		return true
+	case model.TokenPiiMaskDisabled:
+		return false
+	}
+	return operation_setting.PiiMaskEnabledForGroup(c.GetString("group"))
+}
+
+// MaskRequestPii 在转换为上游请求前对消息内容脱敏，并在响应（含流式响应）中还原占位符。
+// 重试时复用同一映射，返回是否有内容被替换
+func MaskRequestPii(c *gin.Context, request *dto.GeneralOpenAIRequest) bool {
+	if !ShouldMaskPii(c) {
+		return false
+	}
+	masker, installed := requestPiiMasker(c)
+	for i := range request.Messages {
+		masker.maskMessage(&request.Messages[i])
+	}
+	if !masker.Masked() {
+		return false
+	}
+	if !installed {
+		installPiiMasker(c, masker)
+	}
+	return true
+}
+
+// piiTextKeys 请求体中需要脱敏的文本字段，覆盖 Claude Messages、Responses 与 Embeddings 请求
+var piiTextKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"prompt":       true,
+	"input":        true,
+	"instructions": true,
+	"system":       true,
+	"query":        true,
+}
+
+// MaskRequestBodyPii 在解析请求前对 JSON 请求体中的文本字段脱敏并替换请求体，用于非 Chat Completions 格式的请求，
+// 之后的解析与透传都使用脱敏后的请求体，响应中的占位符同样会被还原。返回是否有内容被替换
+func MaskRequestBodyPii(c *gin.Context) (bool, error) {
+	if !ShouldMaskPii(c) || !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return false, nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return false, err
+	}
+	var body any
+	decoder := json.NewDecoder(bytes.NewReader(requestBody))
+	decoder.UseNumber()
+	if err = decoder.Decode(&body); err != nil {
+		// 交由后续流程报告请求格式错误
+		return false, nil
+	}
+	masker, installed := requestPiiMasker(c)
+	body, masked := masker.maskJSON(body, false)
+	if !masked {
+		return false, nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(body); err != nil {
+		return false, err
+	}
+	requestBody = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	c.Set(common.KeyRequestBody, requestBody)
+	c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	c.Request.ContentLength = int64(len(requestBody))
+	if !installed {
+		installPiiMasker(c, masker)
+	}
+	return true, nil
+}
+
+// maskJSON 脱敏 piiTextKeys 字段下的字符串，包括其中数组与对象内的文本，返回是否有内容被替换
+func (m *PiiMasker) maskJSON(node any, textField bool) (any, bool) {
+	changed := false
+	switch v := node.(type) {
+	case map[string]any:
+		for key, child := range v {
+			masked, ok := m.maskJSON(child, piiTextKeys[key])
+			v[key], changed = masked, changed || ok
+		}
+	case []any:
+		for i, child := range v {
+			masked, ok := m.maskJSON(child, textField)
+			v[i], changed = masked, changed || ok
+		}
+	case string:
+		if textField {
+			masked := m.Mask(v)
+			return masked, masked != v
+		}
+	}
+	return node, changed
+}
+
+// requestPiiMasker 返回本次请求已安装的映射，重试时复用，没有时新建
+func requestPiiMasker(c *gin.Context) (*PiiMasker, bool) {
+	if existing, ok := c.Get(constant.ContextKeyPiiMasker); ok {
+		return existing.(*PiiMasker), true
+	}
+	return NewPiiMasker(), false
+}
+
+// installPiiMasker 保存映射并在响应（含流式响应）中还原占位符
+func installPiiMasker(c *gin.Context, masker *PiiMasker) {
+	c.Set(constant.ContextKeyPiiMasker, masker)
+	c.Writer = &piiRestoreWriter{ResponseWriter: c.Writer, masker: masker}
+	helper.AddStreamTextProcessor(c, &piiStreamRestorer{masker: masker, pending: make(map[string]string)})
+}
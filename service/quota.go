@@ -94,11 +94,10 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userBalance, err := model.GetUserQuotaBalance(relayInfo.UserId, false)
+	totalQuota, err := GetRelayQuota(relayInfo)
 	if err != nil {
 		return err
 	}
-	totalQuota := userBalance.Total()
 	relayInfo.UserQuota = totalQuota
 
 	token, err := model.GetTokenByKey(strings.TrimLeft(relayInfo.TokenKey, "sk-"), false)
@@ -152,6 +151,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	modelPrice float64, usePrice bool, extraContent string) {
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
+	SettleTPM(ctx, relayInfo, usage.TotalTokens)
 	textInputTokens := usage.InputTokenDetails.TextTokens
 	textOutTokens := usage.OutputTokenDetails.TextTokens
 
@@ -224,6 +224,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
+	SettleTPM(ctx, relayInfo, promptTokens+completionTokens)
 	modelName := relayInfo.OriginModelName
 
 	tokenName := ctx.GetString("token_name")
@@ -289,6 +290,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
+	SettleTPM(ctx, relayInfo, usage.PromptTokens+usage.CompletionTokens)
 	textInputTokens := usage.PromptTokensDetails.TextTokens
 	textOutTokens := usage.CompletionTokenDetails.TextTokens
 
@@ -372,6 +374,11 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	// 周期限额在预扣时同步占用，后续步骤失败时退还
+	err := ReserveSpendingUsage(relayInfo, quota)
+	if err != nil {
+		return err
+	}
 	if relayInfo.IsPlayground {
 		return nil
 	}
@@ -380,24 +387,83 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	//}
 	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
 	if err != nil {
+		RecordSpendingUsage(relayInfo, -quota)
 		return err
 	}
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
+		RecordSpendingUsage(relayInfo, -quota)
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
 	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
 	if err != nil {
+		RecordSpendingUsage(relayInfo, -quota)
 		return err
 	}
 	return nil
 }
 
+// GetRelayQuota 返回本次请求可用的额度：组织令牌为组织额度池余额，否则为用户余额与订阅额度之和，
+// 套餐不支持当前模型时只计入余额
+func GetRelayQuota(relayInfo *relaycommon.RelayInfo) (int, error) {
+	if relayInfo.OrgId == 0 {
+		balance, err := model.GetUserQuotaBalance(relayInfo.UserId, false)
+		if err != nil {
+			return 0, err
+		}
+		if relayInfo.SubscriptionExcluded {
+			return balance.Quota, nil
+		}
+		return balance.Total(), nil
+	}
+	org, err := model.CacheGetOrganization(relayInfo.OrgId)
+	if err != nil {
+		return 0, err
+	}
+	if org.Status != model.OrganizationStatusEnabled {
+		return 0, errors.New("organization is disabled")
+	}
+	return org.Quota, nil
+}
+
+// ConsumeRelayQuota 扣减本次请求的额度，组织令牌从组织额度池扣减，返回值含义同 model.ConsumeUserQuota
+func ConsumeRelayQuota(relayInfo *relaycommon.RelayInfo, amount int) (subscriptionUsed int, quotaUsed int, err error) {
+	change := RelayQuotaChange(relayInfo, model.QuotaReasonConsume)
+	if relayInfo.OrgId == 0 {
+		return model.ConsumeUserQuota(relayInfo.UserId, amount, !relayInfo.SubscriptionExcluded, change)
+	}
+	err = model.ConsumeOrganizationQuota(relayInfo.OrgId, relayInfo.OrgMemberId, relayInfo.UserId, amount, change)
+	if err != nil {
+		return 0, 0, err
+	}
+	return 0, amount, nil
+}
+
+// RestoreRelayQuota 退还本次请求扣减的额度
+func RestoreRelayQuota(relayInfo *relaycommon.RelayInfo, subscriptionAmount int, quotaAmount int) error {
+	change := RelayQuotaChange(relayInfo, model.QuotaReasonConsumeRefund)
+	if relayInfo.OrgId == 0 {
+		return model.RestoreUserQuota(relayInfo.UserId, subscriptionAmount, quotaAmount, change)
+	}
+	return model.RestoreOrganizationQuota(relayInfo.OrgId, relayInfo.OrgMemberId, relayInfo.UserId,
+		subscriptionAmount+quotaAmount, change)
+}
+
+// RelayQuotaChange 生成本次请求额度变动的账本信息，以请求 ID 关联
+func RelayQuotaChange(relayInfo *relaycommon.RelayInfo, reason string) model.QuotaChange {
+	return model.QuotaChange{
+		Reason:  reason,
+		RefId:   relayInfo.RequestId,
+		ActorId: relayInfo.UserId,
+		Remark:  relayInfo.OriginModelName,
+	}
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
 	var subscriptionUsed, quotaUsed int
 	if quota > 0 {
 		var consumeErr error
-		subscriptionUsed, quotaUsed, consumeErr = model.ConsumeUserQuota(relayInfo.UserId, quota)
+		subscriptionUsed, quotaUsed, consumeErr = ConsumeRelayQuota(relayInfo, quota)
 		if consumeErr != nil {
 			return consumeErr
 		}
@@ -430,7 +496,7 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 			quotaRefund += refundTotal
 		}
 		if subscriptionRefund > 0 || quotaRefund > 0 {
-			err = model.RestoreUserQuota(relayInfo.UserId, subscriptionRefund, quotaRefund)
+			err = RestoreRelayQuota(relayInfo, subscriptionRefund, quotaRefund)
 			if err != nil {
 				return err
 			}
@@ -443,7 +509,7 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 			tokenErr := model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
 			if tokenErr != nil {
 				if subscriptionUsed > 0 || quotaUsed > 0 {
-					rollbackErr := model.RestoreUserQuota(relayInfo.UserId, subscriptionUsed, quotaUsed)
+					rollbackErr := RestoreRelayQuota(relayInfo, subscriptionUsed, quotaUsed)
 					if rollbackErr != nil {
 						common.SysError(fmt.Sprintf("failed to rollback user quota for user %d after token consume error: %s", relayInfo.UserId, rollbackErr.Error()))
 					} else {
@@ -460,6 +526,8 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 		}
 	}
 
+	RecordSpendingUsage(relayInfo, quota)
+
 	if sendEmail {
 		if (quota + preConsumedQuota) != 0 {
 			checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
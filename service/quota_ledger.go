@@ -0,0 +1,126 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"fmt"
+	"veloera/model"
+)
+
+const (
+	quotaLedgerCheckBatch    = 500
+	quotaLedgerMaxMismatches = 1000
+)
+
+// QuotaLedgerMismatch 用户或组织账户余额与账本重算余额不一致的记录，Difference 为余额减账本余额
+type QuotaLedgerMismatch struct {
+	UserId     int    `json:"user_id"`
+	OrgId      int    `json:"org_id"`
+	Account    string `json:"account"`
+	Balance    int64  `json:"balance"`
+	Ledger     int64  `json:"ledger"`
+	Difference int64  `json:"difference"`
+}
+
+type QuotaLedgerCheckResult struct {
+	CheckedUsers           int                    `json:"checked_users"`
+	CheckedOrganizations   int                    `json:"checked_organizations"`
+	Mismatches             []*QuotaLedgerMismatch `json:"mismatches"`
+	Truncated              bool                   `json:"truncated"`
+	UnbalancedTransactions []string               `json:"unbalanced_transactions"`
+	Reconciled             int                    `json:"reconciled"`
+}
+
+// CheckQuotaLedger 按账本重新计算用户及组织余额并与当前余额比对，userId 为 0 时检查全部用户和组织。
+// reconcile 时锁定账户重新比对，差额在两次检查中一致才记一笔对账修正分录，使账本与余额重新一致，修正记录保留操作者以便追溯
+func CheckQuotaLedger(userId int, reconcile bool, actorId int) (*QuotaLedgerCheckResult, error) {
+	result := &QuotaLedgerCheckResult{
+		Mismatches: make([]*QuotaLedgerMismatch, 0),
+	}
+	afterId := 0
+	for {
+		balances, err := model.GetQuotaLedgerBalances(userId, afterId, quotaLedgerCheckBatch)
+		if err != nil {
+			return nil, err
+		}
+		if len(balances) == 0 {
+			break
+		}
+		if err = compareQuotaLedgerBalances(result, balances, reconcile, actorId); err != nil {
+			return nil, err
+		}
+		result.CheckedUsers += len(balances)
+		afterId = balances[len(balances)-1].UserId
+	}
+	if userId == 0 {
+		afterId = 0
+		for {
+			balances, err := model.GetOrganizationLedgerBalances(afterId, quotaLedgerCheckBatch)
+			if err != nil {
+				return nil, err
+			}
+			if len(balances) == 0 {
+				break
+			}
+			if err = compareQuotaLedgerBalances(result, balances, reconcile, actorId); err != nil {
+				return nil, err
+			}
+			result.CheckedOrganizations += len(balances)
+			afterId = balances[len(balances)-1].OrgId
+		}
+	}
+	unbalanced, err := model.GetUnbalancedQuotaTransactions(quotaLedgerMaxMismatches)
+	if err != nil {
+		return nil, err
+	}
+	result.UnbalancedTransactions = unbalanced
+	return result, nil
+}
+
+func compareQuotaLedgerBalances(result *QuotaLedgerCheckResult, balances []*model.QuotaLedgerBalance, reconcile bool, actorId int) error {
+	for _, balance := range balances {
+		for account, amount := range balance.Balance {
+			difference := amount - balance.Ledger[account]
+			if difference == 0 {
+				continue
+			}
+			if reconcile {
+				remark := fmt.Sprintf("余额 %d，账本 %d", amount, balance.Ledger[account])
+				reconciled, err := model.ReconcileQuotaLedger(balance.UserId, balance.OrgId, account, difference, actorId, remark)
+				if err != nil {
+					return err
+				}
+				if reconciled {
+					result.Reconciled++
+				}
+			}
+			if len(result.Mismatches) >= quotaLedgerMaxMismatches {
+				result.Truncated = true
+				continue
+			}
+			result.Mismatches = append(result.Mismatches, &QuotaLedgerMismatch{
+				UserId:     balance.UserId,
+				OrgId:      balance.OrgId,
+				Account:    account,
+				Balance:    amount,
+				Ledger:     balance.Ledger[account],
+				Difference: difference,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	"veloera/relay/helper"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 等待中的请求在没有收到唤醒信号时重新尝试准入的间隔，用于感知其他节点释放的名额与 TPM、请求频率窗口的滚动
+const requestQueuePollInterval = 200 * time.Millisecond
+
+// 空队列保留统计信息的时长，超过后从 requestQueues 中移除
+const requestQueueIdleTTL = 10 * time.Minute
+
+// RequestQueueAttempt 尝试准入一次，limitErr 为 nil 表示成功；
+// shared 表示被分组、渠道等共享维度拒绝，此时排在其后的请求需要等待，
+// 否则为令牌、用户等私有维度拒绝，不阻塞队列中的其他请求
+type RequestQueueAttempt func() (release func(), limitErr *dto.OpenAIErrorWithStatusCode, shared bool)
+
+type queueWaiter struct {
+	priority int
+	seq      uint64
+	wake     chan struct{}
+	shared   bool // 最近一次尝试是否被共享维度拒绝
+}
+
+type requestQueue struct {
+	key         string
+	waiters     []*queueWaiter
+	idleSince   time.Time
+	admitted    int64
+	timedOut    int64
+	rejected    int64
+	totalWaitMs int64
+	maxWaitMs   int64
+}
+
+// RequestQueueStats 队列统计信息，供管理员查看
+type RequestQueueStats struct {
+	Key           string `json:"key"`
+	Depth         int    `json:"depth"`
+	Admitted      int64  `json:"admitted"`
+	TimedOut      int64  `json:"timed_out"`
+	Rejected      int64  `json:"rejected"`
+	AverageWaitMs int64  `json:"average_wait_ms"`
+	MaxWaitMs     int64  `json:"max_wait_ms"`
+}
+
+var (
+	requestQueues     = make(map[string]*requestQueue)
+	requestQueueMutex sync.Mutex
+	requestQueueSeq   uint64
+	requestQueueSweep time.Time
+)
+
+func (w *queueWaiter) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pruneRequestQueues 移除空闲超过 requestQueueIdleTTL 的空队列，调用方需持有 requestQueueMutex
+func pruneRequestQueues(now time.Time) {
+	if now.Sub(requestQueueSweep) < time.Minute {
+		return
+	}
+	requestQueueSweep = now
+	for key, queue := range requestQueues {
+		if len(queue.waiters) == 0 && now.Sub(queue.idleSince) > requestQueueIdleTTL {
+			delete(requestQueues, key)
+		}
+	}
+}
+
+// enqueueRequest 按优先级插入等待队列，队列已满时返回 nil
+func enqueueRequest(key string, priority int, maxLength int, shared bool) (*requestQueue, *queueWaiter) {
+	requestQueueMutex.Lock()
+	defer requestQueueMutex.Unlock()
+	pruneRequestQueues(time.Now())
+	queue, ok := requestQueues[key]
+	if !ok {
+		queue = &requestQueue{key: key}
+		requestQueues[key] = queue
+	}
+	if maxLength > 0 && len(queue.waiters) >= maxLength {
+		queue.rejected++
+		if len(queue.waiters) == 0 {
+			queue.idleSince = time.Now()
+		}
+		return queue, nil
+	}
+	waiter := &queueWaiter{
+		priority: priority,
+		seq:      atomic.AddUint64(&requestQueueSeq, 1),
+		wake:     make(chan struct{}, 1),
+		shared:   shared,
+	}
+	index := sort.Search(len(queue.waiters), func(i int) bool {
+		return queue.waiters[i].priority < priority
+	})
+	queue.waiters = append(queue.waiters, nil)
+	copy(queue.waiters[index+1:], queue.waiters[index:])
+	queue.waiters[index] = waiter
+	return queue, waiter
+}
+
+// canAttempt 排在 w 之前的请求都只被私有维度拒绝时，w 才可以尝试准入，
+// 避免被自身令牌或用户上限卡住的请求阻塞整个队列
+func (q *requestQueue) canAttempt(w *queueWaiter) bool {
+	requestQueueMutex.Lock()
+	defer requestQueueMutex.Unlock()
+	for _, waiter := range q.waiters {
+		if waiter == w {
+			return true
+		}
+		if waiter.shared {
+			return false
+		}
+	}
+	return false
+}
+
+// setShared 更新 w 的拒绝维度，从共享变为私有时唤醒其后的请求
+func (q *requestQueue) setShared(w *queueWaiter, shared bool) {
+	requestQueueMutex.Lock()
+	defer requestQueueMutex.Unlock()
+	if w.shared == shared {
+		return
+	}
+	w.shared = shared
+	if !shared {
+		q.notifyAll()
+	}
+}
+
+// notifyAll 唤醒队列中的所有请求，调用方需持有 requestQueueMutex
+func (q *requestQueue) notifyAll() {
+	for _, waiter := range q.waiters {
+		waiter.notify()
+	}
+}
+
+// leave 将 w 移出队列、更新统计并唤醒剩余请求
+func (q *requestQueue) leave(w *queueWaiter, admitted bool, timedOut bool, waited time.Duration) {
+	requestQueueMutex.Lock()
+	defer requestQueueMutex.Unlock()
+	for i, waiter := range q.waiters {
+		if waiter == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			break
+		}
+	}
+	waitMs := waited.Milliseconds()
+	if admitted {
+		q.admitted++
+		q.totalWaitMs += waitMs
+		if waitMs > q.maxWaitMs {
+			q.maxWaitMs = waitMs
+		}
+	}
+	if timedOut {
+		q.timedOut++
+	}
+	if len(q.waiters) == 0 {
+		q.idleSince = time.Now()
+	}
+	q.notifyAll()
+}
+
+func wakeRequestQueue(key string) {
+	requestQueueMutex.Lock()
+	defer requestQueueMutex.Unlock()
+	if queue, ok := requestQueues[key]; ok {
+		queue.notifyAll()
+	}
+}
+
+// GetRequestQueueStats 返回所有队列的当前深度与等待时间统计
+func GetRequestQueueStats() []RequestQueueStats {
+	requestQueueMutex.Lock()
+	defer requestQueueMutex.Unlock()
+	pruneRequestQueues(time.Now())
+	stats := make([]RequestQueueStats, 0, len(requestQueues))
+	for key, queue := range requestQueues {
+		stat := RequestQueueStats{
+			Key:       key,
+			Depth:     len(queue.waiters),
+			Admitted:  queue.admitted,
+			TimedOut:  queue.timedOut,
+			Rejected:  queue.rejected,
+			MaxWaitMs: queue.maxWaitMs,
+		}
+		if queue.admitted > 0 {
+			stat.AverageWaitMs = queue.totalWaitMs / queue.admitted
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Key < stats[j].Key
+	})
+	return stats
+}
+
+func isStreamRequest(c *gin.Context) bool {
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return false
+	}
+	return request.Stream
+}
+
+func requestQueueKey(c *gin.Context) string {
+	return c.GetString("group") + "/" + c.GetString("original_model")
+}
+
+// WaitInRequestQueue 首次尝试以 limitErr 被拒绝后，在启用排队时于 key 队列中等待并重试 attempt，
+// 直至准入、超时或客户端断开；未启用排队或队列已满时直接返回 limitErr
+func WaitInRequestQueue(c *gin.Context, key string, limitErr *dto.OpenAIErrorWithStatusCode, shared bool, attempt RequestQueueAttempt) (func(), *dto.OpenAIErrorWithStatusCode) {
+	queueSetting := operation_setting.GetRequestQueueSetting()
+	if !queueSetting.Enabled || queueSetting.MaxWaitSeconds <= 0 {
+		return func() {}, limitErr
+	}
+	priority := operation_setting.GetGroupQueuePriority(c.GetString(constant.ContextKeyUserGroup))
+	queue, waiter := enqueueRequest(key, priority, queueSetting.MaxQueueLength, shared)
+	if waiter == nil {
+		return func() {}, limitErr
+	}
+
+	start := time.Now()
+	if isStreamRequest(c) {
+		interval := time.Duration(max(queueSetting.HeartbeatIntervalSeconds, 1)) * time.Second
+		if !c.Writer.Written() {
+			helper.SetEventStreamHeaders(c)
+		}
+		_ = helper.WaitData(c)
+		stopHeartbeat := helper.StartWaitingHeartbeat(c, interval)
+		defer stopHeartbeat()
+	}
+	timer := time.NewTimer(time.Duration(queueSetting.MaxWaitSeconds) * time.Second)
+	defer timer.Stop()
+	ticker := time.NewTicker(requestQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		if queue.canAttempt(waiter) {
+			release, attemptErr, attemptShared := attempt()
+			if attemptErr == nil {
+				queue.leave(waiter, true, false, time.Since(start))
+				return release, nil
+			}
+			limitErr = attemptErr
+			queue.setShared(waiter, attemptShared)
+		}
+		select {
+		case <-waiter.wake:
+		case <-ticker.C:
+		case <-timer.C:
+			queue.leave(waiter, false, true, 0)
+			return func() {}, OpenAIErrorWrapperLocal(fmt.Errorf("排队等待超过 %d 秒：%s", queueSetting.MaxWaitSeconds, limitErr.Error.Message),
+				"queue_timeout", http.StatusTooManyRequests)
+		case <-c.Request.Context().Done():
+			queue.leave(waiter, false, false, 0)
+			return func() {}, OpenAIErrorWrapperLocal(c.Request.Context().Err(), "client_gone", http.StatusRequestTimeout)
+		}
+	}
+}
+
+// AcquireTrafficConcurrencyQueued 获取并发名额，超限且启用排队时在 分组/模型 队列中等待
+func AcquireTrafficConcurrencyQueued(c *gin.Context) (func(), *dto.OpenAIErrorWithStatusCode) {
+	key := requestQueueKey(c)
+	wrap := func(release func()) func() {
+		return func() {
+			release()
+			wakeRequestQueue(key)
+		}
+	}
+	release, limitErr := acquireTrafficConcurrency(c)
+	if limitErr == nil {
+		return wrap(release), nil
+	}
+	release, queueErr := WaitInRequestQueue(c, key, trafficLimitErrorWrapper(c, limitErr), limitErr.Shared,
+		func() (func(), *dto.OpenAIErrorWithStatusCode, bool) {
+			release, limitErr := acquireTrafficConcurrency(c)
+			if limitErr != nil {
+				return release, trafficLimitErrorWrapper(c, limitErr), limitErr.Shared
+			}
+			return release, nil, false
+		})
+	if queueErr != nil {
+		return release, queueErr
+	}
+	return wrap(release), nil
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+var ErrSpendingLimitExceeded = errors.New("spending limit exceeded")
+
+var spendingPeriodNames = map[string]string{
+	model.SpendingPeriodDaily:   "每日",
+	model.SpendingPeriodWeekly:  "每周",
+	model.SpendingPeriodMonthly: "每月",
+}
+
+// CheckSpendingLimits 检查令牌与用户的周期消费限额，quota 为本次将要消耗的额度，只读不累加
+func CheckSpendingLimits(relayInfo *relaycommon.RelayInfo, quota int) error {
+	for _, owner := range getSpendingOwners(relayInfo) {
+		if err := checkSpendingLimit(owner.ownerType, owner.ownerId, owner.limit, quota); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSpendingLimit(ownerType string, ownerId int, limit dto.SpendingLimit, quota int) error {
+	used, err := model.GetSpendingWindows(ownerType, ownerId)
+	if err != nil {
+		return err
+	}
+	for _, period := range model.SpendingPeriods {
+		periodLimit := model.GetSpendingPeriodLimit(limit, period)
+		if periodLimit <= 0 {
+			continue
+		}
+		if used[period]+quota > periodLimit {
+			return fmt.Errorf("%w: %s %s limit %s reached, used: %s", ErrSpendingLimitExceeded, ownerType, period,
+				common.FormatQuota(periodLimit), common.FormatQuota(used[period]))
+		}
+	}
+	return nil
+}
+
+// spendingOwner 启用了周期限额的令牌、用户或组织成员
+type spendingOwner struct {
+	ownerType string
+	ownerId   int
+	limit     dto.SpendingLimit
+}
+
+func getSpendingOwners(relayInfo *relaycommon.RelayInfo) []spendingOwner {
+	owners := make([]spendingOwner, 0, 3)
+	if relayInfo.TokenId != 0 && relayInfo.TokenSpendingLimit.Enabled() {
+		owners = append(owners, spendingOwner{model.SpendingOwnerToken, relayInfo.TokenId, relayInfo.TokenSpendingLimit})
+	}
+	if relayInfo.UserSpendingLimit.Enabled() {
+		owners = append(owners, spendingOwner{model.SpendingOwnerUser, relayInfo.UserId, relayInfo.UserSpendingLimit})
+	}
+	if relayInfo.OrgMemberId != 0 && relayInfo.OrgMemberSpendingLimit.Enabled() {
+		owners = append(owners, spendingOwner{model.SpendingOwnerOrgMember, relayInfo.OrgMemberId, relayInfo.OrgMemberSpendingLimit})
+	}
+	return owners
+}
+
+// ReserveSpendingUsage 预扣时同步累加周期窗口用量，每个窗口以条件更新保证累加后不超过限额，
+// 并发请求不会同时通过检查。任一窗口会超限时撤销本次已累加的部分并返回 ErrSpendingLimitExceeded
+func ReserveSpendingUsage(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if quota <= 0 {
+		return CheckSpendingLimits(relayInfo, quota)
+	}
+	type reservedWindow struct {
+		owner  spendingOwner
+		period string
+		window *model.SpendingWindow
+	}
+	var reserved []reservedWindow
+	rollback := func() {
+		for _, r := range reserved {
+			if _, err := model.IncreaseSpendingWindowUsage(r.owner.ownerType, r.owner.ownerId, r.period, -quota); err != nil {
+				common.SysError(fmt.Sprintf("failed to rollback %s spending for %s %d: %s", r.period, r.owner.ownerType, r.owner.ownerId, err.Error()))
+			}
+		}
+	}
+	for _, owner := range getSpendingOwners(relayInfo) {
+		for _, period := range model.SpendingPeriods {
+			periodLimit := model.GetSpendingPeriodLimit(owner.limit, period)
+			if periodLimit <= 0 {
+				continue
+			}
+			window, ok, err := model.ReserveSpendingWindowUsage(owner.ownerType, owner.ownerId, period, quota, periodLimit)
+			if err != nil {
+				rollback()
+				return err
+			}
+			if !ok {
+				rollback()
+				return fmt.Errorf("%w: %s %s limit %s reached, used: %s", ErrSpendingLimitExceeded, owner.ownerType, period,
+					common.FormatQuota(periodLimit), common.FormatQuota(window.UsedQuota))
+			}
+			reserved = append(reserved, reservedWindow{owner: owner, period: period, window: window})
+		}
+	}
+	if len(reserved) == 0 {
+		return nil
+	}
+	info := *relayInfo
+	gopool.Go(func() {
+		for _, r := range reserved {
+			notifySpendingSoftLimit(&info, r.owner, r.period, r.window)
+		}
+	})
+	return nil
+}
+
+// RecordSpendingUsage 异步累加周期窗口用量，用于结算时的差额与退还，delta 为负数时表示退还
+func RecordSpendingUsage(relayInfo *relaycommon.RelayInfo, delta int) {
+	if delta == 0 || relayInfo == nil {
+		return
+	}
+	owners := getSpendingOwners(relayInfo)
+	if len(owners) == 0 {
+		return
+	}
+	info := *relayInfo
+	gopool.Go(func() {
+		for _, owner := range owners {
+			recordSpendingUsage(&info, owner, delta)
+		}
+	})
+}
+
+func recordSpendingUsage(relayInfo *relaycommon.RelayInfo, owner spendingOwner, delta int) {
+	for _, period := range model.SpendingPeriods {
+		if model.GetSpendingPeriodLimit(owner.limit, period) <= 0 {
+			continue
+		}
+		window, err := model.IncreaseSpendingWindowUsage(owner.ownerType, owner.ownerId, period, delta)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to record %s spending for %s %d: %s", period, owner.ownerType, owner.ownerId, err.Error()))
+			continue
+		}
+		notifySpendingSoftLimit(relayInfo, owner, period, window)
+	}
+}
+
+// notifySpendingSoftLimit 窗口用量达到软限额比例时发送一次提醒
+func notifySpendingSoftLimit(relayInfo *relaycommon.RelayInfo, owner spendingOwner, period string, window *model.SpendingWindow) {
+	periodLimit := model.GetSpendingPeriodLimit(owner.limit, period)
+	if owner.limit.SoftLimitPercent <= 0 || window.SoftNotified {
+		return
+	}
+	if window.UsedQuota*100 < periodLimit*owner.limit.SoftLimitPercent {
+		return
+	}
+	marked, err := model.MarkSpendingWindowNotified(window.Id, window.WindowStart)
+	if err != nil || !marked {
+		return
+	}
+	sendSpendingLimitNotify(relayInfo, owner.ownerType, owner.ownerId, period, window.UsedQuota, periodLimit)
+}
+
+func sendSpendingLimitNotify(relayInfo *relaycommon.RelayInfo, ownerType string, ownerId int, period string, used int, limit int) {
+	subject := "账户"
+	switch ownerType {
+	case model.SpendingOwnerToken:
+		subject = fmt.Sprintf("令牌 #%d ", ownerId)
+	case model.SpendingOwnerOrgMember:
+		subject = fmt.Sprintf("组织 #%d 成员额度", relayInfo.OrgId)
+	}
+	prompt := "消费即将达到周期限额"
+	content := "您的{{value}}{{value}}消费已达 {{value}}，限额为 {{value}}，达到限额后请求将被拒绝，直至下个周期自动重置。"
+	err := NotifyUser(relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting, dto.NewNotify(dto.NotifyTypeSpendingLimit, prompt, content,
+		[]interface{}{subject, spendingPeriodNames[period], common.FormatQuota(used), common.FormatQuota(limit)}))
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to send spending limit notify to user %d: %s", relayInfo.UserId, err.Error()))
+	}
+}
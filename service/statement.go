@@ -0,0 +1,392 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// StatementPeriodLayout 对账期格式，例如 2025-01
+const StatementPeriodLayout = "2006-01"
+
+const statementUserBatch = 100
+
+// StatementSummary 对账期内各类额度变动合计
+type StatementSummary struct {
+	TopUp       int64 `json:"top_up"`
+	Redemption  int64 `json:"redemption"`
+	Rebate      int64 `json:"rebate"`
+	CheckIn     int64 `json:"check_in"`
+	TaskRefund  int64 `json:"task_refund"`
+	TopUpRefund int64 `json:"top_up_refund"`
+	Consumption int64 `json:"consumption"`
+}
+
+func (s StatementSummary) Credits() int64 {
+	return s.TopUp + s.Redemption + s.Rebate + s.CheckIn + s.TaskRefund
+}
+
+func (s StatementSummary) Debits() int64 {
+	return s.TopUpRefund + s.Consumption
+}
+
+// StatementDetail 对账单明细
+type StatementDetail struct {
+	Summary      StatementSummary             `json:"summary"`
+	TopUps       []*model.StatementTopUp      `json:"top_ups"`
+	TopUpRefunds []*model.TopUpRefund         `json:"top_up_refunds"`
+	Redemptions  []*model.StatementRedemption `json:"redemptions"`
+	Rebates      []*model.StatementLogEntry   `json:"rebates"`
+	CheckIns     []*model.StatementLogEntry   `json:"check_ins"`
+	TaskRefunds  []*model.StatementLogEntry   `json:"task_refunds"`
+	ModelUsages  []*model.StatementModelUsage `json:"model_usages"`
+}
+
+// Statement 月度对账单，期初余额 + 收入 - 支出 + 其他调整 = 期末余额，
+// 其他调整为管理员修改额度、邀请额度划转等未单独记账的变动
+type Statement struct {
+	UserId         int              `json:"user_id"`
+	Username       string           `json:"username"`
+	Period         string           `json:"period"`
+	StartTime      int64            `json:"start_time"`
+	EndTime        int64            `json:"end_time"`
+	OpeningBalance int64            `json:"opening_balance"`
+	ClosingBalance int64            `json:"closing_balance"`
+	TotalCredits   int64            `json:"total_credits"`
+	TotalDebits    int64            `json:"total_debits"`
+	Adjustment     int64            `json:"adjustment"`
+	Final          bool             `json:"final"`
+	CreatedTime    int64            `json:"created_time"`
+	Detail         *StatementDetail `json:"detail"`
+}
+
+// IsEmpty 对账期内没有任何变动且余额为 0
+func (s *Statement) IsEmpty() bool {
+	d := s.Detail
+	return s.OpeningBalance == 0 && s.ClosingBalance == 0 &&
+		len(d.TopUps) == 0 && len(d.TopUpRefunds) == 0 && len(d.Redemptions) == 0 && len(d.Rebates) == 0 &&
+		len(d.CheckIns) == 0 && len(d.TaskRefunds) == 0 && len(d.ModelUsages) == 0
+}
+
+// ParseStatementPeriod 解析对账期，返回该月的起止时间（本地时区）
+func ParseStatementPeriod(period string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(StatementPeriodLayout, period, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("无效的对账期，格式应为 YYYY-MM")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// collectStatementDetail 汇总 [start, end) 内的额度变动
+func collectStatementDetail(userId int, start int64, end int64) (*StatementDetail, error) {
+	detail := &StatementDetail{}
+	var err error
+	if detail.TopUps, err = model.GetStatementTopUps(userId, start, end); err != nil {
+		return nil, err
+	}
+	if detail.TopUpRefunds, err = model.GetStatementTopUpRefunds(userId, start, end); err != nil {
+		return nil, err
+	}
+	if detail.Redemptions, err = model.GetStatementRedemptions(userId, start, end); err != nil {
+		return nil, err
+	}
+	if detail.Rebates, err = model.GetStatementRebates(userId, start, end); err != nil {
+		return nil, err
+	}
+	if detail.CheckIns, err = model.GetStatementLogEntries(userId, model.LogTypeCheckIn, start, end); err != nil {
+		return nil, err
+	}
+	if detail.TaskRefunds, err = model.GetStatementLogEntries(userId, model.LogTypeRefund, start, end); err != nil {
+		return nil, err
+	}
+	if detail.ModelUsages, err = model.GetStatementModelUsages(userId, start, end); err != nil {
+		return nil, err
+	}
+
+	summary := &detail.Summary
+	for _, topUp := range detail.TopUps {
+		summary.TopUp += topUp.Quota
+	}
+	for _, refund := range detail.TopUpRefunds {
+		summary.TopUpRefund += int64(refund.Quota)
+	}
+	for _, redemption := range detail.Redemptions {
+		summary.Redemption += redemption.Quota
+	}
+	for _, entry := range detail.Rebates {
+		summary.Rebate += entry.Quota
+	}
+	for _, entry := range detail.CheckIns {
+		summary.CheckIn += entry.Quota
+	}
+	for _, entry := range detail.TaskRefunds {
+		summary.TaskRefund += entry.Quota
+	}
+	for _, usage := range detail.ModelUsages {
+		summary.Consumption += usage.Quota
+	}
+	return detail, nil
+}
+
+// buildStatement 计算对账单。已结束的对账期以当前余额倒推期末余额，当月对账单为截至当前的预览
+func buildStatement(user *model.User, period string, now time.Time) (*Statement, error) {
+	start, end, err := ParseStatementPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	if start.After(now) {
+		return nil, errors.New("对账期尚未开始")
+	}
+	final := !end.After(now)
+	periodEnd := end
+	if !final {
+		periodEnd = now
+	}
+	detail, err := collectStatementDetail(user.Id, start.Unix(), periodEnd.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	closing := int64(user.Quota) + int64(user.SubscriptionQuota)
+	if final {
+		after, err := collectStatementDetail(user.Id, end.Unix(), now.Unix()+1)
+		if err != nil {
+			return nil, err
+		}
+		closing -= after.Summary.Credits() - after.Summary.Debits()
+	}
+	net := detail.Summary.Credits() - detail.Summary.Debits()
+	opening := closing - net
+	// 上期已结账时沿用上期期末余额，差额计入其他调整
+	if previous, err := model.GetUserStatement(user.Id, start.AddDate(0, -1, 0).Format(StatementPeriodLayout)); err == nil {
+		opening = previous.ClosingBalance
+	}
+	return &Statement{
+		UserId:         user.Id,
+		Username:       user.Username,
+		Period:         period,
+		StartTime:      start.Unix(),
+		EndTime:        end.Unix(),
+		OpeningBalance: opening,
+		ClosingBalance: closing,
+		TotalCredits:   detail.Summary.Credits(),
+		TotalDebits:    detail.Summary.Debits(),
+		Adjustment:     closing - opening - net,
+		Final:          final,
+		CreatedTime:    now.Unix(),
+		Detail:         detail,
+	}, nil
+}
+
+func statementFromModel(user *model.User, record *model.UserStatement) (*Statement, error) {
+	detail := &StatementDetail{}
+	if err := json.Unmarshal([]byte(record.Detail), detail); err != nil {
+		return nil, err
+	}
+	return &Statement{
+		UserId:         record.UserId,
+		Username:       user.Username,
+		Period:         record.Period,
+		StartTime:      record.StartTime,
+		EndTime:        record.EndTime,
+		OpeningBalance: record.OpeningBalance,
+		ClosingBalance: record.ClosingBalance,
+		TotalCredits:   record.TotalCredits,
+		TotalDebits:    record.TotalDebits,
+		Adjustment:     record.Adjustment,
+		Final:          true,
+		CreatedTime:    record.CreatedTime,
+		Detail:         detail,
+	}, nil
+}
+
+func saveStatement(statement *Statement) (*model.UserStatement, error) {
+	detail, err := json.Marshal(statement.Detail)
+	if err != nil {
+		return nil, err
+	}
+	record := &model.UserStatement{
+		UserId:         statement.UserId,
+		Period:         statement.Period,
+		StartTime:      statement.StartTime,
+		EndTime:        statement.EndTime,
+		OpeningBalance: statement.OpeningBalance,
+		ClosingBalance: statement.ClosingBalance,
+		TotalCredits:   statement.TotalCredits,
+		TotalDebits:    statement.TotalDebits,
+		Adjustment:     statement.Adjustment,
+		Detail:         string(detail),
+	}
+	if err := record.Insert(); err != nil {
+		// 并发生成时以先写入的为准
+		if existing, getErr := model.GetUserStatement(statement.UserId, statement.Period); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// GetStatement 获取用户某月对账单，已结束的对账期首次查看时结账并保存
+func GetStatement(user *model.User, period string) (*Statement, error) {
+	if record, err := model.GetUserStatement(user.Id, period); err == nil {
+		return statementFromModel(user, record)
+	}
+	statement, err := buildStatement(user, period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if statement.Final && !statement.IsEmpty() {
+		if _, err := saveStatement(statement); err != nil {
+			return nil, err
+		}
+	}
+	return statement, nil
+}
+
+// SendStatementEmail 将对账单以 HTML 邮件发送给用户
+func SendStatementEmail(user *model.User, statement *Statement) error {
+	if user.Email == "" {
+		return errors.New("用户未绑定邮箱")
+	}
+	content, err := RenderStatementHTML(statement)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("%s %s 月度对账单", common.SystemName, statement.Period)
+	return common.SendEmail(subject, user.Email, content)
+}
+
+// GenerateMonthlyStatements 为所有用户生成指定月份的对账单，sendEmail 时向未发送过的用户发送邮件
+func GenerateMonthlyStatements(period string, sendEmail bool) (generated int, emailed int, err error) {
+	afterId := 0
+	for {
+		users, err := model.GetStatementUsers(afterId, statementUserBatch)
+		if err != nil {
+			return generated, emailed, err
+		}
+		if len(users) == 0 {
+			return generated, emailed, nil
+		}
+		for _, user := range users {
+			afterId = user.Id
+			record, err := model.GetUserStatement(user.Id, period)
+			if err != nil {
+				statement, buildErr := buildStatement(user, period, time.Now())
+				if buildErr != nil {
+					return generated, emailed, buildErr
+				}
+				if !statement.Final || statement.IsEmpty() {
+					continue
+				}
+				if record, err = saveStatement(statement); err != nil {
+					common.SysError(fmt.Sprintf("save statement for user %d error: %s", user.Id, err.Error()))
+					continue
+				}
+				generated++
+			}
+			if !sendEmail || user.Email == "" || record.EmailedTime != 0 {
+				continue
+			}
+			statement, err := statementFromModel(user, record)
+			if err != nil {
+				common.SysError(fmt.Sprintf("decode statement for user %d error: %s", user.Id, err.Error()))
+				continue
+			}
+			if err := SendStatementEmail(user, statement); err != nil {
+				common.SysError(fmt.Sprintf("send statement to user %d error: %s", user.Id, err.Error()))
+				continue
+			}
+			if err := record.MarkEmailed(); err != nil {
+				common.SysError("mark statement emailed error: " + err.Error())
+			}
+			emailed++
+		}
+	}
+}
+
+// 对账单生成任务的租约时长，定时任务与手动生成共用同一租约
+const statementLeaseTTL = 2 * time.Hour
+
+var ErrStatementGenerating = errors.New("该月份的对账单正在生成中，请稍后再试")
+
+func statementLeaseName(period string) string {
+	return "statement:" + period
+}
+
+// StartStatementGeneration 获取租约后在后台生成指定月份的对账单，租约被定时任务或其他节点持有时返回 ErrStatementGenerating
+func StartStatementGeneration(period string, sendEmail bool) error {
+	acquired, err := model.AcquireJobLease(statementLeaseName(period), jobNodeId, statementLeaseTTL)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrStatementGenerating
+	}
+	gopool.Go(func() {
+		defer releaseJobLease(statementLeaseName(period))
+		runStatementGeneration(period, sendEmail)
+	})
+	return nil
+}
+
+// runStatementGeneration 生成对账单并记录结果，调用方需持有租约
+func runStatementGeneration(period string, sendEmail bool) bool {
+	generated, emailed, err := GenerateMonthlyStatements(period, sendEmail)
+	if err != nil {
+		common.SysError(fmt.Sprintf("generate %s statements error: %s", period, err.Error()))
+		return false
+	}
+	common.SysLog(fmt.Sprintf("%s 对账单生成完成，新生成 %d 份，发送邮件 %d 封", period, generated, emailed))
+	return true
+}
+
+// RunMonthlyStatementJob 每小时检查一次，到达设置的发送日后生成上月对账单，多节点时通过租约保证只有一个节点执行
+func RunMonthlyStatementJob() {
+	completedPeriod := ""
+	for {
+		time.Sleep(time.Hour)
+		setting := operation_setting.GetStatementSetting()
+		if !setting.Enabled {
+			continue
+		}
+		now := time.Now()
+		if now.Day() < max(setting.SendDay, 1) {
+			continue
+		}
+		period := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.Local).Format(StatementPeriodLayout)
+		if period == completedPeriod {
+			continue
+		}
+		acquired, err := model.AcquireJobLease(statementLeaseName(period), jobNodeId, statementLeaseTTL)
+		if err != nil || !acquired {
+			continue
+		}
+		if runStatementGeneration(period, setting.EmailEnabled) {
+			completedPeriod = period
+		}
+		releaseJobLease(statementLeaseName(period))
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/model"
+
+	"github.com/go-pdf/fpdf"
+)
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"quota": func(quota int64) string {
+		return common.FormatQuota(int(quota))
+	},
+	"quotaInt": common.FormatQuota,
+	"time": func(timestamp int64) string {
+		return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.SystemName}} {{.Statement.Period}} 对账单</title>
+<style>
+body { font-family: -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; color: #333; max-width: 800px; margin: 0 auto; padding: 24px; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 24px; }
+th, td { border: 1px solid #ddd; padding: 6px 10px; text-align: left; font-size: 13px; }
+th { background: #f5f5f5; }
+td.num { text-align: right; }
+h2 { font-size: 16px; margin-top: 24px; }
+</style>
+</head>
+<body>
+{{with .Statement}}
+<h1>{{$.SystemName}} 月度对账单</h1>
+<p>用户：{{.Username}}（ID {{.UserId}}）<br>对账期：{{.Period}}（{{time .StartTime}} 至 {{time .EndTime}}）{{if not .Final}}<br><strong>本期尚未结束，以下为截至 {{time .CreatedTime}} 的预览</strong>{{end}}</p>
+<table>
+<tr><th>期初余额</th><td class="num">{{quota .OpeningBalance}}</td></tr>
+<tr><th>收入合计</th><td class="num">{{quota .TotalCredits}}</td></tr>
+<tr><th>支出合计</th><td class="num">{{quota .TotalDebits}}</td></tr>
+<tr><th>其他调整</th><td class="num">{{quota .Adjustment}}</td></tr>
+<tr><th>期末余额</th><td class="num">{{quota .ClosingBalance}}</td></tr>
+</table>
+{{with .Detail.Summary}}
+<h2>收支汇总</h2>
+<table>
+<tr><th>充值</th><td class="num">{{quota .TopUp}}</td></tr>
+<tr><th>兑换码</th><td class="num">{{quota .Redemption}}</td></tr>
+<tr><th>邀请返佣</th><td class="num">{{quota .Rebate}}</td></tr>
+<tr><th>签到奖励</th><td class="num">{{quota .CheckIn}}</td></tr>
+<tr><th>任务退款</th><td class="num">{{quota .TaskRefund}}</td></tr>
+<tr><th>充值退款</th><td class="num">-{{quota .TopUpRefund}}</td></tr>
+<tr><th>模型消费</th><td class="num">-{{quota .Consumption}}</td></tr>
+</table>
+{{end}}
+{{with .Detail.TopUps}}
+<h2>充值记录</h2>
+<table>
+<tr><th>时间</th><th>订单号</th><th>支付方式</th><th>支付金额</th><th>额度</th></tr>
+{{range .}}<tr><td>{{time .Time}}</td><td>{{.TradeNo}}</td><td>{{.Provider}}</td><td class="num">{{printf "%.2f" .Money}} {{.Currency}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Detail.TopUpRefunds}}
+<h2>充值退款</h2>
+<table>
+<tr><th>时间</th><th>订单号</th><th>原因</th><th>退款金额</th><th>额度</th></tr>
+{{range .}}<tr><td>{{time .CreateTime}}</td><td>{{.TradeNo}}</td><td>{{.Reason}}</td><td class="num">{{printf "%.2f" .Money}}</td><td class="num">-{{quotaInt .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Detail.Redemptions}}
+<h2>兑换记录</h2>
+<table>
+<tr><th>时间</th><th>名称</th><th>类型</th><th>额度</th></tr>
+{{range .}}<tr><td>{{time .Time}}</td><td>{{.Name}}</td><td>{{if .IsGift}}礼品码{{else}}兑换码{{end}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+{{range $section := $.LogSections}}{{with $section.Entries}}
+<h2>{{$section.Title}}</h2>
+<table>
+<tr><th>时间</th><th>说明</th><th>额度</th></tr>
+{{range .}}<tr><td>{{time .Time}}</td><td>{{.Content}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+{{with .Detail.ModelUsages}}
+<h2>模型消费</h2>
+<table>
+<tr><th>模型</th><th>请求数</th><th>提示 Tokens</th><th>补全 Tokens</th><th>额度</th></tr>
+{{range .}}<tr><td>{{.ModelName}}</td><td class="num">{{.RequestCount}}</td><td class="num">{{.PromptTokens}}</td><td class="num">{{.CompletionTokens}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>`))
+
+// RenderStatementHTML 渲染 HTML 对账单，同时用作邮件正文
+func RenderStatementHTML(statement *Statement) (string, error) {
+	var buf bytes.Buffer
+	err := statementTemplate.Execute(&buf, map[string]any{
+		"SystemName":  common.SystemName,
+		"Statement":   statement,
+		"LogSections": statementLogSections(statement),
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type statementLogSection struct {
+	Title   string
+	Entries []*model.StatementLogEntry
+}
+
+func statementLogSections(statement *Statement) []statementLogSection {
+	return []statementLogSection{
+		{Title: "邀请返佣", Entries: statement.Detail.Rebates},
+		{Title: "签到奖励", Entries: statement.Detail.CheckIns},
+		{Title: "任务退款", Entries: statement.Detail.TaskRefunds},
+	}
+}
+
+// pdfText PDF 使用内置字体，仅支持 Latin 字符，其余字符替换为 ?
+func pdfText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, text)
+}
+
+func pdfQuota(quota int64) string {
+	if common.DisplayInCurrencyEnabled {
+		return fmt.Sprintf("$%.6f", float64(quota)/common.QuotaPerUnit)
+	}
+	return fmt.Sprintf("%d", quota)
+}
+
+func pdfTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format("2006-01-02 15:04")
+}
+
+func pdfTable(pdf *fpdf.Fpdf, title string, headers []string, widths []float64, rows [][]string) {
+	if len(rows) == 0 {
+		return
+	}
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 7, title, "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(240, 240, 240)
+	for i, header := range headers {
+		pdf.CellFormat(widths[i], 6, header, "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 9)
+	for _, row := range rows {
+		for i, cell := range row {
+			align := "L"
+			if i == len(row)-1 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[i], 6, pdfText(cell), "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+}
+
+// RenderStatementPDF 渲染 PDF 对账单
+func RenderStatementPDF(statement *Statement) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(fmt.Sprintf("%s statement %s", pdfText(common.SystemName), statement.Period), false)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, fmt.Sprintf("%s Monthly Statement", pdfText(common.SystemName)), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, fmt.Sprintf("User: %s (ID %d)", pdfText(statement.Username), statement.UserId), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("Period: %s (%s - %s)", statement.Period, pdfTime(statement.StartTime), pdfTime(statement.EndTime)), "", 1, "L", false, 0, "")
+	if !statement.Final {
+		pdf.CellFormat(0, 6, fmt.Sprintf("Preview as of %s, the period has not ended yet", pdfTime(statement.CreatedTime)), "", 1, "L", false, 0, "")
+	}
+
+	summary := statement.Detail.Summary
+	pdfTable(pdf, "Balance", []string{"Item", "Amount"}, []float64{120, 60}, [][]string{
+		{"Opening balance", pdfQuota(statement.OpeningBalance)},
+		{"Total credits", pdfQuota(statement.TotalCredits)},
+		{"Total debits", pdfQuota(statement.TotalDebits)},
+		{"Other adjustments", pdfQuota(statement.Adjustment)},
+		{"Closing balance", pdfQuota(statement.ClosingBalance)},
+	})
+	pdfTable(pdf, "Summary", []string{"Item", "Amount"}, []float64{120, 60}, [][]string{
+		{"Top-ups", pdfQuota(summary.TopUp)},
+		{"Redemptions", pdfQuota(summary.Redemption)},
+		{"Affiliate rebates", pdfQuota(summary.Rebate)},
+		{"Check-in rewards", pdfQuota(summary.CheckIn)},
+		{"Task refunds", pdfQuota(summary.TaskRefund)},
+		{"Top-up refunds", pdfQuota(-summary.TopUpRefund)},
+		{"Consumption", pdfQuota(-summary.Consumption)},
+	})
+
+	rows := make([][]string, 0, len(statement.Detail.TopUps))
+	for _, topUp := range statement.Detail.TopUps {
+		rows = append(rows, []string{pdfTime(topUp.Time), topUp.TradeNo, topUp.Provider,
+			fmt.Sprintf("%.2f %s", topUp.Money, topUp.Currency), pdfQuota(topUp.Quota)})
+	}
+	pdfTable(pdf, "Top-ups", []string{"Time", "Trade No", "Provider", "Paid", "Amount"}, []float64{32, 62, 22, 30, 34}, rows)
+
+	rows = rows[:0]
+	for _, refund := range statement.Detail.TopUpRefunds {
+		rows = append(rows, []string{pdfTime(refund.CreateTime), refund.TradeNo, refund.Reason,
+			fmt.Sprintf("%.2f", refund.Money), pdfQuota(-int64(refund.Quota))})
+	}
+	pdfTable(pdf, "Top-up refunds", []string{"Time", "Trade No", "Reason", "Refunded", "Amount"}, []float64{32, 50, 44, 20, 34}, rows)
+
+	rows = rows[:0]
+	for _, redemption := range statement.Detail.Redemptions {
+		kind := "Code"
+		if redemption.IsGift {
+			kind = "Gift"
+		}
+		rows = append(rows, []string{pdfTime(redemption.Time), redemption.Name, kind, pdfQuota(redemption.Quota)})
+	}
+	pdfTable(pdf, "Redemptions", []string{"Time", "Name", "Type", "Amount"}, []float64{32, 90, 24, 34}, rows)
+
+	entrySections := []struct {
+		title   string
+		entries []*model.StatementLogEntry
+	}{
+		{"Affiliate rebates", statement.Detail.Rebates},
+		{"Check-in rewards", statement.Detail.CheckIns},
+		{"Task refunds", statement.Detail.TaskRefunds},
+	}
+	for _, section := range entrySections {
+		rows = rows[:0]
+		for _, entry := range section.entries {
+			rows = append(rows, []string{pdfTime(entry.Time), pdfQuota(entry.Quota)})
+		}
+		pdfTable(pdf, section.title, []string{"Time", "Amount"}, []float64{120, 60}, rows)
+	}
+
+	rows = rows[:0]
+	for _, usage := range statement.Detail.ModelUsages {
+		rows = append(rows, []string{usage.ModelName, fmt.Sprintf("%d", usage.RequestCount),
+			fmt.Sprintf("%d", usage.PromptTokens), fmt.Sprintf("%d", usage.CompletionTokens), pdfQuota(usage.Quota)})
+	}
+	pdfTable(pdf, "Consumption by model", []string{"Model", "Requests", "Prompt tokens", "Completion tokens", "Amount"},
+		[]float64{60, 22, 30, 34, 34}, rows)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"fmt"
+	"time"
+	"veloera/common"
+	"veloera/model"
+)
+
+const (
+	subscriptionJobInterval = time.Minute
+	subscriptionJobBatch    = 100
+	// 单个订阅一次最多补发的周期数，避免异常数据导致死循环
+	subscriptionMaxCatchUp = 64
+)
+
+// RunSubscriptionJob 定时结算到期的订阅周期：按套餐规则结转或作废剩余额度并发放新周期额度，到期后结束订阅。
+// 结算在订阅行锁内完成且只推进已到期的周期，多节点同时执行也不会重复发放
+func RunSubscriptionJob() {
+	for {
+		time.Sleep(subscriptionJobInterval)
+		processDueSubscriptions()
+	}
+}
+
+func processDueSubscriptions() {
+	now := common.GetTimestamp()
+	// 按 id 游标遍历一轮，推进失败的订阅留到下一轮重试，避免反复取到同一批订阅
+	lastId := 0
+	for {
+		ids, err := model.GetDueSubscriptionIds(now, lastId, subscriptionJobBatch)
+		if err != nil {
+			common.SysError("failed to get due subscriptions: " + err.Error())
+			return
+		}
+		for _, id := range ids {
+			advanceSubscription(id, now)
+		}
+		if len(ids) < subscriptionJobBatch {
+			return
+		}
+		lastId = ids[len(ids)-1]
+	}
+}
+
+func advanceSubscription(id int, now int64) {
+	for i := 0; i < subscriptionMaxCatchUp; i++ {
+		advanced, err := model.AdvanceSubscription(id, now)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to advance subscription %d: %s", id, err.Error()))
+			return
+		}
+		if !advanced {
+			return
+		}
+	}
+	common.SysError(fmt.Sprintf("subscription %d has too many pending periods", id))
+}
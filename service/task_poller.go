@@ -0,0 +1,181 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	"veloera/setting/operation_setting"
+)
+
+const (
+	TaskPollerTask       = "task"
+	TaskPollerMidjourney = "midjourney"
+)
+
+var (
+	taskPollerNodeId      = common.GetUUID()
+	taskPollerStartedTime = time.Now().Unix()
+)
+
+// TaskPollerShards 本节点本轮持有的轮询分片及其渠道轮询状态
+type TaskPollerShards struct {
+	poller string
+	count  int
+	owned  map[int]bool
+	states map[int]*model.ChannelPollState
+}
+
+func pollerLeaseName(poller string, shard int) string {
+	return fmt.Sprintf("%s:%d", poller, shard)
+}
+
+// AcquireTaskPollerShards 上报心跳并按存活节点分配分片：分片 i 优先由排序后第 i % n 个节点持有，
+// 通过租约保证同一分片不会被两个节点同时轮询。未持有任何分片时返回 nil
+func AcquireTaskPollerShards(poller string) *TaskPollerShards {
+	setting := operation_setting.GetTaskPollerSetting()
+	shardCount := max(setting.ShardCount, 1)
+	ttl := time.Duration(max(setting.LeaseSeconds, 1)) * time.Second
+
+	hostname, _ := os.Hostname()
+	err := model.HeartbeatPollerNode(&model.PollerNode{
+		NodeId:      taskPollerNodeId,
+		Hostname:    hostname,
+		StartedTime: taskPollerStartedTime,
+	})
+	if err != nil {
+		common.SysError("poller heartbeat error: " + err.Error())
+		return nil
+	}
+	nodes, err := model.GetAlivePollerNodes(time.Now().Add(-ttl).Unix())
+	if err != nil {
+		common.SysError("get poller nodes error: " + err.Error())
+		return nil
+	}
+	nodeIndex, nodeCount := 0, max(len(nodes), 1)
+	for i, node := range nodes {
+		if node.NodeId == taskPollerNodeId {
+			nodeIndex = i
+		}
+	}
+
+	shards := &TaskPollerShards{
+		poller: poller,
+		count:  shardCount,
+		owned:  make(map[int]bool),
+		states: make(map[int]*model.ChannelPollState),
+	}
+	for shard := 0; shard < shardCount; shard++ {
+		name := pollerLeaseName(poller, shard)
+		if shard%nodeCount != nodeIndex {
+			// 节点变化后让出不再分配给本节点的分片
+			if err := model.ReleasePollerLease(name, taskPollerNodeId); err != nil {
+				common.SysError("release poller lease error: " + err.Error())
+			}
+			continue
+		}
+		acquired, err := model.AcquirePollerLease(name, taskPollerNodeId, ttl)
+		if err != nil {
+			common.SysError("acquire poller lease error: " + err.Error())
+			continue
+		}
+		if acquired {
+			shards.owned[shard] = true
+		}
+	}
+	if len(shards.owned) == 0 {
+		return nil
+	}
+
+	states, err := model.GetChannelPollStates(poller)
+	if err != nil {
+		common.SysError("get channel poll states error: " + err.Error())
+	}
+	for _, state := range states {
+		shards.states[state.ChannelId] = state
+	}
+	return shards
+}
+
+// Count 分片总数
+func (s *TaskPollerShards) Count() int {
+	return s.count
+}
+
+// Shards 本节点持有的分片编号
+func (s *TaskPollerShards) Shards() []int {
+	shards := make([]int, 0, len(s.owned))
+	for shard := range s.owned {
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// Owns 渠道是否属于本节点持有的分片
+func (s *TaskPollerShards) Owns(channelId int) bool {
+	return s.owned[channelId%s.count]
+}
+
+// ShouldPoll 渠道属于本节点且不处于失败退避中
+func (s *TaskPollerShards) ShouldPoll(channelId int) bool {
+	if !s.Owns(channelId) {
+		return false
+	}
+	state, ok := s.states[channelId]
+	if !ok || state.FailureCount == 0 {
+		return true
+	}
+	setting := operation_setting.GetTaskPollerSetting()
+	backoff := max(setting.FailureBackoffSeconds, 1)
+	for i := 1; i < state.FailureCount && backoff < setting.MaxFailureBackoffSeconds; i++ {
+		backoff *= 2
+	}
+	if setting.MaxFailureBackoffSeconds > 0 {
+		backoff = min(backoff, setting.MaxFailureBackoffSeconds)
+	}
+	return time.Now().Unix() >= state.LastPollTime+int64(backoff)
+}
+
+// RecordResult 持久化渠道本次轮询结果
+func (s *TaskPollerShards) RecordResult(channelId int, pollErr error) {
+	now := time.Now().Unix()
+	state := &model.ChannelPollState{
+		Poller:       s.poller,
+		ChannelId:    channelId,
+		NodeId:       taskPollerNodeId,
+		LastPollTime: now,
+	}
+	if previous, ok := s.states[channelId]; ok {
+		state.LastSuccessTime = previous.LastSuccessTime
+		state.FailureCount = previous.FailureCount
+	}
+	if pollErr != nil {
+		state.FailureCount++
+		state.LastError = pollErr.Error()
+	} else {
+		state.FailureCount = 0
+		state.LastSuccessTime = now
+	}
+	if err := model.SaveChannelPollState(state); err != nil {
+		common.SysError("save channel poll state error: " + err.Error())
+		return
+	}
+	s.states[channelId] = state
+}
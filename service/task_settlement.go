@@ -0,0 +1,315 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"veloera/common"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+)
+
+const (
+	TaskReconcileActionTimeout = "timeout"
+	TaskReconcileActionCommit  = "commit"
+	TaskReconcileActionRefund  = "refund"
+)
+
+// TaskReconcileTimeoutReason 对账时将长时间未完成的任务判定为失败的原因
+const TaskReconcileTimeoutReason = "任务超时未完成，对账自动失败"
+
+type TaskReconcileItem struct {
+	Source   string `json:"source"`
+	RecordId int64  `json:"record_id"`
+	TaskId   string `json:"task_id"`
+	UserId   int    `json:"user_id"`
+	Quota    int    `json:"quota"`
+	Action   string `json:"action"`
+}
+
+type TaskReconcileResult struct {
+	DryRun bool                `json:"dry_run"`
+	Items  []TaskReconcileItem `json:"items"`
+}
+
+func taskSettlementModelName(task *model.Task) string {
+	if task.Properties.Model != "" {
+		return task.Properties.Model
+	}
+	return CoverTaskActionToModelName(task.Platform, task.Action)
+}
+
+func reserveSettlement(ctx context.Context, settlement *model.TaskSettlement) *model.TaskSettlement {
+	reserved, err := model.ReserveTaskSettlement(settlement)
+	if err != nil {
+		common.LogError(ctx, fmt.Sprintf("reserve task settlement %s#%d error: %s", settlement.Source, settlement.RecordId, err.Error()))
+		return nil
+	}
+	return reserved
+}
+
+// subscriptionCharged 本次请求从订阅额度扣除的部分，不超过预留额度
+func subscriptionCharged(relayInfo *relaycommon.RelayInfo, quota int) int {
+	return max(0, min(relayInfo.ConsumedSubscriptionQuota, quota))
+}
+
+// ReserveTaskQuota 任务提交成功并扣费后记录预留额度
+func ReserveTaskQuota(ctx context.Context, task *model.Task, relayInfo *relaycommon.RelayInfo) {
+	tokenId := relayInfo.TokenId
+	if relayInfo.IsPlayground {
+		tokenId = 0
+	}
+	reserveSettlement(ctx, &model.TaskSettlement{
+		Source:            model.TaskSettlementSourceTask,
+		RecordId:          task.ID,
+		TaskId:            task.TaskID,
+		UserId:            task.UserId,
+		TokenId:           tokenId,
+		OrgId:             relayInfo.OrgId,
+		OrgMemberId:       relayInfo.OrgMemberId,
+		ChannelId:         task.ChannelId,
+		ModelName:         taskSettlementModelName(task),
+		Quota:             task.Quota,
+		SubscriptionQuota: subscriptionCharged(relayInfo, task.Quota),
+	})
+}
+
+// ReserveMidjourneyQuota Midjourney 任务入库后记录预留额度，未扣费的提交以 0 额度预留，避免失败时被误退款
+func ReserveMidjourneyQuota(ctx context.Context, task *model.Midjourney, relayInfo *relaycommon.RelayInfo, quota int) {
+	tokenId := relayInfo.TokenId
+	if relayInfo.IsPlayground {
+		tokenId = 0
+	}
+	reserveSettlement(ctx, &model.TaskSettlement{
+		Source:            model.TaskSettlementSourceMidjourney,
+		RecordId:          int64(task.Id),
+		TaskId:            task.MjId,
+		UserId:            task.UserId,
+		TokenId:           tokenId,
+		OrgId:             relayInfo.OrgId,
+		OrgMemberId:       relayInfo.OrgMemberId,
+		ChannelId:         task.ChannelId,
+		ModelName:         CoverActionToModelName(task.Action),
+		Quota:             quota,
+		SubscriptionQuota: subscriptionCharged(relayInfo, quota),
+	})
+}
+
+// SettleTask 根据任务最终状态提交或退还预留额度，重复调用不会重复退款
+func SettleTask(ctx context.Context, task *model.Task, reason string) {
+	var success bool
+	switch task.Status {
+	case model.TaskStatusSuccess:
+		success = true
+	case model.TaskStatusFailure:
+		success = false
+	default:
+		return
+	}
+	// 结算记录上线前提交的任务没有预留记录，按任务额度补建，失败时沿用旧逻辑退回用户余额
+	settlement := reserveSettlement(ctx, &model.TaskSettlement{
+		Source:    model.TaskSettlementSourceTask,
+		RecordId:  task.ID,
+		TaskId:    task.TaskID,
+		UserId:    task.UserId,
+		ChannelId: task.ChannelId,
+		ModelName: taskSettlementModelName(task),
+		Quota:     task.Quota,
+	})
+	settle(ctx, settlement, success, settlementReason(reason, task.FailReason))
+}
+
+// SettleMidjourney 根据 Midjourney 任务最终状态提交或退还预留额度，重复调用不会重复退款
+func SettleMidjourney(ctx context.Context, task *model.Midjourney, reason string) {
+	var success bool
+	switch task.Status {
+	case "SUCCESS":
+		success = true
+	case "FAILURE":
+		success = false
+	default:
+		return
+	}
+	settlement := reserveSettlement(ctx, &model.TaskSettlement{
+		Source:    model.TaskSettlementSourceMidjourney,
+		RecordId:  int64(task.Id),
+		TaskId:    task.MjId,
+		UserId:    task.UserId,
+		ChannelId: task.ChannelId,
+		ModelName: CoverActionToModelName(task.Action),
+		Quota:     task.Quota,
+	})
+	settle(ctx, settlement, success, settlementReason(reason, task.FailReason))
+}
+
+// settlementReason 优先使用调用方给出的原因，其次为任务失败原因
+func settlementReason(reason string, fallback string) string {
+	if reason != "" {
+		return reason
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return "执行失败"
+}
+
+func settle(ctx context.Context, settlement *model.TaskSettlement, success bool, reason string) {
+	if settlement == nil || settlement.Status != model.TaskSettlementStatusReserved {
+		return
+	}
+	if success {
+		if _, err := model.CommitTaskSettlement(settlement.Id); err != nil {
+			common.LogError(ctx, "commit task settlement error: "+err.Error())
+		}
+		return
+	}
+	refunded, err := model.RefundTaskSettlement(settlement, reason)
+	if err != nil {
+		common.LogError(ctx, "refund task settlement error: "+err.Error())
+		return
+	}
+	if !refunded || settlement.Quota <= 0 {
+		return
+	}
+	common.LogInfo(ctx, fmt.Sprintf("异步任务 %s 退还额度 %d，原因：%s", settlement.TaskId, settlement.Quota, reason))
+	logContent := fmt.Sprintf("异步任务 %s %s，退还 %s", settlement.TaskId, reason, common.LogQuota(settlement.Quota))
+	other := map[string]interface{}{
+		"source":        settlement.Source,
+		"task_id":       settlement.TaskId,
+		"record_id":     settlement.RecordId,
+		"settlement_id": settlement.Id,
+		"reason":        reason,
+	}
+	model.RecordRefundLog(settlement.UserId, settlement.ChannelId, settlement.TokenId, settlement.ModelName,
+		settlement.Quota, logContent, other)
+}
+
+// ReconcileTasks 将超过 olderThan 仍未完成的任务判定为失败并退款，
+// 同时补结算已结束但仍处于预留状态的记录（例如进程在更新状态后、结算前退出）
+func ReconcileTasks(ctx context.Context, olderThan time.Duration, limit int, dryRun bool) *TaskReconcileResult {
+	result := &TaskReconcileResult{DryRun: dryRun, Items: make([]TaskReconcileItem, 0)}
+	cutoff := time.Now().Add(-olderThan)
+
+	for _, task := range model.GetStuckTasks(cutoff.Unix(), limit) {
+		if !dryRun {
+			task.Status = model.TaskStatusFailure
+			task.FailReason = TaskReconcileTimeoutReason
+			task.Progress = "100%"
+			task.FinishTime = time.Now().Unix()
+			updated, err := task.UpdateUnfinished()
+			if err != nil {
+				common.LogError(ctx, "reconcile task error: "+err.Error())
+				continue
+			}
+			if !updated {
+				continue
+			}
+			SettleTask(ctx, task, TaskReconcileTimeoutReason)
+			NotifyTaskFinished(task)
+		}
+		result.Items = append(result.Items, TaskReconcileItem{
+			Source:   model.TaskSettlementSourceTask,
+			RecordId: task.ID,
+			TaskId:   task.TaskID,
+			UserId:   task.UserId,
+			Quota:    task.Quota,
+			Action:   TaskReconcileActionTimeout,
+		})
+	}
+
+	for _, task := range model.GetStuckMidjourneyTasks(cutoff.UnixMilli(), limit) {
+		if !dryRun {
+			task.Status = "FAILURE"
+			task.FailReason = TaskReconcileTimeoutReason
+			task.Progress = "100%"
+			task.FinishTime = time.Now().UnixMilli()
+			updated, err := task.UpdateUnfinished()
+			if err != nil {
+				common.LogError(ctx, "reconcile midjourney task error: "+err.Error())
+				continue
+			}
+			if !updated {
+				continue
+			}
+			SettleMidjourney(ctx, task, TaskReconcileTimeoutReason)
+			NotifyMidjourneyFinished(task)
+		}
+		result.Items = append(result.Items, TaskReconcileItem{
+			Source:   model.TaskSettlementSourceMidjourney,
+			RecordId: int64(task.Id),
+			TaskId:   task.MjId,
+			UserId:   task.UserId,
+			Quota:    task.Quota,
+			Action:   TaskReconcileActionTimeout,
+		})
+	}
+
+	settlements, err := model.GetReservedTaskSettlements(cutoff.Unix(), limit)
+	if err != nil {
+		common.LogError(ctx, "get reserved task settlements error: "+err.Error())
+		return result
+	}
+	for _, settlement := range settlements {
+		action := reconcileSettlement(ctx, settlement, dryRun)
+		if action == "" {
+			continue
+		}
+		result.Items = append(result.Items, TaskReconcileItem{
+			Source:   settlement.Source,
+			RecordId: settlement.RecordId,
+			TaskId:   settlement.TaskId,
+			UserId:   settlement.UserId,
+			Quota:    settlement.Quota,
+			Action:   action,
+		})
+	}
+	return result
+}
+
+// reconcileSettlement 按任务的最终状态补结算预留记录，任务未结束时返回空
+func reconcileSettlement(ctx context.Context, settlement *model.TaskSettlement, dryRun bool) string {
+	var status string
+	switch settlement.Source {
+	case model.TaskSettlementSourceTask:
+		task, exist, err := model.GetTaskByID(settlement.RecordId)
+		if err != nil || !exist || task.Progress != "100%" {
+			return ""
+		}
+		status = string(task.Status)
+		if !dryRun {
+			SettleTask(ctx, task, "")
+		}
+	case model.TaskSettlementSourceMidjourney:
+		task := model.GetMjByuId(int(settlement.RecordId))
+		if task == nil || task.Progress != "100%" {
+			return ""
+		}
+		status = task.Status
+		if !dryRun {
+			SettleMidjourney(ctx, task, "")
+		}
+	}
+	switch status {
+	case string(model.TaskStatusSuccess):
+		return TaskReconcileActionCommit
+	case string(model.TaskStatusFailure):
+		return TaskReconcileActionRefund
+	}
+	return ""
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/model"
+	"veloera/setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TaskWebhookSourceTask       = "task"
+	TaskWebhookSourceMidjourney = "mj"
+
+	TaskWebhookEventSucceeded = "task.succeeded"
+	TaskWebhookEventFailed    = "task.failed"
+)
+
+const (
+	taskWebhookMaxAttempts   = 6
+	taskWebhookBaseDelay     = 30 * time.Second
+	taskWebhookPollInterval  = 5 * time.Second
+	taskWebhookDeliveryBatch = 100
+)
+
+// TaskWebhookPayload 异步任务完成回调的负载，签名方式与 webhook 通知相同
+type TaskWebhookPayload struct {
+	Event      string          `json:"event"`
+	Source     string          `json:"source"`
+	TaskId     string          `json:"task_id"`
+	Platform   string          `json:"platform,omitempty"`
+	Action     string          `json:"action"`
+	Status     string          `json:"status"`
+	Progress   string          `json:"progress"`
+	FailReason string          `json:"fail_reason,omitempty"`
+	ResultUrl  string          `json:"result_url,omitempty"`
+	SubmitTime int64           `json:"submit_time"`
+	FinishTime int64           `json:"finish_time"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Timestamp  int64           `json:"timestamp"`
+}
+
+// ValidateWebhookUrl 回调地址必须为 http 或 https 地址，且主机不能解析到内网地址
+func ValidateWebhookUrl(rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("回调地址必须为 http 或 https 地址")
+	}
+	if err = CheckExternalHost(parsed.Hostname()); err != nil {
+		return fmt.Errorf("回调地址无效: %w", err)
+	}
+	return nil
+}
+
+// GetTaskCallbackUrl 优先使用请求体中的 callback_url，其次为令牌的默认回调地址
+func GetTaskCallbackUrl(c *gin.Context) (string, error) {
+	var request struct {
+		CallbackUrl string `json:"callback_url"`
+	}
+	_ = common.UnmarshalBodyReusable(c, &request)
+	callbackUrl := request.CallbackUrl
+	if callbackUrl == "" {
+		callbackUrl = c.GetString("token_webhook_url")
+	}
+	if callbackUrl == "" {
+		return "", nil
+	}
+	if err := ValidateWebhookUrl(callbackUrl); err != nil {
+		return "", err
+	}
+	return callbackUrl, nil
+}
+
+func taskWebhookEvent(status string) (string, bool) {
+	switch status {
+	case model.TaskStatusSuccess:
+		return TaskWebhookEventSucceeded, true
+	case model.TaskStatusFailure:
+		return TaskWebhookEventFailed, true
+	}
+	return "", false
+}
+
+// NotifyTaskFinished 任务进入成功或失败状态后投递回调，未设置回调地址时忽略
+func NotifyTaskFinished(task *model.Task) {
+	if task.CallbackUrl == "" {
+		return
+	}
+	event, ok := taskWebhookEvent(string(task.Status))
+	if !ok {
+		return
+	}
+	payload := TaskWebhookPayload{
+		Event:      event,
+		Source:     TaskWebhookSourceTask,
+		TaskId:     task.TaskID,
+		Platform:   string(task.Platform),
+		Action:     task.Action,
+		Status:     string(task.Status),
+		Progress:   task.Progress,
+		FailReason: task.FailReason,
+		ResultUrl:  task.ResultURL,
+		SubmitTime: task.SubmitTime,
+		FinishTime: task.FinishTime,
+	}
+	if json.Valid(task.Data) {
+		payload.Data = task.Data
+	}
+	enqueueTaskWebhook(task.UserId, task.CallbackUrl, payload)
+}
+
+// NotifyMidjourneyFinished Midjourney 任务进入成功或失败状态后投递回调
+func NotifyMidjourneyFinished(task *model.Midjourney) {
+	if task.CallbackUrl == "" {
+		return
+	}
+	event, ok := taskWebhookEvent(task.Status)
+	if !ok {
+		return
+	}
+	imageUrl := task.ImageUrl
+	if imageUrl != "" && setting.MjForwardUrlEnabled {
+		imageUrl = setting.ServerAddress + "/mj/image/" + task.MjId
+	}
+	payload := TaskWebhookPayload{
+		Event:      event,
+		Source:     TaskWebhookSourceMidjourney,
+		TaskId:     task.MjId,
+		Platform:   string(constant.TaskPlatformMidjourney),
+		Action:     task.Action,
+		Status:     task.Status,
+		Progress:   task.Progress,
+		FailReason: task.FailReason,
+		ResultUrl:  imageUrl,
+		SubmitTime: task.SubmitTime,
+		FinishTime: task.FinishTime,
+	}
+	enqueueTaskWebhook(task.UserId, task.CallbackUrl, payload)
+}
+
+func enqueueTaskWebhook(userId int, callbackUrl string, payload TaskWebhookPayload) {
+	payload.Timestamp = time.Now().Unix()
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		common.SysError("failed to marshal task webhook payload: " + err.Error())
+		return
+	}
+	delivery := &model.WebhookDelivery{
+		UserId:  userId,
+		Source:  payload.Source,
+		TaskId:  payload.TaskId,
+		Event:   payload.Event,
+		Url:     callbackUrl,
+		Payload: string(payloadBytes),
+		Status:  model.WebhookDeliveryStatusPending,
+		// 首次投递由下面的协程立即完成，定时任务只处理之后的重试
+		NextAttemptTime: payload.Timestamp + int64(taskWebhookBaseDelay.Seconds()),
+		CreatedTime:     payload.Timestamp,
+	}
+	if err = delivery.Insert(); err != nil {
+		common.SysError("failed to insert task webhook delivery: " + err.Error())
+		return
+	}
+	gopool.Go(func() {
+		deliverTaskWebhook(delivery)
+	})
+}
+
+// deliverTaskWebhook 投递一次，失败时按 30s、1m、2m... 退避，超过最大次数后标记为失败
+func deliverTaskWebhook(delivery *model.WebhookDelivery) {
+	var secret string
+	if userSetting, err := model.GetUserSetting(delivery.UserId, false); err == nil {
+		secret, _ = userSetting[constant.UserSettingWebhookSecret].(string)
+	}
+	// 投递时连接阶段会再次检查解析结果，防止保存后 DNS 指向内网
+	statusCode, err := postSignedWebhook(GetExternalHttpClient(), delivery.Url, secret, []byte(delivery.Payload))
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseCode = statusCode
+	if err == nil {
+		delivery.Status = model.WebhookDeliveryStatusSuccess
+		delivery.LastError = ""
+		delivery.DeliveredTime = now.Unix()
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= taskWebhookMaxAttempts {
+			delivery.Status = model.WebhookDeliveryStatusFailed
+		} else {
+			delay := taskWebhookBaseDelay * time.Duration(1<<(delivery.Attempts-1))
+			delivery.NextAttemptTime = now.Add(delay).Unix()
+		}
+	}
+	if err := delivery.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update task webhook delivery %d: %s", delivery.Id, err.Error()))
+	}
+}
+
+// RetryWebhookDelivery 重新投递一条回调，重置重试次数
+func RetryWebhookDelivery(delivery *model.WebhookDelivery) {
+	delivery.Status = model.WebhookDeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptTime = time.Now().Unix()
+	deliverTaskWebhook(delivery)
+}
+
+// RunTaskWebhookWorker 定期投递到达重试时间的回调，仅在主节点运行
+func RunTaskWebhookWorker() {
+	for {
+		time.Sleep(taskWebhookPollInterval)
+		deliveries, err := model.GetDueWebhookDeliveries(time.Now().Unix(), taskWebhookDeliveryBatch)
+		if err != nil {
+			common.SysError("failed to get task webhook deliveries: " + err.Error())
+			continue
+		}
+		for _, delivery := range deliveries {
+			deliverTaskWebhook(delivery)
+		}
+	}
+}
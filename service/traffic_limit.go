@@ -0,0 +1,295 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	"veloera/setting/model_setting"
+	"veloera/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TrafficLimitKindTokens      = "tokens"
+	TrafficLimitKindConcurrency = "concurrency"
+)
+
+type trafficScope struct {
+	Name  string
+	Key   string
+	Limit operation_setting.TrafficLimit
+	// Shared 分组、渠道等多个用户共用的维度，令牌与用户维度只限制请求者自己
+	Shared bool
+}
+
+// TrafficLimitError TPM 或并发超限
+type TrafficLimitError struct {
+	Scope        string
+	Kind         string
+	Limit        int
+	Remaining    int
+	ResetSeconds int
+	Shared       bool
+}
+
+func (e *TrafficLimitError) Error() string {
+	if e.Kind == TrafficLimitKindConcurrency {
+		return fmt.Sprintf("%s并发请求数已达上限：最多同时处理 %d 个请求", e.Scope, e.Limit)
+	}
+	return fmt.Sprintf("%sTPM 已达上限：每分钟最多 %d tokens，剩余 %d tokens，请 %d 秒后重试", e.Scope, e.Limit, e.Remaining, e.ResetSeconds)
+}
+
+func channelSettingInt(setting map[string]interface{}, key string) int {
+	switch v := setting[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+// getTrafficScopes 按令牌、用户、分组、渠道收集生效的 TPM 与并发限制
+func getTrafficScopes(c *gin.Context) []trafficScope {
+	trafficSetting := operation_setting.GetTrafficLimitSetting()
+	if !trafficSetting.Enabled {
+		return nil
+	}
+	scopes := make([]trafficScope, 0, 4)
+	add := func(name string, key string, limit operation_setting.TrafficLimit, shared bool) {
+		if limit.TPM > 0 || limit.Concurrency > 0 {
+			scopes = append(scopes, trafficScope{Name: name, Key: key, Limit: limit, Shared: shared})
+		}
+	}
+	group := c.GetString("group")
+	if !model_setting.ShouldBypassRateLimit(c.GetString("token_group")) {
+		if tokenId := c.GetInt("token_id"); tokenId != 0 {
+			add("令牌", fmt.Sprintf("token:%d", tokenId), operation_setting.TrafficLimit{
+				TPM:         c.GetInt("token_tpm_limit"),
+				Concurrency: c.GetInt("token_concurrency_limit"),
+			}, false)
+		}
+		userLimit := operation_setting.TrafficLimit{
+			TPM:         c.GetInt(constant.ContextKeyUserTPMLimit),
+			Concurrency: c.GetInt(constant.ContextKeyUserConcurrencyLimit),
+		}
+		if userLimit.TPM == 0 {
+			userLimit.TPM = trafficSetting.DefaultUserTPM
+		}
+		if userLimit.Concurrency == 0 {
+			userLimit.Concurrency = trafficSetting.DefaultUserConcurrency
+		}
+		add("用户", fmt.Sprintf("user:%d", c.GetInt("id")), userLimit, false)
+		if group != "" {
+			add("分组", "group:"+group, operation_setting.GetGroupTrafficLimit(group), true)
+		}
+	}
+	if channelId := c.GetInt("channel_id"); channelId != 0 {
+		channelSetting := c.GetStringMap("channel_setting")
+		add("渠道", fmt.Sprintf("channel:%d", channelId), operation_setting.TrafficLimit{
+			TPM:         channelSettingInt(channelSetting, constant.ChannelSettingTPMLimit),
+			Concurrency: channelSettingInt(channelSetting, constant.ChannelSettingConcurrencyLimit),
+		}, true)
+	}
+	return scopes
+}
+
+func trafficLimitErrorWrapper(c *gin.Context, limitErr *TrafficLimitError) *dto.OpenAIErrorWithStatusCode {
+	SetTrafficLimitHeaders(c, limitErr)
+	code := "tpm_limit_exceeded"
+	if limitErr.Kind == TrafficLimitKindConcurrency {
+		code = "concurrency_limit_exceeded"
+	}
+	return OpenAIErrorWrapperLocal(limitErr, code, http.StatusTooManyRequests)
+}
+
+// SetTrafficLimitHeaders 写入 x-ratelimit-*-tokens 与 Retry-After 响应头，
+// 并发超限没有对应的标准响应头，只提示客户端稍后重试
+func SetTrafficLimitHeaders(c *gin.Context, limitErr *TrafficLimitError) {
+	header := c.Writer.Header()
+	if limitErr.Kind == TrafficLimitKindTokens {
+		common.SetRateLimitHeaders(header, TrafficLimitKindTokens, limitErr.Limit, limitErr.Remaining, int64(limitErr.ResetSeconds))
+	}
+	common.SetRetryAfter(header, int64(limitErr.ResetSeconds))
+}
+
+// AcquireTrafficConcurrency 依次占用各维度的并发名额，任一维度超限时释放已占用的名额
+func AcquireTrafficConcurrency(c *gin.Context) (func(), *dto.OpenAIErrorWithStatusCode) {
+	release, limitErr := acquireTrafficConcurrency(c)
+	if limitErr != nil {
+		return release, trafficLimitErrorWrapper(c, limitErr)
+	}
+	return release, nil
+}
+
+func acquireTrafficConcurrency(c *gin.Context) (func(), *TrafficLimitError) {
+	acquired := make([]string, 0, 4)
+	release := func() {
+		for _, key := range acquired {
+			common.ReleaseConcurrency(key)
+		}
+	}
+	for _, scope := range getTrafficScopes(c) {
+		if scope.Limit.Concurrency <= 0 {
+			continue
+		}
+		ok, _, err := common.AcquireConcurrency(scope.Key, scope.Limit.Concurrency)
+		if err != nil {
+			common.LogError(c, "acquire concurrency failed: "+err.Error())
+			continue
+		}
+		if !ok {
+			release()
+			return func() {}, &TrafficLimitError{
+				Scope:  scope.Name,
+				Kind:   TrafficLimitKindConcurrency,
+				Limit:  scope.Limit.Concurrency,
+				Shared: scope.Shared,
+			}
+		}
+		acquired = append(acquired, scope.Key)
+	}
+	return release, nil
+}
+
+// relayConcurrency 本次转发占用的并发名额，等待 TPM 时可暂时归还
+type relayConcurrency struct {
+	release func()
+}
+
+func (r *relayConcurrency) Release() {
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+}
+
+// AcquireRelayConcurrency 为本次转发占用并发名额并记录在上下文中，返回的释放函数可重复调用
+func AcquireRelayConcurrency(c *gin.Context) (func(), *dto.OpenAIErrorWithStatusCode) {
+	release, limitErr := AcquireTrafficConcurrencyQueued(c)
+	if limitErr != nil {
+		return func() {}, limitErr
+	}
+	holder := &relayConcurrency{release: release}
+	c.Set(constant.ContextKeyTrafficConcurrency, holder)
+	return holder.Release, nil
+}
+
+// ReserveTPM 准入时按预估 tokens 预占各维度的 TPM，超限且启用排队时在 分组/模型 队列中等待；
+// 等待期间归还已占用的并发名额，避免排队的请求占满并发，准入后重新获取
+func ReserveTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo, tokens int) *dto.OpenAIErrorWithStatusCode {
+	limitErr := reserveTPM(c, relayInfo, tokens)
+	if limitErr == nil {
+		return nil
+	}
+	holder, _ := c.Value(constant.ContextKeyTrafficConcurrency).(*relayConcurrency)
+	suspended := holder != nil && holder.release != nil
+	if suspended {
+		holder.Release()
+	}
+	_, queueErr := WaitInRequestQueue(c, requestQueueKey(c), trafficLimitErrorWrapper(c, limitErr), limitErr.Shared,
+		func() (func(), *dto.OpenAIErrorWithStatusCode, bool) {
+			if limitErr := reserveTPM(c, relayInfo, tokens); limitErr != nil {
+				return func() {}, trafficLimitErrorWrapper(c, limitErr), limitErr.Shared
+			}
+			return func() {}, nil, false
+		})
+	if queueErr != nil || !suspended {
+		return queueErr
+	}
+	release, concurrencyErr := AcquireTrafficConcurrencyQueued(c)
+	if concurrencyErr != nil {
+		SettleTPM(c, relayInfo, 0)
+		return concurrencyErr
+	}
+	holder.release = release
+	return nil
+}
+
+func reserveTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo, tokens int) *TrafficLimitError {
+	if tokens <= 0 {
+		tokens = 1
+	}
+	reservation := &relaycommon.TPMReservation{Tokens: tokens}
+	tightestLimit, tightestRemaining := 0, 0
+	for _, scope := range getTrafficScopes(c) {
+		if scope.Limit.TPM <= 0 {
+			continue
+		}
+		ok, used, minute, err := common.ReserveTPM(scope.Key, scope.Limit.TPM, tokens)
+		if err != nil {
+			common.LogError(c, "reserve tpm failed: "+err.Error())
+			continue
+		}
+		if !ok {
+			for _, key := range reservation.Keys {
+				common.AdjustTPM(key, reservation.Minute, -tokens)
+			}
+			return &TrafficLimitError{
+				Scope:        scope.Name,
+				Kind:         TrafficLimitKindTokens,
+				Limit:        scope.Limit.TPM,
+				Remaining:    max(scope.Limit.TPM-used, 0),
+				ResetSeconds: int(60 - time.Now().Unix()%60),
+				Shared:       scope.Shared,
+			}
+		}
+		reservation.Minute = minute
+		reservation.Keys = append(reservation.Keys, scope.Key)
+		remaining := max(scope.Limit.TPM-used, 0)
+		if tightestLimit == 0 || int64(remaining)*int64(tightestLimit) < int64(tightestRemaining)*int64(scope.Limit.TPM) {
+			tightestLimit, tightestRemaining = scope.Limit.TPM, remaining
+		}
+	}
+	if len(reservation.Keys) > 0 {
+		relayInfo.TPMReservation = reservation
+		common.SetRateLimitHeaders(c.Writer.Header(), TrafficLimitKindTokens, tightestLimit, tightestRemaining, 60-time.Now().Unix()%60)
+	}
+	return nil
+}
+
+// SettleTPM 按实际 tokens 修正预占量；没有预占记录时直接计入实际用量
+func SettleTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo, actualTokens int) {
+	reservation := relayInfo.TPMReservation
+	if reservation != nil && !reservation.Settled {
+		reservation.Settled = true
+		for _, key := range reservation.Keys {
+			common.AdjustTPM(key, reservation.Minute, actualTokens-reservation.Tokens)
+		}
+		return
+	}
+	if actualTokens <= 0 {
+		return
+	}
+	minute := time.Now().Unix() / 60
+	for _, scope := range getTrafficScopes(c) {
+		if scope.Limit.TPM > 0 {
+			common.AdjustTPM(scope.Key, minute, actualTokens)
+		}
+	}
+}
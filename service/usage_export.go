@@ -0,0 +1,491 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+	"veloera/common"
+	"veloera/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	UsageExportFormatCSV     = "csv"
+	UsageExportFormatJSONL   = "jsonl"
+	UsageExportFormatParquet = "parquet"
+
+	UsageExportRollupDay = "day"
+)
+
+// usageExportBatchSize 每批读取的日志条数，同时作为进度更新的粒度
+const usageExportBatchSize = 2000
+
+// usageExportMaxActive 每个用户同时进行中的导出任务上限
+const usageExportMaxActive = 2
+
+// usageExportStaleTimeout 超过该时长没有进度的导出任务视为已中断
+const usageExportStaleTimeout = 10 * time.Minute
+
+// usageExportChunkSize 导出文件在数据库中每个分块的大小，需小于 MySQL 默认的 max_allowed_packet
+const usageExportChunkSize = 1 << 20
+
+// usageExportRowGroupSize parquet 每个行组的行数，写满即编码并写出，避免整个文件缓存在内存中
+const usageExportRowGroupSize = 10000
+
+// usageExportMaxRollupRows 按天汇总时内存中保留的汇总行上限，超过时提示缩小筛选范围
+const usageExportMaxRollupRows = 500000
+
+// UsageExportRow 明细导出行
+type UsageExportRow struct {
+	Id               int64  `json:"id" parquet:"id"`
+	CreatedAt        int64  `json:"created_at" parquet:"created_at"`
+	Time             string `json:"time" parquet:"time"`
+	Type             int64  `json:"type" parquet:"type"`
+	UserId           int64  `json:"user_id" parquet:"user_id"`
+	Username         string `json:"username" parquet:"username"`
+	TokenId          int64  `json:"token_id" parquet:"token_id"`
+	TokenName        string `json:"token_name" parquet:"token_name"`
+	Group            string `json:"group" parquet:"group"`
+	ChannelId        int64  `json:"channel_id" parquet:"channel_id"`
+	ModelName        string `json:"model_name" parquet:"model_name"`
+	Quota            int64  `json:"quota" parquet:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens" parquet:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" parquet:"completion_tokens"`
+	UseTime          int64  `json:"use_time" parquet:"use_time"`
+	IsStream         bool   `json:"is_stream" parquet:"is_stream"`
+	Content          string `json:"content" parquet:"content"`
+}
+
+func (r UsageExportRow) csvHeader() []string {
+	return []string{"id", "created_at", "time", "type", "user_id", "username", "token_id", "token_name", "group",
+		"channel_id", "model_name", "quota", "prompt_tokens", "completion_tokens", "use_time", "is_stream", "content"}
+}
+
+func (r UsageExportRow) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(r.Id, 10), strconv.FormatInt(r.CreatedAt, 10), r.Time, strconv.FormatInt(r.Type, 10),
+		strconv.FormatInt(r.UserId, 10), r.Username, strconv.FormatInt(r.TokenId, 10), r.TokenName, r.Group,
+		strconv.FormatInt(r.ChannelId, 10), r.ModelName, strconv.FormatInt(r.Quota, 10),
+		strconv.FormatInt(r.PromptTokens, 10), strconv.FormatInt(r.CompletionTokens, 10),
+		strconv.FormatInt(r.UseTime, 10), strconv.FormatBool(r.IsStream), r.Content,
+	}
+}
+
+// UsageRollupRow 按天汇总的导出行，Day 为 UTC 日期，与服务器时区无关
+type UsageRollupRow struct {
+	Day              string `json:"day" parquet:"day"`
+	UserId           int64  `json:"user_id" parquet:"user_id"`
+	Username         string `json:"username" parquet:"username"`
+	TokenName        string `json:"token_name" parquet:"token_name"`
+	Group            string `json:"group" parquet:"group"`
+	ChannelId        int64  `json:"channel_id" parquet:"channel_id"`
+	ModelName        string `json:"model_name" parquet:"model_name"`
+	RequestCount     int64  `json:"request_count" parquet:"request_count"`
+	Quota            int64  `json:"quota" parquet:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens" parquet:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" parquet:"completion_tokens"`
+}
+
+func (r UsageRollupRow) csvHeader() []string {
+	return []string{"day", "user_id", "username", "token_name", "group", "channel_id", "model_name",
+		"request_count", "quota", "prompt_tokens", "completion_tokens"}
+}
+
+func (r UsageRollupRow) csvRecord() []string {
+	return []string{
+		r.Day, strconv.FormatInt(r.UserId, 10), r.Username, r.TokenName, r.Group,
+		strconv.FormatInt(r.ChannelId, 10), r.ModelName, strconv.FormatInt(r.RequestCount, 10),
+		strconv.FormatInt(r.Quota, 10), strconv.FormatInt(r.PromptTokens, 10), strconv.FormatInt(r.CompletionTokens, 10),
+	}
+}
+
+type exportRow interface {
+	csvHeader() []string
+	csvRecord() []string
+}
+
+type exportWriter[T exportRow] interface {
+	Write(rows []T) error
+	Close() error
+}
+
+type csvExportWriter[T exportRow] struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvExportWriter[T]) Write(rows []T) error {
+	for _, row := range rows {
+		if !w.wroteHeader {
+			if err := w.writer.Write(row.csvHeader()); err != nil {
+				return err
+			}
+			w.wroteHeader = true
+		}
+		if err := w.writer.Write(row.csvRecord()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *csvExportWriter[T]) Close() error {
+	if !w.wroteHeader {
+		var zero T
+		if err := w.writer.Write(zero.csvHeader()); err != nil {
+			return err
+		}
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type jsonlExportWriter[T exportRow] struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlExportWriter[T]) Write(rows []T) error {
+	for _, row := range rows {
+		if err := w.encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *jsonlExportWriter[T]) Close() error {
+	return nil
+}
+
+type parquetExportWriter[T exportRow] struct {
+	writer *parquet.GenericWriter[T]
+}
+
+func (w *parquetExportWriter[T]) Write(rows []T) error {
+	_, err := w.writer.Write(rows)
+	return err
+}
+
+func (w *parquetExportWriter[T]) Close() error {
+	return w.writer.Close()
+}
+
+func newExportWriter[T exportRow](format string, output io.Writer) exportWriter[T] {
+	switch format {
+	case UsageExportFormatJSONL:
+		return &jsonlExportWriter[T]{encoder: json.NewEncoder(output)}
+	case UsageExportFormatParquet:
+		return &parquetExportWriter[T]{writer: parquet.NewGenericWriter[T](output, parquet.MaxRowsPerRowGroup(usageExportRowGroupSize))}
+	default:
+		return &csvExportWriter[T]{writer: csv.NewWriter(output)}
+	}
+}
+
+// CreateUsageExport 创建导出任务并在后台生成文件
+func CreateUsageExport(userId int, scope string, format string, rollup string, filter *model.UsageExportFilter) (*model.UsageExport, error) {
+	switch format {
+	case "":
+		format = UsageExportFormatCSV
+	case UsageExportFormatCSV, UsageExportFormatJSONL, UsageExportFormatParquet:
+	default:
+		return nil, errors.New("不支持的导出格式")
+	}
+	if rollup != "" && rollup != UsageExportRollupDay {
+		return nil, errors.New("不支持的汇总方式")
+	}
+	if filter.StartTimestamp != 0 && filter.EndTimestamp != 0 && filter.StartTimestamp > filter.EndTimestamp {
+		return nil, errors.New("开始时间不能晚于结束时间")
+	}
+	if scope == model.UsageExportScopeSelf {
+		// 用户只能导出自己的日志，且不暴露渠道信息
+		filter.UserId = userId
+		filter.Username = ""
+		filter.Channel = 0
+	}
+	active, err := model.CountActiveUsageExports(userId)
+	if err != nil {
+		return nil, err
+	}
+	if active >= usageExportMaxActive {
+		return nil, errors.New("已有导出任务正在进行，请稍后再试")
+	}
+	filters, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	export := &model.UsageExport{
+		UserId:  userId,
+		Scope:   scope,
+		Format:  format,
+		Rollup:  rollup,
+		Filters: string(filters),
+	}
+	if err := export.Insert(); err != nil {
+		return nil, err
+	}
+	export.FileName = fmt.Sprintf("usage-%d-%s.%s", export.Id, time.Unix(export.CreatedTime, 0).Format("20060102150405"), format)
+	created := *export
+	gopool.Go(func() {
+		runUsageExport(export, filter)
+	})
+	return &created, nil
+}
+
+func runUsageExport(export *model.UsageExport, filter *model.UsageExportFilter) {
+	export.Status = model.UsageExportStatusRunning
+	if err := export.Update(); err != nil {
+		common.SysError("update usage export error: " + err.Error())
+	}
+	err := writeUsageExport(export, filter)
+	export.FinishedTime = common.GetTimestamp()
+	if err != nil {
+		common.SysError(fmt.Sprintf("usage export #%d failed: %s", export.Id, err.Error()))
+		export.Status = model.UsageExportStatusFailed
+		export.Error = err.Error()
+		if err := model.DeleteUsageExportChunks(export.Id); err != nil {
+			common.SysError("delete usage export chunks error: " + err.Error())
+		}
+	} else {
+		export.Status = model.UsageExportStatusCompleted
+	}
+	if err := export.Update(); err != nil {
+		common.SysError("update usage export error: " + err.Error())
+	}
+}
+
+// usageExportChunkWriter 将导出内容按固定大小分块写入数据库
+type usageExportChunkWriter struct {
+	exportId int
+	seq      int
+	size     int64
+}
+
+func (w *usageExportChunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		data := make([]byte, min(len(p)-written, usageExportChunkSize))
+		copy(data, p[written:])
+		if err := model.InsertUsageExportChunk(w.exportId, w.seq, data); err != nil {
+			return written, err
+		}
+		w.seq++
+		written += len(data)
+		w.size += int64(len(data))
+	}
+	return written, nil
+}
+
+func writeUsageExport(export *model.UsageExport, filter *model.UsageExportFilter) error {
+	chunks := &usageExportChunkWriter{exportId: export.Id}
+	buffered := bufio.NewWriterSize(chunks, usageExportChunkSize)
+
+	var err error
+	if export.Rollup == UsageExportRollupDay {
+		err = writeUsageRollup(export, filter, buffered)
+	} else {
+		err = writeUsageDetail(export, filter, buffered)
+	}
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	export.FileSize = chunks.size
+	return nil
+}
+
+// StreamUsageExport 将已生成的导出文件按分块顺序写入 output
+func StreamUsageExport(export *model.UsageExport, output io.Writer) error {
+	return model.IterateUsageExportChunks(export.Id, func(data []byte) error {
+		_, err := output.Write(data)
+		return err
+	})
+}
+
+func writeUsageDetail(export *model.UsageExport, filter *model.UsageExportFilter, output io.Writer) error {
+	writer := newExportWriter[UsageExportRow](export.Format, output)
+	err := model.IterateExportLogs(filter, usageExportBatchSize, func(logs []*model.Log) error {
+		rows := make([]UsageExportRow, 0, len(logs))
+		for _, log := range logs {
+			row := UsageExportRow{
+				Id:               int64(log.Id),
+				CreatedAt:        log.CreatedAt,
+				Time:             time.Unix(log.CreatedAt, 0).Format(time.RFC3339),
+				Type:             int64(log.Type),
+				UserId:           int64(log.UserId),
+				Username:         log.Username,
+				TokenId:          int64(log.TokenId),
+				TokenName:        log.TokenName,
+				Group:            log.Group,
+				ModelName:        log.ModelName,
+				Quota:            int64(log.Quota),
+				PromptTokens:     int64(log.PromptTokens),
+				CompletionTokens: int64(log.CompletionTokens),
+				UseTime:          int64(log.UseTime),
+				IsStream:         log.IsStream,
+				Content:          log.Content,
+			}
+			if export.Scope != model.UsageExportScopeSelf {
+				row.ChannelId = int64(log.ChannelId)
+			}
+			rows = append(rows, row)
+		}
+		if err := writer.Write(rows); err != nil {
+			return err
+		}
+		return reportUsageExportProgress(export, int64(len(rows)))
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+type usageRollupKey struct {
+	day       string
+	userId    int
+	tokenName string
+	group     string
+	channelId int
+	modelName string
+}
+
+func writeUsageRollup(export *model.UsageExport, filter *model.UsageExportFilter, output io.Writer) error {
+	rollup := make(map[usageRollupKey]*UsageRollupRow)
+	var scanned int64
+	err := model.IterateExportLogs(filter, usageExportBatchSize, func(logs []*model.Log) error {
+		for _, log := range logs {
+			key := usageRollupKey{
+				day:       time.Unix(log.CreatedAt, 0).UTC().Format("2006-01-02"),
+				userId:    log.UserId,
+				tokenName: log.TokenName,
+				group:     log.Group,
+				modelName: log.ModelName,
+			}
+			if export.Scope != model.UsageExportScopeSelf {
+				key.channelId = log.ChannelId
+			}
+			row, ok := rollup[key]
+			if !ok {
+				if len(rollup) >= usageExportMaxRollupRows {
+					return fmt.Errorf("汇总结果超过 %d 行，请缩小时间范围或增加筛选条件", usageExportMaxRollupRows)
+				}
+				row = &UsageRollupRow{
+					Day:       key.day,
+					UserId:    int64(key.userId),
+					Username:  log.Username,
+					TokenName: key.tokenName,
+					Group:     key.group,
+					ChannelId: int64(key.channelId),
+					ModelName: key.modelName,
+				}
+				rollup[key] = row
+			}
+			row.RequestCount++
+			row.Quota += int64(log.Quota)
+			row.PromptTokens += int64(log.PromptTokens)
+			row.CompletionTokens += int64(log.CompletionTokens)
+		}
+		scanned += int64(len(logs))
+		// 汇总模式下进度只记录已扫描的日志数，完成后改为汇总行数
+		export.RowCount = scanned
+		return export.Update()
+	})
+	if err != nil {
+		return err
+	}
+	rows := make([]UsageRollupRow, 0, len(rollup))
+	for _, row := range rollup {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		if rows[i].UserId != rows[j].UserId {
+			return rows[i].UserId < rows[j].UserId
+		}
+		if rows[i].ModelName != rows[j].ModelName {
+			return rows[i].ModelName < rows[j].ModelName
+		}
+		if rows[i].TokenName != rows[j].TokenName {
+			return rows[i].TokenName < rows[j].TokenName
+		}
+		if rows[i].Group != rows[j].Group {
+			return rows[i].Group < rows[j].Group
+		}
+		return rows[i].ChannelId < rows[j].ChannelId
+	})
+	writer := newExportWriter[UsageRollupRow](export.Format, output)
+	if err := writer.Write(rows); err != nil {
+		return err
+	}
+	export.RowCount = int64(len(rows))
+	return writer.Close()
+}
+
+// reportUsageExportProgress 保存导出进度，同时作为任务仍在运行的心跳
+func reportUsageExportProgress(export *model.UsageExport, rows int64) error {
+	export.RowCount += rows
+	return export.Update()
+}
+
+// DeleteUsageExport 删除导出记录及其文件
+func DeleteUsageExport(export *model.UsageExport) error {
+	if export.Status == model.UsageExportStatusPending || export.Status == model.UsageExportStatusRunning {
+		return errors.New("导出任务正在进行，无法删除")
+	}
+	return model.DeleteUsageExport(export.Id)
+}
+
+// RunUsageExportJanitor 定期将进程重启等原因中断的导出任务标记为失败
+func RunUsageExportJanitor() {
+	for {
+		failStaleUsageExports()
+		time.Sleep(usageExportStaleTimeout)
+	}
+}
+
+func failStaleUsageExports() {
+	exports, err := model.GetStaleUsageExports(time.Now().Add(-usageExportStaleTimeout).Unix())
+	if err != nil {
+		common.SysError("get stale usage exports error: " + err.Error())
+		return
+	}
+	for _, export := range exports {
+		if err := model.DeleteUsageExportChunks(export.Id); err != nil {
+			common.SysError("delete usage export chunks error: " + err.Error())
+		}
+		export.Status = model.UsageExportStatusFailed
+		export.Error = "导出任务已中断，请重新发起"
+		export.FinishedTime = common.GetTimestamp()
+		if err := export.Update(); err != nil {
+			common.SysError("update usage export error: " + err.Error())
+		}
+	}
+}
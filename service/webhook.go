@@ -45,6 +45,11 @@ func generateSignature(secret string, payload []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// GenerateWebhookSignature 使用与 webhook 通知相同的 HMAC-SHA256 方案计算签名
+func GenerateWebhookSignature(secret string, payload []byte) string {
+	return generateSignature(secret, payload)
+}
+
 // SendWebhookNotify 发送 webhook 通知
 func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error {
 	// 处理占位符
@@ -68,9 +73,21 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 		return fmt.Errorf("failed to marshal webhook payload: %v", err)
 	}
 
+	_, err = PostSignedWebhook(webhookURL, secret, payloadBytes)
+	return err
+}
+
+// PostSignedWebhook 发送带 HMAC 签名的 webhook 请求，返回响应状态码
+func PostSignedWebhook(webhookURL string, secret string, payloadBytes []byte) (int, error) {
+	return postSignedWebhook(GetImpatientHttpClient(), webhookURL, secret, payloadBytes)
+}
+
+// postSignedWebhook 使用指定的客户端直连发送，启用 worker 时由 worker 转发
+func postSignedWebhook(client *http.Client, webhookURL string, secret string, payloadBytes []byte) (int, error) {
 	// 创建 HTTP 请求
 	var req *http.Request
 	var resp *http.Response
+	var err error
 
 	if setting.EnableWorker() {
 		// 构建worker请求数据
@@ -93,18 +110,13 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 
 		resp, err = DoWorkerRequest(workerReq)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request through worker: %v", err)
+			return 0, fmt.Errorf("failed to send webhook request through worker: %v", err)
 		}
 		defer resp.Body.Close()
-
-		// 检查响应状态
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
-		}
 	} else {
 		req, err = http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(payloadBytes))
 		if err != nil {
-			return fmt.Errorf("failed to create webhook request: %v", err)
+			return 0, fmt.Errorf("failed to create webhook request: %v", err)
 		}
 
 		// 设置请求头
@@ -117,18 +129,16 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 		}
 
 		// 发送请求
-		client := GetImpatientHttpClient()
 		resp, err = client.Do(req)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request: %v", err)
+			return 0, fmt.Errorf("failed to send webhook request: %v", err)
 		}
 		defer resp.Body.Close()
-
-		// 检查响应状态
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
-		}
 	}
 
-	return nil
+	// 检查响应状态
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
 }
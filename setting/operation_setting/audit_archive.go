@@ -0,0 +1,63 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import (
+	"veloera/common"
+	"veloera/setting/config"
+)
+
+const (
+	AuditStorageDB   = "db"   // 存入日志数据库的 audit_records 表
+	AuditStorageFile = "file" // 存入 AUDIT_DIR 目录，数据库只保存元数据；多节点部署时 AUDIT_DIR 需为共享目录才能在任意节点查看
+)
+
+// AuditArchiveSetting 请求与响应审计归档。请求体为转换后发往上游的内容，
+// 响应体为返回给客户端的内容，流式响应重组为完整结果后保存。
+// 支持 Chat Completions、Claude Messages、Responses、Embeddings、Rerank 与图像生成接口，
+// 音频、实时会话、Midjourney 与异步任务接口在开启归档时直接拒绝请求；
+// 需设置环境变量 AUDIT_ENCRYPTION_KEY 才能启用
+type AuditArchiveSetting struct {
+	Enabled bool `json:"enabled"`
+	// Groups 默认归档的分组，"*" 表示所有分组；令牌可单独开启或关闭
+	Groups        []string `json:"groups"`
+	Storage       string   `json:"storage"`
+	RetentionDays int      `json:"retention_days"`
+	// MaxBodyBytes 单个请求体或响应体的最大归档长度，超出部分截断
+	MaxBodyBytes int `json:"max_body_bytes"`
+}
+
+var auditArchiveSetting = AuditArchiveSetting{
+	Enabled:       false,
+	Groups:        []string{},
+	Storage:       AuditStorageDB,
+	RetentionDays: 30,
+	MaxBodyBytes:  1 << 20,
+}
+
+func init() {
+	config.GlobalConfig.Register("audit_archive", &auditArchiveSetting)
+}
+
+func GetAuditArchiveSetting() *AuditArchiveSetting {
+	return &auditArchiveSetting
+}
+
+// AuditArchiveEnabledForGroup 分组是否默认归档
+func AuditArchiveEnabledForGroup(group string) bool {
+	return common.StringsContains(auditArchiveSetting.Groups, "*") || common.StringsContains(auditArchiveSetting.Groups, group)
+}
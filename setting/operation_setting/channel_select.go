@@ -0,0 +1,55 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import (
+	"veloera/common"
+	"veloera/setting/config"
+)
+
+const (
+	ChannelSelectStrategyWeighted      = "weighted"       // 同一优先级内按权重随机
+	ChannelSelectStrategyCheapestFirst = "cheapest_first" // 同一优先级内优先选择上游成本最低的渠道
+)
+
+// ChannelSelectSetting 渠道选择策略，渠道成本取自渠道设置中的 cost 字段
+type ChannelSelectSetting struct {
+	Strategy string   `json:"strategy"`
+	Groups   []string `json:"groups"` // 使用该策略的分组，为空时对全部分组生效
+}
+
+// 默认配置
+var channelSelectSetting = ChannelSelectSetting{
+	Strategy: ChannelSelectStrategyWeighted,
+	Groups:   []string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_select", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+func CheapestFirstEnabledForGroup(group string) bool {
+	if channelSelectSetting.Strategy != ChannelSelectStrategyCheapestFirst {
+		return false
+	}
+	return len(channelSelectSetting.Groups) == 0 || common.StringsContains(channelSelectSetting.Groups, group)
+}
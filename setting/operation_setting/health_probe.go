@@ -0,0 +1,61 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+// HealthProbeSetting 定时健康探测配置，按 渠道/模型 发起流式测试请求并记录耗时与首字耗时
+type HealthProbeSetting struct {
+	Enabled             bool     `json:"enabled"`
+	IntervalMinutes     int      `json:"interval_minutes"`
+	Models              []string `json:"models"`                 // 仅探测这些模型，为空时探测渠道的全部模型
+	MaxModelsPerChannel int      `json:"max_models_per_channel"` // 每个渠道每轮最多探测的模型数
+	Concurrency         int      `json:"concurrency"`
+	// SLO：窗口内成功率低于目标或平均耗时超过目标时告警
+	SLOWindowMinutes    int     `json:"slo_window_minutes"`
+	SLOSuccessRate      float64 `json:"slo_success_rate"` // 百分比
+	SLOLatencyMs        int     `json:"slo_latency_ms"`   // 为 0 时不检查
+	SLOFirstResponseMs  int     `json:"slo_first_response_ms"`
+	RawRetentionDays    int     `json:"raw_retention_days"`
+	RollupRetentionDays int     `json:"rollup_retention_days"`
+	PublicStatusEnabled bool    `json:"public_status_enabled"` // 是否开放公开状态页接口
+}
+
+// 默认配置
+var healthProbeSetting = HealthProbeSetting{
+	Enabled:             false,
+	IntervalMinutes:     5,
+	Models:              []string{},
+	MaxModelsPerChannel: 5,
+	Concurrency:         2,
+	SLOWindowMinutes:    60,
+	SLOSuccessRate:      99,
+	SLOLatencyMs:        0,
+	SLOFirstResponseMs:  0,
+	RawRetentionDays:    3,
+	RollupRetentionDays: 31,
+	PublicStatusEnabled: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("health_probe", &healthProbeSetting)
+}
+
+func GetHealthProbeSetting() *HealthProbeSetting {
+	return &healthProbeSetting
+}
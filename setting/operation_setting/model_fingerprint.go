@@ -0,0 +1,48 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+const (
+	FingerprintActionNotify        = "notify"
+	FingerprintActionLowerPriority = "lower_priority"
+	FingerprintActionDisable       = "disable"
+)
+
+// ModelFingerprintSetting 模型指纹检测配置，得分低于阈值的 渠道/模型 会被标记并按 Action 处理
+type ModelFingerprintSetting struct {
+	ScoreThreshold float64 `json:"score_threshold"` // 0-100
+	Action         string  `json:"action"`          // notify / lower_priority / disable
+	DemotePriority int64   `json:"demote_priority"` // 降级后的优先级，不低于原优先级时改为原优先级减 1
+}
+
+// 默认配置
+var modelFingerprintSetting = ModelFingerprintSetting{
+	ScoreThreshold: 70,
+	Action:         FingerprintActionNotify,
+	DemotePriority: -1,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fingerprint", &modelFingerprintSetting)
+}
+
+func GetModelFingerprintSetting() *ModelFingerprintSetting {
+	return &modelFingerprintSetting
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import (
+	"veloera/common"
+	"veloera/setting/config"
+)
+
+const (
+	ModerationStageKeyword = "keyword" // 屏蔽词匹配，未填写 words 时使用全局屏蔽词
+	ModerationStageRegex   = "regex"   // 正则匹配
+	ModerationStageOpenAI  = "openai"  // 通过本站渠道调用 OpenAI 兼容的 /v1/moderations
+	ModerationStageHTTP    = "http"    // 自定义 HTTP 分类服务
+)
+
+const (
+	ModerationActionBlock  = "block"  // 拒绝请求或终止输出
+	ModerationActionRedact = "redact" // 遮盖命中内容后继续
+	ModerationActionLog    = "log"    // 仅记录
+	ModerationActionRoute  = "route"  // 将请求改由 route_group 分组处理，仅对输入生效，输出时按 block 处理
+)
+
+const (
+	ModerationDirectionInput  = "input"
+	ModerationDirectionOutput = "output"
+)
+
+// ModerationStage 审核流水线中的一个阶段
+type ModerationStage struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	Input   bool   `json:"input"`  // 审核用户输入
+	Output  bool   `json:"output"` // 审核模型输出
+	// keyword / regex
+	Words    []string `json:"words"`
+	Category string   `json:"category"` // keyword / regex 命中时的分类，默认为阶段类型
+	// openai
+	Model string `json:"model"`
+	Group string `json:"group"` // 选择渠道使用的分组，为空时使用请求所在分组
+	// openai / http，分类得分达到阈值视为命中，0 时使用服务返回的 flagged
+	Threshold float64 `json:"threshold"`
+	// http
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	// FailOpen 分类服务出错时放行，否则拒绝请求
+	FailOpen bool `json:"fail_open"`
+	// Actions 分类到动作的映射，"*" 为默认动作，未配置时为 block
+	Actions    map[string]string `json:"actions"`
+	RouteGroup string            `json:"route_group"`
+}
+
+// ModerationGroupOverride 分组级覆盖
+type ModerationGroupOverride struct {
+	Disabled       bool                         `json:"disabled"`
+	DisabledStages []string                     `json:"disabled_stages"`
+	Actions        map[string]map[string]string `json:"actions"` // 阶段名 -> 分类 -> 动作
+	RouteGroup     string                       `json:"route_group"`
+}
+
+type ModerationSetting struct {
+	Enabled        bool                               `json:"enabled"`
+	Stages         []ModerationStage                  `json:"stages"`
+	GroupOverrides map[string]ModerationGroupOverride `json:"group_overrides"`
+	// StreamWindow 流式输出审核保留的窗口长度（字符），跨分片的命中长度不超过该值时可被完整遮盖
+	StreamWindow int `json:"stream_window"`
+	// StreamRemoteInterval 流式输出每累计多少字符调用一次分类服务等远程阶段，逐窗口只运行关键词、正则等本地阶段
+	StreamRemoteInterval int `json:"stream_remote_interval"`
+}
+
+var moderationSetting = ModerationSetting{
+	Enabled:        false,
+	Stages:         []ModerationStage{},
+	GroupOverrides: map[string]ModerationGroupOverride{},
+	StreamWindow:   64,
+	// 远程阶段按累计字符节流，避免每个窗口都发起请求
+	StreamRemoteInterval: 1000,
+}
+
+func init() {
+	config.GlobalConfig.Register("moderation", &moderationSetting)
+}
+
+func GetModerationSetting() *ModerationSetting {
+	return &moderationSetting
+}
+
+// ModerationEnabledForGroup 分组是否启用审核流水线
+func ModerationEnabledForGroup(group string) bool {
+	if !moderationSetting.Enabled || len(moderationSetting.Stages) == 0 {
+		return false
+	}
+	override, ok := moderationSetting.GroupOverrides[group]
+	return !ok || !override.Disabled
+}
+
+// ModerationOutputEnabledForGroup 分组是否有需要审核模型输出的阶段
+func ModerationOutputEnabledForGroup(group string) bool {
+	return moderationStageEnabledForGroup(group, func(stage *ModerationStage) bool {
+		return stage.Output
+	})
+}
+
+// ModerationKeywordInputEnabledForGroup 分组是否有审核用户输入的 keyword 阶段
+func ModerationKeywordInputEnabledForGroup(group string) bool {
+	return moderationStageEnabledForGroup(group, func(stage *ModerationStage) bool {
+		return stage.Input && stage.Type == ModerationStageKeyword
+	})
+}
+
+// moderationStageEnabledForGroup 分组是否有满足 match 且未被分组禁用的阶段
+func moderationStageEnabledForGroup(group string, match func(stage *ModerationStage) bool) bool {
+	if !ModerationEnabledForGroup(group) {
+		return false
+	}
+	override := moderationSetting.GroupOverrides[group]
+	for i := range moderationSetting.Stages {
+		stage := &moderationSetting.Stages[i]
+		if stage.Enabled && match(stage) && !common.StringsContains(override.DisabledStages, stage.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+// PaymentProviderSetting 单个支付渠道的通用配置
+// ExchangeRate 为 1 单位站内充值金额（按 Price 计价的货币）折合该渠道结算货币的数量
+type PaymentProviderSetting struct {
+	Enabled      bool    `json:"enabled"`
+	Currency     string  `json:"currency"`
+	ExchangeRate float64 `json:"exchange_rate"`
+}
+
+type PaymentSetting struct {
+	Stripe              PaymentProviderSetting `json:"stripe"`
+	StripeSecretKey     string                 `json:"stripe_secret_key"`
+	StripeWebhookSecret string                 `json:"stripe_webhook_secret"`
+
+	PayPal             PaymentProviderSetting `json:"paypal"`
+	PayPalClientId     string                 `json:"paypal_client_id"`
+	PayPalClientSecret string                 `json:"paypal_client_secret"`
+	PayPalWebhookId    string                 `json:"paypal_webhook_id"`
+	PayPalSandbox      bool                   `json:"paypal_sandbox"`
+
+	// Webhook 通用签名回调渠道：跳转到 WebhookCheckoutURL 完成支付，支付平台以 HMAC-SHA256 签名回调
+	Webhook            PaymentProviderSetting `json:"webhook"`
+	WebhookCheckoutURL string                 `json:"webhook_checkout_url"`
+	WebhookRefundURL   string                 `json:"webhook_refund_url"`
+	WebhookSecret      string                 `json:"webhook_secret"`
+
+	// Mock 仅用于测试环境，回调不做签名校验，需设置环境变量 PAYMENT_MOCK_ENABLED=true 才会注册，切勿在生产环境启用
+	Mock PaymentProviderSetting `json:"mock"`
+}
+
+// 默认配置
+var paymentSetting = PaymentSetting{
+	Stripe:  PaymentProviderSetting{Currency: "usd", ExchangeRate: 1},
+	PayPal:  PaymentProviderSetting{Currency: "USD", ExchangeRate: 1},
+	Webhook: PaymentProviderSetting{Currency: "CNY", ExchangeRate: 1},
+	Mock:    PaymentProviderSetting{Currency: "CNY", ExchangeRate: 1},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("payment_setting", &paymentSetting)
+}
+
+func GetPaymentSetting() *PaymentSetting {
+	return &paymentSetting
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import (
+	"veloera/common"
+	"veloera/setting/config"
+)
+
+const (
+	PiiTypeEmail      = "email"
+	PiiTypePhone      = "phone"
+	PiiTypeCard       = "card"        // 银行卡号，需通过 Luhn 校验
+	PiiTypeNationalId = "national_id" // 中国居民身份证号与美国 SSN
+)
+
+// PiiMaskSetting 发往上游前的敏感信息脱敏
+type PiiMaskSetting struct {
+	Enabled bool `json:"enabled"`
+	// Groups 默认启用脱敏的分组，"*" 表示所有分组；令牌可单独启用或关闭
+	Groups []string `json:"groups"`
+	// Types 启用的内置检测类型
+	Types []string `json:"types"`
+	// CustomPatterns 自定义检测，名称 -> 正则
+	CustomPatterns map[string]string `json:"custom_patterns"`
+}
+
+var piiMaskSetting = PiiMaskSetting{
+	Enabled:        false,
+	Groups:         []string{},
+	Types:          []string{PiiTypeEmail, PiiTypePhone, PiiTypeCard, PiiTypeNationalId},
+	CustomPatterns: map[string]string{},
+}
+
+func init() {
+	config.GlobalConfig.Register("pii_mask", &piiMaskSetting)
+}
+
+func GetPiiMaskSetting() *PiiMaskSetting {
+	return &piiMaskSetting
+}
+
+// PiiMaskEnabledForGroup 分组是否默认启用脱敏
+func PiiMaskEnabledForGroup(group string) bool {
+	return common.StringsContains(piiMaskSetting.Groups, "*") || common.StringsContains(piiMaskSetting.Groups, group)
+}
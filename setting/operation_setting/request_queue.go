@@ -0,0 +1,50 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+// RequestQueueSetting 并发、TPM 或请求频率超限时的排队配置，队列按 分组/模型 划分
+type RequestQueueSetting struct {
+	Enabled                  bool           `json:"enabled"`
+	MaxQueueLength           int            `json:"max_queue_length"`
+	MaxWaitSeconds           int            `json:"max_wait_seconds"`
+	HeartbeatIntervalSeconds int            `json:"heartbeat_interval_seconds"`
+	GroupPriorities          map[string]int `json:"group_priorities"` // 用户分组 -> 优先级，数值越大越先出队
+}
+
+// 默认配置
+var requestQueueSetting = RequestQueueSetting{
+	Enabled:                  false,
+	MaxQueueLength:           100,
+	MaxWaitSeconds:           30,
+	HeartbeatIntervalSeconds: 5,
+	GroupPriorities:          map[string]int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("request_queue", &requestQueueSetting)
+}
+
+func GetRequestQueueSetting() *RequestQueueSetting {
+	return &requestQueueSetting
+}
+
+func GetGroupQueuePriority(group string) int {
+	return requestQueueSetting.GroupPriorities[group]
+}
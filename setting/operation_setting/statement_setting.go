@@ -0,0 +1,44 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+// StatementSetting 月度对账单设置
+type StatementSetting struct {
+	// 是否在每月 SendDay 日自动生成上月对账单
+	Enabled bool `json:"enabled"`
+	// 生成后是否通过邮件发送给已绑定邮箱的用户
+	EmailEnabled bool `json:"email_enabled"`
+	SendDay      int  `json:"send_day"`
+}
+
+// 默认配置
+var statementSetting = StatementSetting{
+	Enabled:      false,
+	EmailEnabled: true,
+	SendDay:      1,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("statement_setting", &statementSetting)
+}
+
+func GetStatementSetting() *StatementSetting {
+	return &statementSetting
+}
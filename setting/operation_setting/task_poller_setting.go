@@ -0,0 +1,47 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+// TaskPollerSetting 多节点部署下异步任务轮询的选主与分片设置
+type TaskPollerSetting struct {
+	// 渠道按 channel_id % ShardCount 分片，每个分片同一时刻只由一个节点持有租约并轮询
+	ShardCount int `json:"shard_count"`
+	// 租约与节点心跳的有效期，节点失联超过该时长后其分片由其他节点接管
+	LeaseSeconds int `json:"lease_seconds"`
+	// 渠道查询失败后按失败次数指数退避，最长 MaxFailureBackoffSeconds
+	FailureBackoffSeconds    int `json:"failure_backoff_seconds"`
+	MaxFailureBackoffSeconds int `json:"max_failure_backoff_seconds"`
+}
+
+// 默认配置
+var taskPollerSetting = TaskPollerSetting{
+	ShardCount:               4,
+	LeaseSeconds:             60,
+	FailureBackoffSeconds:    15,
+	MaxFailureBackoffSeconds: 600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("task_poller_setting", &taskPollerSetting)
+}
+
+func GetTaskPollerSetting() *TaskPollerSetting {
+	return &taskPollerSetting
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import "veloera/setting/config"
+
+// TrafficLimit TPM 与并发限制，0 表示不限制
+type TrafficLimit struct {
+	TPM         int `json:"tpm"`
+	Concurrency int `json:"concurrency"`
+}
+
+type TrafficLimitSetting struct {
+	Enabled                bool                    `json:"enabled"`
+	DefaultUserTPM         int                     `json:"default_user_tpm"`
+	DefaultUserRPM         int                     `json:"default_user_rpm"` // 用户每分钟请求数，0 表示不限制
+	DefaultUserConcurrency int                     `json:"default_user_concurrency"`
+	GroupLimits            map[string]TrafficLimit `json:"group_limits"`
+}
+
+// 默认配置
+var trafficLimitSetting = TrafficLimitSetting{
+	Enabled:     false,
+	GroupLimits: map[string]TrafficLimit{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("traffic_limit", &trafficLimitSetting)
+}
+
+func GetTrafficLimitSetting() *TrafficLimitSetting {
+	return &trafficLimitSetting
+}
+
+// GetGroupTrafficLimit 获取分组整体的 TPM 与并发限制
+func GetGroupTrafficLimit(group string) TrafficLimit {
+	return trafficLimitSetting.GroupLimits[group]
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package operation_setting
+
+import (
+	"fmt"
+	"strings"
+	"veloera/setting/config"
+)
+
+// VideoPrice 视频模型价格（美元），Resolutions 按分辨率设置每秒价格，优先于 PerSecond
+type VideoPrice struct {
+	PerSecond      float64            `json:"per_second"`
+	Resolutions    map[string]float64 `json:"resolutions"`
+	DefaultSeconds int                `json:"default_seconds"`
+}
+
+// VideoSetting 视频任务设置
+type VideoSetting struct {
+	Prices map[string]VideoPrice `json:"prices"`
+	// 轮询间隔从 PollIntervalSeconds 开始按指数退避，最长 MaxPollIntervalSeconds
+	PollIntervalSeconds    int `json:"poll_interval_seconds"`
+	MaxPollIntervalSeconds int `json:"max_poll_interval_seconds"`
+	// 超过该时长仍未完成的任务视为失败并退还额度
+	TaskTimeoutMinutes int `json:"task_timeout_minutes"`
+}
+
+// 默认配置
+var videoSetting = VideoSetting{
+	Prices:                 map[string]VideoPrice{},
+	PollIntervalSeconds:    15,
+	MaxPollIntervalSeconds: 300,
+	TaskTimeoutMinutes:     1440,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("video_setting", &videoSetting)
+}
+
+func GetVideoSetting() *VideoSetting {
+	return &videoSetting
+}
+
+// normalizeVideoResolution 将 1280x720 形式的尺寸转换为 720p
+func normalizeVideoResolution(size string) string {
+	var width, height int
+	if _, err := fmt.Sscanf(strings.ToLower(size), "%dx%d", &width, &height); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%dp", min(width, height))
+}
+
+// GetVideoPrice 按时长与分辨率计算视频任务价格，未配置时返回 false
+func GetVideoPrice(modelName string, seconds int, size string) (float64, bool) {
+	price, ok := videoSetting.Prices[modelName]
+	if !ok {
+		return 0, false
+	}
+	if seconds <= 0 {
+		seconds = price.DefaultSeconds
+	}
+	perSecond := price.PerSecond
+	if resolutionPrice, ok := price.Resolutions[size]; ok {
+		perSecond = resolutionPrice
+	} else if resolutionPrice, ok := price.Resolutions[normalizeVideoResolution(size)]; ok {
+		perSecond = resolutionPrice
+	}
+	if perSecond <= 0 || seconds <= 0 {
+		return 0, false
+	}
+	return perSecond * float64(seconds), true
+}
@@ -19,6 +19,7 @@ package setting
 import (
 	"regexp"
 	"strings"
+	"veloera/setting/operation_setting"
 )
 
 var CheckSensitiveEnabled = true
@@ -99,6 +100,10 @@ func ShouldCheckPromptSensitiveWithGroup(group string) bool {
 	if SafeCheckExemptEnabled && group == SafeCheckExemptGroup {
 		return false
 	}
+	// 分组启用了审核流水线且配置了审核输入的 keyword 阶段时，由该阶段负责屏蔽词检查
+	if operation_setting.ModerationKeywordInputEnabledForGroup(group) {
+		return false
+	}
 	return ShouldCheckPromptSensitive()
 }
 
@@ -47,8 +47,14 @@ func UpdateUserUsableGroupsByJSONString(jsonStr string) error {
 	return json.Unmarshal([]byte(jsonStr), &userUsableGroups)
 }
 
-func GetUserUsableGroups(userGroup string) map[string]string {
+// GetUserUsableGroups 返回用户可用的分组，planGroups 为用户订阅套餐解锁的分组
+func GetUserUsableGroups(userGroup string, planGroups ...string) map[string]string {
 	groupsCopy := GetUserUsableGroupsCopy()
+	for _, group := range planGroups {
+		if _, ok := groupsCopy[group]; !ok {
+			groupsCopy[group] = "套餐分组"
+		}
+	}
 	if userGroup == "" {
 		if _, ok := groupsCopy["default"]; !ok {
 			groupsCopy["default"] = "default"
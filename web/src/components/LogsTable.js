@@ -133,6 +133,12 @@ const LogsTable = () => {
             {t('错误')}
           </Tag>
         );
+      case 7:
+        return (
+          <Tag color='teal' size='large'>
+            {t('退款')}
+          </Tag>
+        );
       default:
         return (
           <Tag color='grey' size='large'>
@@ -1631,6 +1637,7 @@ const LogsTable = () => {
             <Select.Option value='4'>{t('系统')}</Select.Option>
             <Select.Option value='5'>{t('签到')}</Select.Option> {/* 添加签到选项 */}
             <Select.Option value='6'>{t('错误')}</Select.Option>
+            <Select.Option value='7'>{t('退款')}</Select.Option>
           </Select>
           <Button
             theme='light'
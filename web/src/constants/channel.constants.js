@@ -33,6 +33,16 @@ export const CHANNEL_OPTIONS = [
     color: 'purple',
     label: 'Suno API',
   },
+  {
+    value: 50,
+    color: 'purple',
+    label: '可灵 Kling',
+  },
+  {
+    value: 51,
+    color: 'purple',
+    label: 'Runway',
+  },
   { value: 4, color: 'grey', label: 'Ollama' },
   {
     value: 14,